@@ -16,7 +16,15 @@ export interface ManifestVariable {
   description?: string;
   example?: string;
   pattern?: string;
+  format?: string;
   values?: string[];
+  min?: number;
+  max?: number;
+  min_length?: number;
+  max_length?: number;
+  min_items?: number;
+  max_items?: number;
+  required_if?: string;
   deprecated?: boolean;
   deprecated_message?: string;
 }
@@ -29,10 +37,13 @@ export interface ManifestSettings {
   session_key_ttl?: string;
   session_key_max_rate?: number;
   allowed_origins?: string[];
+  environment?: string;
 }
 
 export interface Manifest {
   version: string;
+  extends?: string;
+  strict?: boolean;
   variables: Record<string, ManifestVariable>;
   settings?: ManifestSettings;
 }
@@ -7,6 +7,7 @@
 // Usage:
 //
 //	rep-gateway [flags]
+//	rep-gateway schema [--manifest path] [--env-name name]
 //
 // Modes:
 //
@@ -18,13 +19,17 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/ruachtech/rep/gateway/internal/audit"
 	"github.com/ruachtech/rep/gateway/internal/config"
+	"github.com/ruachtech/rep/gateway/internal/guardrails"
+	"github.com/ruachtech/rep/gateway/internal/manifest"
 	"github.com/ruachtech/rep/gateway/internal/server"
 )
 
@@ -32,6 +37,10 @@ import (
 var version = "0.1.0-dev"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		os.Exit(printSchema(os.Args[2:]))
+	}
+
 	cfg, err := config.Parse(os.Args[1:], version)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "rep-gateway: %v\n", err)
@@ -43,21 +52,57 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Configure structured logger.
+	// Configure structured logger. Report modes keep stdout for the report.
+	logOut := os.Stdout
+	if cfg.GuardrailOutput != "" {
+		logOut = os.Stderr
+	}
 	var handler slog.Handler
 	if cfg.LogFormat == "json" {
-		handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: cfg.LogLevel()})
+		handler = slog.NewJSONHandler(logOut, &slog.HandlerOptions{Level: cfg.LogLevel()})
 	} else {
-		handler = slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: cfg.LogLevel()})
+		handler = slog.NewTextHandler(logOut, &slog.HandlerOptions{Level: cfg.LogLevel()})
+	}
+
+	if cfg.GuardrailOutput != "" {
+		os.Exit(reportGuardrails(cfg, slog.New(handler)))
+	}
+
+	// Security-relevant events are also appended to the audit file. It is
+	// flushed on every exit path below.
+	exit := os.Exit
+	if cfg.AuditFile != "" {
+		auditLog, err := audit.Open(cfg.AuditFile, int64(cfg.AuditMaxSize))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rep-gateway: %v\n", err)
+			os.Exit(1)
+		}
+		handler = audit.NewHandler(handler, auditLog)
+		exit = func(code int) {
+			_ = auditLog.Close()
+			os.Exit(code)
+		}
 	}
 	logger := slog.New(handler)
+	for _, w := range cfg.Warnings {
+		logger.Warn("configuration warning", "detail", w)
+	}
+
+	// Bind the ports first so probes see "initializing" while the startup
+	// sequence runs, rather than connection refused.
+	startup, err := server.Listen(cfg, logger, version)
+	if err != nil {
+		logger.Error("failed to listen", "error", err)
+		exit(1)
+	}
 
 	// Create and start the server.
 	srv, err := server.New(cfg, logger, version)
 	if err != nil {
 		logger.Error("failed to initialise gateway", "error", err)
-		os.Exit(1)
+		exit(1)
 	}
+	srv.UseStartup(startup)
 
 	// Graceful shutdown on SIGINT/SIGTERM.
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
@@ -75,10 +120,75 @@ func main() {
 				}
 			}
 		}()
+	} else if cfg.MaintenanceFile != "" {
+		// Without signal-mode hot reload, SIGHUP re-checks --maintenance-file.
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		go func() {
+			for range sighup {
+				srv.CheckMaintenance()
+			}
+		}()
 	}
 
 	if err := srv.Start(ctx); err != nil {
 		logger.Error("server exited with error", "error", err)
-		os.Exit(1)
+		exit(1)
+	}
+	exit(0)
+}
+
+// reportGuardrails scans the configured variables without starting the
+// server and writes the findings to stdout (--guardrail-output). It returns
+// the process exit code: 1 on error, or on findings when --strict is set.
+func reportGuardrails(cfg *config.Config, logger *slog.Logger) int {
+	vars, err := config.ReadAndClassifyFrom(cfg.Sources())
+	if err != nil {
+		logger.Error("failed to read variables", "error", err)
+		return 1
+	}
+	vars.ApplyDefaults(cfg.Manifest)
+
+	result := guardrails.ScanWithLimits(vars, logger, guardrails.Limits{MaxScanLength: cfg.GuardrailMaxScan})
+	if err := result.WriteSARIF(os.Stdout, version); err != nil {
+		logger.Error("failed to write guardrail report", "error", err)
+		return 1
+	}
+
+	if result.HasWarnings() && cfg.Strict {
+		return 1
+	}
+	return 0
+}
+
+// printSchema implements the schema subcommand: it writes the JSON Schema
+// of the environment the manifest expects to stdout (see
+// manifest.ToJSONSchema). It returns the process exit code.
+func printSchema(args []string) int {
+	fs := flag.NewFlagSet("rep-gateway schema", flag.ContinueOnError)
+	path := fs.String("manifest", os.Getenv("REP_GATEWAY_MANIFEST"), "Path or URL of the .rep.yaml manifest (- for stdin)")
+	envName := fs.String("env-name", os.Getenv("REP_GATEWAY_ENV"), "Apply this environments: section of the manifest")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" {
+		fmt.Fprintln(os.Stderr, "rep-gateway schema: --manifest is required")
+		return 2
+	}
+
+	m, err := manifest.Load(*path)
+	if err == nil && *envName != "" {
+		err = m.ApplyEnvironment(*envName)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rep-gateway schema: %v\n", err)
+		return 1
+	}
+	out, err := m.ToJSONSchema()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rep-gateway schema: %v\n", err)
+		return 1
 	}
+	fmt.Println(string(out))
+	return 0
 }
@@ -0,0 +1,260 @@
+// Package audit writes security-relevant gateway events to an append-only
+// JSON Lines file (--audit-file).
+//
+// Events are not emitted separately: Handler wraps the gateway's slog
+// handler and copies the records named in Events to a Writer, so every
+// audit line has a matching log line. Attributes that may carry variable
+// values (even masked ones) are dropped, so the file never contains them.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultMaxSize is the size at which the audit file is rotated when no
+// other limit is configured.
+const DefaultMaxSize = 10 << 20 // 10 MiB
+
+// flushInterval bounds how long a buffered event waits before reaching the
+// file.
+const flushInterval = time.Second
+
+// Events are the log messages copied to the audit file.
+var Events = map[string]bool{
+	"rep.session_key.issued":       true,
+	"rep.session_key.rejected":     true,
+	"rep.session_key.rate_limited": true,
+	"rep.guardrail.warning":        true,
+	"rep.guardrail.blocked":        true,
+	"rep.config.reload":            true,
+	"rep.config.changed":           true,
+}
+
+// droppedAttrs are attribute keys never written to the audit file: guardrail
+// warnings carry a masked value in both.
+var droppedAttrs = map[string]bool{
+	"value":  true,
+	"detail": true,
+}
+
+// Writer appends JSON lines to a file through a buffer, flushed every
+// flushInterval and on Close. When a write would grow the file past
+// maxSize, the file is renamed to path + ".1" (replacing any previous
+// backup) and a new one is started. If the rename fails, writing continues
+// in the original file and the error is reported.
+type Writer struct {
+	path    string
+	maxSize int64
+
+	mu     sync.Mutex
+	f      *os.File
+	buf    *bufio.Writer
+	size   int64
+	closed bool
+	done   chan struct{}
+}
+
+// Open opens (or creates) the audit file at path for appending. A maxSize
+// of zero or less means DefaultMaxSize.
+func Open(path string, maxSize int64) (*Writer, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	w := &Writer{path: path, maxSize: maxSize, done: make(chan struct{})}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	go w.flushLoop()
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening audit file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("opening audit file: %w", err)
+	}
+	w.f, w.buf, w.size = f, bufio.NewWriter(f), fi.Size()
+	return nil
+}
+
+// Write appends one JSON object (without its trailing newline) as a line.
+func (w *Writer) Write(line []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return fmt.Errorf("audit file %s is closed", w.path)
+	}
+	// A failed rotation may have left no file open; try again.
+	if w.f == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	n := int64(len(line)) + 1
+	var rotateErr error
+	if w.size > 0 && w.size+n > w.maxSize {
+		rotateErr = w.rotate()
+		if w.f == nil {
+			return rotateErr
+		}
+	}
+	if _, err := w.buf.Write(line); err != nil {
+		return err
+	}
+	if err := w.buf.WriteByte('\n'); err != nil {
+		return err
+	}
+	w.size += n
+	return rotateErr
+}
+
+// rotate moves the current file to path + ".1" and starts a new one.
+func (w *Writer) rotate() error {
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("flushing audit file: %w", err)
+	}
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("closing audit file: %w", err)
+	}
+	w.f = nil
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		// Keep auditing to the original file rather than stopping.
+		if oerr := w.open(); oerr != nil {
+			return fmt.Errorf("rotating audit file: %w (reopening: %v)", err, oerr)
+		}
+		return fmt.Errorf("rotating audit file: %w", err)
+	}
+	return w.open()
+}
+
+// Flush writes buffered events to the file.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	return w.buf.Flush()
+}
+
+// Close flushes buffered events and closes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	if w.f == nil {
+		return nil
+	}
+	err := w.buf.Flush()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	w.f = nil
+	return err
+}
+
+func (w *Writer) flushLoop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			_ = w.Flush()
+		}
+	}
+}
+
+// Handler is a slog.Handler that passes records to next and additionally
+// writes those named in Events to an audit Writer.
+type Handler struct {
+	next  slog.Handler
+	w     *Writer
+	attrs []slog.Attr
+}
+
+// NewHandler returns a Handler wrapping next. Audit events are written
+// regardless of next's level.
+func NewHandler(next slog.Handler, w *Writer) *Handler {
+	return &Handler{next: next, w: w}
+}
+
+// Enabled implements slog.Handler.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo || h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if Events[r.Message] {
+		if err := h.write(r); err != nil {
+			fmt.Fprintf(os.Stderr, "rep-gateway: writing audit event: %v\n", err)
+		}
+	}
+	if !h.next.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *Handler) write(r slog.Record) error {
+	event := map[string]any{
+		"time":  r.Time.UTC().Format(time.RFC3339Nano),
+		"level": r.Level.String(),
+		"event": r.Message,
+	}
+	add := func(a slog.Attr) bool {
+		if droppedAttrs[a.Key] {
+			return true
+		}
+		v := a.Value.Resolve().Any()
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		event[a.Key] = v
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return h.w.Write(line)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{
+		next:  h.next.WithAttrs(attrs),
+		w:     h.w,
+		attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...),
+	}
+}
+
+// WithGroup implements slog.Handler. Groups are not reflected in audit
+// lines, which stay flat.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), w: h.w, attrs: h.attrs}
+}
@@ -0,0 +1,171 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readLines returns the JSON objects in the file at path.
+func readLines(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	var lines []map[string]any
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		var m map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Fatalf("line %q is not JSON: %v", sc.Text(), err)
+		}
+		lines = append(lines, m)
+	}
+	return lines
+}
+
+func TestHandler_AppendsAuditEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(path, []byte(`{"event":"earlier"}`+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	w, err := Open(path, 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	var logs bytes.Buffer
+	next := slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelError})
+	logger := slog.New(NewHandler(next, w)).With("replica", "a")
+
+	logger.Info("rep.session_key.issued", "client_ip", "10.0.0.1", "key_id", "abc")
+	logger.Warn("rep.guardrail.warning", "variable_name", "API_KEY", "value", "sk_l****", "detail", "value sk_l**** matches")
+	logger.Warn("rep.config.reload", "outcome", "failed", "error", errors.New("re-reading allowed origins"))
+	logger.Info("rep.inject.summary", "count", 3)
+	logger.Error("rep.guardrail.blocked", "warnings", 1)
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	lines := readLines(t, path)
+	var events []string
+	for _, l := range lines {
+		events = append(events, l["event"].(string))
+	}
+	want := "earlier,rep.session_key.issued,rep.guardrail.warning,rep.config.reload,rep.guardrail.blocked"
+	if got := strings.Join(events, ","); got != want {
+		t.Fatalf("expected events %s, got %s", want, got)
+	}
+
+	issued := lines[1]
+	if issued["client_ip"] != "10.0.0.1" || issued["replica"] != "a" || issued["level"] != "INFO" || issued["time"] == nil {
+		t.Errorf("unexpected issuance line %v", issued)
+	}
+	if _, ok := lines[2]["value"]; ok {
+		t.Errorf("expected the guardrail value attribute dropped, got %v", lines[2])
+	}
+	if _, ok := lines[2]["detail"]; ok {
+		t.Errorf("expected the guardrail detail attribute dropped, got %v", lines[2])
+	}
+	if lines[3]["error"] != "re-reading allowed origins" {
+		t.Errorf("expected the error rendered as a string, got %v", lines[3]["error"])
+	}
+
+	// The wrapped handler still applies its own level.
+	if strings.Contains(logs.String(), "rep.session_key.issued") || !strings.Contains(logs.String(), "rep.guardrail.blocked") {
+		t.Errorf("expected only error-level records in the log, got:\n%s", logs.String())
+	}
+}
+
+func TestWriter_RotatesPastMaxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	line := []byte(`{"event":"rep.session_key.issued","n":"0000000000"}`)
+	maxSize := int64(len(line)+1) * 3
+
+	w, err := Open(path, maxSize)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for range 5 {
+		if err := w.Write(line); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if got := len(readLines(t, path+".1")); got != 3 {
+		t.Errorf("expected 3 lines in the rotated file, got %d", got)
+	}
+	if got := len(readLines(t, path)); got != 2 {
+		t.Errorf("expected 2 lines in the current file, got %d", got)
+	}
+	fi, err := os.Stat(path + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() > maxSize {
+		t.Errorf("rotated file is %d bytes, over the %d limit", fi.Size(), maxSize)
+	}
+}
+
+func TestWriter_RotateRenameFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	// A non-empty directory in the way makes the rename fail.
+	if err := os.MkdirAll(filepath.Join(path+".1", "keep"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(`{"event":"rep.session_key.issued"}`)
+
+	w, err := Open(path, int64(len(line)+1))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := w.Write(line); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Write(line); err == nil || !strings.Contains(err.Error(), "rotating audit file") {
+		t.Errorf("expected the failed rotation to be reported, got %v", err)
+	}
+	if err := w.Write(line); err == nil {
+		t.Error("expected the next rotation attempt to fail too")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case <-w.done:
+	default:
+		t.Error("expected Close to stop the flush loop")
+	}
+
+	// Every event stays in the original file.
+	if got := len(readLines(t, path)); got != 3 {
+		t.Errorf("expected 3 lines in the original file, got %d", got)
+	}
+}
+
+func TestWriter_WriteAfterClose(t *testing.T) {
+	w, err := Open(filepath.Join(t.TempDir(), "audit.jsonl"), 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := w.Write([]byte(`{}`)); err == nil {
+		t.Error("expected an error writing to a closed audit file")
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("expected a second Close to be a no-op, got %v", err)
+	}
+}
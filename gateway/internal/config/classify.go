@@ -11,7 +11,10 @@ package config
 import (
 	"fmt"
 	"os"
+	"sort"
 	"strings"
+
+	"github.com/ruachtech/rep/gateway/internal/manifest"
 )
 
 // Tier represents the security classification of a variable.
@@ -87,6 +90,85 @@ func (cv *ClassifiedVars) ServerMap() map[string]string {
 	return m
 }
 
+// ApplyDefaults adds a variable for every manifest declaration that has a
+// default and is absent from all tiers, placed in the tier the declaration
+// names. Variables that are present, even with an empty value, are never
+// overridden, and required variables never get a default: a missing
+// required variable must still fail validation (manifest lint flags such
+// defaults as dead). It returns the names of the defaults applied, sorted.
+//
+// ${NAME} references in a default are expanded (see manifest.ExpandDefault)
+// from the variables that were set before any default applied. A default
+// can only reference variables in its own tier or a less sensitive one, so
+// a public default never exposes a sensitive or server value. References
+// that do not resolve are kept literally and returned as "NAME: ${REF}",
+// sorted.
+//
+// This lives here rather than on manifest.Manifest because the manifest
+// package cannot import config.
+func (cv *ClassifiedVars) ApplyDefaults(m *manifest.Manifest) (applied, unresolved []string) {
+	if m == nil {
+		return nil, nil
+	}
+
+	present := make(map[string]Variable)
+	for _, tier := range [][]Variable{cv.Public, cv.Sensitive, cv.Server} {
+		for _, v := range tier {
+			present[v.Name] = v
+		}
+	}
+
+	for name, decl := range m.Variables {
+		if _, ok := present[name]; ok || !decl.HasDefault || decl.Required {
+			continue
+		}
+		v := Variable{Name: name}
+		switch decl.Tier {
+		case "public":
+			v.Tier, v.OriginalKey = TierPublic, "REP_PUBLIC_"+name
+		case "sensitive":
+			v.Tier, v.OriginalKey = TierSensitive, "REP_SENSITIVE_"+name
+		case "server":
+			v.Tier, v.OriginalKey = TierServer, "REP_SERVER_"+name
+		default:
+			continue
+		}
+
+		var refs []string
+		v.Value, refs = manifest.ExpandDefault(decl.Default, func(ref string) (string, bool) {
+			src, ok := present[ref]
+			return src.Value, ok && src.Tier <= v.Tier
+		})
+		for _, ref := range refs {
+			unresolved = append(unresolved, name+": ${"+ref+"}")
+		}
+
+		switch v.Tier {
+		case TierPublic:
+			cv.Public = append(cv.Public, v)
+		case TierSensitive:
+			cv.Sensitive = append(cv.Sensitive, v)
+		case TierServer:
+			cv.Server = append(cv.Server, v)
+		}
+		applied = append(applied, name)
+	}
+	sort.Strings(applied)
+	sort.Strings(unresolved)
+	return applied, unresolved
+}
+
+// Sources lists the places variables are read from in addition to the
+// process environment.
+type Sources struct {
+	// EnvFile is an optional .env file (see ParseEnvFile).
+	EnvFile string
+
+	// EnvDir is an optional directory with one file per variable
+	// (see ParseEnvDir).
+	EnvDir string
+}
+
 // ReadAndClassify reads environment variables, filters for the REP_ prefix,
 // classifies them, strips prefixes, and validates uniqueness.
 //
@@ -99,11 +181,18 @@ func (cv *ClassifiedVars) ServerMap() map[string]string {
 //   - The classification prefix is stripped from the name.
 //   - Names MUST be unique across all tiers after stripping.
 func ReadAndClassify(envFile string) (*ClassifiedVars, error) {
-	// Build a merged map: env file (base) + os.Environ() (override).
+	return ReadAndClassifyFrom(Sources{EnvFile: envFile})
+}
+
+// ReadAndClassifyFrom is like ReadAndClassify but reads from all configured
+// sources. Layers are applied lowest to highest precedence: env file, env
+// directory, then the process environment.
+func ReadAndClassifyFrom(src Sources) (*ClassifiedVars, error) {
+	// Build a merged map: env file, env dir (base) + os.Environ() (override).
 	merged := make(map[string]string)
 
-	if envFile != "" {
-		fileVars, err := ParseEnvFile(envFile)
+	if src.EnvFile != "" {
+		fileVars, err := ParseEnvFile(src.EnvFile)
 		if err != nil {
 			return nil, fmt.Errorf("reading env file: %w", err)
 		}
@@ -112,6 +201,16 @@ func ReadAndClassify(envFile string) (*ClassifiedVars, error) {
 		}
 	}
 
+	if src.EnvDir != "" {
+		dirVars, err := ParseEnvDir(src.EnvDir)
+		if err != nil {
+			return nil, fmt.Errorf("reading env dir: %w", err)
+		}
+		for k, v := range dirVars {
+			merged[k] = v
+		}
+	}
+
 	// Process environment overrides file values.
 	for _, env := range os.Environ() {
 		key, value, ok := strings.Cut(env, "=")
@@ -2,7 +2,10 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/ruachtech/rep/gateway/internal/manifest"
 )
 
 // clearREPEnv removes all REP_* env vars to provide a clean test environment.
@@ -217,6 +220,102 @@ func TestSensitiveMap(t *testing.T) {
 	}
 }
 
+func TestApplyDefaults(t *testing.T) {
+	vars := &ClassifiedVars{
+		Public: []Variable{
+			{Name: "THEME", Value: "dark", Tier: TierPublic, OriginalKey: "REP_PUBLIC_THEME"},
+			{Name: "BANNER", Value: "", Tier: TierPublic, OriginalKey: "REP_PUBLIC_BANNER"},
+		},
+	}
+	m := &manifest.Manifest{
+		Variables: map[string]*manifest.VarDecl{
+			"THEME":       {Tier: "public", Default: "light", HasDefault: true},
+			"BANNER":      {Tier: "public", Default: "hello", HasDefault: true},
+			"PAGE_SIZE":   {Tier: "public", Default: "20", HasDefault: true},
+			"ANALYTICS":   {Tier: "sensitive", Default: "", HasDefault: true},
+			"DB_POOL":     {Tier: "server", Default: "10", HasDefault: true},
+			"API_URL":     {Tier: "public"},
+			"FEATURE_OFF": {Tier: "public", Required: true},
+			"LICENSE_KEY": {Tier: "server", Required: true, Default: "dev", HasDefault: true},
+		},
+	}
+
+	applied, _ := vars.ApplyDefaults(m)
+	if got := strings.Join(applied, ","); got != "ANALYTICS,DB_POOL,PAGE_SIZE" {
+		t.Errorf("expected defaults applied for ANALYTICS,DB_POOL,PAGE_SIZE, got %s", got)
+	}
+
+	pub := vars.PublicMap()
+	if pub["THEME"] != "dark" || pub["BANNER"] != "" {
+		t.Errorf("defaults overrode set values: %v", pub)
+	}
+	if pub["PAGE_SIZE"] != "20" {
+		t.Errorf("expected PAGE_SIZE default 20, got %q", pub["PAGE_SIZE"])
+	}
+	if _, ok := pub["API_URL"]; ok {
+		t.Error("expected no value for API_URL, which has no default")
+	}
+	if v, ok := vars.SensitiveMap()["ANALYTICS"]; !ok || v != "" {
+		t.Errorf("expected an empty ANALYTICS default in the sensitive tier, got %q (%v)", v, ok)
+	}
+	if vars.ServerMap()["DB_POOL"] != "10" {
+		t.Errorf("expected DB_POOL default in the server tier, got %v", vars.ServerMap())
+	}
+	if _, ok := vars.ServerMap()["LICENSE_KEY"]; ok {
+		t.Error("expected no default for the required LICENSE_KEY")
+	}
+	if last := vars.Server[len(vars.Server)-1]; last.Tier != TierServer || last.OriginalKey != "REP_SERVER_DB_POOL" {
+		t.Errorf("unexpected synthetic variable %+v", last)
+	}
+
+	if again, _ := vars.ApplyDefaults(m); len(again) != 0 {
+		t.Errorf("expected a second call to apply nothing, got %v", again)
+	}
+	if applied, _ := (&ClassifiedVars{}).ApplyDefaults(nil); applied != nil {
+		t.Error("expected no defaults without a manifest")
+	}
+}
+
+func TestApplyDefaults_Interpolation(t *testing.T) {
+	vars := &ClassifiedVars{
+		Public: []Variable{{Name: "REGION", Value: "eu-west-1", Tier: TierPublic}},
+		Server: []Variable{{Name: "DB_HOST", Value: "db.internal", Tier: TierServer}},
+	}
+	m := &manifest.Manifest{
+		Variables: map[string]*manifest.VarDecl{
+			"BUCKET":   {Tier: "public", Default: "${REGION}-bucket", HasDefault: true},
+			"LEAK":     {Tier: "public", Default: "${DB_HOST}", HasDefault: true},
+			"DSN":      {Tier: "server", Default: "postgres://${DB_HOST}/${REGION}", HasDefault: true},
+			"TEMPLATE": {Tier: "public", Default: "$${REGION}", HasDefault: true},
+			"CHAINED":  {Tier: "public", Default: "${BUCKET}/assets", HasDefault: true},
+		},
+	}
+
+	_, unresolved := vars.ApplyDefaults(m)
+
+	pub := vars.PublicMap()
+	if pub["BUCKET"] != "eu-west-1-bucket" {
+		t.Errorf("BUCKET: got %q", pub["BUCKET"])
+	}
+	if pub["TEMPLATE"] != "${REGION}" {
+		t.Errorf("TEMPLATE: got %q", pub["TEMPLATE"])
+	}
+	// A public default must not pull in a server value.
+	if pub["LEAK"] != "${DB_HOST}" {
+		t.Errorf("LEAK: got %q", pub["LEAK"])
+	}
+	// Only variables set before defaults apply are referenced.
+	if pub["CHAINED"] != "${BUCKET}/assets" {
+		t.Errorf("CHAINED: got %q", pub["CHAINED"])
+	}
+	if got := vars.ServerMap()["DSN"]; got != "postgres://db.internal/eu-west-1" {
+		t.Errorf("DSN: got %q", got)
+	}
+	if got := strings.Join(unresolved, ","); got != "CHAINED: ${BUCKET},LEAK: ${DB_HOST}" {
+		t.Errorf("unresolved: got %q", got)
+	}
+}
+
 func TestTierString(t *testing.T) {
 	tests := []struct {
 		tier Tier
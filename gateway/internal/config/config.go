@@ -12,14 +12,27 @@ import (
 	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
+	repcrypto "github.com/ruachtech/rep/gateway/internal/crypto"
 	"github.com/ruachtech/rep/gateway/internal/manifest"
 )
 
+// Defaults for the upstream connection pool flags, also applied to zero
+// Config fields (see Config.UpstreamMaxIdleConns).
+const (
+	DefaultUpstreamMaxIdleConns        = 100
+	DefaultUpstreamMaxIdleConnsPerHost = 20
+	DefaultUpstreamIdleConnTimeout     = 90 * time.Second
+)
+
 // Config holds the parsed gateway configuration.
 type Config struct {
 	// Operating mode: "proxy" or "embedded".
@@ -28,18 +41,119 @@ type Config struct {
 	// Upstream server address (proxy mode only).
 	Upstream string
 
+	// StripPrefix is removed from request paths before proxying and added
+	// back to upstream redirect Locations (proxy mode only).
+	StripPrefix string
+
+	// EarlyHints sends a 103 Early Hints response with a preconnect Link
+	// for /rep/session-key before proxying HTML navigations over HTTP/2
+	// (proxy mode only).
+	EarlyHints bool
+
+	// Upstream TLS settings for https upstreams (proxy mode only).
+	// UpstreamCA is a PEM bundle trusted in addition to the system roots;
+	// UpstreamClientCert and UpstreamClientKey present a client certificate
+	// for mutual TLS. UpstreamInsecureSkipVerify disables certificate
+	// verification entirely and is meant for controlled environments only.
+	UpstreamCA                 string
+	UpstreamClientCert         string
+	UpstreamClientKey          string
+	UpstreamInsecureSkipVerify bool
+
+	// MaxRequestBody caps the request body size, in bytes, forwarded to
+	// the upstream; larger requests get 413 (0 = unlimited; proxy mode
+	// only).
+	MaxRequestBody int
+
+	// Upstream connection pooling (proxy mode). Zero means the default
+	// (see DefaultUpstreamMaxIdleConns and friends), so a Config built
+	// without Parse still gets a bounded pool; a negative MaxIdleConns or
+	// IdleConnTimeout means no limit, which is what a 0 flag parses to.
+	// UpstreamHTTP2 lets the transport negotiate HTTP/2 with https
+	// upstreams.
+	UpstreamMaxIdleConns        int
+	UpstreamMaxIdleConnsPerHost int
+	UpstreamIdleConnTimeout     time.Duration
+	UpstreamHTTP2               bool
+
+	// AllowedMethods lists the request methods forwarded to the upstream;
+	// others receive 405. Nil allows every method. Defaults to GET and
+	// HEAD in embedded mode and nil in proxy mode.
+	AllowedMethods []string
+
 	// Listen port for the main server.
 	Port int
 
 	// Static file directory (embedded mode only).
 	StaticDir string
 
+	// ErrorPageDir holds custom error pages (404.html, 500.html) served
+	// with REP injection in place of the default bodies (embedded mode only).
+	ErrorPageDir string
+
+	// Maintenance answers every request outside /rep/* with
+	// MaintenancePage (or a built-in page) and 503, without reaching the
+	// upstream. MaintenanceFile, when set, also turns maintenance mode on
+	// while that file exists; it is re-checked on every reload.
+	Maintenance     bool
+	MaintenancePage string
+	MaintenanceFile string
+
+	// DisableDirRedirects serves a directory's index.html directly instead
+	// of http.FileServer's trailing-slash and /index.html redirects
+	// (embedded mode only).
+	DisableDirRedirects bool
+
+	// StaticFollowSymlinks serves files reached through symlinks that
+	// resolve outside StaticDir; by default they are refused (embedded
+	// mode only).
+	StaticFollowSymlinks bool
+
+	// SPAPaths are path prefixes under which unknown extensionless paths
+	// fall back to the prefix's index.html. Nil falls back everywhere, to
+	// the root index.html (embedded mode only).
+	SPAPaths []string
+
 	// Path to .rep.yaml manifest file.
 	ManifestPath string
 
+	// ManifestSHA256 pins the hex SHA-256 digest the loaded manifest must
+	// have, e.g. when it is fetched from a URL.
+	ManifestSHA256 string
+
+	// ManifestMaxSize and ManifestMaxLineLength bound the manifest, in
+	// bytes, and each manifest it extends (see manifest.Limits).
+	ManifestMaxSize       int
+	ManifestMaxLineLength int
+
+	// EnvName selects a manifest environments: section whose values
+	// override the base manifest (e.g. "production").
+	EnvName string
+
+	// Environment is the deployment environment label surfaced as
+	// _meta.environment in the payload (e.g. "production"). Defaults to
+	// the manifest's environment setting, then EnvName.
+	Environment string
+
 	// If true, guardrail warnings cause a startup failure.
 	Strict bool
 
+	// Warnings lists non-fatal problems found while parsing; the gateway
+	// logs each one at startup.
+	Warnings []string
+
+	// GuardrailOutput, when set, runs the guardrail scan, writes its findings
+	// to stdout in this format ("sarif") and exits instead of serving.
+	GuardrailOutput string
+
+	// GuardrailMaxScan caps how many bytes of each value the guardrail
+	// entropy/length checks examine; longer values are sampled.
+	GuardrailMaxScan int
+
+	// RequiredGrace is how long startup keeps retrying when required
+	// manifest variables are missing (0 = fail immediately).
+	RequiredGrace time.Duration
+
 	// Hot reload configuration.
 	HotReload     bool
 	HotReloadMode string // "file_watch", "signal", "poll"
@@ -51,13 +165,105 @@ type Config struct {
 	// On hot reload, the file is re-read to pick up changes.
 	EnvFile string
 
+	// Path to a directory with one file per variable (e.g. a mounted
+	// ConfigMap or Secret). Layered above EnvFile, below process env, and
+	// re-read on hot reload.
+	EnvDir string
+
+	// If true, HTML is detected by sniffing the body when the upstream
+	// Content-Type is missing or application/octet-stream.
+	SniffContentType bool
+
+	// Optional <base href> injected into HTML for apps served under a path
+	// prefix. BaseHrefReplace rewrites an existing <base> instead of leaving it.
+	BaseHref        string
+	BaseHrefReplace bool
+
+	// EncryptPublic encrypts PUBLIC variables in the payload like SENSITIVE
+	// ones, so the client needs a session key to read any configuration.
+	EncryptPublic bool
+
+	// MetaTypes adds each PUBLIC variable's manifest-declared type to the
+	// payload as _meta.types.
+	MetaTypes bool
+
+	// KeyFile is a file holding a base64 master seed from which the
+	// encryption key and HMAC secret are derived, so replicas sharing it
+	// serve interchangeable payloads. Empty means ephemeral keys.
+	KeyFile string
+
+	// AuditFile, when set, receives security-relevant events (key
+	// issuance, origin rejections, guardrail findings, reloads) as JSON
+	// Lines, rotated to AuditFile + ".1" past AuditMaxSize bytes.
+	AuditFile    string
+	AuditMaxSize int
+
+	// ReplicaID identifies this replica in _meta.replica_id and the
+	// advertised key_endpoint, so the session key endpoint can reject key
+	// requests for another replica's payload (for sticky routing without
+	// shared keys).
+	ReplicaID string
+
+	// ServerHeader is the Server header sent on gateway-originated
+	// responses; empty omits it ("none" on the command line).
+	ServerHeader string
+
+	// ScriptTemplate is a text/template file rendering the injected script
+	// block in place of the default element (see payload.ScriptTemplate).
+	ScriptTemplate string
+
+	// KeySeed is a base64 master seed used like KeyFile's, taken only from
+	// REP_GATEWAY_KEY_SEED so it never appears in the process arguments.
+	KeySeed string
+
+	// PublicHeaders also emits each PUBLIC variable as an X-REP-<NAME>
+	// header on injected HTML responses.
+	PublicHeaders bool
+
+	// CSPReportOnly, when set, is sent as a Content-Security-Policy-Report-Only
+	// header on injected HTML responses; violations go to CSPReportURI.
+	CSPReportOnly string
+	CSPReportURI  string
+
+	// ValidatePayload checks the startup payload against the embedded
+	// payload JSON schema and fails startup if it does not conform.
+	ValidatePayload bool
+
+	// BodyRewrites are "old => new" HTML body replacements from the manifest
+	// settings (body_rewrites), applied before injection.
+	BodyRewrites []string
+
+	// NotReadyPolicy controls injection while the gateway is not ready:
+	// "fail-open" injects anyway, "fail-closed" serves responses without
+	// the payload.
+	NotReadyPolicy string
+
+	// InjectLogSample logs one in N per-request injection debug lines.
+	// InjectLogSummary, if positive, logs an injection count/bytes summary
+	// at that interval.
+	InjectLogSample  int
+	InjectLogSummary time.Duration
+
+	// InjectMaxConcurrent caps responses buffered for injection at once;
+	// excess GET/HEAD documents get 503, other methods wait (0 = unlimited).
+	InjectMaxConcurrent int
+
+	// InjectStatus lists the response statuses buffered for injection, as
+	// codes ("404") or classes ("2xx"). Other responses are streamed
+	// through. See inject.ParseStatuses.
+	InjectStatus []string
+
 	// Logging.
 	LogFormat   string // "json" or "text"
 	LogLevelStr string // "debug", "info", "warn", "error"
 
-	// CORS allowed origins for /rep/session-key.
+	// CORS allowed origins for /rep/session-key and the other /rep/* endpoints.
 	AllowedOrigins []string
 
+	// AllowedOriginsFile lists further allowed origins, one per line. It is
+	// re-read on every reload; see Origins.
+	AllowedOriginsFile string
+
 	// TLS (optional).
 	TLSCert string
 	TLSKey  string
@@ -65,10 +271,53 @@ type Config struct {
 	// Separate health check port (optional, for K8s probes).
 	HealthPort int
 
+	// ConfigEndpoint serves the effective configuration at
+	// /rep/config/effective for debugging flag/env/manifest precedence.
+	ConfigEndpoint bool
+
+	// DebugEndpoint serves goroutine and memory statistics at
+	// /rep/debug/vars to requests bearing DebugToken, which is taken only
+	// from REP_GATEWAY_DEBUG_TOKEN.
+	DebugEndpoint bool
+	DebugToken    string
+
+	// EnablePprof serves net/http/pprof under /rep/debug/pprof/ on a
+	// separate listener at PprofAddr, never on the main port.
+	EnablePprof bool
+	PprofAddr   string
+
+	// SSEMaxPerIP caps concurrent /rep/changes connections per client IP
+	// (0 = unlimited).
+	SSEMaxPerIP int
+
+	// SSEBuffer is the number of hot reload events queued per SSE client
+	// before events are dropped for that client.
+	SSEBuffer int
+
+	// SSEReplay is the number of recent hot reload events kept for clients
+	// reconnecting with Last-Event-ID (0 disables replay), and
+	// SSEReplayMaxAge how long each is kept. See hotreload.WithReplay.
+	SSEReplay       int
+	SSEReplayMaxAge time.Duration
+
+	// SSEGzip gzips /rep/changes streams for clients that accept it. See
+	// hotreload.WithCompression for the tradeoff.
+	SSEGzip bool
+
 	// Session key settings.
 	SessionKeyTTL     time.Duration
 	SessionKeyMaxRate int // Per minute per IP.
 
+	// SessionKeySkew is added to the advertised expires_at of issued
+	// session keys to tolerate client clock skew. Server-side expiry
+	// tracking uses the unbuffered TTL.
+	SessionKeySkew time.Duration
+
+	// RateLimits maps a /rep/ endpoint name ("health", "ready", "compat",
+	// "changes") to its per-IP limit in requests per minute. Absent means
+	// unlimited.
+	RateLimits map[string]int
+
 	// Version flag.
 	ShowVersion bool
 
@@ -76,6 +325,11 @@ type Config struct {
 	Manifest *manifest.Manifest
 }
 
+// Sources returns the variable sources configured for ReadAndClassifyFrom.
+func (c *Config) Sources() Sources {
+	return Sources{EnvFile: c.EnvFile, EnvDir: c.EnvDir}
+}
+
 // LogLevel returns the slog.Level corresponding to the configured log level string.
 func (c *Config) LogLevel() slog.Level {
 	switch strings.ToLower(c.LogLevelStr) {
@@ -96,16 +350,44 @@ func Parse(args []string, version string) (*Config, error) {
 	cfg := &Config{}
 
 	// ── Phase 1: Pre-scan for --manifest so we can seed flag defaults from it ──
-	manifestPath := prescanManifestFlag(args)
+	manifestPath := prescanFlag(args, "manifest")
 	if manifestPath == "" {
 		manifestPath = os.Getenv("REP_GATEWAY_MANIFEST")
 	}
 	cfg.ManifestPath = manifestPath
+	manifestSHA256 := prescanFlag(args, "manifest-sha256")
+	if manifestSHA256 == "" {
+		manifestSHA256 = os.Getenv("REP_GATEWAY_MANIFEST_SHA256")
+	}
+	envName := prescanFlag(args, "env-name")
+	if envName == "" {
+		envName = os.Getenv("REP_GATEWAY_ENV")
+	}
+	if manifestSHA256 != "" && manifestPath == "" {
+		return nil, fmt.Errorf("manifest-sha256 requires a manifest")
+	}
+	maxSize, err := prescanInt(args, "manifest-max-size", "REP_GATEWAY_MANIFEST_MAX_SIZE", int(manifest.DefaultLimits.MaxFileSize))
+	if err != nil {
+		return nil, err
+	}
+	maxLineLength, err := prescanInt(args, "manifest-max-line-length", "REP_GATEWAY_MANIFEST_MAX_LINE_LENGTH", manifest.DefaultLimits.MaxLineLength)
+	if err != nil {
+		return nil, err
+	}
 	if manifestPath != "" {
-		m, err := manifest.Load(manifestPath)
+		limits := manifest.Limits{MaxFileSize: int64(maxSize), MaxLineLength: maxLineLength}
+		m, err := manifest.LoadWithLimits(manifestPath, limits)
 		if err != nil {
 			return nil, fmt.Errorf("loading manifest: %w", err)
 		}
+		if manifestSHA256 != "" && !strings.EqualFold(manifestSHA256, m.SHA256) {
+			return nil, fmt.Errorf("loading manifest: sha256 %s does not match manifest-sha256 %s", m.SHA256, manifestSHA256)
+		}
+		if envName != "" {
+			if err := m.ApplyEnvironment(envName); err != nil {
+				return nil, fmt.Errorf("loading manifest: %w", err)
+			}
+		}
 		cfg.Manifest = m
 	}
 
@@ -118,6 +400,8 @@ func Parse(args []string, version string) (*Config, error) {
 	defaultSessionMaxRate := 10
 	defaultStrict := false
 	var defaultAllowedOrigins string
+	var defaultRateLimits string
+	defaultEnvironment := envName
 
 	if m := cfg.Manifest; m != nil && m.Settings != nil {
 		defaultHotReload = m.Settings.HotReload
@@ -134,9 +418,14 @@ func Parse(args []string, version string) (*Config, error) {
 			defaultSessionMaxRate = m.Settings.SessionKeyMaxRate
 		}
 		defaultStrict = m.Settings.StrictGuardrails
+		if m.Settings.Environment != "" {
+			defaultEnvironment = m.Settings.Environment
+		}
 		if len(m.Settings.AllowedOrigins) > 0 {
 			defaultAllowedOrigins = strings.Join(m.Settings.AllowedOrigins, ",")
 		}
+		defaultRateLimits = strings.Join(m.Settings.RateLimits, ",")
+		cfg.BodyRewrites = m.Settings.BodyRewrites
 	}
 
 	// ── Phase 3: Parse flags (env vars overlay manifest, CLI flags overlay both)
@@ -145,23 +434,83 @@ func Parse(args []string, version string) (*Config, error) {
 	// Register flags.
 	fs.StringVar(&cfg.Mode, "mode", envOrDefault("REP_GATEWAY_MODE", "proxy"), `Operating mode: "proxy" or "embedded"`)
 	fs.StringVar(&cfg.Upstream, "upstream", envOrDefault("REP_GATEWAY_UPSTREAM", "localhost:80"), "Upstream server address (proxy mode)")
+	fs.StringVar(&cfg.StripPrefix, "strip-prefix", envOrDefault("REP_GATEWAY_STRIP_PREFIX", ""), "Path prefix removed before proxying and restored on redirects, e.g. /app (proxy mode)")
+	fs.StringVar(&cfg.UpstreamCA, "upstream-ca", envOrDefault("REP_GATEWAY_UPSTREAM_CA", ""), "PEM CA bundle trusted for an https upstream, in addition to the system roots (proxy mode)")
+	fs.StringVar(&cfg.UpstreamClientCert, "upstream-client-cert", envOrDefault("REP_GATEWAY_UPSTREAM_CLIENT_CERT", ""), "Client certificate presented to an https upstream (proxy mode)")
+	fs.StringVar(&cfg.UpstreamClientKey, "upstream-client-key", envOrDefault("REP_GATEWAY_UPSTREAM_CLIENT_KEY", ""), "Private key for --upstream-client-cert (proxy mode)")
+	fs.BoolVar(&cfg.UpstreamInsecureSkipVerify, "upstream-insecure-skip-verify", envOrDefaultBool("REP_GATEWAY_UPSTREAM_INSECURE_SKIP_VERIFY", false), "Do not verify the https upstream's certificate (proxy mode; testing only)")
+	fs.IntVar(&cfg.MaxRequestBody, "max-request-body", envOrDefaultInt("REP_GATEWAY_MAX_REQUEST_BODY", 0), "Max request body size in bytes forwarded to the upstream; larger requests get 413 (0 = unlimited; proxy mode)")
+	fs.IntVar(&cfg.UpstreamMaxIdleConns, "upstream-max-idle-conns", envOrDefaultInt("REP_GATEWAY_UPSTREAM_MAX_IDLE_CONNS", DefaultUpstreamMaxIdleConns), "Max idle upstream connections kept open (0 = unlimited; proxy mode)")
+	fs.IntVar(&cfg.UpstreamMaxIdleConnsPerHost, "upstream-max-idle-conns-per-host", envOrDefaultInt("REP_GATEWAY_UPSTREAM_MAX_IDLE_CONNS_PER_HOST", DefaultUpstreamMaxIdleConnsPerHost), "Max idle connections kept open per upstream host (proxy mode)")
+	upstreamIdleTimeout := fs.String("upstream-idle-conn-timeout", envOrDefault("REP_GATEWAY_UPSTREAM_IDLE_CONN_TIMEOUT", DefaultUpstreamIdleConnTimeout.String()), "How long an idle upstream connection is kept open (0 = no limit; proxy mode)")
+	fs.BoolVar(&cfg.UpstreamHTTP2, "upstream-http2", envOrDefaultBool("REP_GATEWAY_UPSTREAM_HTTP2", true), "Attempt HTTP/2 with https upstreams (proxy mode)")
+	fs.BoolVar(&cfg.EarlyHints, "early-hints", envOrDefaultBool("REP_GATEWAY_EARLY_HINTS", false), "Send 103 Early Hints for /rep/session-key to HTTP/2 clients (proxy mode)")
 	fs.IntVar(&cfg.Port, "port", envOrDefaultInt("REP_GATEWAY_PORT", 8080), "Listen port")
 	fs.StringVar(&cfg.StaticDir, "static-dir", envOrDefault("REP_GATEWAY_STATIC_DIR", "/usr/share/nginx/html"), "Static file directory (embedded mode)")
-	fs.StringVar(&cfg.ManifestPath, "manifest", envOrDefault("REP_GATEWAY_MANIFEST", manifestPath), "Path to .rep.yaml manifest")
+	fs.StringVar(&cfg.ErrorPageDir, "error-page-dir", envOrDefault("REP_GATEWAY_ERROR_PAGE_DIR", ""), "Directory with 404.html/500.html custom error pages (embedded mode)")
+	fs.BoolVar(&cfg.Maintenance, "maintenance", envOrDefaultBool("REP_GATEWAY_MAINTENANCE", false), "Serve the maintenance page with 503 for all non-/rep/* requests")
+	fs.StringVar(&cfg.MaintenancePage, "maintenance-page", envOrDefault("REP_GATEWAY_MAINTENANCE_PAGE", ""), "HTML file served in maintenance mode (default: a built-in page)")
+	fs.StringVar(&cfg.MaintenanceFile, "maintenance-file", envOrDefault("REP_GATEWAY_MAINTENANCE_FILE", ""), "Enable maintenance mode while this file exists; re-checked on every reload")
+	fs.BoolVar(&cfg.StaticFollowSymlinks, "static-follow-symlinks", envOrDefaultBool("REP_GATEWAY_STATIC_FOLLOW_SYMLINKS", false), "Serve files through symlinks that resolve outside --static-dir (embedded mode)")
+	spaPaths := fs.String("spa-paths", envOrDefault("REP_GATEWAY_SPA_PATHS", ""), "Comma-separated path prefixes that fall back to their index.html; others 404 normally (embedded mode, default: all paths)")
+	fs.BoolVar(&cfg.DisableDirRedirects, "disable-dir-redirects", envOrDefaultBool("REP_GATEWAY_DISABLE_DIR_REDIRECTS", false), "Serve directory index.html directly instead of redirecting to a trailing slash (embedded mode)")
+	fs.StringVar(&cfg.ManifestPath, "manifest", envOrDefault("REP_GATEWAY_MANIFEST", manifestPath), `Path to .rep.yaml manifest, "-" for stdin, or an http(s) URL`)
+	fs.StringVar(&cfg.ManifestSHA256, "manifest-sha256", manifestSHA256, "Hex SHA-256 digest the manifest must match (e.g. when fetched from a URL)")
+	fs.IntVar(&cfg.ManifestMaxSize, "manifest-max-size", envOrDefaultInt("REP_GATEWAY_MANIFEST_MAX_SIZE", maxSize), "Max manifest size in bytes, per file with extends:")
+	fs.IntVar(&cfg.ManifestMaxLineLength, "manifest-max-line-length", envOrDefaultInt("REP_GATEWAY_MANIFEST_MAX_LINE_LENGTH", maxLineLength), "Max length in bytes of one manifest line")
+	fs.StringVar(&cfg.EnvName, "env-name", envOrDefault("REP_GATEWAY_ENV", envName), "Manifest environment section to apply (e.g. production)")
+	fs.StringVar(&cfg.Environment, "environment", envOrDefault("REP_GATEWAY_ENVIRONMENT", defaultEnvironment), "Deployment environment label exposed as _meta.environment (default: manifest environment setting, then --env-name)")
 	fs.BoolVar(&cfg.Strict, "strict", envOrDefaultBool("REP_GATEWAY_STRICT", defaultStrict), "Exit on guardrail warnings")
+	fs.IntVar(&cfg.GuardrailMaxScan, "guardrail-max-scan", envOrDefaultInt("REP_GATEWAY_GUARDRAIL_MAX_SCAN", 4096), "Max bytes of each value scanned by guardrail checks (longer values are sampled)")
+	fs.StringVar(&cfg.GuardrailOutput, "guardrail-output", envOrDefault("REP_GATEWAY_GUARDRAIL_OUTPUT", ""), `Scan variables, print guardrail findings in this format ("sarif") and exit`)
+	requiredGrace := fs.String("required-grace", envOrDefault("REP_GATEWAY_REQUIRED_GRACE", "0s"), "How long to wait for missing required variables at startup")
 	fs.BoolVar(&cfg.HotReload, "hot-reload", envOrDefaultBool("REP_GATEWAY_HOT_RELOAD", defaultHotReload), "Enable hot reload SSE endpoint")
 	fs.StringVar(&cfg.HotReloadMode, "hot-reload-mode", envOrDefault("REP_GATEWAY_HOT_RELOAD_MODE", defaultHotReloadMode), `Hot reload mode: "file_watch", "signal", or "poll"`)
 	fs.StringVar(&cfg.WatchPath, "watch-path", envOrDefault("REP_GATEWAY_WATCH_PATH", ""), "Path to watch for config changes (file_watch mode)")
 	fs.StringVar(&cfg.EnvFile, "env-file", envOrDefault("REP_GATEWAY_ENV_FILE", ""), "Path to .env file to read variables from (re-read on hot reload)")
+	fs.StringVar(&cfg.EnvDir, "env-dir", envOrDefault("REP_GATEWAY_ENV_DIR", ""), "Directory of files (one per variable) to read variables from (re-read on hot reload)")
 	pollInterval := fs.String("poll-interval", envOrDefault("REP_GATEWAY_POLL_INTERVAL", defaultPollInterval), "Poll interval (poll mode)")
+	fs.BoolVar(&cfg.SniffContentType, "sniff-content-type", envOrDefaultBool("REP_GATEWAY_SNIFF_CONTENT_TYPE", false), "Sniff response bodies for HTML when Content-Type is missing or generic")
+	fs.StringVar(&cfg.BaseHref, "base-href", envOrDefault("REP_GATEWAY_BASE_HREF", ""), "Inject <base href> into HTML (for apps served under a path prefix)")
+	fs.BoolVar(&cfg.BaseHrefReplace, "base-href-replace", envOrDefaultBool("REP_GATEWAY_BASE_HREF_REPLACE", false), "Replace an existing <base> element instead of leaving it")
+	fs.BoolVar(&cfg.EncryptPublic, "encrypt-public", envOrDefaultBool("REP_GATEWAY_ENCRYPT_PUBLIC", false), "Encrypt PUBLIC variables too; clients read them after fetching a session key")
+	fs.StringVar(&cfg.KeyFile, "key-file", envOrDefault("REP_GATEWAY_KEY_FILE", ""), "File with a base64 master seed for keys shared across replicas (default: ephemeral keys)")
+	fs.StringVar(&cfg.ScriptTemplate, "script-template", envOrDefault("REP_GATEWAY_SCRIPT_TEMPLATE", ""), "Template file for the injected script block ({{.JSON}}, {{.SRI}}, {{.Version}}); must keep id=\"__rep__\"")
+	fs.StringVar(&cfg.ServerHeader, "server-header", envOrDefault("REP_GATEWAY_SERVER_HEADER", "rep-gateway"), `Server header on gateway-originated responses ("none" to omit)`)
+	fs.StringVar(&cfg.ReplicaID, "replica-id", envOrDefault("REP_GATEWAY_REPLICA_ID", ""), "Replica identifier checked by /rep/session-key against the payload that advertised it")
+	fs.BoolVar(&cfg.MetaTypes, "meta-types", envOrDefaultBool("REP_GATEWAY_META_TYPES", false), "Include PUBLIC variables' manifest types in the payload as _meta.types")
+	fs.BoolVar(&cfg.PublicHeaders, "public-headers", envOrDefaultBool("REP_GATEWAY_PUBLIC_HEADERS", false), "Also emit PUBLIC variables as X-REP-<NAME> headers on HTML responses")
+	fs.StringVar(&cfg.CSPReportOnly, "csp-report-only", envOrDefault("REP_GATEWAY_CSP_REPORT_ONLY", ""), "Content-Security-Policy-Report-Only policy added to HTML responses")
+	fs.StringVar(&cfg.CSPReportURI, "csp-report-uri", envOrDefault("REP_GATEWAY_CSP_REPORT_URI", ""), "URL that --csp-report-only violations are reported to (report-uri and report-to)")
+	fs.BoolVar(&cfg.ValidatePayload, "validate-payload", envOrDefaultBool("REP_GATEWAY_VALIDATE_PAYLOAD", false), "Validate the generated payload against the REP payload schema at startup")
+	fs.StringVar(&cfg.NotReadyPolicy, "not-ready-policy", envOrDefault("REP_GATEWAY_NOT_READY_POLICY", "fail-open"), `Injection while not ready: "fail-open" or "fail-closed"`)
+	fs.IntVar(&cfg.InjectLogSample, "inject-log-sample", envOrDefaultInt("REP_GATEWAY_INJECT_LOG_SAMPLE", 1), "Log 1 in N per-request injection debug lines")
+	injectLogSummary := fs.String("inject-log-summary", envOrDefault("REP_GATEWAY_INJECT_LOG_SUMMARY", "0s"), "Interval for an aggregate injection count/bytes log line (0 = off)")
+	injectStatus := fs.String("inject-status", envOrDefault("REP_GATEWAY_INJECT_STATUS", "2xx"), `Comma-separated response statuses buffered for injection, e.g. "2xx,404"; others are streamed through`)
+	fs.IntVar(&cfg.InjectMaxConcurrent, "inject-max-concurrent", envOrDefaultInt("REP_GATEWAY_INJECT_MAX_CONCURRENT", 0), "Max HTML responses buffered for injection at once; excess GET/HEAD documents get 503, other methods wait (0 = unlimited)")
 	fs.StringVar(&cfg.LogFormat, "log-format", envOrDefault("REP_GATEWAY_LOG_FORMAT", "json"), `Log format: "json" or "text"`)
 	fs.StringVar(&cfg.LogLevelStr, "log-level", envOrDefault("REP_GATEWAY_LOG_LEVEL", "info"), `Log level: "debug", "info", "warn", "error"`)
-	originsStr := fs.String("allowed-origins", envOrDefault("REP_GATEWAY_ALLOWED_ORIGINS", defaultAllowedOrigins), "Comma-separated allowed CORS origins for /rep/session-key")
+	methodsStr := fs.String("allowed-methods", envOrDefault("REP_GATEWAY_ALLOWED_METHODS", ""), "Comma-separated request methods passed to the upstream (default: all in proxy mode, GET,HEAD in embedded mode)")
+	originsStr := fs.String("allowed-origins", envOrDefault("REP_GATEWAY_ALLOWED_ORIGINS", defaultAllowedOrigins), "Comma-separated allowed CORS origins for /rep/* endpoints")
+	fs.StringVar(&cfg.AllowedOriginsFile, "allowed-origins-file", envOrDefault("REP_GATEWAY_ALLOWED_ORIGINS_FILE", ""), "File of allowed CORS origins, one per line, re-read on reload")
 	fs.StringVar(&cfg.TLSCert, "tls-cert", envOrDefault("REP_GATEWAY_TLS_CERT", ""), "TLS certificate path")
 	fs.StringVar(&cfg.TLSKey, "tls-key", envOrDefault("REP_GATEWAY_TLS_KEY", ""), "TLS private key path")
 	fs.IntVar(&cfg.HealthPort, "health-port", envOrDefaultInt("REP_GATEWAY_HEALTH_PORT", 0), "Separate health check port (0 = same as main)")
+	fs.StringVar(&cfg.AuditFile, "audit-file", envOrDefault("REP_GATEWAY_AUDIT_FILE", ""), "Append security-relevant events to this file as JSON Lines")
+	fs.IntVar(&cfg.AuditMaxSize, "audit-max-size", envOrDefaultInt("REP_GATEWAY_AUDIT_MAX_SIZE", 10<<20), "Audit file size in bytes at which it is rotated to <file>.1")
+	fs.BoolVar(&cfg.DebugEndpoint, "debug-endpoint", envOrDefaultBool("REP_GATEWAY_DEBUG_ENDPOINT", false), "Serve runtime statistics at /rep/debug/vars (requires REP_GATEWAY_DEBUG_TOKEN)")
+	fs.BoolVar(&cfg.EnablePprof, "enable-pprof", envOrDefaultBool("REP_GATEWAY_ENABLE_PPROF", false), "Serve pprof profiles under /rep/debug/pprof/ on --pprof-addr")
+	fs.StringVar(&cfg.PprofAddr, "pprof-addr", envOrDefault("REP_GATEWAY_PPROF_ADDR", "localhost:6060"), "Listen address for --enable-pprof (keep it off public interfaces)")
+	fs.BoolVar(&cfg.ConfigEndpoint, "config-endpoint", envOrDefaultBool("REP_GATEWAY_CONFIG_ENDPOINT", false), "Serve the effective configuration at /rep/config/effective (secrets redacted)")
 	sessionTTL := fs.String("session-key-ttl", envOrDefault("REP_GATEWAY_SESSION_KEY_TTL", defaultSessionTTL), "Session key TTL")
+	sessionSkew := fs.String("session-key-skew", envOrDefault("REP_GATEWAY_SESSION_KEY_SKEW", "0s"), "Clock skew buffer added to the advertised session key expires_at")
 	fs.IntVar(&cfg.SessionKeyMaxRate, "session-key-max-rate", envOrDefaultInt("REP_GATEWAY_SESSION_KEY_MAX_RATE", defaultSessionMaxRate), "Session key max requests/min/IP")
+	fs.IntVar(&cfg.SSEMaxPerIP, "sse-max-per-ip", envOrDefaultInt("REP_GATEWAY_SSE_MAX_PER_IP", 0), "Max concurrent /rep/changes connections per client IP (0 = unlimited)")
+	fs.BoolVar(&cfg.SSEGzip, "sse-gzip", envOrDefaultBool("REP_GATEWAY_SSE_GZIP", false), "Gzip /rep/changes streams for clients that accept it")
+	fs.IntVar(&cfg.SSEBuffer, "sse-buffer", envOrDefaultInt("REP_GATEWAY_SSE_BUFFER", 16), "Hot reload events queued per /rep/changes client before events are dropped for it")
+	fs.IntVar(&cfg.SSEReplay, "sse-replay", envOrDefaultInt("REP_GATEWAY_SSE_REPLAY", 64), "Recent hot reload events kept for replay to clients reconnecting with Last-Event-ID (0 = no replay)")
+	sseReplayMaxAge := fs.String("sse-replay-max-age", envOrDefault("REP_GATEWAY_SSE_REPLAY_MAX_AGE", "5m"), "How long hot reload events are kept for replay; older gaps get a reload event (0s = no age limit)")
+	rateLimits := fs.String("rate-limits", envOrDefault("REP_GATEWAY_RATE_LIMITS", defaultRateLimits), `Per-IP requests/min for /rep/* endpoints, e.g. "changes=30,health=120" (health, ready, compat, changes)`)
 	fs.BoolVar(&cfg.ShowVersion, "version", false, "Print version and exit")
 
 	if err := fs.Parse(args); err != nil {
@@ -169,7 +518,6 @@ func Parse(args []string, version string) (*Config, error) {
 	}
 
 	// Parse durations.
-	var err error
 	cfg.PollInterval, err = time.ParseDuration(*pollInterval)
 	if err != nil {
 		return nil, fmt.Errorf("invalid poll-interval %q: %w", *pollInterval, err)
@@ -178,6 +526,50 @@ func Parse(args []string, version string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("invalid session-key-ttl %q: %w", *sessionTTL, err)
 	}
+	cfg.SessionKeySkew, err = time.ParseDuration(*sessionSkew)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session-key-skew %q: %w", *sessionSkew, err)
+	}
+	if cfg.SessionKeySkew < 0 {
+		return nil, fmt.Errorf("invalid session-key-skew %q: must not be negative", *sessionSkew)
+	}
+	cfg.SSEReplayMaxAge, err = time.ParseDuration(*sseReplayMaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sse-replay-max-age %q: %w", *sseReplayMaxAge, err)
+	}
+	if cfg.SSEReplayMaxAge < 0 {
+		return nil, fmt.Errorf("invalid sse-replay-max-age %q: must not be negative", *sseReplayMaxAge)
+	}
+	cfg.RequiredGrace, err = time.ParseDuration(*requiredGrace)
+	if err != nil {
+		return nil, fmt.Errorf("invalid required-grace %q: %w", *requiredGrace, err)
+	}
+
+	cfg.UpstreamIdleConnTimeout, err = time.ParseDuration(*upstreamIdleTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream-idle-conn-timeout %q: %w", *upstreamIdleTimeout, err)
+	}
+	if cfg.UpstreamIdleConnTimeout < 0 {
+		return nil, fmt.Errorf("invalid upstream-idle-conn-timeout %q: must not be negative", *upstreamIdleTimeout)
+	}
+	if cfg.UpstreamMaxIdleConns < 0 {
+		return nil, fmt.Errorf("invalid upstream-max-idle-conns %d: must not be negative", cfg.UpstreamMaxIdleConns)
+	}
+	if cfg.UpstreamMaxIdleConnsPerHost <= 0 {
+		return nil, fmt.Errorf("invalid upstream-max-idle-conns-per-host %d: must be positive", cfg.UpstreamMaxIdleConnsPerHost)
+	}
+	// An explicit 0 means no limit; zero fields mean the default.
+	if cfg.UpstreamMaxIdleConns == 0 {
+		cfg.UpstreamMaxIdleConns = -1
+	}
+	if cfg.UpstreamIdleConnTimeout == 0 {
+		cfg.UpstreamIdleConnTimeout = -1
+	}
+
+	cfg.InjectLogSummary, err = time.ParseDuration(*injectLogSummary)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inject-log-summary %q: %w", *injectLogSummary, err)
+	}
 
 	// Parse origins.
 	if *originsStr != "" {
@@ -187,11 +579,188 @@ func Parse(args []string, version string) (*Config, error) {
 		}
 	}
 
+	cfg.RateLimits, err = parseRateLimits(*rateLimits)
+	if err != nil {
+		return nil, err
+	}
+
 	// Validate mode.
 	if cfg.Mode != "proxy" && cfg.Mode != "embedded" {
 		return nil, fmt.Errorf("invalid mode %q: must be \"proxy\" or \"embedded\"", cfg.Mode)
 	}
 
+	// Parse allowed methods; a file server only answers GET and HEAD.
+	if *methodsStr != "" {
+		for _, m := range strings.Split(*methodsStr, ",") {
+			m = strings.ToUpper(strings.TrimSpace(m))
+			if m == "" {
+				continue
+			}
+			cfg.AllowedMethods = append(cfg.AllowedMethods, m)
+		}
+	} else if cfg.Mode == "embedded" {
+		cfg.AllowedMethods = []string{"GET", "HEAD"}
+	}
+
+	if cfg.StripPrefix != "" {
+		if cfg.Mode != "proxy" {
+			return nil, fmt.Errorf("strip-prefix is only supported in proxy mode")
+		}
+		if !strings.HasPrefix(cfg.StripPrefix, "/") {
+			return nil, fmt.Errorf("invalid strip-prefix %q: must start with \"/\"", cfg.StripPrefix)
+		}
+	}
+
+	if cfg.UpstreamCA != "" || cfg.UpstreamClientCert != "" || cfg.UpstreamClientKey != "" || cfg.UpstreamInsecureSkipVerify {
+		if cfg.Mode != "proxy" {
+			return nil, fmt.Errorf("upstream TLS settings are only supported in proxy mode")
+		}
+		if (cfg.UpstreamClientCert == "") != (cfg.UpstreamClientKey == "") {
+			return nil, fmt.Errorf("upstream-client-cert and upstream-client-key must be set together")
+		}
+	}
+
+	if cfg.MaxRequestBody < 0 {
+		return nil, fmt.Errorf("invalid max-request-body %d: must not be negative", cfg.MaxRequestBody)
+	}
+	if cfg.MaxRequestBody > 0 && cfg.Mode != "proxy" {
+		return nil, fmt.Errorf("max-request-body is only supported in proxy mode")
+	}
+
+	if cfg.EarlyHints && cfg.Mode != "proxy" {
+		return nil, fmt.Errorf("early-hints is only supported in proxy mode")
+	}
+
+	if cfg.ErrorPageDir != "" && cfg.Mode != "embedded" {
+		return nil, fmt.Errorf("error-page-dir is only supported in embedded mode")
+	}
+
+	if cfg.CSPReportURI != "" && cfg.CSPReportOnly == "" {
+		return nil, fmt.Errorf("csp-report-uri requires csp-report-only")
+	}
+
+	cfg.KeySeed = os.Getenv("REP_GATEWAY_KEY_SEED")
+	if cfg.KeySeed != "" {
+		if cfg.KeyFile != "" {
+			return nil, fmt.Errorf("REP_GATEWAY_KEY_SEED cannot be combined with key-file")
+		}
+		if _, err := repcrypto.ParseSeed(cfg.KeySeed); err != nil {
+			return nil, fmt.Errorf("invalid REP_GATEWAY_KEY_SEED: %w", err)
+		}
+	}
+
+	cfg.DebugToken = os.Getenv("REP_GATEWAY_DEBUG_TOKEN")
+	if cfg.DebugEndpoint && cfg.DebugToken == "" {
+		return nil, fmt.Errorf("debug-endpoint requires REP_GATEWAY_DEBUG_TOKEN")
+	}
+
+	if cfg.EnablePprof {
+		_, port, err := net.SplitHostPort(cfg.PprofAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pprof-addr %q: %w", cfg.PprofAddr, err)
+		}
+		if port == strconv.Itoa(cfg.Port) || (cfg.HealthPort > 0 && port == strconv.Itoa(cfg.HealthPort)) {
+			return nil, fmt.Errorf("pprof-addr %q must not share the gateway or health port", cfg.PprofAddr)
+		}
+	}
+
+	if cfg.MetaTypes && cfg.Manifest == nil {
+		return nil, fmt.Errorf("meta-types requires a manifest")
+	}
+
+	if cfg.MetaTypes && cfg.EncryptPublic {
+		return nil, fmt.Errorf("meta-types cannot be combined with encrypt-public")
+	}
+
+	if cfg.PublicHeaders && cfg.EncryptPublic {
+		return nil, fmt.Errorf("public-headers cannot be combined with encrypt-public")
+	}
+
+	// An environment label derived from --env-name is sanitized rather than
+	// rejected: --env-name predates the label rules, and deployments whose
+	// section names contain spaces or slashes must keep starting.
+	environmentSet := os.Getenv("REP_GATEWAY_ENVIRONMENT") != ""
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "environment" {
+			environmentSet = true
+		}
+	})
+	if !environmentSet && envName != "" && cfg.Environment == envName && !labelPattern.MatchString(envName) {
+		cfg.Environment = sanitizeLabel(envName)
+		cfg.Warnings = append(cfg.Warnings, fmt.Sprintf("env-name %q is not a valid environment label; _meta.environment is %q", envName, cfg.Environment))
+	}
+	if cfg.Environment != "" && !labelPattern.MatchString(cfg.Environment) {
+		return nil, fmt.Errorf("invalid environment %q: must be a label of up to 64 letters, digits, '.', '_' or '-'", cfg.Environment)
+	}
+
+	if cfg.SSEReplay < 0 {
+		return nil, fmt.Errorf("invalid sse-replay %d: must not be negative", cfg.SSEReplay)
+	}
+	if cfg.SSEBuffer <= 0 {
+		return nil, fmt.Errorf("invalid sse-buffer %d: must be positive", cfg.SSEBuffer)
+	}
+	if cfg.AuditMaxSize <= 0 {
+		return nil, fmt.Errorf("invalid audit-max-size %d: must be positive", cfg.AuditMaxSize)
+	}
+
+	if strings.EqualFold(cfg.ServerHeader, "none") {
+		cfg.ServerHeader = ""
+	}
+	if strings.ContainsFunc(cfg.ServerHeader, unicode.IsControl) {
+		return nil, fmt.Errorf("invalid server-header %q: must not contain control characters", cfg.ServerHeader)
+	}
+
+	if cfg.ReplicaID != "" && !labelPattern.MatchString(cfg.ReplicaID) {
+		return nil, fmt.Errorf("invalid replica-id %q: must be a label of up to 64 letters, digits, '.', '_' or '-'", cfg.ReplicaID)
+	}
+
+	if cfg.DisableDirRedirects && cfg.Mode != "embedded" {
+		return nil, fmt.Errorf("disable-dir-redirects is only supported in embedded mode")
+	}
+
+	if cfg.StaticFollowSymlinks && cfg.Mode != "embedded" {
+		return nil, fmt.Errorf("static-follow-symlinks is only supported in embedded mode")
+	}
+
+	for _, s := range strings.Split(*injectStatus, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			cfg.InjectStatus = append(cfg.InjectStatus, s)
+		}
+	}
+
+	if *spaPaths != "" {
+		if cfg.Mode != "embedded" {
+			return nil, fmt.Errorf("spa-paths is only supported in embedded mode")
+		}
+		for _, p := range strings.Split(*spaPaths, ",") {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			if !strings.HasPrefix(p, "/") {
+				return nil, fmt.Errorf("invalid spa-paths entry %q: must start with \"/\"", p)
+			}
+			if p != "/" {
+				p = strings.TrimSuffix(p, "/")
+			}
+			cfg.SPAPaths = append(cfg.SPAPaths, p)
+		}
+	}
+
+	switch cfg.GuardrailOutput {
+	case "", "sarif":
+		// OK.
+	default:
+		return nil, fmt.Errorf("invalid guardrail-output %q: must be \"sarif\"", cfg.GuardrailOutput)
+	}
+
+	switch cfg.NotReadyPolicy {
+	case "fail-open", "fail-closed":
+		// OK.
+	default:
+		return nil, fmt.Errorf("invalid not-ready-policy %q: must be \"fail-open\" or \"fail-closed\"", cfg.NotReadyPolicy)
+	}
+
 	// Validate hot reload mode.
 	switch cfg.HotReloadMode {
 	case "file_watch", "signal", "poll":
@@ -203,17 +772,88 @@ func Parse(args []string, version string) (*Config, error) {
 	return cfg, nil
 }
 
-// prescanManifestFlag scans args for --manifest or -manifest (flag or flag=value form)
+// labelPattern restricts --environment and --replica-id to short labels.
+// Both are published in every page, so they are never taken from a variable
+// and cannot carry anything secret-shaped.
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// sanitizeLabel maps s onto labelPattern: characters outside [A-Za-z0-9._-]
+// become '-', leading characters that cannot start a label are dropped, and
+// the result is cut to 64 characters. It returns "" if nothing is left.
+func sanitizeLabel(s string) string {
+	label := strings.Map(func(r rune) rune {
+		switch {
+		case r == '.', r == '_', r == '-', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		}
+		return '-'
+	}, s)
+	label = strings.TrimLeft(label, "._-")
+	if len(label) > 64 {
+		label = label[:64]
+	}
+	return label
+}
+
+// rateLimitEndpoints are the /rep/ endpoints --rate-limits applies to. The
+// session key endpoint has its own --session-key-max-rate.
+var rateLimitEndpoints = []string{"health", "ready", "compat", "changes"}
+
+// parseRateLimits parses a comma-separated "endpoint=N" list.
+func parseRateLimits(s string) (map[string]int, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	limits := make(map[string]int)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(entry, "=")
+		name = strings.TrimPrefix(strings.TrimSpace(name), "/rep/")
+		n, err := strconv.Atoi(strings.TrimSpace(val))
+		if !ok || err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid rate-limits entry %q: expected endpoint=N with N > 0", entry)
+		}
+		if !slices.Contains(rateLimitEndpoints, name) {
+			return nil, fmt.Errorf("invalid rate-limits endpoint %q: must be one of %s (use --session-key-max-rate for session-key)",
+				name, strings.Join(rateLimitEndpoints, ", "))
+		}
+		limits[name] = n
+	}
+	return limits, nil
+}
+
+// prescanInt is prescanFlag for a positive integer flag, falling back to
+// the env variable and then def. The flag is registered again in phase 3
+// so that it shows in usage.
+func prescanInt(args []string, name, env string, def int) (int, error) {
+	v := prescanFlag(args, name)
+	if v == "" {
+		v = os.Getenv(env)
+	}
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive integer", name, v)
+	}
+	return n, nil
+}
+
+// prescanFlag scans args for --name or -name (flag or flag=value form)
 // without going through the full flag.FlagSet (which would reject unknown flags).
-func prescanManifestFlag(args []string) string {
+func prescanFlag(args []string, name string) string {
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
-		for _, prefix := range []string{"--manifest=", "-manifest="} {
+		for _, prefix := range []string{"--" + name + "=", "-" + name + "="} {
 			if strings.HasPrefix(arg, prefix) {
 				return strings.TrimPrefix(arg, prefix)
 			}
 		}
-		if (arg == "--manifest" || arg == "-manifest") && i+1 < len(args) {
+		if (arg == "--"+name || arg == "-"+name) && i+1 < len(args) {
 			return args[i+1]
 		}
 	}
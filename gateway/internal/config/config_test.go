@@ -1,8 +1,16 @@
 package config
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParse_Defaults(t *testing.T) {
@@ -95,6 +103,374 @@ func TestParse_InvalidHotReloadMode(t *testing.T) {
 	}
 }
 
+func TestParse_ErrorPageDirRequiresEmbedded(t *testing.T) {
+	if _, err := Parse([]string{"--error-page-dir", "/pages"}, "0.1.0"); err == nil {
+		t.Fatal("expected error for error-page-dir in proxy mode")
+	}
+
+	cfg, err := Parse([]string{"--mode", "embedded", "--error-page-dir", "/pages"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ErrorPageDir != "/pages" {
+		t.Errorf("expected ErrorPageDir=/pages, got %q", cfg.ErrorPageDir)
+	}
+}
+
+func TestParse_DisableDirRedirectsRequiresEmbedded(t *testing.T) {
+	if _, err := Parse([]string{"--disable-dir-redirects"}, "0.1.0"); err == nil {
+		t.Fatal("expected error for disable-dir-redirects in proxy mode")
+	}
+
+	cfg, err := Parse([]string{"--mode", "embedded", "--disable-dir-redirects"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.DisableDirRedirects {
+		t.Error("expected DisableDirRedirects=true")
+	}
+}
+
+func TestParse_PublicHeadersConflictsWithEncryptPublic(t *testing.T) {
+	if _, err := Parse([]string{"--public-headers", "--encrypt-public"}, "0.1.0"); err == nil {
+		t.Fatal("expected error for public-headers with encrypt-public")
+	}
+}
+
+func TestParse_CSPReportURIRequiresPolicy(t *testing.T) {
+	if _, err := Parse([]string{"--csp-report-uri", "https://csp.example.com"}, "0.1.0"); err == nil {
+		t.Fatal("expected error for csp-report-uri without csp-report-only")
+	}
+}
+
+func TestParse_GuardrailOutput(t *testing.T) {
+	cfg, err := Parse([]string{"--guardrail-output", "sarif"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.GuardrailOutput != "sarif" {
+		t.Errorf("expected GuardrailOutput=sarif, got %q", cfg.GuardrailOutput)
+	}
+
+	if _, err := Parse([]string{"--guardrail-output", "xml"}, "0.1.0"); err == nil {
+		t.Fatal("expected error for invalid guardrail-output")
+	}
+}
+
+func TestParse_StripPrefix(t *testing.T) {
+	cfg, err := Parse([]string{"--strip-prefix", "/app"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.StripPrefix != "/app" {
+		t.Errorf("expected StripPrefix=/app, got %q", cfg.StripPrefix)
+	}
+
+	if _, err := Parse([]string{"--strip-prefix", "app"}, "0.1.0"); err == nil {
+		t.Error("expected error for prefix without leading slash")
+	}
+	if _, err := Parse([]string{"--mode", "embedded", "--strip-prefix", "/app"}, "0.1.0"); err == nil {
+		t.Error("expected error for strip-prefix in embedded mode")
+	}
+}
+
+func TestParse_EarlyHints(t *testing.T) {
+	t.Setenv("REP_GATEWAY_EARLY_HINTS", "true")
+	cfg, err := Parse([]string{}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.EarlyHints {
+		t.Error("expected EarlyHints=true from env")
+	}
+
+	if _, err := Parse([]string{"--mode", "embedded"}, "0.1.0"); err == nil {
+		t.Error("expected error for early-hints in embedded mode")
+	}
+}
+
+func TestParse_Environment(t *testing.T) {
+	cfg, err := Parse([]string{}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Environment != "" {
+		t.Errorf("expected no environment by default, got %q", cfg.Environment)
+	}
+
+	path := filepath.Join(t.TempDir(), ".rep.yaml")
+	content := `version: "0.1.0"
+settings:
+  environment: "base"
+environments:
+  production:
+    settings:
+      environment: "production"
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = Parse([]string{"--manifest", path}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Environment != "base" {
+		t.Errorf("expected manifest environment=base, got %q", cfg.Environment)
+	}
+
+	cfg, err = Parse([]string{"--manifest", path, "--env-name", "production"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Environment != "production" {
+		t.Errorf("expected production section's environment, got %q", cfg.Environment)
+	}
+
+	cfg, err = Parse([]string{"--env-name", "qa"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Environment != "qa" {
+		t.Errorf("expected environment derived from env-name without a manifest, got %q", cfg.Environment)
+	}
+
+	cfg, err = Parse([]string{"--manifest", path, "--env-name", "production", "--environment", "prod-eu.1"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Environment != "prod-eu.1" {
+		t.Errorf("expected explicit environment to win, got %q", cfg.Environment)
+	}
+
+	for _, bad := range []string{"prod eu", "sk_live=abc/def+ghi", strings.Repeat("a", 65)} {
+		if _, err := Parse([]string{"--environment", bad}, "0.1.0"); err == nil {
+			t.Errorf("expected error for environment %q", bad)
+		}
+	}
+
+	// An --env-name that is not a valid label still starts, with the
+	// derived environment sanitized and a warning.
+	cfg, err = Parse([]string{"--env-name", "eu west/prod"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("expected env-name with spaces and slashes to be accepted, got %v", err)
+	}
+	if cfg.EnvName != "eu west/prod" || cfg.Environment != "eu-west-prod" {
+		t.Errorf("expected env-name kept and environment sanitized, got %q and %q", cfg.EnvName, cfg.Environment)
+	}
+	if len(cfg.Warnings) != 1 || !strings.Contains(cfg.Warnings[0], `"eu-west-prod"`) {
+		t.Errorf("expected a sanitization warning, got %v", cfg.Warnings)
+	}
+}
+
+func TestParse_MetaTypes(t *testing.T) {
+	if _, err := Parse([]string{"--meta-types"}, "0.1.0"); err == nil {
+		t.Error("expected error for meta-types without a manifest")
+	}
+
+	path := filepath.Join(t.TempDir(), ".rep.yaml")
+	if err := os.WriteFile(path, []byte("version: \"0.1.0\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Parse([]string{"--manifest", path, "--meta-types"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.MetaTypes {
+		t.Error("expected MetaTypes=true")
+	}
+
+	if _, err := Parse([]string{"--manifest", path, "--meta-types", "--encrypt-public"}, "0.1.0"); err == nil {
+		t.Error("expected error for meta-types with encrypt-public")
+	}
+}
+
+func TestParse_AuditMaxSize(t *testing.T) {
+	cfg, err := Parse([]string{"--audit-file", "/var/log/rep/audit.jsonl"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.AuditMaxSize != 10<<20 {
+		t.Errorf("expected default audit-max-size of 10 MiB, got %d", cfg.AuditMaxSize)
+	}
+	if _, err := Parse([]string{"--audit-max-size", "0"}, "0.1.0"); err == nil {
+		t.Error("expected error for a zero audit-max-size")
+	}
+}
+
+func TestParse_UpstreamTLS(t *testing.T) {
+	cfg, err := Parse([]string{"--mode", "proxy", "--upstream", "https://app.internal", "--upstream-ca", "/etc/rep/ca.pem",
+		"--upstream-client-cert", "/etc/rep/client.pem", "--upstream-client-key", "/etc/rep/client.key"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.UpstreamCA != "/etc/rep/ca.pem" || cfg.UpstreamClientCert != "/etc/rep/client.pem" || cfg.UpstreamClientKey != "/etc/rep/client.key" {
+		t.Errorf("unexpected upstream TLS settings: %+v", cfg)
+	}
+
+	if _, err := Parse([]string{"--mode", "proxy", "--upstream-client-cert", "/etc/rep/client.pem"}, "0.1.0"); err == nil {
+		t.Error("expected error for a client certificate without a key")
+	}
+	if _, err := Parse([]string{"--mode", "embedded", "--upstream-insecure-skip-verify"}, "0.1.0"); err == nil {
+		t.Error("expected error for upstream TLS settings in embedded mode")
+	}
+}
+
+func TestParse_MaxRequestBody(t *testing.T) {
+	cfg, err := Parse([]string{"--mode", "proxy", "--max-request-body", "1048576"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.MaxRequestBody != 1<<20 {
+		t.Errorf("expected MaxRequestBody=1048576, got %d", cfg.MaxRequestBody)
+	}
+	if _, err := Parse([]string{"--mode", "proxy", "--max-request-body", "-1"}, "0.1.0"); err == nil {
+		t.Error("expected error for a negative max-request-body")
+	}
+	if _, err := Parse([]string{"--mode", "embedded", "--max-request-body", "1024"}, "0.1.0"); err == nil {
+		t.Error("expected error for max-request-body in embedded mode")
+	}
+}
+
+func TestParse_DebugEndpoint(t *testing.T) {
+	if _, err := Parse([]string{"--debug-endpoint"}, "0.1.0"); err == nil {
+		t.Error("expected error for debug-endpoint without a token")
+	}
+	t.Setenv("REP_GATEWAY_DEBUG_TOKEN", "d3bug-t0ken")
+	cfg, err := Parse([]string{"--debug-endpoint"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.DebugEndpoint || cfg.DebugToken != "d3bug-t0ken" {
+		t.Errorf("unexpected debug settings: endpoint=%t token=%q", cfg.DebugEndpoint, cfg.DebugToken)
+	}
+	if got := cfg.Effective()["DebugToken"]; got != "[REDACTED]" {
+		t.Errorf("expected DebugToken redacted in the effective config, got %v", got)
+	}
+}
+
+func TestParse_Pprof(t *testing.T) {
+	cfg, err := Parse([]string{"--enable-pprof"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.EnablePprof || cfg.PprofAddr != "localhost:6060" {
+		t.Errorf("expected pprof on localhost:6060, got enabled=%t addr=%q", cfg.EnablePprof, cfg.PprofAddr)
+	}
+	for _, addr := range []string{"6060", ":8080"} {
+		if _, err := Parse([]string{"--enable-pprof", "--pprof-addr", addr}, "0.1.0"); err == nil {
+			t.Errorf("expected error for pprof-addr %q", addr)
+		}
+	}
+}
+
+func TestParse_UpstreamTransport(t *testing.T) {
+	for _, args := range [][]string{
+		{"--upstream-max-idle-conns", "-1"},
+		{"--upstream-max-idle-conns-per-host", "0"},
+		{"--upstream-idle-conn-timeout", "-1s"},
+		{"--upstream-idle-conn-timeout", "soon"},
+	} {
+		if _, err := Parse(append([]string{"--mode", "proxy"}, args...), "0.1.0"); err == nil {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
+
+func TestParse_SessionKeySkew(t *testing.T) {
+	cfg, err := Parse([]string{"--session-key-skew", "5s"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.SessionKeySkew != 5*time.Second {
+		t.Errorf("expected SessionKeySkew=5s, got %s", cfg.SessionKeySkew)
+	}
+	for _, bad := range []string{"-1s", "soon"} {
+		if _, err := Parse([]string{"--session-key-skew", bad}, "0.1.0"); err == nil {
+			t.Errorf("expected error for session-key-skew %q", bad)
+		}
+	}
+}
+
+func TestParse_InjectStatus(t *testing.T) {
+	cfg, err := Parse([]string{}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.InjectStatus) != 1 || cfg.InjectStatus[0] != "2xx" {
+		t.Errorf("expected default InjectStatus=[2xx], got %v", cfg.InjectStatus)
+	}
+	cfg, err = Parse([]string{"--inject-status", "2xx, 404"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.InjectStatus) != 2 || cfg.InjectStatus[1] != "404" {
+		t.Errorf("expected InjectStatus=[2xx 404], got %v", cfg.InjectStatus)
+	}
+}
+
+func TestParse_ReplicaID(t *testing.T) {
+	cfg, err := Parse([]string{"--replica-id", "eu-west.2"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ReplicaID != "eu-west.2" {
+		t.Errorf("expected ReplicaID=eu-west.2, got %q", cfg.ReplicaID)
+	}
+	if _, err := Parse([]string{"--replica-id", "a&b"}, "0.1.0"); err == nil {
+		t.Error("expected error for an invalid replica-id")
+	}
+}
+
+func TestParse_KeySeed(t *testing.T) {
+	seed := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	t.Setenv("REP_GATEWAY_KEY_SEED", seed)
+	cfg, err := Parse([]string{}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.KeySeed != seed {
+		t.Errorf("expected KeySeed from env, got %q", cfg.KeySeed)
+	}
+	if _, err := Parse([]string{"--key-file", "/etc/rep/key"}, "0.1.0"); err == nil {
+		t.Error("expected error for key seed combined with key-file")
+	}
+
+	t.Setenv("REP_GATEWAY_KEY_SEED", base64.StdEncoding.EncodeToString([]byte("too short")))
+	if _, err := Parse([]string{}, "0.1.0"); err == nil {
+		t.Error("expected error for a short key seed")
+	}
+	t.Setenv("REP_GATEWAY_KEY_SEED", "not base64!")
+	if _, err := Parse([]string{}, "0.1.0"); err == nil {
+		t.Error("expected error for a non-base64 key seed")
+	}
+}
+
+func TestParse_AllowedMethods(t *testing.T) {
+	cfg, err := Parse([]string{}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.AllowedMethods != nil {
+		t.Errorf("expected all methods in proxy mode, got %v", cfg.AllowedMethods)
+	}
+
+	cfg, err = Parse([]string{"--mode", "embedded"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(cfg.AllowedMethods, ",") != "GET,HEAD" {
+		t.Errorf("expected GET,HEAD in embedded mode, got %v", cfg.AllowedMethods)
+	}
+
+	cfg, err = Parse([]string{"--allowed-methods", "get, post"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(cfg.AllowedMethods, ",") != "GET,POST" {
+		t.Errorf("expected GET,POST, got %v", cfg.AllowedMethods)
+	}
+}
+
 func TestParse_AllowedOrigins(t *testing.T) {
 	cfg, err := Parse([]string{"--allowed-origins", "https://a.com, https://b.com"}, "0.1.0")
 	if err != nil {
@@ -112,6 +488,104 @@ func TestParse_AllowedOrigins(t *testing.T) {
 	}
 }
 
+func TestParse_RateLimits(t *testing.T) {
+	cfg, err := Parse([]string{"--rate-limits", "changes=30, /rep/health=120"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.RateLimits["changes"] != 30 || cfg.RateLimits["health"] != 120 || len(cfg.RateLimits) != 2 {
+		t.Errorf("unexpected rate limits: %v", cfg.RateLimits)
+	}
+
+	for _, bad := range []string{"changes", "changes=0", "changes=x", "session-key=5", "metrics=5"} {
+		if _, err := Parse([]string{"--rate-limits", bad}, "0.1.0"); err == nil {
+			t.Errorf("expected error for rate-limits %q", bad)
+		}
+	}
+}
+
+func TestParse_SSEMaxPerIP(t *testing.T) {
+	t.Setenv("REP_GATEWAY_SSE_MAX_PER_IP", "3")
+	cfg, err := Parse([]string{}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.SSEMaxPerIP != 3 {
+		t.Errorf("expected SSEMaxPerIP=3 from env, got %d", cfg.SSEMaxPerIP)
+	}
+}
+
+func TestParse_SSEBuffer(t *testing.T) {
+	cfg, err := Parse([]string{}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.SSEBuffer != 16 {
+		t.Errorf("expected default sse-buffer of 16, got %d", cfg.SSEBuffer)
+	}
+	cfg, err = Parse([]string{"--sse-buffer", "128"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.SSEBuffer != 128 {
+		t.Errorf("expected SSEBuffer=128, got %d", cfg.SSEBuffer)
+	}
+	for _, bad := range []string{"0", "-1"} {
+		if _, err := Parse([]string{"--sse-buffer", bad}, "0.1.0"); err == nil {
+			t.Errorf("expected error for sse-buffer %s", bad)
+		}
+	}
+}
+
+func TestParse_ServerHeader(t *testing.T) {
+	cfg, err := Parse([]string{}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ServerHeader != "rep-gateway" {
+		t.Errorf("expected default server-header rep-gateway, got %q", cfg.ServerHeader)
+	}
+	cfg, err = Parse([]string{"--server-header", "none"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ServerHeader != "" {
+		t.Errorf("expected none to clear the server header, got %q", cfg.ServerHeader)
+	}
+	if _, err := Parse([]string{"--server-header", "edge\r\nX-Injected: 1"}, "0.1.0"); err == nil {
+		t.Error("expected error for a server-header with control characters")
+	}
+}
+
+func TestParse_SPAPaths(t *testing.T) {
+	cfg, err := Parse([]string{"--mode", "embedded", "--spa-paths", "/app/, /admin"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.SPAPaths) != 2 || cfg.SPAPaths[0] != "/app" || cfg.SPAPaths[1] != "/admin" {
+		t.Errorf("expected SPAPaths [/app /admin], got %v", cfg.SPAPaths)
+	}
+	if _, err := Parse([]string{"--mode", "embedded", "--spa-paths", "app"}, "0.1.0"); err == nil {
+		t.Error("expected error for a spa-paths entry without a leading slash")
+	}
+	if _, err := Parse([]string{"--mode", "proxy", "--upstream", "localhost:3000", "--spa-paths", "/app"}, "0.1.0"); err == nil {
+		t.Error("expected error for spa-paths in proxy mode")
+	}
+}
+
+func TestParse_StaticFollowSymlinks(t *testing.T) {
+	cfg, err := Parse([]string{"--mode", "embedded"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.StaticFollowSymlinks {
+		t.Error("expected symlinks outside the static dir to be refused by default")
+	}
+	if _, err := Parse([]string{"--static-follow-symlinks"}, "0.1.0"); err == nil {
+		t.Error("expected error for static-follow-symlinks in proxy mode")
+	}
+}
+
 func TestParse_VersionFlag(t *testing.T) {
 	cfg, err := Parse([]string{"--version"}, "0.1.0")
 	if err != nil {
@@ -142,3 +616,99 @@ func TestLogLevel(t *testing.T) {
 		}
 	}
 }
+
+func TestParse_ManifestSHA256(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".rep.yaml")
+	content := "version: \"0.1.0\"\nvariables:\n  API_URL:\n    tier: public\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	digest := hex.EncodeToString(sum[:])
+
+	cfg, err := Parse([]string{"--manifest", path, "--manifest-sha256", strings.ToUpper(digest)}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error with a matching pin: %v", err)
+	}
+	if cfg.Manifest.SHA256 != digest {
+		t.Errorf("expected manifest SHA256 %s, got %s", digest, cfg.Manifest.SHA256)
+	}
+
+	t.Setenv("REP_GATEWAY_MANIFEST_SHA256", strings.Repeat("0", 64))
+	if _, err := Parse([]string{"--manifest", path}, "0.1.0"); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected a pin mismatch error, got %v", err)
+	}
+	if _, err := Parse([]string{}, "0.1.0"); err == nil {
+		t.Error("expected error for manifest-sha256 without a manifest")
+	}
+}
+
+func TestParse_ManifestLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".rep.yaml")
+	content := "version: \"0.1.0\"\nvariables:\n  API_URL:\n    tier: public\n    description: \"" + strings.Repeat("x", 200) + "\"\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Parse([]string{"--manifest", path}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error with default limits: %v", err)
+	}
+	if cfg.ManifestMaxSize != 1<<20 || cfg.ManifestMaxLineLength != 64<<10 {
+		t.Errorf("expected default limits, got %d/%d", cfg.ManifestMaxSize, cfg.ManifestMaxLineLength)
+	}
+
+	if _, err := Parse([]string{"--manifest", path, "--manifest-max-line-length", "100"}, "0.1.0"); err == nil || !strings.Contains(err.Error(), "exceeds the maximum line length of 100 bytes") {
+		t.Errorf("expected a line length error from the flag, got %v", err)
+	}
+	if _, err := Parse([]string{"--manifest", path, "--manifest-max-size=64"}, "0.1.0"); err == nil {
+		t.Error("expected a size error from the flag")
+	}
+	if _, err := Parse([]string{"--manifest-max-size", "big"}, "0.1.0"); err == nil || !strings.Contains(err.Error(), `invalid manifest-max-size "big"`) {
+		t.Errorf("expected an invalid value error, got %v", err)
+	}
+
+	t.Setenv("REP_GATEWAY_MANIFEST_MAX_LINE_LENGTH", "100")
+	if _, err := Parse([]string{"--manifest", path}, "0.1.0"); err == nil || !strings.Contains(err.Error(), "maximum line length") {
+		t.Errorf("expected a line length error from the env var, got %v", err)
+	}
+}
+
+func TestParse_ManifestEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".rep.yaml")
+	content := `version: "0.1.0"
+settings:
+  session_key_max_rate: 10
+environments:
+  production:
+    settings:
+      session_key_max_rate: 3
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Parse([]string{"--manifest", path}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.SessionKeyMaxRate != 10 {
+		t.Errorf("expected base session-key-max-rate=10, got %d", cfg.SessionKeyMaxRate)
+	}
+
+	t.Setenv("REP_GATEWAY_ENV", "production")
+	cfg, err = Parse([]string{"--manifest", path}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.EnvName != "production" {
+		t.Errorf("expected EnvName=production, got %q", cfg.EnvName)
+	}
+	if cfg.SessionKeyMaxRate != 3 {
+		t.Errorf("expected production session-key-max-rate=3, got %d", cfg.SessionKeyMaxRate)
+	}
+
+	if _, err := Parse([]string{"--manifest", path, "--env-name", "qa"}, "0.1.0"); err == nil {
+		t.Error("expected error for undefined manifest environment")
+	}
+}
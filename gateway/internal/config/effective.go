@@ -0,0 +1,44 @@
+package config
+
+import (
+	"reflect"
+	"regexp"
+	"time"
+)
+
+// redacted replaces the value of fields Effective must not reveal.
+const redacted = "[REDACTED]"
+
+// secretFieldPattern matches Config field names whose values are treated as
+// secrets in the effective config dump.
+var secretFieldPattern = regexp.MustCompile(`(?i)secret|token|password|credential|seed|^TLSKey$|^UpstreamClientKey$|^KeyFile$`)
+
+// Effective returns the resolved configuration, keyed by Config field name,
+// for the /rep/config/effective endpoint. Durations are rendered as strings,
+// the loaded manifest is omitted (ManifestPath identifies it), and the TLS
+// key and key file paths and secret-like fields are redacted when set.
+func (c *Config) Effective() map[string]any {
+	out := make(map[string]any)
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || f.Name == "Manifest" {
+			continue
+		}
+		fv := v.Field(i)
+		switch {
+		case secretFieldPattern.MatchString(f.Name):
+			if fv.IsZero() {
+				out[f.Name] = fv.Interface()
+			} else {
+				out[f.Name] = redacted
+			}
+		case f.Type == reflect.TypeOf(time.Duration(0)):
+			out[f.Name] = time.Duration(fv.Int()).String()
+		default:
+			out[f.Name] = fv.Interface()
+		}
+	}
+	return out
+}
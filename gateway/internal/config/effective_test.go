@@ -0,0 +1,73 @@
+package config
+
+import "testing"
+
+func TestEffective_ReflectsOverrides(t *testing.T) {
+	t.Setenv("REP_GATEWAY_PORT", "3000")
+	t.Setenv("REP_GATEWAY_LOG_LEVEL", "debug")
+	t.Setenv("REP_GATEWAY_MODE", "embedded")
+
+	cfg, err := Parse([]string{"--mode", "proxy", "--session-key-ttl", "45s"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eff := cfg.Effective()
+
+	if eff["Port"] != 3000 {
+		t.Errorf("env over default: expected Port=3000, got %v", eff["Port"])
+	}
+	if eff["LogLevelStr"] != "debug" {
+		t.Errorf("env over default: expected LogLevelStr=debug, got %v", eff["LogLevelStr"])
+	}
+	if eff["Mode"] != "proxy" {
+		t.Errorf("flag over env: expected Mode=proxy, got %v", eff["Mode"])
+	}
+	if eff["SessionKeyTTL"] != "45s" {
+		t.Errorf("expected SessionKeyTTL rendered as 45s, got %v", eff["SessionKeyTTL"])
+	}
+	if eff["HotReloadMode"] != "signal" {
+		t.Errorf("expected default HotReloadMode=signal, got %v", eff["HotReloadMode"])
+	}
+	if _, ok := eff["Manifest"]; ok {
+		t.Error("expected the loaded manifest to be omitted")
+	}
+}
+
+func TestEffective_RedactsSecrets(t *testing.T) {
+	cfg, err := Parse([]string{"--tls-cert", "/etc/tls/cert.pem", "--tls-key", "/etc/tls/key.pem"}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	eff := cfg.Effective()
+
+	if eff["TLSKey"] != redacted {
+		t.Errorf("expected TLSKey redacted, got %v", eff["TLSKey"])
+	}
+	if eff["TLSCert"] != "/etc/tls/cert.pem" {
+		t.Errorf("expected TLSCert shown, got %v", eff["TLSCert"])
+	}
+
+	cfg.KeyFile = "/etc/rep/key"
+	if got := cfg.Effective()["KeyFile"]; got != redacted {
+		t.Errorf("expected KeyFile redacted, got %v", got)
+	}
+
+	cfg.KeySeed = "c2VlZA=="
+	if got := cfg.Effective()["KeySeed"]; got != redacted {
+		t.Errorf("expected KeySeed redacted, got %v", got)
+	}
+
+	cfg.TLSKey = ""
+	if got := cfg.Effective()["TLSKey"]; got != "" {
+		t.Errorf("expected unset TLSKey shown as empty, got %v", got)
+	}
+
+	for _, name := range []string{"AdminToken", "WebhookSecret", "DBPassword"} {
+		if !secretFieldPattern.MatchString(name) {
+			t.Errorf("expected %s to be treated as secret", name)
+		}
+	}
+	if secretFieldPattern.MatchString("SessionKeyTTL") {
+		t.Error("expected SessionKeyTTL not to be treated as secret")
+	}
+}
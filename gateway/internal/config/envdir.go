@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ParseEnvDir reads a directory of files, one per variable, as produced by
+// mounting a Kubernetes ConfigMap or Secret as a volume. Each file name is
+// the variable name and its contents the value, with trailing newlines
+// trimmed.
+//
+// Only regular files (or symlinks to them) whose names start with REP_ are
+// read. Hidden entries such as Kubernetes' ..data bookkeeping are skipped.
+func ParseEnvDir(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading env dir %q: %w", dir, err)
+	}
+
+	vars := make(map[string]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !strings.HasPrefix(name, "REP_") {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		fi, err := os.Stat(path) // Follows symlinks.
+		if err != nil {
+			return nil, fmt.Errorf("reading env dir entry %q: %w", path, err)
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading env dir entry %q: %w", path, err)
+		}
+		vars[name] = strings.TrimRight(string(data), "\r\n")
+	}
+
+	return vars, nil
+}
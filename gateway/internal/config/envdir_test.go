@@ -0,0 +1,109 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTempEnvDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("writing env dir file: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestParseEnvDir_Basic(t *testing.T) {
+	dir := writeTempEnvDir(t, map[string]string{
+		"REP_PUBLIC_API_URL":  "https://api.example.com\n",
+		"REP_SENSITIVE_TOKEN": "abc\r\n",
+		"README":              "not a variable",
+		".hidden":             "ignored",
+	})
+
+	vars, err := ParseEnvDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vars) != 2 {
+		t.Fatalf("expected 2 vars, got %d: %v", len(vars), vars)
+	}
+	if vars["REP_PUBLIC_API_URL"] != "https://api.example.com" {
+		t.Errorf("expected trailing newline trimmed, got %q", vars["REP_PUBLIC_API_URL"])
+	}
+	if vars["REP_SENSITIVE_TOKEN"] != "abc" {
+		t.Errorf("expected trailing CRLF trimmed, got %q", vars["REP_SENSITIVE_TOKEN"])
+	}
+}
+
+func TestParseEnvDir_KubernetesLayout(t *testing.T) {
+	// Kubernetes volume mounts expose each key as a symlink into a hidden
+	// timestamped directory via ..data.
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "..2026_01_01_00_00_00.000000000")
+	if err := os.Mkdir(dataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "REP_PUBLIC_ENV_NAME"), []byte("production"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Base(dataDir), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..data", "REP_PUBLIC_ENV_NAME"), filepath.Join(dir, "REP_PUBLIC_ENV_NAME")); err != nil {
+		t.Fatal(err)
+	}
+
+	vars, err := ParseEnvDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vars) != 1 || vars["REP_PUBLIC_ENV_NAME"] != "production" {
+		t.Errorf("expected only REP_PUBLIC_ENV_NAME=production, got %v", vars)
+	}
+}
+
+func TestParseEnvDir_Missing(t *testing.T) {
+	_, err := ParseEnvDir(filepath.Join(t.TempDir(), "nope"))
+	if err == nil {
+		t.Fatal("expected error for missing directory")
+	}
+}
+
+func TestReadAndClassifyFrom_EnvDir(t *testing.T) {
+	clearREPEnv(t)
+	dir := writeTempEnvDir(t, map[string]string{
+		"REP_PUBLIC_API_URL":     "from_dir\n",
+		"REP_SENSITIVE_DB_TOKEN": "secret\n",
+	})
+	file := writeTempEnvFile(t, "REP_PUBLIC_API_URL=from_file\nREP_PUBLIC_FILE_ONLY=yes\n")
+
+	vars, err := ReadAndClassifyFrom(Sources{EnvFile: file, EnvDir: dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	public := vars.PublicMap()
+	if public["API_URL"] != "from_dir" {
+		t.Errorf("env dir should override env file: got %q", public["API_URL"])
+	}
+	if public["FILE_ONLY"] != "yes" {
+		t.Errorf("expected FILE_ONLY from env file, got %q", public["FILE_ONLY"])
+	}
+	if vars.SensitiveMap()["DB_TOKEN"] != "secret" {
+		t.Errorf("expected sensitive DB_TOKEN from env dir, got %v", vars.SensitiveMap())
+	}
+
+	t.Setenv("REP_PUBLIC_API_URL", "from_env")
+	vars, err = ReadAndClassifyFrom(Sources{EnvFile: file, EnvDir: dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := vars.PublicMap()["API_URL"]; got != "from_env" {
+		t.Errorf("process env should override env dir: got %q", got)
+	}
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ParseOriginsFile reads an allowed-origins file: one origin per line, with
+// empty lines and lines starting with # skipped.
+func ParseOriginsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening allowed origins file %q: %w", path, err)
+	}
+	defer f.Close()
+
+	var origins []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		origins = append(origins, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading allowed origins file %q: %w", path, err)
+	}
+	return origins, nil
+}
+
+// Origins returns the CORS allowlist: AllowedOrigins followed by the
+// contents of AllowedOriginsFile, if set. The server calls it at startup
+// and on every reload, so the file can change without a restart.
+//
+// An empty allowlist admits every origin, so once AllowedOriginsFile is
+// configured an empty result is an error: startup fails, and a reload is
+// rejected and keeps the previous list.
+func (c *Config) Origins() ([]string, error) {
+	if c.AllowedOriginsFile == "" {
+		return c.AllowedOrigins, nil
+	}
+	fromFile, err := ParseOriginsFile(c.AllowedOriginsFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.AllowedOrigins)+len(fromFile) == 0 {
+		return nil, fmt.Errorf("allowed origins file %q lists no origins", c.AllowedOriginsFile)
+	}
+	origins := make([]string, 0, len(c.AllowedOrigins)+len(fromFile))
+	origins = append(origins, c.AllowedOrigins...)
+	return append(origins, fromFile...), nil
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseOriginsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "origins.txt")
+	content := "# partner apps\nhttps://a.example.com\n\n  https://b.example.com  \n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origins, err := ParseOriginsFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(origins, ",") != "https://a.example.com,https://b.example.com" {
+		t.Errorf("unexpected origins: %v", origins)
+	}
+}
+
+func TestParseOriginsFile_Missing(t *testing.T) {
+	if _, err := ParseOriginsFile(filepath.Join(t.TempDir(), "nope")); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
+
+func TestConfigOrigins_MergesFlagAndFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "origins.txt")
+	if err := os.WriteFile(path, []byte("https://file.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Parse([]string{"--allowed-origins", "https://flag.example.com", "--allowed-origins-file", path}, "0.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	origins, err := cfg.Origins()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(origins, ",") != "https://flag.example.com,https://file.example.com" {
+		t.Errorf("unexpected origins: %v", origins)
+	}
+}
+
+func TestConfigOrigins_EmptyFileFailsClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "origins.txt")
+	if err := os.WriteFile(path, []byte("# all partners removed\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{AllowedOriginsFile: path}
+	if _, err := cfg.Origins(); err == nil || !strings.Contains(err.Error(), "lists no origins") {
+		t.Fatalf("expected an error for an empty origins file, got %v", err)
+	}
+
+	// Flag origins still make a non-empty allowlist.
+	cfg.AllowedOrigins = []string{"https://flag.example.com"}
+	origins, err := cfg.Origins()
+	if err != nil || strings.Join(origins, ",") != "https://flag.example.com" {
+		t.Errorf("expected the flag origins alone, got %v, %v", origins, err)
+	}
+}
@@ -0,0 +1,96 @@
+// Package cors provides shared CORS handling for the gateway's /rep/*
+// endpoints.
+//
+// /rep/session-key has its own stricter handling in the crypto package. The
+// remaining endpoints (health, readiness, hot reload) use this package so
+// that cross-origin dashboards and SDK instances can reach them when their
+// origin is listed in --allowed-origins or --allowed-origins-file.
+package cors
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Origins is an origin allowlist that can be replaced while requests are
+// being served, so --allowed-origins-file changes apply on reload.
+type Origins struct {
+	list atomic.Pointer[[]string]
+}
+
+// NewOrigins returns an allowlist holding origins.
+func NewOrigins(origins []string) *Origins {
+	o := &Origins{}
+	o.Set(origins)
+	return o
+}
+
+// Set replaces the allowlist.
+func (o *Origins) Set(origins []string) {
+	o.list.Store(&origins)
+}
+
+// List returns the current allowlist. Callers must not modify it.
+func (o *Origins) List() []string {
+	if l := o.list.Load(); l != nil {
+		return *l
+	}
+	return nil
+}
+
+// Policy decides which cross-origin requests receive CORS headers.
+type Policy struct {
+	// AllowedOrigins lists origins permitted to make cross-origin requests.
+	// When empty, no CORS headers are emitted (same-origin only).
+	AllowedOrigins []string
+
+	// Origins, if set, is consulted instead of AllowedOrigins.
+	Origins *Origins
+}
+
+// Allowed reports whether origin is explicitly permitted.
+func (p Policy) Allowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	allowed := p.AllowedOrigins
+	if p.Origins != nil {
+		allowed = p.Origins.List()
+	}
+	return slices.Contains(allowed, origin)
+}
+
+// Wrap returns a handler that answers OPTIONS preflight requests itself and
+// adds CORS headers to responses from next for allowed origins. methods lists
+// the methods next supports (e.g. "GET"); OPTIONS is always added.
+//
+// Preflights always receive 204 with an Allow header, so they never fall
+// through to next's 405 handling; the Access-Control-* headers are only set
+// for allowed origins, which is what the browser enforces.
+func (p Policy) Wrap(next http.Handler, methods ...string) http.Handler {
+	allow := strings.Join(append(methods, http.MethodOptions), ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := p.Allowed(origin)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if r.Method != http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Allow", allow)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Methods", allow)
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "3600")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
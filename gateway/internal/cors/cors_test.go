@@ -0,0 +1,105 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestWrap_PreflightAllowedOrigin(t *testing.T) {
+	h := Policy{AllowedOrigins: []string{"https://app.example.com"}}.Wrap(okHandler, http.MethodGet)
+
+	req := httptest.NewRequest(http.MethodOptions, "/rep/health", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("expected allow-origin echo, got %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, OPTIONS" {
+		t.Errorf("expected GET, OPTIONS, got %q", got)
+	}
+}
+
+func TestWrap_PreflightDisallowedOrigin(t *testing.T) {
+	h := Policy{AllowedOrigins: []string{"https://app.example.com"}}.Wrap(okHandler, http.MethodGet)
+
+	req := httptest.NewRequest(http.MethodOptions, "/rep/health", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("disallowed origin should get no CORS headers, got %q", got)
+	}
+	if got := rec.Header().Get("Allow"); got != "GET, OPTIONS" {
+		t.Errorf("expected Allow header, got %q", got)
+	}
+}
+
+func TestWrap_EmptyPolicyIsSameOriginOnly(t *testing.T) {
+	h := Policy{}.Wrap(okHandler, http.MethodGet)
+
+	req := httptest.NewRequest(http.MethodGet, "/rep/health", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("expected no CORS headers without an allow list, got %q", got)
+	}
+}
+
+func TestWrap_ActualRequestGetsCORSHeaders(t *testing.T) {
+	h := Policy{AllowedOrigins: []string{"https://app.example.com"}}.Wrap(okHandler, http.MethodGet)
+
+	req := httptest.NewRequest(http.MethodGet, "/rep/health", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("expected allow-origin on actual response, got %q", got)
+	}
+	if got := rec.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("expected Vary: Origin, got %q", got)
+	}
+}
+
+func TestPolicy_OriginsReplaced(t *testing.T) {
+	origins := NewOrigins([]string{"https://a.example.com"})
+	p := Policy{AllowedOrigins: []string{"https://ignored.example.com"}, Origins: origins}
+
+	if !p.Allowed("https://a.example.com") || p.Allowed("https://ignored.example.com") {
+		t.Fatal("expected Origins to take precedence over AllowedOrigins")
+	}
+
+	origins.Set([]string{"https://b.example.com"})
+	if p.Allowed("https://a.example.com") {
+		t.Error("expected a.example.com to be dropped after Set")
+	}
+	if !p.Allowed("https://b.example.com") {
+		t.Error("expected b.example.com to be allowed after Set")
+	}
+}
@@ -6,12 +6,18 @@
 //   - Key: Ephemeral, generated at gateway startup
 //   - Nonce: 12-byte random per encryption
 //   - Blob format: [nonce (12B)][ciphertext][auth tag (16B)]
+//   - AAD: "rep-blob-v1|" + gateway version + "|" + integrity token
+//
+// AES-256-GCM is the only cipher: blobs are decrypted by the SDK with
+// WebCrypto, which does not offer ChaCha20-Poly1305, and the blob format
+// carries no algorithm tag.
 //
 // Per REP-RFC-0001 §8.3:
 //   - Integrity: HMAC-SHA256 over canonicalize(public) + "|" + sensitive
 package crypto
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
@@ -20,7 +26,10 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"sort"
+	"strings"
 )
 
 // Keys holds the ephemeral cryptographic material generated at gateway startup.
@@ -67,19 +76,91 @@ func GenerateKeys() (*Keys, error) {
 	}, nil
 }
 
-// DeriveKey derives a fixed-length key using HKDF-SHA256 (RFC 5869).
-//
-// This is a single-round HKDF implementation valid for output lengths up to
-// 32 bytes (one SHA-256 hash output). It uses stdlib crypto/hmac and
-// crypto/sha256 only — no external dependencies.
+// KeySize is the length in bytes of both the encryption key and the HMAC
+// secret.
+const KeySize = 32
+
+// KeysFromBytes builds Keys from externally supplied material instead of
+// generating them, for deterministic tests and deployments that manage
+// keys themselves. Both keys must be exactly KeySize bytes. The slices are
+// copied, so the caller may clear its own copies afterwards.
+func KeysFromBytes(enc, hmacSecret []byte) (*Keys, error) {
+	if len(enc) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(enc))
+	}
+	if len(hmacSecret) != KeySize {
+		return nil, fmt.Errorf("HMAC secret must be %d bytes, got %d", KeySize, len(hmacSecret))
+	}
+	return &Keys{
+		EncryptionKey: bytes.Clone(enc),
+		HMACSecret:    bytes.Clone(hmacSecret),
+	}, nil
+}
+
+// MinSeedSize is the minimum length in bytes of a shared master seed.
+const MinSeedSize = 32
+
+// sharedKeysSalt is the fixed HKDF salt for KeysFromSeed. Unlike
+// GenerateKeys' per-startup salt it must be constant, so that every replica
+// given the same seed derives the same keys.
+const sharedKeysSalt = "rep-shared-keys-v1"
+
+// KeysFromSeed deterministically derives Keys from a master seed of at
+// least MinSeedSize bytes, using DeriveKey with distinct info strings for
+// the encryption key and the HMAC secret. Gateways given the same seed
+// produce identical integrity tokens and interchangeable session keys.
+func KeysFromSeed(seed []byte) (*Keys, error) {
+	if len(seed) < MinSeedSize {
+		return nil, fmt.Errorf("key seed must be at least %d bytes, got %d", MinSeedSize, len(seed))
+	}
+	salt := []byte(sharedKeysSalt)
+	return KeysFromBytes(
+		DeriveKey(seed, salt, "rep-blob-encryption-v1", KeySize),
+		DeriveKey(seed, salt, "rep-payload-integrity-v1", KeySize),
+	)
+}
+
+// ParseSeed decodes a base64-encoded master seed (surrounding whitespace
+// ignored) and checks that it is at least MinSeedSize bytes.
+func ParseSeed(s string) ([]byte, error) {
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %w", err)
+	}
+	if len(seed) < MinSeedSize {
+		return nil, fmt.Errorf("key seed must be at least %d bytes, got %d", MinSeedSize, len(seed))
+	}
+	return seed, nil
+}
+
+// LoadKeyFile reads a master seed in ParseSeed's format from path and
+// derives Keys from it with KeysFromSeed.
+func LoadKeyFile(path string) (*Keys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+	seed, err := ParseSeed(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+	return KeysFromSeed(seed)
+}
+
+// DeriveKey derives a fixed-length key using HKDF-SHA256 (RFC 5869). It
+// uses stdlib crypto/hmac and crypto/sha256 only — no external dependencies.
 //
 //   - Extract: PRK = HMAC-SHA256(salt, ikm)
-//   - Expand:  T(1) = HMAC-SHA256(PRK, info || 0x01)   (one round, L ≤ 32)
+//   - Expand:  T(n) = HMAC-SHA256(PRK, T(n-1) || info || n), T(0) = ""
+//     OKM = first length bytes of T(1) || T(2) || ...
+//
+// Lengths up to 32 bytes take one expand round. length may be at most
+// 255*32 bytes, the RFC 5869 limit; DeriveKey panics beyond it.
 //
 // Use distinct info strings to produce independent keys from the same IKM.
 func DeriveKey(ikm, salt []byte, info string, length int) []byte {
-	if length > 32 {
-		panic("rep: DeriveKey length exceeds one HKDF-SHA256 round (max 32)")
+	if length > 255*sha256.Size {
+		panic("rep: DeriveKey length exceeds the HKDF-SHA256 limit (255*32 bytes)")
 	}
 
 	// Extract: PRK = HMAC-SHA256(salt, IKM)
@@ -92,16 +173,49 @@ func DeriveKey(ikm, salt []byte, info string, length int) []byte {
 	expander.Write([]byte(info))
 	expander.Write([]byte{0x01})
 	okm := expander.Sum(nil)
+	if length <= sha256.Size {
+		return okm[:length]
+	}
 
+	// Further rounds chain the previous block: T(n) = HMAC(PRK, T(n-1) || info || n).
+	prev := okm
+	for n := byte(2); len(okm) < length; n++ {
+		expander.Reset()
+		expander.Write(prev)
+		expander.Write([]byte(info))
+		expander.Write([]byte{n})
+		prev = expander.Sum(nil)
+		okm = append(okm, prev...)
+	}
 	return okm[:length]
 }
 
+// BlobAADContext is the domain separator that starts the AAD of every
+// encrypted blob, so the AAD can never collide with another use of the key.
+const BlobAADContext = "rep-blob-v1"
+
+// BlobAAD returns the additional authenticated data for an encrypted blob
+// per §8.2: BlobAADContext + "|" + version + "|" + integrityToken. Binding
+// the gateway version means a blob from one gateway version fails to decrypt
+// when presented with the metadata of another. The integrity token never
+// contains "|", so the format stays unambiguous for any version string.
+func BlobAAD(version, integrityToken string) string {
+	return BlobAADContext + "|" + version + "|" + integrityToken
+}
+
 // EncryptSensitive encrypts the sensitive variables map using AES-256-GCM.
 // Returns a base64-encoded blob: [nonce (12B)][ciphertext][auth tag (16B)].
 //
-// The integrityToken is used as additional authenticated data (AAD), binding
-// the encrypted blob to the integrity token per §8.2.
-func EncryptSensitive(sensitiveMap map[string]string, key []byte, integrityToken string) (string, error) {
+// The aad is used as additional authenticated data; the payload builder
+// passes BlobAAD(version, integrity) per §8.2.
+func EncryptSensitive(sensitiveMap map[string]string, key []byte, aad string) (string, error) {
+	return encryptSensitive(sensitiveMap, key, aad, rand.Reader)
+}
+
+// encryptSensitive implements EncryptSensitive, reading the nonce from
+// nonceSource. It is unexported so that only tests in this package can
+// supply a fixed nonce; a reused GCM nonce breaks confidentiality.
+func encryptSensitive(sensitiveMap map[string]string, key []byte, aad string, nonceSource io.Reader) (string, error) {
 	if len(sensitiveMap) == 0 {
 		return "", nil
 	}
@@ -124,22 +238,22 @@ func EncryptSensitive(sensitiveMap map[string]string, key []byte, integrityToken
 		return "", fmt.Errorf("creating GCM: %w", err)
 	}
 
-	// Generate random nonce.
+	// Generate nonce.
 	nonce := make([]byte, gcm.NonceSize()) // 12 bytes for GCM.
-	if _, err := rand.Read(nonce); err != nil {
+	if _, err := io.ReadFull(nonceSource, nonce); err != nil {
 		return "", fmt.Errorf("generating nonce: %w", err)
 	}
 
 	// Encrypt with AAD.
-	aad := []byte(integrityToken)
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, aad) // Prepends nonce to output.
+	ciphertext := gcm.Seal(nonce, nonce, plaintext, []byte(aad)) // Prepends nonce to output.
 
 	return base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
 // DecryptSensitive decrypts a base64-encoded AES-256-GCM blob.
-// Returns the plaintext JSON bytes of the sensitive variables map.
-func DecryptSensitive(blob string, key []byte, integrityToken string) ([]byte, error) {
+// Returns the plaintext JSON bytes of the sensitive variables map. The aad
+// must match the value the blob was encrypted with.
+func DecryptSensitive(blob string, key []byte, aad string) ([]byte, error) {
 	data, err := base64.StdEncoding.DecodeString(blob)
 	if err != nil {
 		return nil, fmt.Errorf("base64 decode: %w", err)
@@ -161,9 +275,8 @@ func DecryptSensitive(blob string, key []byte, integrityToken string) ([]byte, e
 	}
 
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	aad := []byte(integrityToken)
 
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(aad))
 	if err != nil {
 		return nil, fmt.Errorf("decryption failed: %w", err)
 	}
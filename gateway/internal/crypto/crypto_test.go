@@ -1,8 +1,12 @@
 package crypto
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -42,6 +46,143 @@ func TestGenerateKeys_Unique(t *testing.T) {
 	}
 }
 
+func TestKeysFromBytes_Lengths(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	for _, tc := range []struct {
+		name      string
+		enc, hmac []byte
+	}{
+		{"short encryption key", key[:16], key},
+		{"long encryption key", append(key, 0), key},
+		{"short HMAC secret", key, key[:31]},
+		{"missing HMAC secret", key, nil},
+	} {
+		if _, err := KeysFromBytes(tc.enc, tc.hmac); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+
+	keys, err := KeysFromBytes(key, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key[0] = 2
+	if keys.EncryptionKey[0] != 1 || keys.HMACSecret[0] != 1 {
+		t.Error("expected KeysFromBytes to copy its inputs")
+	}
+}
+
+func TestKeysFromBytes_Interop(t *testing.T) {
+	enc := bytes.Repeat([]byte{0x11}, KeySize)
+	mac := bytes.Repeat([]byte{0x22}, KeySize)
+	k1, err := KeysFromBytes(enc, mac)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	k2, err := KeysFromBytes(enc, mac)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	aad := BlobAAD("0.1.0", "hmac-sha256:test")
+	blob, err := EncryptSensitive(map[string]string{"SECRET": "shared"}, k1.EncryptionKey, aad)
+	if err != nil {
+		t.Fatalf("encrypt error: %v", err)
+	}
+	plain, err := DecryptSensitive(blob, k2.EncryptionKey, aad)
+	if err != nil {
+		t.Fatalf("decrypt with equal keys: %v", err)
+	}
+	if string(plain) != `{"SECRET":"shared"}` {
+		t.Errorf("unexpected plaintext %s", plain)
+	}
+
+	public := map[string]string{"API_URL": "https://api.example.com"}
+	if ComputeIntegrity(public, "", k1.HMACSecret) != ComputeIntegrity(public, "", k2.HMACSecret) {
+		t.Error("expected equal keys to produce equal integrity tokens")
+	}
+}
+
+func TestKeysFromSeed(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x5a}, MinSeedSize)
+	k1, err := KeysFromSeed(seed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	k2, err := KeysFromSeed(seed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(k1.EncryptionKey, k2.EncryptionKey) || !bytes.Equal(k1.HMACSecret, k2.HMACSecret) {
+		t.Error("expected the same seed to derive the same keys")
+	}
+	if bytes.Equal(k1.EncryptionKey, k1.HMACSecret) {
+		t.Error("expected independent encryption key and HMAC secret")
+	}
+
+	other, err := KeysFromSeed(bytes.Repeat([]byte{0x5b}, MinSeedSize))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Equal(k1.EncryptionKey, other.EncryptionKey) || bytes.Equal(k1.HMACSecret, other.HMACSecret) {
+		t.Error("expected different seeds to derive different keys")
+	}
+
+	if _, err := KeysFromSeed(seed[:MinSeedSize-1]); err == nil {
+		t.Error("expected error for a short seed")
+	}
+}
+
+func TestParseSeed(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x5a}, MinSeedSize)
+	got, err := ParseSeed(" " + base64.StdEncoding.EncodeToString(seed) + "\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, seed) {
+		t.Errorf("expected decoded seed, got %x", got)
+	}
+
+	for _, bad := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(seed[:16])} {
+		if _, err := ParseSeed(bad); err == nil {
+			t.Errorf("expected error for seed %q", bad)
+		}
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	seed := bytes.Repeat([]byte{0x5a}, MinSeedSize)
+	path := filepath.Join(dir, "key")
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(seed)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want, _ := KeysFromSeed(seed)
+	if !bytes.Equal(keys.EncryptionKey, want.EncryptionKey) || !bytes.Equal(keys.HMACSecret, want.HMACSecret) {
+		t.Error("expected key file keys to match KeysFromSeed")
+	}
+
+	for name, content := range map[string]string{
+		"not-base64": "not base64!",
+		"short":      base64.StdEncoding.EncodeToString(seed[:16]),
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadKeyFile(p); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, err := LoadKeyFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error for a missing key file")
+	}
+}
+
 func TestEncryptDecryptRoundtrip(t *testing.T) {
 	keys, _ := GenerateKeys()
 	input := map[string]string{
@@ -107,6 +248,27 @@ func TestEncryptDecrypt_WrongAAD(t *testing.T) {
 	}
 }
 
+func TestBlobAAD(t *testing.T) {
+	got := BlobAAD("0.1.0", "hmac-sha256:abc=")
+	if got != "rep-blob-v1|0.1.0|hmac-sha256:abc=" {
+		t.Errorf("BlobAAD = %q", got)
+	}
+}
+
+func TestEncryptDecrypt_VersionMismatch(t *testing.T) {
+	keys, _ := GenerateKeys()
+	input := map[string]string{"KEY": "value"}
+
+	blob, err := EncryptSensitive(input, keys.EncryptionKey, BlobAAD("1.0.0", "integrity"))
+	if err != nil {
+		t.Fatalf("encrypt error: %v", err)
+	}
+
+	if _, err := DecryptSensitive(blob, keys.EncryptionKey, BlobAAD("1.0.1", "integrity")); err == nil {
+		t.Fatal("expected error when decrypting with a different version")
+	}
+}
+
 func TestEncryptSensitive_EmptyMap(t *testing.T) {
 	keys, _ := GenerateKeys()
 
@@ -119,6 +281,46 @@ func TestEncryptSensitive_EmptyMap(t *testing.T) {
 	}
 }
 
+func TestEncryptSensitive_FixedNonce(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	nonce := []byte("fixed-nonce!") // 12 bytes
+	input := map[string]string{"ANALYTICS_KEY": "ak_live_123"}
+
+	blob, err := encryptSensitive(input, key, "hmac-sha256:test", bytes.NewReader(nonce))
+	if err != nil {
+		t.Fatalf("encrypt error: %v", err)
+	}
+
+	const golden = "Zml4ZWQtbm9uY2UhPAJbok2xdf14qLa2qEGwO/vPUfJvKkvpIrxbpdoNqrxspWxrAfiVBwYZKowct+8="
+	if blob != golden {
+		t.Errorf("blob = %q, want %q", blob, golden)
+	}
+
+	again, _ := encryptSensitive(input, key, "hmac-sha256:test", bytes.NewReader(nonce))
+	if again != blob {
+		t.Error("expected identical output for the same nonce")
+	}
+
+	raw, _ := base64.StdEncoding.DecodeString(blob)
+	if !bytes.Equal(raw[:len(nonce)], nonce) {
+		t.Errorf("expected blob to start with the nonce, got %x", raw[:len(nonce)])
+	}
+	if _, err := DecryptSensitive(blob, key, "hmac-sha256:test"); err != nil {
+		t.Errorf("decrypt error: %v", err)
+	}
+}
+
+func TestEncryptSensitive_ShortNonceSource(t *testing.T) {
+	key := make([]byte, 32)
+	_, err := encryptSensitive(map[string]string{"K": "v"}, key, "test", bytes.NewReader([]byte("short")))
+	if err == nil {
+		t.Fatal("expected error when the nonce source is exhausted")
+	}
+}
+
 func TestComputeIntegrity_Deterministic(t *testing.T) {
 	keys, _ := GenerateKeys()
 	publicMap := map[string]string{"A": "1", "B": "2"}
@@ -278,6 +480,53 @@ func TestDeriveKey_Length(t *testing.T) {
 	}
 }
 
+// seqBytes returns the n bytes start, start+1, ...
+func seqBytes(start byte, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = start + byte(i)
+	}
+	return b
+}
+
+func TestDeriveKey_RFC5869Vectors(t *testing.T) {
+	// RFC 5869 A.1 (basic) and A.2 (longer inputs/outputs). An HKDF output
+	// is a prefix of every longer output for the same inputs, so the 82-byte
+	// A.2 result also checks the 48- and 64-byte lengths.
+	case1 := mustHex(t, "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865")
+	case2 := mustHex(t, "b11e398dc80327a1c8e7f78c596a49344f012eda2d4efad8a050cc4c19afa97c"+
+		"59045a99cac7827271cb41c65e590e09da3275600c2f09b8367793a9aca3db71"+
+		"cc30c58179ec3e87c14c01d5c1f3434f1d87")
+
+	tests := []struct {
+		name            string
+		ikm, salt, info []byte
+		length          int
+		want            []byte
+	}{
+		{"A.1", bytes.Repeat([]byte{0x0b}, 22), seqBytes(0x00, 13), seqBytes(0xf0, 10), 42, case1},
+		{"A.1 32", bytes.Repeat([]byte{0x0b}, 22), seqBytes(0x00, 13), seqBytes(0xf0, 10), 32, case1[:32]},
+		{"A.2", seqBytes(0x00, 80), seqBytes(0x60, 80), seqBytes(0xb0, 80), 82, case2},
+		{"A.2 48", seqBytes(0x00, 80), seqBytes(0x60, 80), seqBytes(0xb0, 80), 48, case2[:48]},
+		{"A.2 64", seqBytes(0x00, 80), seqBytes(0x60, 80), seqBytes(0xb0, 80), 64, case2[:64]},
+	}
+	for _, tt := range tests {
+		got := DeriveKey(tt.ikm, tt.salt, string(tt.info), tt.length)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %x, want %x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
 func TestDeriveKey_IntegratesWithEncryptDecrypt(t *testing.T) {
 	// Ensure a HKDF-derived key works end-to-end in AES-256-GCM.
 	ikm := make([]byte, 32)
@@ -12,12 +12,15 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"github.com/ruachtech/rep/gateway/internal/ratelimit"
 )
 
 // SessionKeyResponse is the JSON response from /rep/session-key.
 //
 // Key is the base64-encoded HKDF-derived AES-256 blob encryption key.
-// ExpiresAt is the RFC3339 expiry of this issuance.
+// ExpiresAt is the RFC3339 expiry of this issuance, including any clock
+// skew buffer (see SetClockSkew).
 type SessionKeyResponse struct {
 	Key       string `json:"key"`
 	ExpiresAt string `json:"expires_at"`
@@ -25,15 +28,24 @@ type SessionKeyResponse struct {
 
 // SessionKeyHandler manages session key issuance and validation.
 type SessionKeyHandler struct {
-	encryptionKey  []byte
-	ttl            time.Duration
-	maxRate        int // Per minute per IP.
-	allowedOrigins []string
-	logger         *slog.Logger
+	encryptionKey []byte
+	ttl           time.Duration
+	maxRate       int // Per minute per IP.
+	logger        *slog.Logger
+
+	// replicaID, if set, must match the "replica" query parameter of key
+	// requests that carry one.
+	replicaID string
 
-	mu          sync.Mutex
-	issuedKeys  map[string]time.Time   // keyID → expiry (for single-use tracking)
-	rateLimiter map[string][]time.Time // IP → request timestamps
+	// skew is added to the advertised ExpiresAt only; issuedKeys keeps the
+	// unbuffered expiry.
+	skew time.Duration
+
+	// mu protects allowedOrigins and issuedKeys.
+	mu             sync.Mutex
+	allowedOrigins []string
+	issuedKeys     map[string]time.Time // keyID → expiry (for single-use tracking)
+	rateLimiter    *ratelimit.Limiter
 }
 
 // NewSessionKeyHandler creates a handler for the /rep/session-key endpoint.
@@ -51,10 +63,10 @@ func NewSessionKeyHandler(
 		allowedOrigins: allowedOrigins,
 		logger:         logger,
 		issuedKeys:     make(map[string]time.Time),
-		rateLimiter:    make(map[string][]time.Time),
+		rateLimiter:    ratelimit.New(maxRate),
 	}
 
-	// Start cleanup goroutine for expired keys and rate limit entries.
+	// Start cleanup goroutine for expired keys.
 	go h.cleanup()
 
 	return h
@@ -80,9 +92,23 @@ func (h *SessionKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A payload from another replica was sealed with that replica's keys;
+	// issuing ours would only produce a key that cannot decrypt it.
+	if replica := r.URL.Query().Get("replica"); h.replicaID != "" && replica != "" && replica != h.replicaID {
+		h.logger.Warn("rep.session_key.rejected",
+			"client_ip", r.RemoteAddr,
+			"reason", "replica_mismatch",
+			"replica", replica,
+		)
+		w.Header().Set("Cache-Control", "no-store")
+		http.Error(w, "replica mismatch: the page was served by replica "+replica+
+			" but this is replica "+h.replicaID+"; reload the page and retry", http.StatusConflict)
+		return
+	}
+
 	// Rate limiting per §4.4.
-	clientIP := extractIP(r)
-	if !h.checkRateLimit(clientIP) {
+	clientIP := ratelimit.ClientIP(r)
+	if !h.rateLimiter.Allow(clientIP) {
 		h.logger.Warn("rep.session_key.rate_limited",
 			"client_ip", clientIP,
 			"requests_in_window", h.maxRate,
@@ -106,7 +132,7 @@ func (h *SessionKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// and exists only within GenerateKeys() — it never leaves that function.
 	resp := SessionKeyResponse{
 		Key:       base64.StdEncoding.EncodeToString(h.encryptionKey),
-		ExpiresAt: expiresAt.Format(time.RFC3339),
+		ExpiresAt: expiresAt.Add(h.skew).Format(time.RFC3339),
 	}
 
 	h.logger.Info("rep.session_key.issued",
@@ -134,9 +160,34 @@ func (h *SessionKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// SetReplicaID makes the handler reject key requests whose "replica" query
+// parameter names a different replica (see payload.WithReplicaID). Requests
+// without the parameter are served as before. Must be called before the
+// handler serves requests.
+func (h *SessionKeyHandler) SetReplicaID(id string) {
+	h.replicaID = id
+}
+
+// SetClockSkew adds d to the expires_at advertised to clients, so clients
+// whose clocks run slightly ahead do not treat a fresh key as expired. The
+// server-side expiry is unchanged. Must be called before the handler serves
+// requests.
+func (h *SessionKeyHandler) SetClockSkew(d time.Duration) {
+	h.skew = d
+}
+
+// SetAllowedOrigins replaces the origin allowlist (used after reload).
+func (h *SessionKeyHandler) SetAllowedOrigins(origins []string) {
+	h.mu.Lock()
+	h.allowedOrigins = origins
+	h.mu.Unlock()
+}
+
 // isOriginAllowed checks if the origin is in the allowed list.
 // If no origins are configured, same-origin requests are allowed (empty Origin header).
 func (h *SessionKeyHandler) isOriginAllowed(origin string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	if len(h.allowedOrigins) == 0 {
 		// No explicit allow list — allow same-origin (empty or absent Origin).
 		return true
@@ -151,34 +202,7 @@ func (h *SessionKeyHandler) isOriginAllowed(origin string) bool {
 	return false
 }
 
-// checkRateLimit enforces the per-IP rate limit.
-// Returns true if the request is within the limit.
-func (h *SessionKeyHandler) checkRateLimit(ip string) bool {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
-	now := time.Now()
-	windowStart := now.Add(-1 * time.Minute)
-
-	// Filter timestamps within the window.
-	timestamps := h.rateLimiter[ip]
-	valid := timestamps[:0]
-	for _, ts := range timestamps {
-		if ts.After(windowStart) {
-			valid = append(valid, ts)
-		}
-	}
-
-	if len(valid) >= h.maxRate {
-		h.rateLimiter[ip] = valid
-		return false
-	}
-
-	h.rateLimiter[ip] = append(valid, now)
-	return true
-}
-
-// cleanup periodically removes expired keys and stale rate limit entries.
+// cleanup periodically removes expired keys.
 func (h *SessionKeyHandler) cleanup() {
 	ticker := time.NewTicker(1 * time.Minute)
 	for range ticker.C {
@@ -192,22 +216,6 @@ func (h *SessionKeyHandler) cleanup() {
 			}
 		}
 
-		// Clean stale rate limiter entries.
-		windowStart := now.Add(-1 * time.Minute)
-		for ip, timestamps := range h.rateLimiter {
-			valid := timestamps[:0]
-			for _, ts := range timestamps {
-				if ts.After(windowStart) {
-					valid = append(valid, ts)
-				}
-			}
-			if len(valid) == 0 {
-				delete(h.rateLimiter, ip)
-			} else {
-				h.rateLimiter[ip] = valid
-			}
-		}
-
 		h.mu.Unlock()
 	}
 }
@@ -223,29 +231,6 @@ func generateKeyID() string {
 	return base64.URLEncoding.EncodeToString(b)
 }
 
-// extractIP extracts the client IP from the request,
-// respecting X-Forwarded-For if present.
-func extractIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// Take the first IP in the chain (the client).
-		for i := 0; i < len(xff); i++ {
-			if xff[i] == ',' {
-				return xff[:i]
-			}
-		}
-		return xff
-	}
-
-	// Fall back to RemoteAddr (strip port).
-	addr := r.RemoteAddr
-	for i := len(addr) - 1; i >= 0; i-- {
-		if addr[i] == ':' {
-			return addr[:i]
-		}
-	}
-	return addr
-}
-
 // CORSPreflight handles OPTIONS requests for the session key endpoint.
 func (h *SessionKeyHandler) CORSPreflight(w http.ResponseWriter, r *http.Request) {
 	origin := r.Header.Get("Origin")
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -90,6 +91,76 @@ func TestSessionKey_OriginAllowed(t *testing.T) {
 	}
 }
 
+func TestSessionKey_SetAllowedOrigins(t *testing.T) {
+	h := newTestHandler(t, []string{"https://old.com"}, 100)
+	h.SetAllowedOrigins([]string{"https://new.com"})
+
+	for origin, want := range map[string]int{
+		"https://old.com": http.StatusForbidden,
+		"https://new.com": http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/rep/session-key", nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", origin, want, rec.Code)
+		}
+	}
+}
+
+func TestSessionKey_ReplicaID(t *testing.T) {
+	h := newTestHandler(t, nil, 100)
+	h.SetReplicaID("replica-a")
+
+	for target, want := range map[string]int{
+		"/rep/session-key?replica=replica-a": http.StatusOK,
+		"/rep/session-key":                   http.StatusOK,
+		"/rep/session-key?replica=replica-b": http.StatusConflict,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", target, want, rec.Code)
+		}
+		if want == http.StatusConflict && !strings.Contains(rec.Body.String(), "reload the page and retry") {
+			t.Errorf("%s: expected a retry hint, got %q", target, rec.Body.String())
+		}
+	}
+}
+
+func TestSessionKey_ClockSkew(t *testing.T) {
+	h := newTestHandler(t, nil, 100)
+	h.SetClockSkew(2 * time.Minute)
+
+	before := time.Now().UTC().Truncate(time.Second)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rep/session-key", nil))
+	after := time.Now().UTC()
+
+	var resp SessionKeyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	expiresAt, err := time.Parse(time.RFC3339, resp.ExpiresAt)
+	if err != nil {
+		t.Fatalf("expires_at is not valid RFC3339: %v", err)
+	}
+	// TTL (30s) plus the 2m buffer.
+	if lo, hi := before.Add(150*time.Second), after.Add(150*time.Second); expiresAt.Before(lo) || expiresAt.After(hi) {
+		t.Errorf("expires_at %s not within [%s, %s]", expiresAt, lo, hi)
+	}
+
+	// Single-use tracking keeps the unbuffered expiry.
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, tracked := range h.issuedKeys {
+		if tracked.After(after.Add(30 * time.Second)) {
+			t.Errorf("tracked expiry %s includes the skew buffer", tracked)
+		}
+	}
+}
+
 func TestSessionKey_RateLimit(t *testing.T) {
 	maxRate := 3
 	h := newTestHandler(t, nil, maxRate)
@@ -164,24 +235,3 @@ func TestSessionKey_CORSPreflight(t *testing.T) {
 	}
 }
 
-func TestExtractIP_XForwardedFor(t *testing.T) {
-	req := httptest.NewRequest(http.MethodGet, "/", nil)
-	req.Header.Set("X-Forwarded-For", "1.2.3.4, 5.6.7.8")
-
-	ip := extractIP(req)
-	if ip != "1.2.3.4" {
-		t.Errorf("expected 1.2.3.4, got %s", ip)
-	}
-}
-
-func TestExtractIP_RemoteAddr(t *testing.T) {
-	req := httptest.NewRequest(http.MethodGet, "/", nil)
-	req.Header.Del("X-Forwarded-For")
-	req.RemoteAddr = "192.168.1.1:12345"
-
-	ip := extractIP(req)
-	if ip != "192.168.1.1" {
-		t.Errorf("expected 192.168.1.1, got %s", ip)
-	}
-}
-
@@ -10,7 +10,9 @@ import (
 	"fmt"
 	"log/slog"
 	"math"
+	"slices"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/ruachtech/rep/gateway/internal/config"
 )
@@ -19,7 +21,7 @@ import (
 type Warning struct {
 	VariableName  string // Name without prefix (e.g., "API_KEY").
 	OriginalKey   string // Full env var name (e.g., "REP_PUBLIC_API_KEY").
-	DetectionType string // "high_entropy", "known_format", "length_anomaly".
+	DetectionType string // "high_entropy", "known_format", "length_anomaly", "html_unsafe".
 	Message       string // Human-readable explanation.
 }
 
@@ -57,14 +59,44 @@ var knownSecretPrefixes = []struct {
 	{"AGE-SECRET-KEY-", "age Encryption Key"},
 }
 
-// Scan checks all PUBLIC tier variables for potential misclassification.
+// Limits bounds the work done per value by ScanWithLimits.
+type Limits struct {
+	// MaxScanLength is the number of leading bytes of a value examined by
+	// the entropy and length-anomaly checks. Longer values are sampled:
+	// only this prefix is scanned, so a multi-megabyte value cannot stall
+	// startup. The reported length is always the full length.
+	MaxScanLength int
+}
+
+// DefaultLimits are the limits applied by Scan. Real secrets are far shorter
+// than this, so the sample always covers them in full.
+var DefaultLimits = Limits{
+	MaxScanLength: 4096,
+}
+
+// Scan checks all PUBLIC tier variables for potential misclassification
+// using DefaultLimits.
 //
 // Per REP-RFC-0001 §3.3, the gateway MUST scan and MUST log warnings.
 // If strict mode is enabled, the caller should treat warnings as errors.
 func Scan(vars *config.ClassifiedVars, logger *slog.Logger) *Result {
+	return ScanWithLimits(vars, logger, DefaultLimits)
+}
+
+// ScanWithLimits is like Scan but applies the given limits. A non-positive
+// MaxScanLength falls back to DefaultLimits.
+func ScanWithLimits(vars *config.ClassifiedVars, logger *slog.Logger, limits Limits) *Result {
+	if limits.MaxScanLength <= 0 {
+		limits.MaxScanLength = DefaultLimits.MaxScanLength
+	}
 	result := &Result{}
 
 	for _, v := range vars.Public {
+		// Only ever surface a masked form of the value: a PUBLIC value that
+		// trips a guardrail may well be a real secret.
+		masked := maskValue(v.Value)
+		sample := scanSample(v.Value, limits.MaxScanLength)
+
 		// Check known secret formats.
 		for _, kp := range knownSecretPrefixes {
 			if strings.HasPrefix(v.Value, kp.prefix) {
@@ -72,12 +104,13 @@ func Scan(vars *config.ClassifiedVars, logger *slog.Logger) *Result {
 					VariableName:  v.Name,
 					OriginalKey:   v.OriginalKey,
 					DetectionType: "known_format",
-					Message:       fmt.Sprintf("value matches known %s format (prefix: %s)", kp.service, kp.prefix),
+					Message:       fmt.Sprintf("value %s matches known %s format", masked, kp.service),
 				}
 				result.Warnings = append(result.Warnings, w)
 				logger.Warn("rep.guardrail.warning",
 					"variable_name", v.Name,
 					"detection_type", "known_format",
+					"value", masked,
 					"detail", w.Message,
 				)
 				break // One match is enough per variable.
@@ -85,34 +118,36 @@ func Scan(vars *config.ClassifiedVars, logger *slog.Logger) *Result {
 		}
 
 		// Check Shannon entropy.
-		entropy := shannonEntropy(v.Value)
+		entropy := shannonEntropy(sample)
 		if entropy > 4.5 && len(v.Value) > 16 {
 			w := Warning{
 				VariableName:  v.Name,
 				OriginalKey:   v.OriginalKey,
 				DetectionType: "high_entropy",
-				Message:       fmt.Sprintf("value has high entropy (%.2f bits/char) — may be a secret", entropy),
+				Message:       fmt.Sprintf("value %s has high entropy (%.2f bits/char) — may be a secret", masked, entropy),
 			}
 			result.Warnings = append(result.Warnings, w)
 			logger.Warn("rep.guardrail.warning",
 				"variable_name", v.Name,
 				"detection_type", "high_entropy",
+				"value", masked,
 				"entropy", fmt.Sprintf("%.2f", entropy),
 			)
 		}
 
 		// Check length anomaly.
-		if len(v.Value) > 64 && !strings.Contains(v.Value, " ") && !strings.HasPrefix(v.Value, "http") {
+		if len(v.Value) > 64 && !strings.Contains(sample, " ") && !strings.HasPrefix(v.Value, "http") {
 			w := Warning{
 				VariableName:  v.Name,
 				OriginalKey:   v.OriginalKey,
 				DetectionType: "length_anomaly",
-				Message:       fmt.Sprintf("value is %d chars with no spaces and no URL prefix — may be an encoded secret", len(v.Value)),
+				Message:       fmt.Sprintf("value %s is %d chars with no spaces and no URL prefix — may be an encoded secret", masked, len(v.Value)),
 			}
 			result.Warnings = append(result.Warnings, w)
 			logger.Warn("rep.guardrail.warning",
 				"variable_name", v.Name,
 				"detection_type", "length_anomaly",
+				"value", masked,
 				"length", len(v.Value),
 			)
 		}
@@ -121,6 +156,80 @@ func Scan(vars *config.ClassifiedVars, logger *slog.Logger) *Result {
 	return result
 }
 
+// htmlUnsafeChars are the characters that can end an HTML attribute value
+// or open markup when a value is placed in one unescaped.
+const htmlUnsafeChars = `"'<>`
+
+// HTMLAttributeSafe reports whether v can be written into an HTML attribute
+// value without escaping.
+func HTMLAttributeSafe(v string) bool {
+	return !strings.ContainsAny(v, htmlUnsafeChars)
+}
+
+// ScanAttributes checks the named PUBLIC variables, which a caller is about
+// to emit as HTML attribute values (meta tags, data-* attributes), for
+// characters that could break out of the attribute. Each one found is
+// logged and returned as an "html_unsafe" warning. Unlike Scan, the values
+// are not suspected secrets; the caller decides whether to refuse them or
+// to emit them escaped with html.EscapeString, which is always safe.
+func ScanAttributes(vars *config.ClassifiedVars, names []string, logger *slog.Logger) *Result {
+	result := &Result{}
+	for _, v := range vars.Public {
+		if HTMLAttributeSafe(v.Value) || !slices.Contains(names, v.Name) {
+			continue
+		}
+		w := Warning{
+			VariableName:  v.Name,
+			OriginalKey:   v.OriginalKey,
+			DetectionType: "html_unsafe",
+			Message:       fmt.Sprintf("value %s contains quotes or angle brackets, which are unsafe in an HTML attribute", maskValue(v.Value)),
+		}
+		result.Warnings = append(result.Warnings, w)
+		logger.Warn("rep.guardrail.warning",
+			"variable_name", v.Name,
+			"detection_type", "html_unsafe",
+			"value", maskValue(v.Value),
+			"detail", w.Message,
+		)
+	}
+	return result
+}
+
+// maskPrefixLen is the number of leading characters maskValue reveals.
+const maskPrefixLen = 4
+
+// maskValue returns a redacted form of a value for logs and messages: a
+// fixed-length prefix followed by the value's length in characters, e.g.
+// "AKIA…(20)". Values too short to keep most of their characters hidden are
+// shown as length only ("…(6)").
+func maskValue(v string) string {
+	n := utf8.RuneCountInString(v)
+	if n <= 2*maskPrefixLen {
+		return fmt.Sprintf("…(%d)", n)
+	}
+	// Decode only the prefix; converting the whole value to []rune would
+	// allocate for every byte of a huge value.
+	end := 0
+	for i := 0; i < maskPrefixLen; i++ {
+		_, size := utf8.DecodeRuneInString(v[end:])
+		end += size
+	}
+	return fmt.Sprintf("%s…(%d)", v[:end], n)
+}
+
+// scanSample returns at most max leading bytes of v, cut back to a rune
+// boundary so multi-byte characters are not split.
+func scanSample(v string, max int) string {
+	if len(v) <= max {
+		return v
+	}
+	end := max
+	for end > 0 && !utf8.RuneStart(v[end]) {
+		end--
+	}
+	return v[:end]
+}
+
 // shannonEntropy calculates the Shannon entropy (bits per character) of a string.
 // High entropy (>4.5) typically indicates random/secret-like content.
 func shannonEntropy(s string) float64 {
@@ -0,0 +1,97 @@
+package guardrails
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// SARIF 2.1.0 document types — only the subset needed to report guardrail
+// findings to code scanning tools (e.g. GitHub code scanning).
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifLogicalLocation struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// sarifRules describes each detection type; the detection type is the rule id.
+var sarifRules = []sarifRule{
+	{ID: "known_format", ShortDescription: sarifMessage{Text: "PUBLIC value matches a known secret format"}},
+	{ID: "high_entropy", ShortDescription: sarifMessage{Text: "PUBLIC value has high entropy and may be a secret"}},
+	{ID: "length_anomaly", ShortDescription: sarifMessage{Text: "PUBLIC value is long and unstructured and may be an encoded secret"}},
+	{ID: "html_unsafe", ShortDescription: sarifMessage{Text: "PUBLIC value emitted as an HTML attribute contains quotes or angle brackets"}},
+}
+
+// WriteSARIF writes the scan result as a SARIF 2.1.0 document with one result
+// per warning. Each result is located at its variable's full env var name.
+func (r *Result) WriteSARIF(w io.Writer, toolVersion string) error {
+	results := make([]sarifResult, 0, len(r.Warnings))
+	for _, warn := range r.Warnings {
+		results = append(results, sarifResult{
+			RuleID:  warn.DetectionType,
+			Level:   "warning",
+			Message: sarifMessage{Text: warn.OriginalKey + ": " + warn.Message},
+			Locations: []sarifLocation{{
+				LogicalLocations: []sarifLogicalLocation{{Name: warn.OriginalKey, Kind: "variable"}},
+			}},
+		})
+	}
+
+	doc := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           "rep-gateway",
+				Version:        toolVersion,
+				InformationURI: "https://github.com/ruachtech/rep",
+				Rules:          sarifRules,
+			}},
+			Results: results,
+		}},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
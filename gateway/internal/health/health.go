@@ -1,4 +1,4 @@
-// Package health provides the /rep/health endpoint.
+// Package health provides the /rep/health and /rep/ready endpoints.
 //
 // Per REP-RFC-0001 §4.5, this endpoint returns gateway health status
 // including variable counts per tier and guardrail status.
@@ -8,19 +8,26 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/ruachtech/rep/gateway/internal/config"
 	"github.com/ruachtech/rep/gateway/internal/guardrails"
+	"github.com/ruachtech/rep/gateway/internal/manifest"
 )
 
 // Response is the JSON body returned by /rep/health.
 type Response struct {
-	Status        string          `json:"status"`
-	Version       string          `json:"version"`
-	Variables     VariableCounts  `json:"variables"`
-	Guardrails    GuardrailStatus `json:"guardrails"`
-	UptimeSeconds int64           `json:"uptime_seconds"`
+	Status        string            `json:"status"`
+	Version       string            `json:"version"`
+	Variables     VariableCounts    `json:"variables"`
+	Guardrails    GuardrailStatus   `json:"guardrails"`
+	Validation    *ValidationStatus `json:"validation,omitempty"`
+	Reload        *ReloadStatus     `json:"reload,omitempty"`
+	Reloads       *ReloadStats      `json:"reloads,omitempty"`
+	HotReload     *HotReloadStatus  `json:"hot_reload,omitempty"`
+	Maintenance   bool              `json:"maintenance,omitempty"`
+	UptimeSeconds int64             `json:"uptime_seconds"`
 }
 
 // VariableCounts holds per-tier variable counts.
@@ -36,12 +43,57 @@ type GuardrailStatus struct {
 	Blocked  int `json:"blocked"`
 }
 
+// ValidationStatus holds the latest manifest validation result. It is
+// omitted from the response when no manifest is configured.
+type ValidationStatus struct {
+	Passed     bool     `json:"passed"`
+	Violations int      `json:"violations"`
+	Names      []string `json:"names,omitempty"`
+}
+
+// ReloadStatus reports a failed hot reload. It is omitted from the response
+// until a reload fails and cleared by the next successful reload. While it
+// is present the gateway keeps serving the last good payload.
+type ReloadStatus struct {
+	Stale    bool   `json:"stale"`
+	FailedAt string `json:"failed_at"`
+}
+
+// ReloadStats counts hot reloads since startup. It is omitted from the
+// response until the first reload.
+type ReloadStats struct {
+	Total          int64 `json:"total"`
+	Failures       int64 `json:"failures"`
+	LastDurationMs int64 `json:"last_duration_ms"`
+	MaxDurationMs  int64 `json:"max_duration_ms"`
+}
+
+// HotReloadStatus reports /rep/changes fan-out. It is omitted from the
+// response when hot reload is disabled.
+type HotReloadStatus struct {
+	Clients int `json:"clients"`
+}
+
+// ReadyResponse is the JSON body returned by /rep/ready.
+type ReadyResponse struct {
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"`
+}
+
 // Handler serves the /rep/health endpoint.
 type Handler struct {
 	version         string
-	vars            *config.ClassifiedVars
 	guardrailResult *guardrails.Result
 	startTime       time.Time
+
+	// mu protects the fields below, which change on hot reload.
+	mu          sync.RWMutex
+	vars        *config.ClassifiedVars
+	validation  *ValidationStatus
+	reload      *ReloadStatus
+	reloads     *ReloadStats
+	sseClients  func() int
+	maintenance bool
 }
 
 // NewHandler creates a new health check handler.
@@ -54,6 +106,95 @@ func NewHandler(version string, vars *config.ClassifiedVars, gr *guardrails.Resu
 	}
 }
 
+// SetVars replaces the variables reported in the counts (used after reload).
+func (h *Handler) SetVars(vars *config.ClassifiedVars) {
+	h.mu.Lock()
+	h.vars = vars
+	h.mu.Unlock()
+}
+
+// SetValidation records the latest manifest validation result. An empty
+// violations slice marks validation as passed.
+func (h *Handler) SetValidation(violations []manifest.Violation) {
+	status := &ValidationStatus{
+		Passed:     len(violations) == 0,
+		Violations: len(violations),
+	}
+	seen := make(map[string]bool, len(violations))
+	for _, v := range violations {
+		if !seen[v.Name] {
+			seen[v.Name] = true
+			status.Names = append(status.Names, v.Name)
+		}
+	}
+
+	h.mu.Lock()
+	h.validation = status
+	h.mu.Unlock()
+}
+
+// SetReloadFailed records whether the latest hot reload failed. A failed
+// reload marks the served payload as stale and the gateway as degraded; it
+// does not affect readiness, since the previous payload is still served.
+func (h *Handler) SetReloadFailed(failed bool) {
+	var status *ReloadStatus
+	if failed {
+		status = &ReloadStatus{Stale: true, FailedAt: time.Now().UTC().Format(time.RFC3339)}
+	}
+
+	h.mu.Lock()
+	h.reload = status
+	h.mu.Unlock()
+}
+
+// RecordReload counts a hot reload that took d, and records whether it
+// failed as SetReloadFailed does.
+func (h *Handler) RecordReload(d time.Duration, failed bool) {
+	h.mu.Lock()
+	stats := ReloadStats{}
+	if h.reloads != nil {
+		stats = *h.reloads
+	}
+	stats.Total++
+	if failed {
+		stats.Failures++
+	}
+	stats.LastDurationMs = d.Milliseconds()
+	stats.MaxDurationMs = max(stats.MaxDurationMs, stats.LastDurationMs)
+	h.reloads = &stats
+	h.mu.Unlock()
+
+	h.SetReloadFailed(failed)
+}
+
+// SetSSEClients reports the number of connected /rep/changes clients, as
+// returned by count, in the hot_reload block of the response.
+func (h *Handler) SetSSEClients(count func() int) {
+	h.mu.Lock()
+	h.sseClients = count
+	h.mu.Unlock()
+}
+
+// SetMaintenance records whether the gateway is in maintenance mode. It
+// is reported as "maintenance": true and does not change the status or
+// readiness: the gateway is working, and serves the maintenance page.
+func (h *Handler) SetMaintenance(on bool) {
+	h.mu.Lock()
+	h.maintenance = on
+	h.mu.Unlock()
+}
+
+// Ready reports whether the gateway should receive traffic. It is false
+// when the latest manifest validation failed, along with a reason.
+func (h *Handler) Ready() (bool, string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.validation != nil && !h.validation.Passed {
+		return false, "manifest validation failed"
+	}
+	return true, ""
+}
+
 // ServeHTTP handles GET /rep/health requests.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -67,8 +208,17 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		warnings = len(h.guardrailResult.Warnings)
 	}
 
+	status := "healthy"
+	if ready, _ := h.Ready(); !ready {
+		status = "degraded"
+	}
+
+	h.mu.RLock()
+	if h.reload != nil {
+		status = "degraded"
+	}
 	resp := Response{
-		Status:  "healthy",
+		Status:  status,
 		Version: h.version,
 		Variables: VariableCounts{
 			Public:    len(h.vars.Public),
@@ -79,8 +229,16 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			Warnings: warnings,
 			Blocked:  blocked,
 		},
+		Validation:    h.validation,
+		Reload:        h.reload,
+		Reloads:       h.reloads,
+		Maintenance:   h.maintenance,
 		UptimeSeconds: int64(time.Since(h.startTime).Seconds()),
 	}
+	if h.sseClients != nil {
+		resp.HotReload = &HotReloadStatus{Clients: h.sseClients()}
+	}
+	h.mu.RUnlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -88,3 +246,46 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		slog.Default().Error("rep.health.encode_error", "error", err)
 	}
 }
+
+// ReadyHandler returns a handler for GET /rep/ready. It responds 200 when
+// the gateway is ready and 503 otherwise, for use as a readiness probe.
+func (h *Handler) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ready, reason := h.Ready()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(ReadyResponse{Ready: ready, Reason: reason}); err != nil {
+			slog.Default().Error("rep.health.encode_error", "error", err)
+		}
+	})
+}
+
+// InitializingHandler serves probes while the gateway is still starting up:
+// /rep/health reports status "initializing" (200, so liveness probes pass)
+// and /rep/ready reports not ready (503). Every other path gets 503 with
+// Retry-After.
+func InitializingHandler(version string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		switch r.URL.Path {
+		case "/rep/health":
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]string{"status": "initializing", "version": version})
+		case "/rep/ready":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(ReadyResponse{Ready: false, Reason: "initializing"})
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "gateway initializing", http.StatusServiceUnavailable)
+		}
+	})
+}
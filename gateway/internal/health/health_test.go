@@ -9,6 +9,7 @@ import (
 
 	"github.com/ruachtech/rep/gateway/internal/config"
 	"github.com/ruachtech/rep/gateway/internal/guardrails"
+	"github.com/ruachtech/rep/gateway/internal/manifest"
 )
 
 func TestHealth_Success(t *testing.T) {
@@ -155,3 +156,165 @@ func TestHealth_ContentType(t *testing.T) {
 		t.Errorf("expected application/json, got %q", ct)
 	}
 }
+
+func TestHealth_ValidationOmittedWithoutManifest(t *testing.T) {
+	h := NewHandler("0.1.0", &config.ClassifiedVars{}, &guardrails.Result{}, time.Now())
+
+	req := httptest.NewRequest(http.MethodGet, "/rep/health", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var resp Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if resp.Validation != nil {
+		t.Errorf("expected no validation block, got %+v", resp.Validation)
+	}
+}
+
+func TestHealth_ValidationFailed(t *testing.T) {
+	h := NewHandler("0.1.0", &config.ClassifiedVars{}, &guardrails.Result{}, time.Now())
+	h.SetValidation([]manifest.Violation{
+		{Name: "API_URL", Kind: manifest.KindMissingRequired, Message: "required variable \"API_URL\" is not set"},
+		{Name: "MODE", Kind: manifest.KindType, Message: "bad"},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/rep/health", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var resp Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if resp.Status != "degraded" {
+		t.Errorf("expected status=degraded, got %s", resp.Status)
+	}
+	if resp.Validation == nil || resp.Validation.Passed || resp.Validation.Violations != 2 {
+		t.Fatalf("unexpected validation status: %+v", resp.Validation)
+	}
+	if len(resp.Validation.Names) != 2 || resp.Validation.Names[0] != "API_URL" {
+		t.Errorf("unexpected violation names: %v", resp.Validation.Names)
+	}
+}
+
+func TestHealth_ReloadFailed(t *testing.T) {
+	h := NewHandler("0.1.0", &config.ClassifiedVars{}, &guardrails.Result{}, time.Now())
+	h.SetReloadFailed(true)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rep/health", nil))
+	var resp Response
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if resp.Status != "degraded" {
+		t.Errorf("expected status=degraded, got %s", resp.Status)
+	}
+	if resp.Reload == nil || !resp.Reload.Stale || resp.Reload.FailedAt == "" {
+		t.Errorf("unexpected reload status: %+v", resp.Reload)
+	}
+	if ready, _ := h.Ready(); !ready {
+		t.Error("a failed reload should not affect readiness")
+	}
+
+	h.SetReloadFailed(false)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rep/health", nil))
+	resp = Response{}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if resp.Status != "healthy" || resp.Reload != nil {
+		t.Errorf("expected healthy with no reload block, got %+v", resp)
+	}
+}
+
+func TestHealth_ReloadStats(t *testing.T) {
+	h := NewHandler("0.1.0", &config.ClassifiedVars{}, &guardrails.Result{}, time.Now())
+
+	get := func() Response {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rep/health", nil))
+		var resp Response
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		return resp
+	}
+
+	if resp := get(); resp.Reloads != nil {
+		t.Errorf("expected no reloads block before the first reload, got %+v", resp.Reloads)
+	}
+
+	h.RecordReload(30*time.Millisecond, false)
+	h.RecordReload(10*time.Millisecond, true)
+	resp := get()
+	want := ReloadStats{Total: 2, Failures: 1, LastDurationMs: 10, MaxDurationMs: 30}
+	if resp.Reloads == nil || *resp.Reloads != want {
+		t.Errorf("expected %+v, got %+v", want, resp.Reloads)
+	}
+	if resp.Reload == nil || !resp.Reload.Stale {
+		t.Error("expected the failed reload to mark the payload stale")
+	}
+}
+
+func TestHealth_SSEClients(t *testing.T) {
+	h := NewHandler("0.1.0", &config.ClassifiedVars{}, &guardrails.Result{}, time.Now())
+
+	get := func() Response {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rep/health", nil))
+		var resp Response
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		return resp
+	}
+
+	if resp := get(); resp.HotReload != nil {
+		t.Errorf("expected no hot_reload block without hot reload, got %+v", resp.HotReload)
+	}
+
+	clients := 3
+	h.SetSSEClients(func() int { return clients })
+	if resp := get(); resp.HotReload == nil || resp.HotReload.Clients != 3 {
+		t.Errorf("expected 3 clients, got %+v", resp.HotReload)
+	}
+	clients = 1
+	if resp := get(); resp.HotReload == nil || resp.HotReload.Clients != 1 {
+		t.Errorf("expected the count to be read per request, got %+v", resp.HotReload)
+	}
+}
+
+func TestReady(t *testing.T) {
+	h := NewHandler("0.1.0", &config.ClassifiedVars{}, &guardrails.Result{}, time.Now())
+	ready := h.ReadyHandler()
+
+	get := func() (int, ReadyResponse) {
+		rec := httptest.NewRecorder()
+		ready.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rep/ready", nil))
+		var resp ReadyResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		return rec.Code, resp
+	}
+
+	if code, resp := get(); code != http.StatusOK || !resp.Ready {
+		t.Errorf("expected ready 200, got %d %+v", code, resp)
+	}
+
+	h.SetValidation([]manifest.Violation{{Name: "API_URL"}})
+	if code, resp := get(); code != http.StatusServiceUnavailable || resp.Ready {
+		t.Errorf("expected not ready 503, got %d %+v", code, resp)
+	}
+
+	h.SetValidation(nil)
+	if code, _ := get(); code != http.StatusOK {
+		t.Errorf("expected ready again after passing validation, got %d", code)
+	}
+}
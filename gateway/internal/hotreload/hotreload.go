@@ -5,41 +5,113 @@
 package hotreload
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/ruachtech/rep/gateway/internal/ratelimit"
 )
 
 // Event represents a configuration change event.
 type Event struct {
-	Type  string // "rep:config:update" or "rep:config:delete"
+	Type  string // "rep:config:update", "rep:config:delete", "rep:config:error" or "rep:config:reload"
 	Key   string
 	Tier  string
 	Value string // Empty for delete events.
+
+	// Error describes a failed reload ("rep:config:error" only). The
+	// payload clients hold is stale until a later reload succeeds.
+	Error string
+
+	// ID is the event's position, assigned by Broadcast from a counter
+	// shared by all clients. It increases by one per event for the life of
+	// the hub. The SSE id sent to clients prefixes it with the hub's epoch.
+	ID uint64
+
+	at time.Time // When the event was broadcast, for replay expiry.
 }
 
+// DefaultClientBuffer is the number of events buffered per SSE client
+// before further events are dropped for that client.
+const DefaultClientBuffer = 16
+
 // Hub manages SSE client connections and broadcasts events.
 type Hub struct {
 	mu      sync.RWMutex
 	clients map[chan Event]struct{}
+	lastID  uint64 // Guarded by mu (write lock).
+	epoch   string // Distinguishes this hub's SSE ids from a previous process's.
+	buffer  int
 	logger  *slog.Logger
+
+	// Replay state (see WithReplay), guarded by mu. history holds the most
+	// recent events in id order.
+	replay  int
+	maxAge  time.Duration
+	history []Event
+	now     func() time.Time
+}
+
+// HubOption configures optional Hub behaviour.
+type HubOption func(*Hub)
+
+// WithClientBuffer sets how many events each client may have pending
+// before the hub drops events for it. n <= 0 keeps DefaultClientBuffer.
+func WithClientBuffer(n int) HubOption {
+	return func(h *Hub) {
+		if n > 0 {
+			h.buffer = n
+		}
+	}
+}
+
+// WithReplay keeps up to n recent events, each for at most maxAge, so that
+// a client reconnecting with Last-Event-ID is sent the events it missed.
+// If some of those events are no longer kept, or the id is from another
+// epoch, the client is instead sent a single "rep:config:reload" event
+// telling it to refetch its whole configuration, so it never applies a
+// partial set of deltas. n <= 0 disables replay; maxAge <= 0 expires events
+// by count only.
+func WithReplay(n int, maxAge time.Duration) HubOption {
+	return func(h *Hub) {
+		h.replay = n
+		h.maxAge = maxAge
+	}
 }
 
 // NewHub creates a new hot reload hub.
-func NewHub(logger *slog.Logger) *Hub {
-	return &Hub{
+func NewHub(logger *slog.Logger, opts ...HubOption) *Hub {
+	h := &Hub{
 		clients: make(map[chan Event]struct{}),
+		buffer:  DefaultClientBuffer,
 		logger:  logger,
+		now:     time.Now,
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
 
-// Broadcast sends an event to all connected clients.
+// Broadcast assigns the event the next id and sends it to all connected
+// clients. Broadcasts are serialized so every client sees ids in order.
 func (h *Hub) Broadcast(event Event) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastID++
+	event.ID = h.lastID
+	h.record(event)
 
 	for ch := range h.clients {
 		select {
@@ -54,6 +126,7 @@ func (h *Hub) Broadcast(event Event) {
 		"key", event.Key,
 		"tier", event.Tier,
 		"action", event.Type,
+		"event_id", event.ID,
 		"clients_notified", len(h.clients),
 	)
 }
@@ -77,11 +150,85 @@ func (h *Hub) Close() {
 	}
 }
 
+// record adds event to the replay state. The caller holds mu.
+func (h *Hub) record(event Event) {
+	if h.replay <= 0 {
+		return
+	}
+	event.at = h.now()
+	h.history = append(h.history, event)
+	if over := len(h.history) - h.replay; over > 0 {
+		h.history = slices.Delete(h.history, 0, over)
+	}
+}
+
+// missed returns the events a client that last saw lastID has missed, and
+// whether they are a reload event rather than a replay. A client from
+// another epoch, or whose gap is no longer fully kept, gets the reload
+// event, with the current id so that it resumes from here. A position the
+// hub has not reached yet gets nothing. The caller holds mu.
+func (h *Hub) missed(lastID uint64, foreign bool) ([]Event, bool) {
+	if h.replay <= 0 {
+		return nil, false
+	}
+	reload := []Event{{Type: "rep:config:reload", ID: h.lastID}}
+	if foreign {
+		return reload, true
+	}
+	if lastID >= h.lastID {
+		return nil, false
+	}
+	if h.maxAge > 0 {
+		cutoff := h.now().Add(-h.maxAge)
+		i := 0
+		for i < len(h.history) && h.history[i].at.Before(cutoff) {
+			i++
+		}
+		h.history = slices.Delete(h.history, 0, i)
+	}
+
+	// History ids are consecutive, so it covers the gap if it reaches back
+	// to the event after lastID.
+	if len(h.history) > 0 && h.history[0].ID <= lastID+1 {
+		return slices.Clone(h.history[lastID+1-h.history[0].ID:]), false
+	}
+	return reload, true
+}
+
+// position maps a client's Last-Event-ID to the last position it saw. A
+// fresh client (no header) gets math.MaxUint64, so nothing is replayed. An
+// id from another epoch, e.g. issued before a restart, is not a position in
+// this hub's stream at all: position reports false, and the client was
+// served by a process whose values may differ from this one's in any key.
+func (h *Hub) position(lastEventID string) (uint64, bool) {
+	if lastEventID == "" {
+		return math.MaxUint64, true
+	}
+	epoch, n, ok := strings.Cut(lastEventID, "-")
+	if !ok || epoch != h.epoch {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(n, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
 // subscribe registers a new client channel and returns an unsubscribe function.
 func (h *Hub) subscribe() (chan Event, func()) {
-	ch := make(chan Event, 16) // Buffered to handle bursts.
+	ch, _, _, unsub := h.resume(math.MaxUint64, false)
+	return ch, unsub
+}
+
+// resume is like subscribe, but also returns the events the client missed
+// since lastID and whether they are a reload event (see missed). Both
+// happen under one lock, so no event is missed or sent twice.
+func (h *Hub) resume(lastID uint64, foreign bool) (chan Event, []Event, bool, func()) {
+	ch := make(chan Event, h.buffer) // Buffered to handle bursts.
 
 	h.mu.Lock()
+	backlog, reload := h.missed(lastID, foreign)
 	h.clients[ch] = struct{}{}
 	h.mu.Unlock()
 
@@ -96,41 +243,174 @@ func (h *Hub) subscribe() (chan Event, func()) {
 		}
 	}
 
-	return ch, unsub
+	return ch, backlog, reload, unsub
 }
 
 // Handler serves the GET /rep/changes SSE endpoint.
 type Handler struct {
 	hub *Hub
+
+	// maxPerIP caps concurrent connections from one client IP (0 = no cap).
+	maxPerIP int
+
+	// compress gzips streams for clients that accept it.
+	compress bool
+
+	mu     sync.Mutex
+	perIP  map[string]int
+	logger *slog.Logger
+}
+
+// HandlerOption configures optional Handler behaviour.
+type HandlerOption func(*Handler)
+
+// WithMaxConnectionsPerIP limits how many SSE connections a single client
+// IP may hold open at once. Further connections from that IP get 429 until
+// one of its existing connections closes. n <= 0 means no limit.
+func WithMaxConnectionsPerIP(n int) HandlerOption {
+	return func(h *Handler) {
+		h.maxPerIP = n
+	}
+}
+
+// WithCompression gzips the stream for clients that send Accept-Encoding:
+// gzip. Every frame is sync-flushed through the compressor, so events are
+// never held back waiting for more data; the cost is a few bytes of flush
+// overhead per frame and a compressor (several hundred KiB) held for the
+// life of each connection. The compression window spans frames, so
+// streams of similar JSON events still compress well.
+func WithCompression(enabled bool) HandlerOption {
+	return func(h *Handler) {
+		h.compress = enabled
+	}
 }
 
 // NewHandler creates a new SSE handler backed by the given hub.
-func NewHandler(hub *Hub) *Handler {
-	return &Handler{hub: hub}
+func NewHandler(hub *Hub, opts ...HandlerOption) *Handler {
+	h := &Handler{
+		hub:    hub,
+		perIP:  make(map[string]int),
+		logger: hub.logger,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// acquire reserves a connection slot for ip, reporting false when the IP
+// is at its limit. Every successful acquire must be paired with release.
+func (h *Handler) acquire(ip string) bool {
+	if h.maxPerIP <= 0 {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.perIP[ip] >= h.maxPerIP {
+		return false
+	}
+	h.perIP[ip]++
+	return true
+}
+
+// release frees a slot reserved by acquire.
+func (h *Handler) release(ip string) {
+	if h.maxPerIP <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.perIP[ip] <= 1 {
+		delete(h.perIP, ip)
+	} else {
+		h.perIP[ip]--
+	}
 }
 
 // ServeHTTP handles SSE connections.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Verify the client supports SSE.
-	flusher, ok := w.(http.Flusher)
-	if !ok {
+	if _, ok := w.(http.Flusher); !ok {
 		http.Error(w, "streaming not supported", http.StatusInternalServerError)
 		return
 	}
 
+	// Enforce the per-IP connection cap; the slot is freed on disconnect.
+	clientIP := ratelimit.ClientIP(r)
+	if !h.acquire(clientIP) {
+		h.logger.Warn("rep.hotreload.too_many_connections",
+			"client_ip", clientIP,
+			"limit", h.maxPerIP,
+		)
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+	defer h.release(clientIP)
+
 	// Set SSE headers.
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx buffering.
 
-	// Subscribe to events.
-	ch, unsub := h.hub.subscribe()
+	// Frames are written to out: the response itself, or a gzip stream
+	// over it that is sync-flushed after each frame.
+	var out io.Writer = w
+	var gz *gzip.Writer
+	if h.compress {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz = gzip.NewWriter(w)
+			defer func() { _ = gz.Close() }()
+			out = gz
+		}
+	}
+
+	// Subscribe to events. EventSource sends Last-Event-ID when it
+	// reconnects; without it the client is fresh.
+	lastID, known := h.hub.position(r.Header.Get("Last-Event-ID"))
+	ch, backlog, reload, unsub := h.hub.resume(lastID, !known)
 	defer unsub()
 
+	// send writes one SSE frame and flushes it. A write or flush error means
+	// the client is gone (possibly a half-open connection whose context has
+	// not been cancelled yet), so the caller returns and unsubscribes.
+	rc := http.NewResponseController(w)
+	send := func(frame string) bool {
+		_, err := io.WriteString(out, frame)
+		if err == nil && gz != nil {
+			err = gz.Flush()
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			h.logger.Debug("rep.hotreload.write_failed", "client_ip", clientIP, "error", err)
+			return false
+		}
+		return true
+	}
+
 	// Send initial ping to confirm connection.
-	_, _ = fmt.Fprintf(w, ": connected to REP hot reload\n\n")
-	flusher.Flush()
+	if !send(": connected to REP hot reload\n\n") {
+		return
+	}
+
+	// Catch a reconnecting client up before streaming live events.
+	if len(backlog) > 0 {
+		h.logger.Debug("rep.hotreload.replay",
+			"client_ip", clientIP,
+			"last_event_id", r.Header.Get("Last-Event-ID"),
+			"events", len(backlog),
+			"reload", reload,
+		)
+	}
+	for _, event := range backlog {
+		if !send(h.hub.frame(event)) {
+			return
+		}
+	}
 
 	// Keep-alive ticker.
 	ticker := time.NewTicker(30 * time.Second)
@@ -143,24 +423,53 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				return // Channel closed.
 			}
 
-			data, _ := json.Marshal(map[string]string{
-				"key":   event.Key,
-				"tier":  event.Tier,
-				"value": event.Value,
-			})
-
-			_, _ = fmt.Fprintf(w, "event: %s\n", event.Type)
-			_, _ = fmt.Fprintf(w, "data: %s\n", string(data))
-			_, _ = fmt.Fprintf(w, "id: %d\n\n", time.Now().UnixMilli())
-			flusher.Flush()
+			if !send(h.hub.frame(event)) {
+				return
+			}
 
 		case <-ticker.C:
 			// Keep-alive comment to prevent proxy timeouts.
-			_, _ = fmt.Fprintf(w, ": keepalive\n\n")
-			flusher.Flush()
+			if !send(": keepalive\n\n") {
+				return
+			}
 
 		case <-r.Context().Done():
 			return // Client disconnected.
 		}
 	}
 }
+
+// frame formats event as an SSE frame, with the id "<epoch>-<ID>".
+func (h *Hub) frame(event Event) string {
+	fields := map[string]string{
+		"key":   event.Key,
+		"tier":  event.Tier,
+		"value": event.Value,
+	}
+	switch {
+	case event.Type == "rep:config:reload":
+		fields = map[string]string{}
+	case event.Error != "":
+		fields = map[string]string{"error": event.Error}
+	}
+	data, _ := json.Marshal(fields)
+	return fmt.Sprintf("event: %s\ndata: %s\nid: %s-%d\n\n", event.Type, data, h.epoch, event.ID)
+}
+
+// acceptsGzip reports whether r's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		// "gzip;q=0" explicitly refuses it.
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if n, err := strconv.ParseFloat(q, 64); err == nil && n == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -2,9 +2,15 @@ package hotreload
 
 import (
 	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -86,12 +92,12 @@ func TestHub_SlowClient(t *testing.T) {
 	ch, unsub := hub.subscribe()
 	defer unsub()
 
-	// Fill the buffer (16 events).
-	for i := 0; i < 16; i++ {
+	// Fill the buffer.
+	for i := 0; i < DefaultClientBuffer; i++ {
 		hub.Broadcast(Event{Type: "rep:config:update", Key: "KEY"})
 	}
 
-	// 17th should not block (dropped for slow client).
+	// The next one should not block (dropped for slow client).
 	done := make(chan struct{})
 	go func() {
 		hub.Broadcast(Event{Type: "rep:config:update", Key: "OVERFLOW"})
@@ -113,6 +119,28 @@ func TestHub_SlowClient(t *testing.T) {
 	}
 }
 
+func TestHub_ClientBuffer(t *testing.T) {
+	// A burst larger than the default buffer, sent while the client is
+	// not reading.
+	const burst = DefaultClientBuffer + 8
+
+	deliver := func(hub *Hub) int {
+		ch, unsub := hub.subscribe()
+		defer unsub()
+		for range burst {
+			hub.Broadcast(Event{Type: "rep:config:update", Key: "KEY"})
+		}
+		return len(ch)
+	}
+
+	if got := deliver(NewHub(slog.Default())); got != DefaultClientBuffer {
+		t.Errorf("expected the default buffer to hold %d events, got %d", DefaultClientBuffer, got)
+	}
+	if got := deliver(NewHub(slog.Default(), WithClientBuffer(64))); got != burst {
+		t.Errorf("expected a 64-event buffer to absorb the %d-event burst, got %d", burst, got)
+	}
+}
+
 func TestSSEHandler_Headers(t *testing.T) {
 	hub := NewHub(slog.Default())
 	h := NewHandler(hub)
@@ -203,3 +231,372 @@ func TestSSEHandler_ReceivesEvent(t *testing.T) {
 		t.Error("expected data: line with TEST_KEY in SSE output")
 	}
 }
+
+func TestSSEHandler_EventIDsIncrease(t *testing.T) {
+	hub := NewHub(slog.Default())
+	defer hub.Close()
+
+	server := httptest.NewServer(NewHandler(hub))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/rep/changes")
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	scanner := bufio.NewScanner(resp.Body)
+	if !scanner.Scan() {
+		t.Fatal("expected initial comment line")
+	}
+
+	// Broadcast faster than the clock ticks; timestamp ids would collide.
+	const n = 10
+	for range n {
+		hub.Broadcast(Event{Type: "rep:config:update", Key: "KEY", Tier: "public"})
+	}
+
+	var ids []uint64
+	for len(ids) < n && scanner.Scan() {
+		if rest, ok := strings.CutPrefix(scanner.Text(), "id: "); ok {
+			epoch, n, _ := strings.Cut(rest, "-")
+			if epoch != hub.epoch {
+				t.Fatalf("id %q does not carry the hub epoch %q", rest, hub.epoch)
+			}
+			id, err := strconv.ParseUint(n, 10, 64)
+			if err != nil {
+				t.Fatalf("id %q does not end in a number: %v", rest, err)
+			}
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) != n {
+		t.Fatalf("expected %d ids, got %v", n, ids)
+	}
+	for i := 1; i < n; i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("expected strictly increasing ids, got %v", ids)
+		}
+	}
+}
+
+func TestSSEHandler_Gzip(t *testing.T) {
+	hub := NewHub(slog.Default())
+	defer hub.Close()
+
+	server := httptest.NewServer(NewHandler(hub, WithCompression(true)))
+	defer server.Close()
+
+	// Setting Accept-Encoding ourselves stops the transport from
+	// decompressing transparently.
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/rep/changes", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("expected Content-Encoding gzip, got %q", got)
+	}
+
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	lines := make(chan string, 16)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(zr)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	// next returns the next non-blank line, failing if the frame carrying
+	// it was not flushed promptly.
+	next := func() string {
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatal("stream ended")
+				}
+				if line != "" {
+					return line
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("timed out waiting for a flushed frame")
+			}
+		}
+	}
+
+	if line := next(); !strings.HasPrefix(line, ": connected") {
+		t.Fatalf("expected connection comment, got %q", line)
+	}
+	for i, key := range []string{"FIRST", "SECOND"} {
+		hub.Broadcast(Event{Type: "rep:config:update", Key: key, Tier: "public", Value: "v"})
+		if line := next(); line != "event: rep:config:update" {
+			t.Fatalf("event %d: expected event line, got %q", i, line)
+		}
+		if line := next(); line != `data: {"key":"`+key+`","tier":"public","value":"v"}` {
+			t.Fatalf("event %d: unexpected data line %q", i, line)
+		}
+		if line := next(); line != "id: "+hub.epoch+"-"+strconv.Itoa(i+1) {
+			t.Fatalf("event %d: unexpected id line %q", i, line)
+		}
+	}
+}
+
+func TestSSEHandler_GzipNotAccepted(t *testing.T) {
+	hub := NewHub(slog.Default())
+	defer hub.Close()
+	h := NewHandler(hub, WithCompression(true))
+
+	for _, accept := range []string{"", "identity", "br, gzip;q=0"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, "/rep/changes", nil).WithContext(ctx)
+		req.Header.Set("Accept-Encoding", accept)
+		rec := httptest.NewRecorder()
+		cancel() // Return after the initial comment.
+		h.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("%q: expected no Content-Encoding, got %q", accept, got)
+		}
+		if !strings.HasPrefix(rec.Body.String(), ": connected") {
+			t.Errorf("%q: expected a plain stream, got %q", accept, rec.Body.String())
+		}
+	}
+}
+
+// readEvents connects to server with the given Last-Event-ID and returns
+// the first n events it is sent, as "id key=value" strings ("id reload" for
+// a reload event).
+func readEvents(t *testing.T, url, lastID string, n int) []string {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Last-Event-ID", lastID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var events []string
+	var typ, data string
+	scanner := bufio.NewScanner(resp.Body)
+	for len(events) < n && scanner.Scan() {
+		line := scanner.Text()
+		if rest, ok := strings.CutPrefix(line, "event: "); ok {
+			typ = rest
+		}
+		if rest, ok := strings.CutPrefix(line, "data: "); ok {
+			data = rest
+		}
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			var fields map[string]string
+			if err := json.Unmarshal([]byte(data), &fields); err != nil {
+				t.Fatalf("bad data line %q: %v", data, err)
+			}
+			if typ == "rep:config:reload" {
+				events = append(events, id+" reload")
+				continue
+			}
+			events = append(events, id+" "+fields["key"]+"="+fields["value"])
+		}
+	}
+	return events
+}
+
+func TestSSEHandler_ReplayWithinWindow(t *testing.T) {
+	hub := NewHub(slog.Default(), WithReplay(10, time.Minute))
+	defer hub.Close()
+	hub.epoch = "boot"
+	server := httptest.NewServer(NewHandler(hub))
+	defer server.Close()
+
+	hub.Broadcast(Event{Type: "rep:config:update", Key: "A", Tier: "public", Value: "1"})
+	hub.Broadcast(Event{Type: "rep:config:update", Key: "B", Tier: "public", Value: "1"})
+	hub.Broadcast(Event{Type: "rep:config:update", Key: "A", Tier: "public", Value: "2"})
+
+	// Every missed event is replayed in order, including superseded ones.
+	got := readEvents(t, server.URL, "boot-1", 2)
+	if want := []string{"boot-2 B=1", "boot-3 A=2"}; !slices.Equal(got, want) {
+		t.Errorf("replay: got %v, want %v", got, want)
+	}
+}
+
+func TestSSEHandler_ReplayAcrossRestart(t *testing.T) {
+	hub := NewHub(slog.Default(), WithReplay(10, time.Minute))
+	defer hub.Close()
+	hub.epoch = "boot2"
+	server := httptest.NewServer(NewHandler(hub))
+	defer server.Close()
+
+	hub.Broadcast(Event{Type: "rep:config:update", Key: "A", Tier: "public", Value: "1"})
+	hub.Broadcast(Event{Type: "rep:config:update", Key: "B", Tier: "public", Value: "1"})
+	hub.Broadcast(Event{Type: "rep:config:update", Key: "A", Tier: "public", Value: "2"})
+
+	// The client's page was served by the previous process, whose values
+	// may differ from this one's in keys that never changed here. Neither a
+	// replay from id 2 nor this process's changes would catch it up, so it
+	// is told to reload, at the current id.
+	for _, lastID := range []string{"boot1-1", "1", "boot2-x"} {
+		got := readEvents(t, server.URL, lastID, 1)
+		if want := []string{"boot2-3 reload"}; !slices.Equal(got, want) {
+			t.Errorf("%s: got %v, want %v", lastID, got, want)
+		}
+	}
+
+	// Reconnecting with the reload event's id resumes from there.
+	hub.Broadcast(Event{Type: "rep:config:update", Key: "A", Tier: "public", Value: "3"})
+	got := readEvents(t, server.URL, "boot2-3", 1)
+	if want := []string{"boot2-4 A=3"}; !slices.Equal(got, want) {
+		t.Errorf("after reload: got %v, want %v", got, want)
+	}
+}
+
+func TestSSEHandler_ReloadBeyondWindow(t *testing.T) {
+	hub := NewHub(slog.Default(), WithReplay(10, time.Minute))
+	defer hub.Close()
+	hub.epoch = "boot"
+	now := time.Now()
+	hub.now = func() time.Time { return now }
+	server := httptest.NewServer(NewHandler(hub))
+	defer server.Close()
+
+	hub.Broadcast(Event{Type: "rep:config:update", Key: "A", Tier: "public", Value: "1"})
+	hub.Broadcast(Event{Type: "rep:config:update", Key: "B", Tier: "public", Value: "1"})
+	hub.Broadcast(Event{Type: "rep:config:update", Key: "A", Tier: "public", Value: "2"})
+	hub.Broadcast(Event{Type: "rep:config:delete", Key: "C", Tier: "public"})
+	now = now.Add(2 * time.Minute)
+	hub.Broadcast(Event{Type: "rep:config:update", Key: "D", Tier: "public", Value: "1"})
+
+	// Events 2-4 have expired, so replaying only 5 would leave the client
+	// with stale values. It is told to reload instead.
+	got := readEvents(t, server.URL, "boot-1", 1)
+	if want := []string{"boot-5 reload"}; !slices.Equal(got, want) {
+		t.Errorf("expired: got %v, want %v", got, want)
+	}
+
+	// Dropping events by count also falls back to a reload.
+	small := NewHub(slog.Default(), WithReplay(1, 0))
+	defer small.Close()
+	small.epoch = "boot"
+	smallServer := httptest.NewServer(NewHandler(small))
+	defer smallServer.Close()
+	small.Broadcast(Event{Type: "rep:config:update", Key: "A", Tier: "public", Value: "1"})
+	small.Broadcast(Event{Type: "rep:config:update", Key: "B", Tier: "public", Value: "1"})
+	small.Broadcast(Event{Type: "rep:config:update", Key: "C", Tier: "public", Value: "1"})
+	got = readEvents(t, smallServer.URL, "boot-1", 1)
+	if want := []string{"boot-3 reload"}; !slices.Equal(got, want) {
+		t.Errorf("count-expired: got %v, want %v", got, want)
+	}
+}
+
+func TestSSEHandler_MaxConnectionsPerIP(t *testing.T) {
+	hub := NewHub(slog.Default())
+	defer hub.Close()
+	h := NewHandler(hub, WithMaxConnectionsPerIP(2))
+
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	connect := func(ip string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/rep/changes", nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET error: %v", err)
+		}
+		return resp
+	}
+
+	first, second := connect("10.0.0.1"), connect("10.0.0.1")
+	defer func() { _ = second.Body.Close() }()
+	if first.StatusCode != http.StatusOK || second.StatusCode != http.StatusOK {
+		t.Fatalf("expected two connections to be accepted, got %d and %d", first.StatusCode, second.StatusCode)
+	}
+
+	third := connect("10.0.0.1")
+	_ = third.Body.Close()
+	if third.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected 429 for a third connection from the same IP, got %d", third.StatusCode)
+	}
+
+	other := connect("10.0.0.2")
+	defer func() { _ = other.Body.Close() }()
+	if other.StatusCode != http.StatusOK {
+		t.Errorf("expected another IP to be unaffected, got %d", other.StatusCode)
+	}
+
+	// Closing a connection frees its slot once the handler notices.
+	_ = first.Body.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp := connect("10.0.0.1")
+		code := resp.StatusCode
+		_ = resp.Body.Close()
+		if code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("slot was not freed after disconnect (last status %d)", code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// failingWriter is a streaming ResponseWriter whose writes fail after the
+// first okWrites, like a connection the peer has silently dropped.
+type failingWriter struct {
+	header   http.Header
+	okWrites int
+}
+
+func (w *failingWriter) Header() http.Header { return w.header }
+func (w *failingWriter) WriteHeader(int)     {}
+func (w *failingWriter) Flush()              {}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.okWrites == 0 {
+		return 0, errors.New("broken pipe")
+	}
+	w.okWrites--
+	return len(p), nil
+}
+
+func TestSSEHandler_WriteErrorUnsubscribes(t *testing.T) {
+	hub := NewHub(slog.Default())
+	h := NewHandler(hub)
+
+	// The request context is never cancelled: only the write error can
+	// end the stream.
+	w := &failingWriter{header: http.Header{}, okWrites: 1}
+	req := httptest.NewRequest(http.MethodGet, "/rep/changes", nil)
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(w, req)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for hub.ClientCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("handler never subscribed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	hub.Broadcast(Event{Type: "rep:config:update", Key: "KEY", Tier: "public"})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler did not return after a write error")
+	}
+	if n := hub.ClientCount(); n != 0 {
+		t.Errorf("expected the client to be unsubscribed, got %d clients", n)
+	}
+}
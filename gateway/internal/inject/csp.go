@@ -0,0 +1,32 @@
+package inject
+
+import (
+	"net/http"
+	"strings"
+)
+
+// cspReportEndpoint names the Reporting-Endpoints entry used by
+// WithReportOnlyCSP's report-to directive.
+const cspReportEndpoint = "rep-csp"
+
+// WithReportOnlyCSP adds a Content-Security-Policy-Report-Only header with
+// policy to every injected HTML response, so a policy can be observed
+// before it is enforced. If reportURI is set, violations are reported to it
+// through both report-uri and report-to (with a Reporting-Endpoints
+// header). The header is added alongside any the upstream sent, and an
+// enforcing Content-Security-Policy is never touched.
+func WithReportOnlyCSP(policy, reportURI string) Option {
+	return func(m *Middleware) {
+		if policy == "" {
+			return
+		}
+		policy = strings.TrimRight(strings.TrimSpace(policy), ";")
+		h := http.Header{}
+		if reportURI != "" {
+			policy += "; report-uri " + reportURI + "; report-to " + cspReportEndpoint
+			h.Set("Reporting-Endpoints", cspReportEndpoint+`="`+reportURI+`"`)
+		}
+		h.Set("Content-Security-Policy-Report-Only", policy)
+		m.cspHeaders = h
+	}
+}
@@ -0,0 +1,98 @@
+package inject
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// PublicHeaderPrefix starts the name of every header emitted by
+// WithPublicHeaders.
+const PublicHeaderPrefix = "X-REP-"
+
+// maxPublicHeaderLen caps the length of an emitted header value. Longer
+// values are omitted rather than truncated, so a reader never sees a
+// silently shortened value.
+const maxPublicHeaderLen = 1024
+
+// PublicHeaders converts PUBLIC tier variables into response headers for
+// consumers that read configuration without parsing HTML. A variable named
+// API_URL becomes X-REP-API-URL. Names are mangled to header token
+// characters, and values that are too long or contain control characters
+// are left out. Variables whose names map to the same header (FEATURE.V2
+// and FEATUREV2, or names differing only in case) are all left out with a
+// warning, so no value wins by map order. Only PUBLIC values may be passed
+// in.
+func PublicHeaders(public map[string]string, logger *slog.Logger) http.Header {
+	names := make([]string, 0, len(public))
+	for name := range public {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	byKey := make(map[string][]string, len(names))
+	var keys []string
+	for _, name := range names {
+		key := http.CanonicalHeaderKey(PublicHeaderPrefix + headerName(name))
+		if _, seen := byKey[key]; !seen {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], name)
+	}
+
+	h := make(http.Header, len(keys))
+	for _, key := range keys {
+		vars := byKey[key]
+		if len(vars) > 1 {
+			logger.Warn("rep.public_headers.collision",
+				"header", key,
+				"variables", strings.Join(vars, ","),
+			)
+			continue
+		}
+		value := public[vars[0]]
+		if len(value) > maxPublicHeaderLen || strings.ContainsFunc(value, isControl) {
+			continue
+		}
+		h[key] = []string{value}
+	}
+	return h
+}
+
+// headerName maps a variable name to header token characters:
+// underscores become hyphens and anything else outside [A-Za-z0-9-] is
+// dropped.
+func headerName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '_':
+			return '-'
+		case r == '-', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		}
+		return -1
+	}, name)
+}
+
+// isControl reports whether r may not appear in a header value (RFC 9110
+// §5.5 allows HTAB among the control characters).
+func isControl(r rune) bool {
+	return (r < 0x20 && r != '\t') || r == 0x7f
+}
+
+// WithPublicHeaders adds the given headers (see PublicHeaders) to every
+// injected HTML response.
+func WithPublicHeaders(h http.Header) Option {
+	return func(m *Middleware) {
+		m.publicHeaders = h
+	}
+}
+
+// UpdatePublicHeaders replaces the headers set by WithPublicHeaders (used
+// during hot reload).
+func (m *Middleware) UpdatePublicHeaders(h http.Header) {
+	m.mu.Lock()
+	m.publicHeaders = h
+	m.mu.Unlock()
+}
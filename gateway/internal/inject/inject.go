@@ -7,13 +7,18 @@ package inject
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
+	"html"
 	"io"
 	"log/slog"
 	"net/http"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Middleware wraps an http.Handler and injects the REP script tag into HTML responses.
@@ -24,19 +29,159 @@ type Middleware struct {
 	// scriptTag is the pre-rendered <script> block to inject.
 	scriptTag []byte
 
-	// mu protects scriptTag from concurrent read/write during hot reload.
+	// publicHeaders are added to injected responses (see WithPublicHeaders).
+	publicHeaders http.Header
+
+	// cspHeaders are added to injected responses (see WithReportOnlyCSP).
+	cspHeaders http.Header
+
+	// mu protects scriptTag and publicHeaders from concurrent read/write
+	// during hot reload.
 	mu sync.RWMutex
 
+	// sniffContentType enables HTML detection from the body when the
+	// upstream Content-Type is missing or generic.
+	sniffContentType bool
+
+	// baseHref, when non-empty, is injected as <base href> at the top of
+	// <head>. replaceBase controls whether an existing <base> is rewritten.
+	baseHref    string
+	replaceBase bool
+
+	// rewrites are applied to HTML bodies before injection.
+	rewrites []Rewrite
+
+	// statuses, when non-empty, limits buffering and injection to responses
+	// with these status codes (see WithStatuses).
+	statuses Statuses
+
+	// slots, when non-nil, bounds the number of responses being buffered
+	// for injection at once (see WithMaxConcurrent).
+	slots chan struct{}
+
+	// ready, when set, gates injection: while it reports not ready the
+	// upstream response is passed through without the payload.
+	ready func() (bool, string)
+
+	// logSample emits the per-request rep.inject.html debug line for one in
+	// every logSample injections (0 or 1 = every injection).
+	logSample uint64
+	injected  atomic.Uint64
+
+	// summaryInterval, when positive, emits a rep.inject.summary line with
+	// the injection count and bytes added since the previous summary.
+	summaryInterval time.Duration
+	statsMu         sync.Mutex
+	windowCount     int64
+	windowBytes     int64
+	lastSummary     time.Time
+	now             func() time.Time
+
 	logger *slog.Logger
 }
 
+// Option configures optional Middleware behaviour.
+type Option func(*Middleware)
+
+// WithContentSniffing enables a body-sniffing fallback: when the upstream
+// omits Content-Type or sends application/octet-stream, the first bytes of
+// the body are checked for an HTML signature (<!doctype html or <html).
+func WithContentSniffing(enabled bool) Option {
+	return func(m *Middleware) {
+		m.sniffContentType = enabled
+	}
+}
+
+// WithBaseHref injects <base href="href"> at the start of <head> so that
+// relative asset URLs resolve correctly when the app is served under a path
+// prefix. If the document already has a <base> element it is left untouched
+// unless replace is true, in which case it is rewritten to href.
+func WithBaseHref(href string, replace bool) Option {
+	return func(m *Middleware) {
+		m.baseHref = href
+		m.replaceBase = replace
+	}
+}
+
+// WithRewrites applies the given body replacements (see ParseRewrite) to
+// every HTML response, in order, before the REP payload is injected. If the
+// rewrites would grow a body past its size bound they are skipped for that
+// response.
+func WithRewrites(rules []Rewrite) Option {
+	return func(m *Middleware) {
+		m.rewrites = rules
+	}
+}
+
+// NotReadyHeader is set on responses served without the payload because the
+// gateway was not ready (see WithReadinessGate).
+const NotReadyHeader = "X-REP-Warning"
+
+// WithReadinessGate makes injection fail closed: while ready reports false,
+// responses are served from upstream unmodified, without the REP payload,
+// and carry a NotReadyHeader explaining why. Without this option the payload
+// is always injected (fail open).
+func WithReadinessGate(ready func() (bool, string)) Option {
+	return func(m *Middleware) {
+		m.ready = ready
+	}
+}
+
+// WithLogSampling reduces per-request injection logging at high volume: the
+// rep.inject.html debug line is emitted for one in every n injections, and if
+// summaryInterval is positive a rep.inject.summary line reports how many
+// documents were injected (and how many bytes were added) in each interval.
+// The summary is written by RunSummary on a ticker; intervals with no
+// injections produce no output.
+func WithLogSampling(n int, summaryInterval time.Duration) Option {
+	return func(m *Middleware) {
+		if n > 1 {
+			m.logSample = uint64(n)
+		}
+		m.summaryInterval = summaryInterval
+	}
+}
+
+// WithStatuses limits buffering to responses whose status is in statuses.
+// Other responses are streamed to the client as the upstream writes them,
+// without being buffered or injected. Without this option (or with an empty
+// set) every status is eligible. Redirects are never injected either way.
+func WithStatuses(statuses Statuses) Option {
+	return func(m *Middleware) {
+		m.statuses = statuses
+	}
+}
+
+// WithMaxConcurrent caps the number of responses buffered for injection at
+// once, bounding memory under bursts. A slot is taken only once the upstream
+// status and Content-Type show an injectable HTML response. Beyond the cap,
+// documents for safe methods (GET, HEAD) are shed with 503 and Retry-After,
+// since a client may retry them; the upstream has already run other methods
+// (e.g. a form POST), so those wait for a free slot rather than invite a
+// retry that would repeat the side effect. Non-HTML responses (API calls,
+// assets) and WebSocket upgrades are streamed and do not count. n <= 0 means
+// unlimited.
+func WithMaxConcurrent(n int) Option {
+	return func(m *Middleware) {
+		if n > 0 {
+			m.slots = make(chan struct{}, n)
+		}
+	}
+}
+
 // New creates a new injection middleware.
-func New(next http.Handler, scriptTag string, logger *slog.Logger) *Middleware {
-	return &Middleware{
+func New(next http.Handler, scriptTag string, logger *slog.Logger, opts ...Option) *Middleware {
+	m := &Middleware{
 		next:      next,
 		scriptTag: []byte(scriptTag),
 		logger:    logger,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt(m)
 	}
+	m.lastSummary = m.now()
+	return m
 }
 
 // UpdateScriptTag replaces the script tag (used during hot reload).
@@ -56,10 +201,28 @@ func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Strip Accept-Encoding from the request so the upstream always responds
-	// with identity encoding. This ensures we can reliably search for </head>
-	// in the response body for injection.
-	r.Header.Del("Accept-Encoding")
+	// Static assets (favicon, robots.txt, scripts, images, ...) are never
+	// HTML, so stream them straight through instead of buffering the body.
+	if isAssetPath(r.URL.Path) {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	// Fail closed: withhold the payload until the gateway is ready.
+	if m.ready != nil {
+		if ok, reason := m.ready(); !ok {
+			w.Header().Set(NotReadyHeader, "payload withheld: "+reason)
+			m.logger.Debug("rep.inject.not_ready", "path", r.URL.Path, "reason", reason)
+			m.next.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	// Ask the upstream for identity encoding so we can reliably search for
+	// </head> in the response body for injection. The header is set rather
+	// than deleted: some upstreams treat a missing Accept-Encoding as
+	// accepting anything and compress anyway.
+	r.Header.Set("Accept-Encoding", "identity")
 
 	// Wrap the response writer to capture the response.
 	rec := &responseRecorder{
@@ -67,13 +230,49 @@ func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		body:           &bytes.Buffer{},
 		statusCode:     http.StatusOK,
 	}
+	if !m.statuses.empty() {
+		rec.buffer = m.statuses.Contains
+	}
+	if m.slots != nil {
+		rec.slots = m.slots
+		rec.mayBeHTML = m.mayBeHTML
+		if !isSafeMethod(r.Method) {
+			rec.queue = true
+			rec.done = r.Context().Done()
+		}
+		defer func() {
+			if rec.held {
+				<-m.slots
+			}
+		}()
+	}
 
 	// Serve the request to the upstream handler.
 	m.next.ServeHTTP(rec, r)
 
+	// Responses with an ineligible status were streamed as written.
+	if rec.streamed {
+		return
+	}
+
+	// An HTML response that found no free slot was discarded unbuffered.
+	if rec.shed {
+		m.logger.Warn("rep.inject.shed", "path", r.URL.Path, "max_concurrent", cap(m.slots))
+		w.Header().Del("Content-Length")
+		w.Header().Del("Content-Encoding")
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "server busy", http.StatusServiceUnavailable)
+		return
+	}
+
 	// Check if the response is HTML.
 	contentType := rec.Header().Get("Content-Type")
-	if !isHTML(contentType) {
+	sniffed := !isHTML(contentType) && m.sniffContentType &&
+		isAmbiguousContentType(contentType) && looksLikeHTML(rec.body.Bytes())
+	// Redirect bodies (e.g. http.Redirect's "Moved Permanently" link) are
+	// HTML but never rendered, so they are passed through like non-HTML.
+	redirect := rec.statusCode >= 300 && rec.statusCode < 400
+	if redirect || (!isHTML(contentType) && !sniffed) {
 		// Not HTML — write the response as-is.
 		w.WriteHeader(rec.statusCode)
 		if _, err := w.Write(rec.body.Bytes()); err != nil {
@@ -82,16 +281,16 @@ func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Decompress the body if the upstream ignored our Accept-Encoding removal.
+	// Decompress the body if the upstream ignored our Accept-Encoding: identity.
 	body := rec.body.Bytes()
-	encoding := rec.Header().Get("Content-Encoding")
+	encoding := strings.Join(rec.Header().Values("Content-Encoding"), ",")
 	if encoding != "" {
 		decompressed, err := decompressBody(body, encoding)
 		if err != nil {
 			// Cannot decompress — pass through unmodified.
 			m.logger.Warn("rep.inject.skip",
 				"path", r.URL.Path,
-				"reason", "unsupported Content-Encoding: "+encoding,
+				"reason", "cannot decode Content-Encoding "+encoding+": "+err.Error(),
 			)
 			w.WriteHeader(rec.statusCode)
 			if _, err := w.Write(body); err != nil {
@@ -102,14 +301,31 @@ func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		body = decompressed
 	}
 
-	// Copy the script tag under a read lock to avoid a data race with UpdateScriptTag.
+	// Copy the script tag under a read lock to avoid a data race with
+	// UpdateScriptTag. The headers map is replaced, never mutated.
 	m.mu.RLock()
 	tag := make([]byte, len(m.scriptTag))
 	copy(tag, m.scriptTag)
+	publicHeaders := m.publicHeaders
 	m.mu.RUnlock()
 
+	// Apply body rewrites ahead of injection so they never touch the payload.
+	if len(m.rewrites) > 0 {
+		rewritten, ok := applyRewrites(body, m.rewrites)
+		if !ok {
+			m.logger.Warn("rep.inject.rewrite_skipped",
+				"path", r.URL.Path,
+				"reason", "rewritten body exceeds size limit",
+			)
+		}
+		body = rewritten
+	}
+
 	// Inject the REP script tag into the HTML.
 	injected := injectIntoHTML(body, tag)
+	if m.baseHref != "" {
+		injected = injectBaseHref(injected, m.baseHref, m.replaceBase)
+	}
 
 	// Update Content-Length to reflect the injected content.
 	w.Header().Set("Content-Length", strconv.Itoa(len(injected)))
@@ -117,34 +333,128 @@ func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Remove Content-Encoding since we've modified the body.
 	w.Header().Del("Content-Encoding")
 
+	for name, values := range publicHeaders {
+		w.Header()[name] = values
+	}
+	for name, values := range m.cspHeaders {
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+
+	// A sniffed document is declared as HTML so the browser renders it.
+	if sniffed {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+
+	// The body we send depends on how we negotiated encoding with the
+	// upstream, so shared caches (CDNs) must key on Accept-Encoding.
+	addVary(w.Header(), "Accept-Encoding")
+
 	w.WriteHeader(rec.statusCode)
 	if _, err := w.Write(injected); err != nil {
 		m.logger.Debug("rep.inject.write_error", "path", r.URL.Path, "error", err)
 	}
 
-	m.logger.Debug("rep.inject.html",
-		"path", r.URL.Path,
-		"original_size", len(body),
-		"injected_size", len(injected),
-	)
+	m.recordInjection(r.URL.Path, len(body), len(injected))
+}
+
+// mayBeHTML reports whether a response with this Content-Type can end up
+// injected, either as declared HTML or, with sniffing, as an ambiguous type.
+func (m *Middleware) mayBeHTML(contentType string) bool {
+	return isHTML(contentType) || (m.sniffContentType && isAmbiguousContentType(contentType))
+}
+
+// recordInjection logs a completed injection, subject to sampling, and
+// folds it into the periodic summary.
+func (m *Middleware) recordInjection(path string, originalSize, injectedSize int) {
+	n := m.injected.Add(1)
+	if m.logSample == 0 || (n-1)%m.logSample == 0 {
+		m.logger.Debug("rep.inject.html",
+			"path", path,
+			"original_size", originalSize,
+			"injected_size", injectedSize,
+		)
+	}
+
+	if m.summaryInterval <= 0 {
+		return
+	}
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	m.windowCount++
+	m.windowBytes += int64(injectedSize - originalSize)
+}
+
+// RunSummary emits a rep.inject.summary line every summary interval until
+// ctx is cancelled, then flushes the final partial window. It returns
+// immediately if WithLogSampling did not set a positive interval.
+func (m *Middleware) RunSummary(ctx context.Context) {
+	if m.summaryInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.summaryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			m.flushSummary()
+			return
+		case <-ticker.C:
+			m.flushSummary()
+		}
+	}
+}
+
+// flushSummary logs and resets the current summary window. Windows with no
+// injections are skipped so idle gateways stay quiet.
+func (m *Middleware) flushSummary() {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	now := m.now()
+	if m.windowCount > 0 {
+		m.logger.Info("rep.inject.summary",
+			"injections", m.windowCount,
+			"bytes_injected", m.windowBytes,
+			"interval", now.Sub(m.lastSummary).String(),
+		)
+	}
+	m.windowCount, m.windowBytes = 0, 0
+	m.lastSummary = now
 }
 
 // decompressBody decompresses a response body based on Content-Encoding.
-// Returns an error for unsupported encodings (e.g., brotli — no stdlib support).
+// encoding may list several codings ("gzip, identity"); they are undone in
+// reverse order, since the last one listed was applied last. Returns an
+// error naming the first unsupported coding (e.g., brotli — no stdlib
+// support) before any decoding is attempted.
 func decompressBody(body []byte, encoding string) ([]byte, error) {
-	switch strings.ToLower(strings.TrimSpace(encoding)) {
-	case "gzip":
+	codings := strings.Split(encoding, ",")
+	for i, c := range codings {
+		codings[i] = strings.ToLower(strings.TrimSpace(c))
+		switch codings[i] {
+		case "gzip", "x-gzip", "identity", "":
+		default:
+			return nil, fmt.Errorf("unsupported encoding: %s", codings[i])
+		}
+	}
+
+	for i := len(codings) - 1; i >= 0; i-- {
+		if codings[i] != "gzip" && codings[i] != "x-gzip" {
+			continue
+		}
 		reader, err := gzip.NewReader(bytes.NewReader(body))
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("decoding %s: %w", codings[i], err)
+		}
+		body, err = io.ReadAll(reader)
+		_ = reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", codings[i], err)
 		}
-		defer func() { _ = reader.Close() }()
-		return io.ReadAll(reader)
-	case "identity", "":
-		return body, nil
-	default:
-		return nil, fmt.Errorf("unsupported encoding: %s", encoding)
 	}
+	return body, nil
 }
 
 // injectIntoHTML inserts the script tag into the HTML document.
@@ -153,6 +463,10 @@ func decompressBody(body []byte, encoding string) ([]byte, error) {
 //  1. Before </head>
 //  2. After <head> (if no </head>)
 //  3. Prepend to body (if neither exists)
+//
+// The tag is inserted exactly once, at the first matching anchor, even when
+// a malformed or frameset document repeats <head> or </head>; later anchors
+// are left untouched so a page never carries two payloads.
 func injectIntoHTML(html, scriptTag []byte) []byte {
 	// Try inserting before </head>, skipping any occurrences inside HTML comments.
 	headClose := findOutsideComments(html, []byte("</head>"))
@@ -190,6 +504,68 @@ func injectIntoHTML(html, scriptTag []byte) []byte {
 	return result
 }
 
+// injectBaseHref inserts a <base href> element immediately after the opening
+// <head> tag, ahead of any content that may reference relative URLs. When the
+// document already declares a <base> element outside comments, it is replaced
+// if replace is true and otherwise left as-is. Documents without <head> get
+// the element prepended.
+func injectBaseHref(doc []byte, href string, replace bool) []byte {
+	baseTag := []byte(`<base href="` + html.EscapeString(href) + `">`)
+
+	if start := findTagOutsideComments(doc, "base"); start != -1 {
+		if !replace {
+			return doc
+		}
+		tagEnd := bytes.IndexByte(doc[start:], '>')
+		if tagEnd == -1 {
+			return doc
+		}
+		result := make([]byte, 0, len(doc)+len(baseTag))
+		result = append(result, doc[:start]...)
+		result = append(result, baseTag...)
+		result = append(result, doc[start+tagEnd+1:]...)
+		return result
+	}
+
+	insertAt := 0
+	if headOpen := findTagOutsideComments(doc, "head"); headOpen != -1 {
+		if tagEnd := bytes.IndexByte(doc[headOpen:], '>'); tagEnd != -1 {
+			insertAt = headOpen + tagEnd + 1
+		}
+	}
+
+	result := make([]byte, 0, len(doc)+len(baseTag))
+	result = append(result, doc[:insertAt]...)
+	result = append(result, baseTag...)
+	result = append(result, doc[insertAt:]...)
+	return result
+}
+
+// findTagOutsideComments returns the index of the first opening tag named
+// name (case-insensitive, e.g. "<base" but not "<basefont") that is not
+// inside an HTML comment. Returns -1 if there is none.
+func findTagOutsideComments(doc []byte, name string) int {
+	lower := bytes.ToLower(doc)
+	target := []byte("<" + name)
+	offset := 0
+	for {
+		idx := findOutsideComments(lower[offset:], target)
+		if idx == -1 {
+			return -1
+		}
+		abs := offset + idx
+		next := abs + len(target)
+		if next >= len(lower) {
+			return -1
+		}
+		switch lower[next] {
+		case '>', '/', ' ', '\t', '\n', '\r', '\f':
+			return abs
+		}
+		offset = next
+	}
+}
+
 // findOutsideComments returns the index of the first occurrence of target
 // in html that is NOT inside an HTML comment (<!-- ... -->). Returns -1 if
 // no match is found outside a comment.
@@ -245,44 +621,192 @@ func isInsideComment(html []byte, pos int, open, close []byte) bool {
 	return false
 }
 
+// addVary appends field to the Vary header unless it is already listed
+// (case-insensitive) or the header is "*".
+func addVary(h http.Header, field string) {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f == "*" || strings.EqualFold(f, field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
 // isWebSocketUpgrade reports whether the request is a WebSocket upgrade.
 func isWebSocketUpgrade(r *http.Request) bool {
 	return strings.EqualFold(r.Header.Get("Connection"), "upgrade") &&
 		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
 }
 
+// assetExtensions are file extensions served without injection. HTML
+// documents never use them, so their responses bypass the recorder.
+var assetExtensions = map[string]bool{
+	".ico": true, ".txt": true, ".xml": true, ".json": true, ".webmanifest": true,
+	".js": true, ".mjs": true, ".css": true, ".map": true, ".wasm": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true, ".avif": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+	".mp3": true, ".mp4": true, ".webm": true, ".pdf": true, ".zip": true,
+}
+
+// isAssetPath reports whether the request path names a non-HTML asset by
+// its extension.
+func isAssetPath(p string) bool {
+	return assetExtensions[strings.ToLower(path.Ext(p))]
+}
+
 // isHTML checks if a Content-Type header indicates an HTML response.
 func isHTML(contentType string) bool {
 	ct := strings.ToLower(contentType)
 	return strings.Contains(ct, "text/html")
 }
 
+// isAmbiguousContentType reports whether a Content-Type carries no useful
+// type information, making body sniffing worthwhile.
+func isAmbiguousContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return mediaType == "" || mediaType == "application/octet-stream"
+}
+
+// sniffLen bounds how much of the body is examined by looksLikeHTML.
+const sniffLen = 512
+
+// looksLikeHTML reports whether body starts with an HTML signature,
+// ignoring a UTF-8 BOM and leading whitespace.
+func looksLikeHTML(body []byte) bool {
+	if len(body) > sniffLen {
+		body = body[:sniffLen]
+	}
+	body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))
+	body = bytes.TrimLeft(body, " \t\r\n\f")
+	for _, sig := range []string{"<!doctype html", "<html"} {
+		if len(body) >= len(sig) && bytes.EqualFold(body[:len(sig)], []byte(sig)) {
+			return true
+		}
+	}
+	return false
+}
+
 // responseRecorder captures the upstream response for inspection.
+//
+// When buffer is set, a response whose status it rejects is streamed: the
+// status and every later write go straight to the real writer.
+//
+// When slots is set, buffering is bounded: once the final status and headers
+// show a response that can be injected, it takes a slot (held). With none
+// free, a response for a safe method is shed and its body discarded; one
+// with queue set blocks for a slot and is shed only if done closes first
+// (the client went away). Other responses are streamed and never count
+// against the limit.
 type responseRecorder struct {
 	http.ResponseWriter
 	body        *bytes.Buffer
 	statusCode  int
 	wroteHeader bool
+	buffer      func(code int) bool
+	streamed    bool
+	slots       chan struct{}
+	mayBeHTML   func(contentType string) bool
+	queue       bool
+	done        <-chan struct{}
+	held        bool
+	shed        bool
 }
 
 func (r *responseRecorder) WriteHeader(code int) {
+	// Informational responses (e.g. 103 Early Hints relayed by the proxy)
+	// precede the final status; pass them on without deciding anything.
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		r.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if r.wroteHeader {
+		return
+	}
 	r.statusCode = code
 	r.wroteHeader = true
-	// Don't forward to the real writer yet — we need to inspect first.
+	if r.buffer != nil && !r.buffer(code) {
+		r.stream(code)
+		return
+	}
+	if r.slots != nil {
+		redirect := code >= 300 && code < 400
+		if redirect || !r.mayBeHTML(r.Header().Get("Content-Type")) {
+			r.stream(code)
+			return
+		}
+		select {
+		case r.slots <- struct{}{}:
+			r.held = true
+			return
+		default:
+		}
+		if !r.queue {
+			r.shed = true
+			return
+		}
+		select {
+		case r.slots <- struct{}{}:
+			r.held = true
+		case <-r.done:
+			r.shed = true
+		}
+	}
+	// Otherwise don't forward to the real writer yet — we need to inspect first.
+}
+
+// isSafeMethod reports whether a request method is safe (RFC 9110 §9.2.1),
+// so shedding its response and having the client retry has no side effects.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func (r *responseRecorder) stream(code int) {
+	r.streamed = true
+	r.ResponseWriter.WriteHeader(code)
 }
 
 func (r *responseRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.streamed {
+		return r.ResponseWriter.Write(b)
+	}
+	if r.shed {
+		return len(b), nil
+	}
 	return r.body.Write(b)
 }
 
-// Flush implements http.Flusher for streaming support.
+// Flush implements http.Flusher. While buffering it is a no-op: the body is
+// held until the upstream handler returns, and flushing the real writer
+// early would commit its status and headers before ServeHTTP has decided
+// them (e.g. httputil.ReverseProxy flushes responses without a
+// Content-Length). Streamed responses are flushed through.
 func (r *responseRecorder) Flush() {
-	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok && r.streamed {
 		f.Flush()
 	}
 }
 
 // ReadFrom implements io.ReaderFrom for efficient copies.
 func (r *responseRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.streamed {
+		return io.Copy(r.ResponseWriter, src)
+	}
+	if r.shed {
+		return io.Copy(io.Discard, src)
+	}
 	return r.body.ReadFrom(src)
 }
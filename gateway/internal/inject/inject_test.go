@@ -1,12 +1,21 @@
 package inject
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 const testScriptTag = `<script id="__rep__" type="application/json">{"public":{}}</script>`
@@ -104,6 +113,31 @@ func TestInjectIntoHTML_CommentContainingOnlyHeadClose(t *testing.T) {
 	}
 }
 
+func TestInjectIntoHTML_InjectsOnce(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		// before is the anchor that must follow the first (and only) tag.
+		before string
+	}{
+		{"two head sections", `<html><head><title>A</title></head><head><title>B</title></head><body></body></html>`, "</head><head><title>B"},
+		{"repeated head open without close", `<html><head><head><body></body></html>`, "<head><body>"},
+		{"frameset", `<html><head></head><frameset><frame src="a.html"></frameset><head></head></html>`, "</head><frameset>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := string(injectIntoHTML([]byte(tt.html), []byte(testScriptTag)))
+			if n := strings.Count(s, testScriptTag); n != 1 {
+				t.Fatalf("expected exactly one script tag, got %d in %s", n, s)
+			}
+			if strings.Index(s, testScriptTag) > strings.Index(s, tt.before) {
+				t.Errorf("expected the tag at the first anchor, got %s", s)
+			}
+		})
+	}
+}
+
 func TestInjectIntoHTML_Fallback(t *testing.T) {
 	html := []byte(`<div>hello</div>`)
 	result := injectIntoHTML(html, []byte(testScriptTag))
@@ -165,6 +199,388 @@ func TestMiddleware_NonHTMLResponse(t *testing.T) {
 	}
 }
 
+func TestMiddleware_RedirectNotInjected(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/app/", http.StatusMovedPermanently)
+	})
+
+	m := New(upstream, testScriptTag, slog.Default())
+
+	req := httptest.NewRequest(http.MethodGet, "/app", nil)
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMovedPermanently {
+		t.Errorf("expected 301, got %d", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/app/" {
+		t.Errorf("expected Location /app/, got %q", loc)
+	}
+	if strings.Contains(rec.Body.String(), "__rep__") {
+		t.Error("redirect body should not be injected")
+	}
+}
+
+func TestParseStatuses(t *testing.T) {
+	s, err := ParseStatuses([]string{"2xx", " 404 "})
+	if err != nil {
+		t.Fatalf("ParseStatuses: %v", err)
+	}
+	for code, want := range map[int]bool{200: true, 204: true, 299: true, 404: true, 301: false, 403: false, 500: false} {
+		if got := s.Contains(code); got != want {
+			t.Errorf("Contains(%d) = %v, want %v", code, got, want)
+		}
+	}
+
+	for _, spec := range []string{"6xx", "2x", "99", "600", "abc", ""} {
+		if _, err := ParseStatuses([]string{spec}); err == nil {
+			t.Errorf("ParseStatuses(%q): expected error", spec)
+		}
+	}
+}
+
+func TestMiddleware_StatusesStreamIneligible(t *testing.T) {
+	statuses, _ := ParseStatuses([]string{"2xx"})
+	const doc = `<html><head></head><body>Not found</body></html>`
+
+	for _, tc := range []struct {
+		status   int
+		injected bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusNotFound, false},
+		{http.StatusInternalServerError, false},
+	} {
+		rec := httptest.NewRecorder()
+		var streamed bool
+		upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(tc.status)
+			_, _ = w.Write([]byte(doc))
+			// An ineligible response reaches the client before the
+			// handler returns instead of being held in the buffer.
+			streamed = rec.Body.Len() > 0
+		})
+		m := New(upstream, testScriptTag, slog.Default(), WithStatuses(statuses))
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rec.Code != tc.status {
+			t.Errorf("%d: got status %d", tc.status, rec.Code)
+		}
+		if got := strings.Contains(rec.Body.String(), "__rep__"); got != tc.injected {
+			t.Errorf("%d: injected = %v, want %v", tc.status, got, tc.injected)
+		}
+		if streamed == tc.injected {
+			t.Errorf("%d: streamed = %v, want %v", tc.status, streamed, !tc.injected)
+		}
+	}
+}
+
+func TestMiddleware_ProxyEarlyHints(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</app.js>; rel=preload; as=script")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("<html><head></head><body></body></html>"))
+	}))
+	defer upstream.Close()
+	target, _ := url.Parse(upstream.URL)
+
+	statuses, _ := ParseStatuses([]string{"2xx"})
+	m := New(httputil.NewSingleHostReverseProxy(target), testScriptTag, slog.Default(), WithStatuses(statuses))
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected final status 200, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "__rep__") {
+		t.Errorf("expected the payload after 103 Early Hints, got:\n%s", body)
+	}
+}
+
+func TestPublicHeaders(t *testing.T) {
+	h := PublicHeaders(map[string]string{
+		"API_URL":    "https://api.example.com",
+		"FEATURE.V2": "on",
+		"MULTILINE":  "a\r\nX-Injected: 1",
+		"HUGE":       strings.Repeat("x", maxPublicHeaderLen+1),
+	}, slog.Default())
+
+	if got := h.Get("X-REP-API-URL"); got != "https://api.example.com" {
+		t.Errorf("expected X-REP-API-URL, got %q", got)
+	}
+	if got := h.Get("X-REP-FEATUREV2"); got != "on" {
+		t.Errorf("expected mangled X-REP-FEATUREV2, got %q", got)
+	}
+	if len(h) != 2 {
+		t.Errorf("expected control-character and oversized values to be omitted, got %v", h)
+	}
+}
+
+func TestPublicHeaders_Collisions(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	h := PublicHeaders(map[string]string{
+		"FEATURE.V2": "on",
+		"FEATUREV2":  "off",
+		"api_url":    "https://a.example.com",
+		"API_URL":    "https://b.example.com",
+		"THEME":      "dark",
+	}, logger)
+
+	if len(h) != 1 || h.Get("X-REP-THEME") != "dark" {
+		t.Errorf("expected only X-REP-THEME, got %v", h)
+	}
+	for _, want := range []string{
+		"header=X-Rep-Featurev2 variables=FEATURE.V2,FEATUREV2",
+		"header=X-Rep-Api-Url variables=API_URL,api_url",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("expected collision warning %q, got:\n%s", want, logs.String())
+		}
+	}
+}
+
+func TestMiddleware_PublicHeaders(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte("{}"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html><head></head><body></body></html>"))
+	})
+
+	m := New(upstream, testScriptTag, slog.Default(),
+		WithPublicHeaders(PublicHeaders(map[string]string{"API_URL": "https://a.example.com"}, slog.Default())))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("X-REP-API-URL"); got != "https://a.example.com" {
+		t.Errorf("expected header on HTML response, got %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if got := rec.Header().Get("X-REP-API-URL"); got != "" {
+		t.Errorf("expected no header on non-HTML response, got %q", got)
+	}
+
+	m.UpdatePublicHeaders(PublicHeaders(map[string]string{"API_URL": "https://b.example.com"}, slog.Default()))
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("X-REP-API-URL"); got != "https://b.example.com" {
+		t.Errorf("expected updated header, got %q", got)
+	}
+}
+
+func TestMiddleware_ReportOnlyCSP(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		w.Header().Set("Content-Security-Policy-Report-Only", "img-src 'none'")
+		_, _ = w.Write([]byte("<html><head></head><body></body></html>"))
+	})
+
+	m := New(upstream, testScriptTag, slog.Default(),
+		WithReportOnlyCSP("script-src 'self';", "https://csp.example.com/report"))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := rec.Header().Get("Content-Security-Policy"); got != "default-src 'self'" {
+		t.Errorf("enforcing CSP should be untouched, got %q", got)
+	}
+	reportOnly := rec.Header().Values("Content-Security-Policy-Report-Only")
+	want := "script-src 'self'; report-uri https://csp.example.com/report; report-to rep-csp"
+	if len(reportOnly) != 2 || reportOnly[0] != "img-src 'none'" || reportOnly[1] != want {
+		t.Errorf("expected upstream and gateway report-only policies, got %q", reportOnly)
+	}
+	if got := rec.Header().Get("Reporting-Endpoints"); got != `rep-csp="https://csp.example.com/report"` {
+		t.Errorf("unexpected Reporting-Endpoints %q", got)
+	}
+}
+
+func TestMiddleware_ReportOnlyCSPDisabled(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html><head></head><body></body></html>"))
+	})
+
+	m := New(upstream, testScriptTag, slog.Default(), WithReportOnlyCSP("", ""))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("Content-Security-Policy-Report-Only"); got != "" {
+		t.Errorf("expected no report-only header, got %q", got)
+	}
+}
+
+func TestMiddleware_MaxConcurrentSheds(t *testing.T) {
+	const limit = 2
+	var inFlight, peak atomic.Int32
+	entered := make(chan struct{}, limit)
+	release := make(chan struct{})
+
+	// A proxied JSON API behind the same middleware, as in proxy mode.
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer api.Close()
+	target, _ := url.Parse(api.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/app.js":
+			w.Header().Set("Content-Type", "text/javascript")
+			return
+		case strings.HasPrefix(r.URL.Path, "/api/"):
+			proxy.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/slow" {
+			// Hold the slot: the response is known to be HTML but the
+			// body has not arrived yet.
+			w.WriteHeader(http.StatusOK)
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			entered <- struct{}{}
+			<-release
+		}
+		_, _ = w.Write([]byte("<html><head></head><body></body></html>"))
+	})
+
+	m := New(upstream, testScriptTag, slog.Default(), WithMaxConcurrent(limit))
+
+	var wg sync.WaitGroup
+	codes := make(chan int, limit)
+	for i := 0; i < limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+			codes <- rec.Code
+		}()
+	}
+	for i := 0; i < limit; i++ {
+		<-entered
+	}
+
+	// The cap is reached: further documents are shed, assets still pass.
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected 503 beyond the cap, got %d", rec.Code)
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Error("expected Retry-After on shed response")
+		}
+		if strings.Contains(rec.Body.String(), "<html>") {
+			t.Error("expected the shed document's body to be discarded")
+		}
+	}
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/app.js", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected asset to bypass the cap, got %d", rec.Code)
+	}
+
+	// A POST has already run upstream, so its document waits for a slot
+	// instead of being shed and retried.
+	posted := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/slow", strings.NewReader("a=1")))
+		posted <- rec
+	}()
+	select {
+	case rec := <-posted:
+		t.Fatalf("expected POST to wait for a slot, got %d", rec.Code)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Proxied non-HTML traffic is streamed and never counts.
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(method, "/api/users", strings.NewReader(`{}`)))
+		if rec.Code != http.StatusOK || rec.Body.String() != `{"ok":true}` {
+			t.Errorf("%s /api/users: expected proxied JSON to bypass the cap, got %d %q", method, rec.Code, rec.Body.String())
+		}
+	}
+
+	close(release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("expected admitted request to succeed, got %d", code)
+		}
+	}
+	if rec := <-posted; rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "__rep__") {
+		t.Errorf("expected the queued POST to be injected once a slot freed, got %d %q", rec.Code, rec.Body.String())
+	}
+	if p := peak.Load(); p > limit {
+		t.Errorf("expected at most %d concurrent injections, saw %d", limit, p)
+	}
+
+	// Slots are released once requests finish.
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code == http.StatusServiceUnavailable {
+		t.Error("expected slots to be released after requests complete")
+	}
+}
+
+func TestMiddleware_AssetPathsBypassRecorder(t *testing.T) {
+	var buffered bool
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, buffered = w.(*responseRecorder)
+		// Even a mislabelled asset must not be injected.
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head></head></html>`))
+	})
+	m := New(upstream, testScriptTag, slog.Default())
+
+	for _, path := range []string{"/favicon.ico", "/robots.txt", "/assets/app.JS", "/fonts/a.woff2"} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if buffered {
+			t.Errorf("%s: response was buffered by the recorder", path)
+		}
+		if strings.Contains(rec.Body.String(), "__rep__") {
+			t.Errorf("%s: asset response was injected", path)
+		}
+	}
+
+	for _, path := range []string{"/", "/dashboard", "/index.html"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		if !buffered {
+			t.Errorf("%s: expected document path to go through the recorder", path)
+		}
+	}
+}
+
 func TestMiddleware_ContentLengthUpdated(t *testing.T) {
 	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -268,6 +684,161 @@ func TestDecompressBody_Unsupported(t *testing.T) {
 	}
 }
 
+func TestDecompressBody_Stacked(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte("hello world"))
+	_ = zw.Close()
+
+	result, err := decompressBody(buf.Bytes(), "gzip, identity")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(result) != "hello world" {
+		t.Errorf("expected decoded body, got %q", result)
+	}
+}
+
+func TestDecompressBody_StackedUnsupported(t *testing.T) {
+	_, err := decompressBody([]byte("data"), "gzip, br")
+	if err == nil {
+		t.Fatal("expected error for unsupported stacked encoding")
+	}
+	if !strings.Contains(err.Error(), "br") {
+		t.Errorf("expected error to name br, got %v", err)
+	}
+}
+
+func TestMiddleware_StackedEncodingInjected(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte("<html><head></head><body></body></html>"))
+	_ = zw.Close()
+
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", "gzip, identity")
+		_, _ = w.Write(buf.Bytes())
+	})
+
+	m := New(upstream, testScriptTag, slog.Default())
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !strings.Contains(rec.Body.String(), testScriptTag) {
+		t.Errorf("expected injected body, got %q", rec.Body.String())
+	}
+	if ce := rec.Header().Values("Content-Encoding"); len(ce) != 0 {
+		t.Errorf("expected Content-Encoding removed, got %q", ce)
+	}
+}
+
+// gzipBytes returns doc gzip-compressed.
+func gzipBytes(t *testing.T, doc string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(doc)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMiddleware_GzipHTMLDecompressed(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(gzipBytes(t, "<html><head></head></html>"))
+	})
+
+	m := New(upstream, testScriptTag, slog.Default())
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !strings.Contains(rec.Body.String(), testScriptTag) {
+		t.Errorf("expected decompressed, injected body, got %q", rec.Body.String())
+	}
+	if ce := rec.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("expected Content-Encoding removed, got %q", ce)
+	}
+}
+
+func TestMiddleware_GzipNonHTMLPassedThrough(t *testing.T) {
+	compressed := gzipBytes(t, `{"ok":true}`)
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(compressed)
+	})
+
+	m := New(upstream, testScriptTag, slog.Default())
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/data", nil))
+
+	if !bytes.Equal(rec.Body.Bytes(), compressed) {
+		t.Errorf("expected the compressed body unchanged, got %q", rec.Body.String())
+	}
+	if ce := rec.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Errorf("expected Content-Encoding gzip kept, got %q", ce)
+	}
+}
+
+func TestMiddleware_UnknownEncodingPassedThrough(t *testing.T) {
+	body := []byte("\x8b\x00not-really-brotli")
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", "br")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write(body)
+		// ReverseProxy flushes responses without a Content-Length.
+		w.(http.Flusher).Flush()
+	})
+
+	m := New(upstream, testScriptTag, slog.Default())
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	resp := rec.Result()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", resp.StatusCode)
+	}
+	if ce := resp.Header.Get("Content-Encoding"); ce != "br" {
+		t.Errorf("expected Content-Encoding br kept, got %q", ce)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), body) {
+		t.Errorf("expected the body unchanged, got %q", rec.Body.String())
+	}
+}
+
+func TestMiddleware_FlushDoesNotCommitHeaders(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write(gzipBytes(t, "<html><head></head></html>"))
+		w.(http.Flusher).Flush()
+	})
+
+	m := New(upstream, testScriptTag, slog.Default())
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	// Result reports the headers as they were when the status was written.
+	resp := rec.Result()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", resp.StatusCode)
+	}
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" {
+		t.Errorf("expected Content-Encoding removed before the status was sent, got %q", ce)
+	}
+	if resp.Header.Get("Content-Length") != strconv.Itoa(rec.Body.Len()) {
+		t.Errorf("expected Content-Length %d, got %q", rec.Body.Len(), resp.Header.Get("Content-Length"))
+	}
+}
+
 func TestDecompressBody_Empty(t *testing.T) {
 	result, err := decompressBody([]byte("data"), "")
 	if err != nil {
@@ -277,3 +848,395 @@ func TestDecompressBody_Empty(t *testing.T) {
 		t.Errorf("expected original data, got %q", result)
 	}
 }
+
+func TestMiddleware_VaryAcceptEncoding(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Vary", "Origin")
+		_, _ = w.Write([]byte(`<html><head></head><body></body></html>`))
+	})
+
+	m := New(upstream, testScriptTag, slog.Default())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+
+	m.ServeHTTP(rec, req)
+
+	vary := rec.Header().Values("Vary")
+	found := false
+	for _, v := range vary {
+		if strings.Contains(v, "Accept-Encoding") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected Vary to include Accept-Encoding, got %v", vary)
+	}
+	if !strings.Contains(strings.Join(vary, ","), "Origin") {
+		t.Errorf("existing Vary value should be preserved, got %v", vary)
+	}
+}
+
+func TestMiddleware_RequestsIdentityEncoding(t *testing.T) {
+	var got []string
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Values("Accept-Encoding")
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head></head><body></body></html>`))
+	})
+
+	m := New(upstream, testScriptTag, slog.Default())
+
+	for _, accept := range []string{"", "gzip, deflate, br"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if accept != "" {
+			req.Header.Set("Accept-Encoding", accept)
+		}
+		m.ServeHTTP(httptest.NewRecorder(), req)
+
+		if len(got) != 1 || got[0] != "identity" {
+			t.Errorf("client Accept-Encoding %q: upstream got %q, want [identity]", accept, got)
+		}
+	}
+}
+
+func TestAddVary_NoDuplicate(t *testing.T) {
+	h := http.Header{}
+	h.Set("Vary", "accept-encoding")
+	addVary(h, "Accept-Encoding")
+	if got := h.Values("Vary"); len(got) != 1 {
+		t.Errorf("expected single Vary value, got %v", got)
+	}
+}
+
+func TestMiddleware_SniffContentType(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Content-Type"] = nil // Suppress net/http's own sniffing.
+		_, _ = w.Write([]byte("\n<!DOCTYPE html><html><head></head><body></body></html>"))
+	})
+
+	tests := []struct {
+		name    string
+		enabled bool
+		want    bool
+	}{
+		{"enabled", true, true},
+		{"disabled", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(upstream, testScriptTag, slog.Default(), WithContentSniffing(tt.enabled))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, req)
+
+			injected := strings.Contains(rec.Body.String(), `id="__rep__"`)
+			if injected != tt.want {
+				t.Errorf("injected = %v, want %v", injected, tt.want)
+			}
+			if tt.want && !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+				t.Errorf("expected sniffed response to be labelled text/html, got %q", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestMiddleware_SniffSkipsExplicitNonHTML(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("<html><head></head></html>"))
+	})
+
+	m := New(upstream, testScriptTag, slog.Default(), WithContentSniffing(true))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+
+	if strings.Contains(rec.Body.String(), `id="__rep__"`) {
+		t.Error("explicit non-HTML Content-Type should not be sniffed")
+	}
+}
+
+func TestLooksLikeHTML(t *testing.T) {
+	tests := []struct {
+		body string
+		want bool
+	}{
+		{"<!doctype html><html>", true},
+		{"  \n<HTML lang=\"en\">", true},
+		{"\xef\xbb\xbf<!DOCTYPE HTML>", true},
+		{`{"json":true}`, false},
+		{"<div>fragment</div>", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := looksLikeHTML([]byte(tt.body)); got != tt.want {
+			t.Errorf("looksLikeHTML(%q) = %v, want %v", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestInjectBaseHref_InsertsAtHeadStart(t *testing.T) {
+	doc := []byte(`<html><head lang="en"><link href="app.css"></head><body></body></html>`)
+	s := string(injectBaseHref(doc, "/app/", false))
+
+	want := `<head lang="en"><base href="/app/"><link`
+	if !strings.Contains(s, want) {
+		t.Errorf("expected base tag at start of head, got %s", s)
+	}
+}
+
+func TestInjectBaseHref_ExistingBase(t *testing.T) {
+	doc := []byte(`<html><head><base href="/"><title>T</title></head></html>`)
+
+	kept := string(injectBaseHref(doc, "/app/", false))
+	if kept != string(doc) {
+		t.Errorf("existing base should be left alone, got %s", kept)
+	}
+
+	replaced := string(injectBaseHref(doc, "/app/", true))
+	if !strings.Contains(replaced, `<base href="/app/">`) || strings.Contains(replaced, `<base href="/">`) {
+		t.Errorf("existing base should be replaced, got %s", replaced)
+	}
+	if strings.Count(replaced, "<base") != 1 {
+		t.Errorf("expected exactly one base tag, got %s", replaced)
+	}
+}
+
+func TestInjectBaseHref_IgnoresCommentsAndSimilarTags(t *testing.T) {
+	doc := []byte(`<html><head><!-- <base href="/old/"> --><basefont size="3"></head></html>`)
+	s := string(injectBaseHref(doc, "/app/", true))
+
+	if !strings.Contains(s, `<head><base href="/app/">`) {
+		t.Errorf("expected a new base tag after <head>, got %s", s)
+	}
+	if !strings.Contains(s, `<!-- <base href="/old/"> -->`) {
+		t.Errorf("commented base tag should be untouched, got %s", s)
+	}
+}
+
+func TestInjectBaseHref_EscapesHref(t *testing.T) {
+	s := string(injectBaseHref([]byte(`<head></head>`), `/a"b/`, false))
+	if !strings.Contains(s, `<base href="/a&#34;b/">`) {
+		t.Errorf("expected escaped href, got %s", s)
+	}
+}
+
+func TestMiddleware_BaseHref(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head><title>T</title></head><body></body></html>`))
+	})
+
+	m := New(upstream, testScriptTag, slog.Default(), WithBaseHref("/prefix/", false))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	baseIdx := strings.Index(body, `<base href="/prefix/">`)
+	scriptIdx := strings.Index(body, `id="__rep__"`)
+	if baseIdx == -1 || scriptIdx == -1 {
+		t.Fatalf("expected both base and REP tags, got %s", body)
+	}
+	if baseIdx > scriptIdx {
+		t.Error("base tag should precede the REP script tag")
+	}
+}
+
+func TestMiddleware_LogSampling(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head></head><body></body></html>`))
+	})
+
+	serve := func(opts ...Option) (lines, summaries int, logs string) {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		m := New(upstream, testScriptTag, logger, opts...)
+
+		clock := time.Unix(0, 0)
+		m.now = func() time.Time { return clock }
+		m.lastSummary = clock
+
+		// 100 requests 100ms apart, with a summary tick every 5s and one
+		// idle tick at the end that must not log.
+		for i := 1; i <= 100; i++ {
+			clock = clock.Add(100 * time.Millisecond)
+			m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			if i%50 == 0 {
+				m.flushSummary()
+			}
+		}
+		clock = clock.Add(5 * time.Second)
+		m.flushSummary()
+
+		out := buf.String()
+		return strings.Count(out, "msg=rep.inject.html"), strings.Count(out, "msg=rep.inject.summary"), out
+	}
+
+	if lines, _, _ := serve(); lines != 100 {
+		t.Errorf("default: expected 100 lines, got %d", lines)
+	}
+
+	lines, summaries, logs := serve(WithLogSampling(10, 5*time.Second))
+	if lines != 10 {
+		t.Errorf("expected 10 sampled lines, got %d", lines)
+	}
+	if summaries != 2 {
+		t.Errorf("expected 2 summaries, got %d:\n%s", summaries, logs)
+	}
+	if !strings.Contains(logs, "injections=50") {
+		t.Errorf("expected summary to count 50 injections, got:\n%s", logs)
+	}
+	added := len(testScriptTag) + 2
+	if !strings.Contains(logs, "bytes_injected="+strconv.Itoa(50*added)) {
+		t.Errorf("expected summary to report %d bytes injected, got:\n%s", 50*added, logs)
+	}
+}
+
+func TestMiddleware_RunSummary(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head></head><body></body></html>`))
+	})
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	m := New(upstream, testScriptTag, logger, WithLogSampling(0, time.Hour))
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// No tick fires within the hour, so the summary comes from the flush on
+	// shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.RunSummary(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RunSummary did not return after cancel")
+	}
+	if !strings.Contains(buf.String(), "msg=rep.inject.summary") || !strings.Contains(buf.String(), "injections=1") {
+		t.Errorf("expected a final summary on shutdown, got:\n%s", buf.String())
+	}
+}
+
+func TestMiddleware_ReadinessGate(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head></head><body></body></html>`))
+	})
+
+	ready, reason := false, "manifest validation failed"
+	m := New(upstream, testScriptTag, slog.Default(), WithReadinessGate(func() (bool, string) {
+		return ready, reason
+	}))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if strings.Contains(rec.Body.String(), "__rep__") {
+		t.Error("payload injected while not ready")
+	}
+	if got := rec.Header().Get(NotReadyHeader); !strings.Contains(got, reason) {
+		t.Errorf("expected %s header with reason, got %q", NotReadyHeader, got)
+	}
+
+	ready = true
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(rec.Body.String(), "__rep__") {
+		t.Error("expected payload once ready")
+	}
+	if got := rec.Header().Get(NotReadyHeader); got != "" {
+		t.Errorf("unexpected %s header once ready: %q", NotReadyHeader, got)
+	}
+}
+
+func TestMiddleware_RewritesHost(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head><link href="http://app.internal.svc/style.css"></head>` +
+			`<body><a href="http://app.internal.svc:8080/docs">Docs</a></body></html>`))
+	})
+
+	rules, err := ParseRewrites([]string{
+		`http://app.internal.svc => https://app.example.com`,
+		`re:https://app\.example\.com:\d+ => https://app.example.com`,
+	})
+	if err != nil {
+		t.Fatalf("ParseRewrites: %v", err)
+	}
+	m := New(upstream, testScriptTag, slog.Default(), WithRewrites(rules))
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	body := rec.Body.String()
+	if strings.Contains(body, "internal.svc") {
+		t.Errorf("internal host not rewritten: %s", body)
+	}
+	if !strings.Contains(body, `href="https://app.example.com/style.css"`) ||
+		!strings.Contains(body, `href="https://app.example.com/docs"`) {
+		t.Errorf("expected rewritten URLs, got %s", body)
+	}
+	if !strings.Contains(body, testScriptTag) {
+		t.Error("expected REP payload after rewriting")
+	}
+	if rec.Header().Get("Content-Length") != strconv.Itoa(len(body)) {
+		t.Errorf("Content-Length %s does not match body length %d", rec.Header().Get("Content-Length"), len(body))
+	}
+}
+
+func TestParseRewrite_Errors(t *testing.T) {
+	for _, spec := range []string{
+		"no separator",
+		" => empty",
+		"re: => empty regexp",
+		"re:( => unbalanced",
+		strings.Repeat("x", maxRewritePatternLen+1) + " => long",
+	} {
+		if _, err := ParseRewrite(spec); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+
+	if _, err := ParseRewrites(make([]string, MaxRewrites+1)); err == nil {
+		t.Error("expected error for too many rules")
+	}
+}
+
+func TestApplyRewrites_SizeBound(t *testing.T) {
+	body := []byte(strings.Repeat("a", 1000))
+	for _, spec := range []string{
+		"a => " + strings.Repeat("b", 200),
+		"re:(a) => " + strings.Repeat("$1", 200),
+	} {
+		rules, err := ParseRewrites([]string{spec})
+		if err != nil {
+			t.Fatalf("ParseRewrites: %v", err)
+		}
+		out, ok := applyRewrites(body, rules)
+		if ok {
+			t.Errorf("%.20s: expected rewrite to be abandoned", spec)
+		}
+		if !bytes.Equal(out, body) {
+			t.Errorf("%.20s: expected original body back", spec)
+		}
+	}
+
+	rules, _ := ParseRewrites([]string{"re:a+ => b"})
+	if out, ok := applyRewrites(body, rules); !ok || string(out) != "b" {
+		t.Errorf("expected shrinking rewrite to apply, got %q (ok=%v)", out, ok)
+	}
+}
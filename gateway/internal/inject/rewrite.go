@@ -0,0 +1,112 @@
+package inject
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Limits on body rewriting. Go's regexp package (RE2) matches in time linear
+// in the input, so patterns cannot backtrack catastrophically; these bounds
+// keep the rule set and its output size in check.
+const (
+	// MaxRewrites is the maximum number of rewrite rules.
+	MaxRewrites = 32
+
+	// maxRewritePatternLen bounds the length of a single pattern.
+	maxRewritePatternLen = 512
+
+	// maxRewriteGrowth is the slack, beyond doubling, a body may grow by
+	// through rewriting before the rewrites are abandoned.
+	maxRewriteGrowth = 64 << 10
+)
+
+// Rewrite is a single response-body replacement rule.
+type Rewrite struct {
+	old string
+	new string
+	re  *regexp.Regexp
+}
+
+// ParseRewrite parses a rule of the form "old => new". A "re:" prefix makes
+// old a regular expression, in which case new may reference capture groups
+// ($1, ${name}); otherwise both sides are literal strings.
+func ParseRewrite(spec string) (Rewrite, error) {
+	old, repl, ok := strings.Cut(spec, " => ")
+	if !ok {
+		return Rewrite{}, fmt.Errorf("rewrite %q: expected \"old => new\"", spec)
+	}
+	old = strings.TrimSpace(old)
+	repl = strings.TrimSpace(repl)
+
+	pattern, isRegexp := strings.CutPrefix(old, "re:")
+	if pattern == "" {
+		return Rewrite{}, fmt.Errorf("rewrite %q: empty match", spec)
+	}
+	if len(pattern) > maxRewritePatternLen {
+		return Rewrite{}, fmt.Errorf("rewrite %q: match exceeds %d bytes", spec, maxRewritePatternLen)
+	}
+
+	r := Rewrite{old: pattern, new: repl}
+	if isRegexp {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return Rewrite{}, fmt.Errorf("rewrite %q: %w", spec, err)
+		}
+		r.re = re
+	}
+	return r, nil
+}
+
+// ParseRewrites parses each spec with ParseRewrite, allowing at most
+// MaxRewrites rules.
+func ParseRewrites(specs []string) ([]Rewrite, error) {
+	if len(specs) > MaxRewrites {
+		return nil, fmt.Errorf("%d body rewrites configured; the maximum is %d", len(specs), MaxRewrites)
+	}
+	rules := make([]Rewrite, 0, len(specs))
+	for _, spec := range specs {
+		r, err := ParseRewrite(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// applyRewrites applies rules to body in order. If the result would grow
+// beyond twice the original size plus maxRewriteGrowth, the body is returned
+// unchanged and ok is false. The bound is checked while replacing, so an
+// oversized result is never materialised.
+func applyRewrites(body []byte, rules []Rewrite) (out []byte, ok bool) {
+	limit := 2*len(body) + maxRewriteGrowth
+	out = body
+	for _, r := range rules {
+		if r.re == nil {
+			n := bytes.Count(out, []byte(r.old))
+			if len(out)+n*(len(r.new)-len(r.old)) > limit {
+				return body, false
+			}
+			out = bytes.ReplaceAll(out, []byte(r.old), []byte(r.new))
+			continue
+		}
+
+		var buf []byte
+		last := 0
+		for _, match := range r.re.FindAllSubmatchIndex(out, -1) {
+			buf = append(buf, out[last:match[0]]...)
+			buf = r.re.Expand(buf, []byte(r.new), out, match)
+			last = match[1]
+			if len(buf) > limit {
+				return body, false
+			}
+		}
+		out = append(buf, out[last:]...)
+		if len(out) > limit {
+			return body, false
+		}
+	}
+	return out, true
+}
@@ -0,0 +1,58 @@
+package inject
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Statuses is a set of HTTP status codes, built by ParseStatuses.
+type Statuses struct {
+	classes [6]bool // classes[n] holds every nxx code.
+	codes   map[int]bool
+}
+
+// ParseStatuses parses status specs such as "2xx" (a whole class) or "404"
+// (a single code).
+func ParseStatuses(specs []string) (Statuses, error) {
+	var s Statuses
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if len(spec) == 3 && spec[0] >= '1' && spec[0] <= '5' && strings.EqualFold(spec[1:], "xx") {
+			s.classes[spec[0]-'0'] = true
+			continue
+		}
+		code, err := strconv.Atoi(spec)
+		if err != nil || code < 100 || code > 599 {
+			return Statuses{}, fmt.Errorf("invalid status %q: want a code (404) or a class (2xx)", spec)
+		}
+		s.Add(code)
+	}
+	return s, nil
+}
+
+// Add adds code to the set.
+func (s *Statuses) Add(code int) {
+	if s.codes == nil {
+		s.codes = make(map[int]bool)
+	}
+	s.codes[code] = true
+}
+
+// Contains reports whether code is in the set.
+func (s Statuses) Contains(code int) bool {
+	if class := code / 100; class >= 1 && class <= 5 && s.classes[class] {
+		return true
+	}
+	return s.codes[code]
+}
+
+// empty reports whether the set has no codes.
+func (s Statuses) empty() bool {
+	for _, c := range s.classes {
+		if c {
+			return false
+		}
+	}
+	return len(s.codes) == 0
+}
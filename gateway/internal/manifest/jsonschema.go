@@ -0,0 +1,136 @@
+package manifest
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// JSON Schema (draft-07) document types — only the subset needed to describe
+// the environment a manifest expects.
+type envSchema struct {
+	Schema               string                 `json:"$schema"`
+	Title                string                 `json:"title"`
+	Type                 string                 `json:"type"`
+	Properties           map[string]envProperty `json:"properties"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties bool                   `json:"additionalProperties"`
+}
+
+type envProperty struct {
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	Format      string   `json:"format,omitempty"`
+	Minimum     *float64 `json:"minimum,omitempty"`
+	Maximum     *float64 `json:"maximum,omitempty"`
+	MinLength   *int     `json:"minLength,omitempty"`
+	MaxLength   *int     `json:"maxLength,omitempty"`
+	Default     any      `json:"default,omitempty"`
+	Examples    []string `json:"examples,omitempty"`
+	Deprecated  bool     `json:"deprecated,omitempty"`
+}
+
+// schemaFormats maps the manifest's format: values to JSON Schema formats.
+var schemaFormats = map[string]string{
+	"email":     "email",
+	"uuid":      "uuid",
+	"ipv4":      "ipv4",
+	"date-time": "date-time",
+}
+
+// ToJSONSchema returns a JSON Schema (draft-07) describing the environment
+// the manifest expects, e.g. for validating deploy configuration in CI.
+// Each declared variable is a property named by its full environment
+// variable name (REP_PUBLIC_API_URL), and required variables are listed in
+// required. Variables of type number and boolean map to those JSON Schema
+// types; all others are strings. Variables without a tier keep their bare
+// name. Conditional requirements (required_if, requires_if) are not
+// expressed.
+func (m *Manifest) ToJSONSchema() ([]byte, error) {
+	doc := envSchema{
+		Schema:               "http://json-schema.org/draft-07/schema#",
+		Title:                "REP environment",
+		Type:                 "object",
+		Properties:           make(map[string]envProperty),
+		AdditionalProperties: true,
+	}
+	if m != nil {
+		for name, decl := range m.Variables {
+			key := envKey(name, decl.Tier)
+			doc.Properties[key] = decl.schemaProperty()
+			if decl.Required {
+				doc.Required = append(doc.Required, key)
+			}
+		}
+	}
+	sort.Strings(doc.Required)
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// envKey returns the environment variable name for a declaration.
+func envKey(name, tier string) string {
+	switch tier {
+	case "public":
+		return "REP_PUBLIC_" + name
+	case "sensitive":
+		return "REP_SENSITIVE_" + name
+	case "server":
+		return "REP_SERVER_" + name
+	}
+	return name
+}
+
+// schemaProperty describes decl as a JSON Schema property.
+func (decl *VarDecl) schemaProperty() envProperty {
+	p := envProperty{
+		Type:        "string",
+		Description: decl.Description,
+		Format:      schemaFormats[decl.Format],
+		Deprecated:  decl.Deprecated,
+	}
+	// JSON Schema patterns are unanchored; anchor them the way the gateway
+	// does so CI rejects the same partial matches.
+	if decl.Pattern != "" {
+		p.Pattern = "^(?:" + decl.Pattern + ")$"
+	}
+	if decl.Example != "" {
+		p.Examples = []string{decl.Example}
+	}
+
+	switch decl.Type {
+	case "number":
+		p.Type = "number"
+		if decl.HasMin {
+			p.Minimum = &decl.Min
+		}
+		if decl.HasMax {
+			p.Maximum = &decl.Max
+		}
+	case "boolean":
+		p.Type = "boolean"
+	case "url":
+		p.Format = "uri"
+	case "enum":
+		p.Enum = decl.Values
+	}
+	if decl.Type == "string" || decl.Type == "csv" {
+		if decl.HasMinLength {
+			p.MinLength = &decl.MinLength
+		}
+		if decl.HasMaxLength {
+			p.MaxLength = &decl.MaxLength
+		}
+	}
+
+	if decl.HasDefault {
+		p.Default = decl.Default
+		// Number and boolean defaults are emitted as JSON values of the
+		// property's type.
+		var v any
+		if p.Type != "string" && json.Unmarshal([]byte(decl.Default), &v) == nil {
+			p.Default = v
+		}
+	}
+	return p
+}
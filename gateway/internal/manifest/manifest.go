@@ -17,17 +17,34 @@
 //   - Inline sequence literals: ["v1", "v2"]
 //   - Block sequences with - prefix items
 //   - Line comments: # ...
+//
+// An optional top-level environments: block holds named sections (e.g.
+// production:) whose nested variables: and settings: blocks override the
+// base manifest when selected via ApplyEnvironment. An optional top-level
+// extends: key names a base manifest that Load applies first.
 package manifest
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // VarDecl declares a single variable entry in the manifest.
@@ -57,13 +74,55 @@ type VarDecl struct {
 	// Pattern is a Go-compatible regular expression the value must match.
 	Pattern string
 
+	// Format is an additional check on the value's shape, independent of
+	// Type: email | uuid | ipv4 | date-time. Unknown formats are ignored.
+	Format string
+
 	// Values lists all allowed values for type: enum.
 	Values []string
 
+	// Min and Max bound the parsed value of a type: number variable,
+	// inclusive. HasMin and HasMax distinguish an explicit 0 from "no bound".
+	Min    float64
+	Max    float64
+	HasMin bool
+	HasMax bool
+
+	// MinLength and MaxLength bound the length, in characters, of a
+	// type: string or csv value. HasMinLength and HasMaxLength distinguish
+	// an explicit 0 from "no bound".
+	MinLength    int
+	MaxLength    int
+	HasMinLength bool
+	HasMaxLength bool
+
+	// MinItems and MaxItems bound the number of comma-separated items in a
+	// type: csv value (see csvItems). HasMinItems and HasMaxItems
+	// distinguish an explicit 0 from "no bound".
+	MinItems    int
+	MaxItems    int
+	HasMinItems bool
+	HasMaxItems bool
+
 	// Deprecated marks the variable as deprecated; the gateway logs a warning
 	// if it is present.
 	Deprecated        bool
 	DeprecatedMessage string
+
+	// RequiresIf makes the variable required when every listed variable
+	// is set to the given value, e.g. requires_if: {FEATURE_X: "true"}.
+	RequiresIf map[string]string
+
+	// RequiredIf is a single-condition shorthand for RequiresIf: the
+	// variable is required when another variable is set
+	// (required_if: OTHER_VAR) or set to a given value
+	// (required_if: OTHER_VAR=value). Required takes precedence, and Lint
+	// flags a declaration that sets both RequiredIf and RequiresIf.
+	RequiredIf string
+
+	// BuildTime marks a variable whose value is baked in at build time
+	// (reload: false). Hot reload keeps its startup value.
+	BuildTime bool
 }
 
 // Settings holds gateway configuration from the manifest settings block.
@@ -77,6 +136,18 @@ type Settings struct {
 	SessionKeyTTL         time.Duration
 	SessionKeyMaxRate     int
 	AllowedOrigins        []string
+
+	// Environment labels the deployment (e.g. "production") in the
+	// payload's _meta.environment.
+	Environment string
+
+	// BodyRewrites are "old => new" replacements applied to HTML response
+	// bodies before injection ("re:" prefixes a regular expression).
+	BodyRewrites []string
+
+	// RateLimits are "endpoint=N" per-IP request limits per minute for
+	// /rep/* endpoints (e.g. "changes=30").
+	RateLimits []string
 }
 
 // Manifest holds the fully parsed .rep.yaml contents.
@@ -90,32 +161,426 @@ type Manifest struct {
 	// Settings holds optional gateway configuration from the manifest.
 	// May be nil if the settings block is absent.
 	Settings *Settings
+
+	// Strict makes unknown variable property and settings keys a parse
+	// error instead of being ignored (strict: true).
+	Strict bool
+
+	// SHA256 is the hex-encoded SHA-256 digest of the manifest as loaded,
+	// for pinning (see config's --manifest-sha256). With extends: it covers
+	// the whole chain: the named manifest's bytes followed by those of each
+	// base in turn. Empty when the manifest was not loaded from bytes.
+	SHA256 string
+
+	// environments holds the raw, root-relative lines of each section under
+	// environments:, keyed by environment name. See ApplyEnvironment.
+	environments map[string][]numberedLine
+}
+
+// validTiers and validTypes are the values accepted for tier: and type:.
+// Unknown types are otherwise skipped by validation (see validateType), so
+// they are only reported under strict: true. tierRank orders the tiers from
+// least to most protected.
+var (
+	validTiers = map[string]bool{"public": true, "sensitive": true, "server": true}
+	tierRank   = map[string]int{"public": 0, "sensitive": 1, "server": 2}
+	validTypes = map[string]bool{
+		"string": true, "url": true, "number": true, "boolean": true,
+		"csv": true, "json": true, "enum": true,
+	}
+)
+
+// numberedLine is a manifest line with its 1-based line number in the file.
+type numberedLine struct {
+	text string
+	no   int
+}
+
+// Limits bounds the input accepted by LoadWithLimits. The parser is a single
+// linear pass, so bounding the input size also bounds parse time.
+type Limits struct {
+	// MaxFileSize is the maximum manifest size in bytes.
+	MaxFileSize int64
+
+	// MaxLineLength is the maximum length of a single line in bytes.
+	MaxLineLength int
 }
 
-// Load reads and parses a .rep.yaml manifest file at path.
-// Returns a non-nil *Manifest on success. Returns an error if the file
+// DefaultLimits are the limits applied by Load. Real manifests are a few
+// kilobytes; these leave generous headroom while rejecting runaway input.
+var DefaultLimits = Limits{
+	MaxFileSize:   1 << 20,  // 1 MiB
+	MaxLineLength: 64 << 10, // 64 KiB
+}
+
+// ProtocolVersion is the REP protocol version this gateway implements. A
+// manifest declaring a different major version is rejected by Load; a
+// different minor version is reported by Lint. See CheckCompatibility.
+const ProtocolVersion = "0.1.0"
+
+// FetchTimeout bounds fetching a manifest from an http(s) URL.
+const FetchTimeout = 10 * time.Second
+
+// Load reads and parses a .rep.yaml manifest using DefaultLimits. path is a
+// file path, "-" for standard input, or an http:// or https:// URL.
+// Returns a non-nil *Manifest on success. Returns an error if the manifest
 // cannot be opened, read, or parsed.
+//
+// A top-level extends: key names a base manifest, resolved relative to the
+// extending one, that is loaded first. The extending manifest is then
+// applied on top of it, overriding the base's declarations and settings
+// property by property. Bases may themselves extend other manifests.
 func Load(path string) (*Manifest, error) {
-	f, err := os.Open(path)
+	return LoadWithLimits(path, DefaultLimits)
+}
+
+// LoadWithLimits is like Load but enforces the given size limits. Zero-valued
+// fields fall back to the corresponding DefaultLimits value. The limits and
+// the version check apply to each extended manifest separately.
+func LoadWithLimits(path string, limits Limits) (*Manifest, error) {
+	if limits.MaxFileSize <= 0 {
+		limits.MaxFileSize = DefaultLimits.MaxFileSize
+	}
+	if limits.MaxLineLength <= 0 {
+		limits.MaxLineLength = DefaultLimits.MaxLineLength
+	}
+
+	m, data, err := load(path, limits, nil)
 	if err != nil {
-		return nil, fmt.Errorf("opening manifest %q: %w", path, err)
+		return nil, err
+	}
+	// The digest covers every manifest in the extends chain, so a pinned
+	// manifest cannot be changed through one of its bases.
+	sum := sha256.Sum256(data)
+	m.SHA256 = hex.EncodeToString(sum[:])
+	return m, nil
+}
+
+// load reads and parses the manifest at path, first loading the manifest
+// it extends, if any. chain lists the manifests already being loaded, for
+// cycle detection. It also returns the raw bytes of path followed by those
+// of its bases, and rejects any manifest in the chain whose version: is
+// incompatible with ProtocolVersion.
+func load(path string, limits Limits, chain []string) (*Manifest, []byte, error) {
+	id := path
+	if path != "-" && !isURL(path) {
+		if abs, err := filepath.Abs(path); err == nil {
+			id = abs
+		}
+	}
+	for i, seen := range chain {
+		if seen == id {
+			return nil, nil, fmt.Errorf("manifest extends cycle: %s", strings.Join(append(chain[i:], id), " -> "))
+		}
+	}
+	chain = append(chain, id)
+
+	var data []byte
+	var err error
+	switch {
+	case path == "-":
+		data, err = readLimited(os.Stdin, path, limits.MaxFileSize)
+	case isURL(path):
+		data, err = fetch(path, limits.MaxFileSize)
+	default:
+		data, err = readFile(path, limits.MaxFileSize)
+	}
+	if err != nil {
+		return nil, nil, err
 	}
-	defer func() { _ = f.Close() }()
 
 	var lines []string
-	sc := bufio.NewScanner(f)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, min(4096, limits.MaxLineLength)), limits.MaxLineLength)
 	for sc.Scan() {
 		lines = append(lines, sc.Text())
 	}
 	if err := sc.Err(); err != nil {
-		return nil, fmt.Errorf("reading manifest %q: %w", path, err)
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, nil, fmt.Errorf("manifest %q: line %d exceeds the maximum line length of %d bytes", path, len(lines)+1, limits.MaxLineLength)
+		}
+		return nil, nil, fmt.Errorf("reading manifest %q: %w", path, err)
+	}
+
+	if _, err := CheckCompatibility(topLevelValue(lines, "version"), ProtocolVersion); err != nil {
+		return nil, nil, fmt.Errorf("manifest %q: %w", path, err)
+	}
+
+	m := &Manifest{Variables: make(map[string]*VarDecl)}
+	var baseData []byte
+	if base := topLevelValue(lines, "extends"); base != "" {
+		ref, err := resolveRef(path, base)
+		if err != nil {
+			return nil, nil, fmt.Errorf("manifest %q: %w", path, err)
+		}
+		if m, baseData, err = load(ref, limits, chain); err != nil {
+			return nil, nil, err
+		}
 	}
+	if err := m.parseLines(numberLines(lines)); err != nil {
+		return nil, nil, fmt.Errorf("parsing manifest %q: %w", path, err)
+	}
+	return m, append(data, baseData...), nil
+}
+
+// isURL reports whether path is an http:// or https:// URL.
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
 
-	m, err := parseManifest(lines)
+// topLevelValue returns the value of the top-level key, or "". It is read
+// ahead of parsing, for keys (extends:, version:) that decide how the rest
+// of the manifest is loaded.
+func topLevelValue(lines []string, key string) string {
+	for _, line := range lines {
+		raw := stripComment(line)
+		if countIndent(raw) != 0 {
+			continue
+		}
+		if k, val, _ := splitKV(strings.TrimSpace(raw)); k == key {
+			return unquoteYAML(val)
+		}
+	}
+	return ""
+}
+
+// resolveRef resolves an extends: reference against the manifest that
+// declares it: relative to its URL or its directory. A manifest read from
+// standard input resolves against the working directory.
+func resolveRef(from, ref string) (string, error) {
+	switch {
+	case isURL(from):
+		base, err := url.Parse(from)
+		if err != nil {
+			return "", err
+		}
+		r, err := url.Parse(ref)
+		if err != nil {
+			return "", fmt.Errorf("invalid extends %q: %w", ref, err)
+		}
+		return base.ResolveReference(r).String(), nil
+	case isURL(ref), filepath.IsAbs(ref), from == "-":
+		return ref, nil
+	default:
+		return filepath.Join(filepath.Dir(from), ref), nil
+	}
+}
+
+// readFile reads the manifest file at path, up to maxSize bytes.
+func readFile(path string, maxSize int64) ([]byte, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("parsing manifest %q: %w", path, err)
+		return nil, fmt.Errorf("opening manifest %q: %w", path, err)
 	}
-	return m, nil
+	defer func() { _ = f.Close() }()
+
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() && fi.Size() > maxSize {
+		return nil, fmt.Errorf("manifest %q is %d bytes, exceeding the maximum size of %d bytes", path, fi.Size(), maxSize)
+	}
+	return readLimited(f, path, maxSize)
+}
+
+// fetch downloads the manifest at rawURL, up to maxSize bytes.
+func fetch(rawURL string, maxSize int64) ([]byte, error) {
+	client := &http.Client{Timeout: FetchTimeout}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching manifest %q: %w", rawURL, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching manifest %q: unexpected status %s", rawURL, resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("manifest %q is %d bytes, exceeding the maximum size of %d bytes", rawURL, resp.ContentLength, maxSize)
+	}
+	return readLimited(resp.Body, rawURL, maxSize)
+}
+
+// readLimited reads r to the end, failing if it holds more than maxSize
+// bytes. name identifies the manifest in errors.
+func readLimited(r io.Reader, name string, maxSize int64) ([]byte, error) {
+	// Read one byte past the limit so oversize inputs are detected.
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest %q: %w", name, err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("manifest %q exceeds the maximum size of %d bytes", name, maxSize)
+	}
+	return data, nil
+}
+
+// Lint reports declarations that are contradictory or likely to reflect
+// author confusion. Unlike Validate, it inspects only the manifest itself,
+// so it can run before any environment variables are read. The gateway logs
+// each finding as a warning, or refuses to start under --strict.
+func (m *Manifest) Lint() []string {
+	if m == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(m.Variables))
+	for name := range m.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var findings []string
+	for _, name := range names {
+		decl := m.Variables[name]
+		if decl.Required && decl.HasDefault {
+			findings = append(findings, fmt.Sprintf("variable %q is required but declares a default; the default can never apply", name))
+		}
+		if decl.Required && decl.RequiredIf != "" {
+			findings = append(findings, fmt.Sprintf("variable %q is required but declares required_if; the condition is redundant", name))
+		}
+		if decl.RequiredIf != "" && len(decl.RequiresIf) > 0 {
+			findings = append(findings, fmt.Sprintf("variable %q declares both required_if and requires_if; use requires_if alone", name))
+		}
+	}
+
+	// Incompatible versions are rejected by Load, so only the compatible
+	// but mismatched cases are left to report here.
+	if warning, err := CheckCompatibility(m.Version, ProtocolVersion); err == nil && warning != "" {
+		findings = append(findings, warning)
+	}
+	return findings
+}
+
+// CheckCompatibility compares a manifest version with the protocol version
+// a gateway implements, both "major.minor.patch". It returns an error if
+// either is malformed or their major versions differ, and a warning if only
+// their minor versions differ. A manifest without a version is compatible.
+func CheckCompatibility(manifestVersion, protocolVersion string) (warning string, err error) {
+	if manifestVersion == "" {
+		return "", nil
+	}
+	v, err := ParseVersion(manifestVersion)
+	if err != nil {
+		return "", err
+	}
+	p, err := ParseVersion(protocolVersion)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case v.Major != p.Major:
+		return "", fmt.Errorf("manifest version %q is not supported by this gateway (protocol version %s)", manifestVersion, protocolVersion)
+	case v.Less(p):
+		return fmt.Sprintf("manifest version %q is older than the supported protocol version %s", manifestVersion, protocolVersion), nil
+	case p.Less(v):
+		return fmt.Sprintf("manifest version %q is newer than the supported protocol version %s; newer declarations may be ignored", manifestVersion, protocolVersion), nil
+	}
+	return "", nil
+}
+
+// Version is a parsed major.minor.patch version.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// ParseVersion parses a "major.minor.patch" version string. A leading "v"
+// and a pre-release or build suffix ("-rc.1", "+build") are ignored.
+func ParseVersion(s string) (Version, error) {
+	core := strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(core, "-+"); i >= 0 {
+		core = core[:i]
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("invalid version %q: want major.minor.patch", s)
+	}
+	var nums [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid version %q: want major.minor.patch", s)
+		}
+		nums[i] = n
+	}
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}, nil
+}
+
+// Less reports whether v is an earlier major.minor release than o. Patch
+// releases do not change the manifest format, so they compare equal.
+func (v Version) Less(o Version) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	return v.Minor < o.Minor
+}
+
+// IsBuildTime reports whether name is declared with reload: false. It is
+// safe to call on a nil manifest.
+func (m *Manifest) IsBuildTime(name string) bool {
+	if m == nil {
+		return false
+	}
+	decl, ok := m.Variables[name]
+	return ok && decl.BuildTime
+}
+
+// ExpandDefault replaces ${NAME} references in a default value with the
+// value lookup returns for NAME. References lookup cannot resolve are left
+// as written and their names returned. "$${" produces a literal "${".
+func ExpandDefault(s string, lookup func(name string) (string, bool)) (string, []string) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	var unresolved []string
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "$${"):
+			b.WriteString("${")
+			i += 3
+		case strings.HasPrefix(s[i:], "${"):
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				// Unterminated: the rest is literal.
+				b.WriteString(s[i:])
+				i = len(s)
+				continue
+			}
+			name := s[i+2 : i+2+end]
+			if val, ok := lookup(name); ok {
+				b.WriteString(val)
+			} else {
+				b.WriteString(s[i : i+3+end])
+				unresolved = append(unresolved, name)
+			}
+			i += 3 + end
+		default:
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+	return b.String(), unresolved
+}
+
+// Violation kinds reported by ValidateDetailed.
+const (
+	KindMissingRequired = "missing_required"
+	KindRequiresIf      = "requires_if"
+	KindType            = "type"
+	KindInvalidPattern  = "invalid_pattern"
+	KindPatternMismatch = "pattern_mismatch"
+	KindTierDowngrade   = "tier_downgrade"
+)
+
+// Violation is a single manifest validation failure for one variable.
+type Violation struct {
+	// Name is the variable name (without REP_*_ prefix).
+	Name string `json:"name"`
+
+	// Kind classifies the failure; one of the Kind* constants.
+	Kind string `json:"kind"`
+
+	// Message is the human-readable description.
+	Message string `json:"message"`
 }
 
 // Validate checks classified environment variables against the manifest
@@ -126,6 +591,26 @@ func Load(path string) (*Manifest, error) {
 // Deprecated variables that are present cause a warning log entry; they do
 // NOT count as errors.
 func (m *Manifest) Validate(public, sensitive, server map[string]string, log func(msg string, args ...any)) error {
+	return ViolationsError(m.ValidateDetailed(public, sensitive, server, log))
+}
+
+// ViolationsError joins violations into the error returned by Validate.
+// Returns nil when violations is empty.
+func ViolationsError(violations []Violation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(violations))
+	for i, v := range violations {
+		msgs[i] = v.Message
+	}
+	return fmt.Errorf("manifest validation failed:\n  - %s", strings.Join(msgs, "\n  - "))
+}
+
+// ValidateDetailed performs the same checks as Validate but returns the
+// individual violations, ordered by variable name, for callers that render
+// structured output. A nil or empty result means validation passed.
+func (m *Manifest) ValidateDetailed(public, sensitive, server map[string]string, log func(msg string, args ...any)) []Violation {
 	if m == nil || len(m.Variables) == 0 {
 		return nil
 	}
@@ -142,19 +627,47 @@ func (m *Manifest) Validate(public, sensitive, server map[string]string, log fun
 		all[k] = v
 	}
 
-	var errs []string
+	names := make([]string, 0, len(m.Variables))
+	for name := range m.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var violations []Violation
+	add := func(name, kind, msg string) {
+		violations = append(violations, Violation{Name: name, Kind: kind, Message: msg})
+	}
 
-	for name, decl := range m.Variables {
+	for _, name := range names {
+		decl := m.Variables[name]
 		value, exists := all[name]
 
 		if !exists {
 			if decl.Required {
-				errs = append(errs, fmt.Sprintf("required variable %q is not set", name))
+				add(name, KindMissingRequired, fmt.Sprintf("required variable %q is not set", name))
+			} else if cond, ok := requiredIfHolds(decl.RequiredIf, all); ok {
+				add(name, KindRequiresIf, fmt.Sprintf("variable %q is required because %s", name, cond))
+			} else if cond, ok := requiresIfHolds(decl.RequiresIf, all); ok {
+				add(name, KindRequiresIf, fmt.Sprintf("variable %q is required when %s", name, cond))
 			}
 			// Optional + absent: nothing to validate.
 			continue
 		}
 
+		// A variable supplied in a less protected tier than declared (e.g.
+		// declared sensitive but set as REP_PUBLIC_*) would be exposed more
+		// widely than the manifest allows.
+		supplied := "server"
+		if _, ok := public[name]; ok {
+			supplied = "public"
+		} else if _, ok := sensitive[name]; ok {
+			supplied = "sensitive"
+		}
+		if tierRank[decl.Tier] > tierRank[supplied] {
+			add(name, KindTierDowngrade, fmt.Sprintf("variable %q is declared %s but supplied as REP_%s_%s", name, decl.Tier, strings.ToUpper(supplied), name))
+			continue
+		}
+
 		// Deprecated variable present → warning.
 		if decl.Deprecated {
 			msg := fmt.Sprintf("variable %q is deprecated", name)
@@ -168,7 +681,7 @@ func (m *Manifest) Validate(public, sensitive, server map[string]string, log fun
 
 		// Type validation.
 		if err := validateType(name, value, decl); err != nil {
-			errs = append(errs, err.Error())
+			add(name, KindType, err.Error())
 			continue
 		}
 
@@ -176,19 +689,86 @@ func (m *Manifest) Validate(public, sensitive, server map[string]string, log fun
 		if decl.Pattern != "" {
 			matched, err := regexp.MatchString(`^(?:`+decl.Pattern+`)$`, value)
 			if err != nil {
-				errs = append(errs, fmt.Sprintf("variable %q has invalid pattern expression %q: %v", name, decl.Pattern, err))
+				add(name, KindInvalidPattern, fmt.Sprintf("variable %q has invalid pattern expression %q: %v", name, decl.Pattern, err))
 				continue
 			}
 			if !matched {
-				errs = append(errs, fmt.Sprintf("variable %q value does not match pattern %q", name, decl.Pattern))
+				add(name, KindPatternMismatch, fmt.Sprintf("variable %q value does not match pattern %q", name, decl.Pattern))
 			}
 		}
 	}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("manifest validation failed:\n  - %s", strings.Join(errs, "\n  - "))
+	return violations
+}
+
+// CheckUndeclared logs rep.manifest.undeclared_var for every variable in
+// public, sensitive or server that the manifest does not declare, usually a
+// leftover or a mistyped name. It only warns; undeclared variables are
+// still served. It does nothing on a nil manifest.
+func (m *Manifest) CheckUndeclared(public, sensitive, server map[string]string, log func(msg string, args ...any)) {
+	if m == nil || log == nil {
+		return
 	}
-	return nil
+	for _, tier := range []struct {
+		name string
+		vars map[string]string
+	}{{"public", public}, {"sensitive", sensitive}, {"server", server}} {
+		names := make([]string, 0, len(tier.vars))
+		for name := range tier.vars {
+			if _, ok := m.Variables[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			log("rep.manifest.undeclared_var", "name", name, "tier", tier.name)
+		}
+	}
+}
+
+// requiresIfHolds reports whether every requires_if condition is satisfied
+// by the environment, returning a description of the conditions if so.
+func requiresIfHolds(conds map[string]string, all map[string]string) (string, bool) {
+	if len(conds) == 0 {
+		return "", false
+	}
+	keys := make([]string, 0, len(conds))
+	for k := range conds {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if v, ok := all[k]; !ok || v != conds[k] {
+			return "", false
+		}
+		parts = append(parts, fmt.Sprintf("%s=%q", k, conds[k]))
+	}
+	return strings.Join(parts, " and "), true
+}
+
+// requiredIfHolds reports whether a required_if condition ("OTHER_VAR" or
+// "OTHER_VAR=value") is satisfied by the environment, returning the reason
+// the variable is required if so.
+func requiredIfHolds(cond string, all map[string]string) (string, bool) {
+	if cond == "" {
+		return "", false
+	}
+	other, want, hasValue := strings.Cut(cond, "=")
+	other = strings.TrimSpace(other)
+	v, ok := all[other]
+	if !ok {
+		return "", false
+	}
+	if !hasValue {
+		return other + " is set", true
+	}
+	want = strings.TrimSpace(want)
+	if v != want {
+		return "", false
+	}
+	return fmt.Sprintf("%s is %q", other, want), true
 }
 
 // validateType checks that value conforms to the declared type.
@@ -200,9 +780,18 @@ func validateType(name, value string, decl *VarDecl) error {
 			return fmt.Errorf("variable %q must be a valid URL, got %q", name, value)
 		}
 	case "number":
-		if _, err := strconv.ParseFloat(value, 64); err != nil {
+		// ParseFloat accepts NaN and ±Inf; neither is a usable number, and
+		// NaN compares false against any bound.
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
 			return fmt.Errorf("variable %q must be a number, got %q", name, value)
 		}
+		if decl.HasMin && n < decl.Min {
+			return fmt.Errorf("variable %q must be >= %g, got %s", name, decl.Min, value)
+		}
+		if decl.HasMax && n > decl.Max {
+			return fmt.Errorf("variable %q must be <= %g, got %s", name, decl.Max, value)
+		}
 	case "boolean":
 		lower := strings.ToLower(value)
 		if lower != "true" && lower != "false" && lower != "1" && lower != "0" {
@@ -221,14 +810,90 @@ func validateType(name, value string, decl *VarDecl) error {
 				return fmt.Errorf("variable %q must be one of %v, got %q", name, decl.Values, value)
 			}
 		}
-	case "string", "csv", "json", "":
-		// No structural type validation; pattern covers string constraints.
+	case "string", "csv", "":
+		// No structural validation beyond the declared length bounds;
+		// pattern is checked separately.
+		n := utf8.RuneCountInString(value)
+		if decl.HasMinLength && n < decl.MinLength {
+			return fmt.Errorf("variable %q length %d is less than min_length %d", name, n, decl.MinLength)
+		}
+		if decl.HasMaxLength && n > decl.MaxLength {
+			return fmt.Errorf("variable %q length %d is greater than max_length %d", name, n, decl.MaxLength)
+		}
+		if decl.Type == "csv" {
+			items := len(csvItems(value))
+			if decl.HasMinItems && items < decl.MinItems {
+				return fmt.Errorf("variable %q has %d items, fewer than min_items %d", name, items, decl.MinItems)
+			}
+			if decl.HasMaxItems && items > decl.MaxItems {
+				return fmt.Errorf("variable %q has %d items, more than max_items %d", name, items, decl.MaxItems)
+			}
+		}
+	case "json":
+		// The value is checked as served: a set-but-empty value is never
+		// replaced by the default. An empty value is accepted only when
+		// the declared default is itself empty.
+		if value == "" && decl.HasDefault && decl.Default == "" {
+			break
+		}
+		var v any
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			if value == "" && decl.HasDefault {
+				return fmt.Errorf("variable %q must be valid JSON: it is set but empty, and the default applies only when it is unset", name)
+			}
+			return fmt.Errorf("variable %q must be valid JSON: %v", name, err)
+		}
 	default:
 		// Unknown type — log nothing, skip (forward compatibility).
 	}
+	return validateFormat(name, value, decl.Format)
+}
+
+var (
+	// emailPattern is deliberately loose: one @, no whitespace, and a dot
+	// in the domain.
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// validateFormat checks value against a declared format.
+func validateFormat(name, value, format string) error {
+	var ok bool
+	switch format {
+	case "email":
+		ok = emailPattern.MatchString(value)
+	case "uuid":
+		ok = uuidPattern.MatchString(value)
+	case "ipv4":
+		ip := net.ParseIP(value)
+		ok = ip != nil && ip.To4() != nil && !strings.Contains(value, ":")
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, value)
+		ok = err == nil
+	default:
+		// No format, or an unknown one (forward compatibility).
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("variable %q is not a valid %s", name, format)
+	}
 	return nil
 }
 
+// csvItems splits a csv value on commas, trimming whitespace around each
+// item and dropping empty trailing items ("a, b," has two items and "" has
+// none).
+func csvItems(value string) []string {
+	items := strings.Split(value, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	for len(items) > 0 && items[len(items)-1] == "" {
+		items = items[:len(items)-1]
+	}
+	return items
+}
+
 // ---------------------------------------------------------------------------
 // Hand-rolled YAML parser (REP manifest subset only)
 // ---------------------------------------------------------------------------
@@ -236,32 +901,160 @@ func validateType(name, value string, decl *VarDecl) error {
 type parserState int
 
 const (
-	stRoot        parserState = iota
-	stVariables               // inside variables: block
-	stVarProps                // inside a specific variable's property block
-	stVarValues               // collecting multi-line `- item` for values:
-	stSettings                // inside settings: block
-	stSettOrigins             // collecting multi-line `- item` for allowed_origins:
+	stRoot         parserState = iota
+	stVariables                // inside variables: block
+	stVarProps                 // inside a specific variable's property block
+	stVarValues                // collecting multi-line `- item` for values:
+	stSettings                 // inside settings: block
+	stSettList                 // collecting multi-line `- item` for a settings list
+	stEnvironments             // inside environments: block
+	stBlock                    // collecting the lines of a `|` block scalar
 )
 
+// blockScalar is a `|` or `|-` variable property being collected.
+type blockScalar struct {
+	key       string
+	no        int // line number of the key
+	keyIndent int // lines indented deeper than the key belong to the block
+	indent    int // indentation of the first content line, removed from all
+	strip     bool
+	lines     []string
+}
+
+// text joins the collected lines. Trailing blank lines are dropped; `|`
+// keeps a single final newline and `|-` none, as in YAML.
+func (b *blockScalar) text() string {
+	lines := b.lines
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	text := strings.Join(lines, "\n")
+	if !b.strip && text != "" {
+		text += "\n"
+	}
+	return text
+}
+
 func parseManifest(lines []string) (*Manifest, error) {
 	m := &Manifest{
 		Variables: make(map[string]*VarDecl),
 	}
+	if err := m.parseLines(numberLines(lines)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// numberLines pairs each line with its 1-based line number.
+func numberLines(lines []string) []numberedLine {
+	numbered := make([]numberedLine, len(lines))
+	for i, text := range lines {
+		numbered[i] = numberedLine{text: text, no: i + 1}
+	}
+	return numbered
+}
 
+// parseLines applies manifest lines on top of m. Variable declarations that
+// already exist are updated in place, so the same routine parses the base
+// manifest and merges environment overrides (see ApplyEnvironment).
+//
+// Unknown variable property and settings keys are ignored unless m.Strict
+// is set, in which case they are all reported in one error.
+func (m *Manifest) parseLines(lines []numberedLine) error {
 	state := stRoot
-	var curVarName string
 	var curVar *VarDecl
+	var curVarName, curEnv string
+	var curList *[]string
+	var block *blockScalar
+	var unknown []string
+
+	// varProp and setting apply one property line. Their errors are
+	// wrapped with the line number by the caller.
+	varProp := func(ln numberedLine, trimmed string) error {
+		key, val, hasVal := splitKV(trimmed)
+		if val == "|" || val == "|-" {
+			block = &blockScalar{key: key, no: ln.no, keyIndent: countIndent(ln.text), strip: val == "|-"}
+			state = stBlock
+			return nil
+		}
+		if err := checkFlow(val); err != nil {
+			return err
+		}
+		known, err := applyVarProp(curVar, key, val, hasVal, func() { state = stVarValues })
+		if err != nil {
+			return fmt.Errorf("invalid %s %q for variable %q: %w", key, unquoteYAML(val), curVarName, err)
+		}
+		if !known {
+			unknown = append(unknown, fmt.Sprintf("unknown key %q under variable %q (line %d)", key, curVarName, ln.no))
+		}
+		switch {
+		case key == "tier" && !validTiers[curVar.Tier]:
+			// The tier drives classification, so it is checked even
+			// without strict: true.
+			return fmt.Errorf("invalid tier %q for variable %q: must be public, sensitive or server", curVar.Tier, curVarName)
+		case key == "type" && !validTypes[curVar.Type]:
+			unknown = append(unknown, fmt.Sprintf("unknown type %q for variable %q (line %d)", curVar.Type, curVarName, ln.no))
+		}
+		return nil
+	}
+	setting := func(ln numberedLine, trimmed string) error {
+		key, val, hasVal := splitKV(trimmed)
+		if err := checkFlow(val); err != nil {
+			return err
+		}
+		list, ok := m.Settings.apply(key, val, hasVal)
+		if !ok {
+			unknown = append(unknown, fmt.Sprintf("unknown key %q under settings (line %d)", key, ln.no))
+		}
+		if curList = list; curList != nil {
+			state = stSettList
+		}
+		return nil
+	}
+
+	// endBlock applies the block scalar being collected.
+	endBlock := func() {
+		if !setVarBlock(curVar, block.key, block.text()) {
+			unknown = append(unknown, fmt.Sprintf("key %q under variable %q does not take a block scalar (line %d)", block.key, curVarName, block.no))
+		}
+		block, state = nil, stVarProps
+	}
+
+	for _, ln := range lines {
+		// Block scalar lines are taken verbatim, comments and blank lines
+		// included, until a line is no deeper than the key.
+		if state == stBlock {
+			indent := countIndent(ln.text)
+			if strings.TrimSpace(ln.text) == "" {
+				block.lines = append(block.lines, "")
+				continue
+			}
+			if indent > block.keyIndent {
+				if block.indent == 0 {
+					block.indent = indent
+				}
+				if indent < block.indent {
+					return fmt.Errorf("line %d: block scalar line is indented less than its first line", ln.no)
+				}
+				block.lines = append(block.lines, ln.text[block.indent:])
+				continue
+			}
+			endBlock()
+		}
 
-	for _, raw := range lines {
 		// Strip inline comments — but only outside of quoted strings.
-		raw = stripComment(raw)
+		raw := stripComment(ln.text)
 
 		trimmed := strings.TrimSpace(raw)
 		if trimmed == "" {
 			continue
 		}
 
+		// countIndent counts spaces only, so a tab would silently move
+		// the line to another block.
+		if strings.ContainsRune(raw[:len(raw)-len(strings.TrimLeft(raw, " \t"))], '\t') {
+			return fmt.Errorf("line %d: tabs are not allowed for indentation", ln.no)
+		}
 		indent := countIndent(raw)
 
 		// ── Root-level lines always reset state ──────────────────────────────
@@ -271,6 +1064,10 @@ func parseManifest(lines []string) (*Manifest, error) {
 			switch key {
 			case "version":
 				m.Version = unquoteYAML(val)
+			case "strict":
+				m.Strict = parseBoolLiteral(val)
+			case "extends":
+				// Resolved by Load before the manifest is parsed.
 			case "variables":
 				state = stVariables
 			case "settings":
@@ -278,6 +1075,16 @@ func parseManifest(lines []string) (*Manifest, error) {
 					m.Settings = defaultSettings()
 				}
 				state = stSettings
+			case "environments":
+				if m.environments == nil {
+					m.environments = make(map[string][]numberedLine)
+				}
+				state = stEnvironments
+			}
+			// An inline empty map ("settings: {}") declares the section
+			// with nothing in it.
+			if isEmptyInlineMap(val) {
+				state = stRoot
 			}
 			continue
 		}
@@ -287,60 +1094,24 @@ func parseManifest(lines []string) (*Manifest, error) {
 
 		case stVariables:
 			// indent == 2 → new variable declaration
-			if !strings.HasSuffix(trimmed, ":") && !strings.Contains(trimmed, ":") {
-				// Bare name with no colon — treat as variable name.
-				curVarName = trimmed
-				curVar = &VarDecl{Type: "string"}
-				m.Variables[curVarName] = curVar
-				state = stVarProps
-				continue
-			}
-			name := strings.TrimSuffix(trimmed, ":")
-			if !strings.Contains(name, ":") {
-				// It's "VARNAME:" — a new variable block.
-				curVarName = name
-				curVar = &VarDecl{Type: "string"}
-				m.Variables[curVarName] = curVar
+			if name, ok := declName(trimmed); ok {
+				curVar, curVarName = m.varDecl(name), name
 				state = stVarProps
 			}
 
 		case stVarProps:
 			if indent == 2 {
-				// New variable at same level — store previous, start new.
-				name := strings.TrimSuffix(trimmed, ":")
-				curVarName = name
-				curVar = &VarDecl{Type: "string"}
-				m.Variables[curVarName] = curVar
+				// New variable at same level.
+				if name, ok := declName(trimmed); ok {
+					curVar, curVarName = m.varDecl(name), name
+				} else {
+					state = stVariables
+				}
 				continue
 			}
 			if indent >= 4 {
-				key, val, hasVal := splitKV(trimmed)
-				switch key {
-				case "tier":
-					curVar.Tier = unquoteYAML(val)
-				case "type":
-					curVar.Type = unquoteYAML(val)
-				case "required":
-					curVar.Required = parseBoolLiteral(val)
-				case "default":
-					curVar.Default = unquoteYAML(val)
-					curVar.HasDefault = true
-				case "description":
-					curVar.Description = unquoteYAML(val)
-				case "example":
-					curVar.Example = unquoteYAML(val)
-				case "pattern":
-					curVar.Pattern = unquoteYAML(val)
-				case "deprecated":
-					curVar.Deprecated = parseBoolLiteral(val)
-				case "deprecated_message":
-					curVar.DeprecatedMessage = unquoteYAML(val)
-				case "values":
-					if hasVal && strings.HasPrefix(strings.TrimSpace(val), "[") {
-						curVar.Values = parseInlineSequence(val)
-					} else if !hasVal {
-						state = stVarValues
-					}
+				if err := varProp(ln, trimmed); err != nil {
+					return fmt.Errorf("line %d: %w", ln.no, err)
 				}
 			}
 
@@ -353,74 +1124,136 @@ func parseManifest(lines []string) (*Manifest, error) {
 			// Anything else ends the list — fall through to stVarProps or stVariables.
 			state = stVarProps
 			if indent == 2 {
-				name := strings.TrimSuffix(trimmed, ":")
-				curVarName = name
-				curVar = &VarDecl{Type: "string"}
-				m.Variables[curVarName] = curVar
+				if name, ok := declName(trimmed); ok {
+					curVar, curVarName = m.varDecl(name), name
+				} else {
+					state = stVariables
+				}
 			} else if indent >= 4 {
-				key, val, hasVal := splitKV(trimmed)
-				applyVarProp(curVar, key, val, hasVal, func() { state = stVarValues })
+				if err := varProp(ln, trimmed); err != nil {
+					return fmt.Errorf("line %d: %w", ln.no, err)
+				}
 			}
 
 		case stSettings:
 			if indent >= 2 && m.Settings != nil {
-				key, val, hasVal := splitKV(trimmed)
-				switch key {
-				case "strict_guardrails":
-					m.Settings.StrictGuardrails = parseBoolLiteral(val)
-				case "hot_reload":
-					m.Settings.HotReload = parseBoolLiteral(val)
-				case "hot_reload_mode":
-					m.Settings.HotReloadMode = unquoteYAML(val)
-				case "hot_reload_poll_interval":
-					if d, err := time.ParseDuration(unquoteYAML(val)); err == nil {
-						m.Settings.HotReloadPollInterval = d
-					}
-				case "session_key_ttl":
-					if d, err := time.ParseDuration(unquoteYAML(val)); err == nil {
-						m.Settings.SessionKeyTTL = d
-					}
-				case "session_key_max_rate":
-					if n, err := strconv.Atoi(val); err == nil {
-						m.Settings.SessionKeyMaxRate = n
-					}
-				case "allowed_origins":
-					if hasVal && strings.HasPrefix(strings.TrimSpace(val), "[") {
-						m.Settings.AllowedOrigins = parseInlineSequence(val)
-					} else if !hasVal {
-						state = stSettOrigins
-					}
+				if err := setting(ln, trimmed); err != nil {
+					return fmt.Errorf("line %d: %w", ln.no, err)
 				}
 			}
 
-		case stSettOrigins:
+		case stSettList:
 			if strings.HasPrefix(trimmed, "- ") {
-				m.Settings.AllowedOrigins = append(m.Settings.AllowedOrigins, unquoteYAML(strings.TrimPrefix(trimmed, "- ")))
+				*curList = append(*curList, unquoteYAML(strings.TrimPrefix(trimmed, "- ")))
 				continue
 			}
-			// End of list.
+			// End of list — the line is the next setting.
 			state = stSettings
 			if indent >= 2 && m.Settings != nil {
-				key, val, hasVal := splitKV(trimmed)
-				if key == "allowed_origins" {
-					if hasVal && strings.HasPrefix(strings.TrimSpace(val), "[") {
-						m.Settings.AllowedOrigins = parseInlineSequence(val)
-					}
-				} else {
-					_ = key
-					_ = val
-					_ = hasVal
+				if err := setting(ln, trimmed); err != nil {
+					return fmt.Errorf("line %d: %w", ln.no, err)
+				}
+			}
+
+		case stEnvironments:
+			// indent == 2 → environment name; deeper lines form that
+			// environment's overlay, re-indented as if at the root.
+			if indent == 2 {
+				name, ok := declName(trimmed)
+				if !ok {
+					return fmt.Errorf("line %d: environments: unexpected line %q", ln.no, trimmed)
 				}
+				curEnv = name
+				if _, ok := m.environments[curEnv]; !ok {
+					m.environments[curEnv] = nil
+				}
+				continue
+			}
+			if curEnv == "" || indent < 4 {
+				return fmt.Errorf("line %d: environments: unexpected line %q", ln.no, trimmed)
 			}
+			// Comments are left for the overlay's own parse to strip, so
+			// that a block scalar in it keeps its text.
+			m.environments[curEnv] = append(m.environments[curEnv], numberedLine{text: ln.text[4:], no: ln.no})
 		}
 	}
+	if block != nil {
+		endBlock()
+	}
 
-	return m, nil
+	if m.Strict && len(unknown) > 0 {
+		return fmt.Errorf("strict manifest:\n  - %s", strings.Join(unknown, "\n  - "))
+	}
+
+	// Bounds are checked once the whole section is applied, since an
+	// overlay may move both ends of a range one line at a time.
+	names := make([]string, 0, len(m.Variables))
+	for name := range m.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := checkBounds(m.Variables[name]); err != nil {
+			return fmt.Errorf("variable %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// checkBounds reports a declaration whose lower bound exceeds its upper
+// bound, which no value could satisfy.
+func checkBounds(v *VarDecl) error {
+	if v.HasMin && v.HasMax && v.Min > v.Max {
+		return fmt.Errorf("min %g is greater than max %g", v.Min, v.Max)
+	}
+	if v.HasMinLength && v.HasMaxLength && v.MinLength > v.MaxLength {
+		return fmt.Errorf("min_length %d is greater than max_length %d", v.MinLength, v.MaxLength)
+	}
+	if v.HasMinItems && v.HasMaxItems && v.MinItems > v.MaxItems {
+		return fmt.Errorf("min_items %d is greater than max_items %d", v.MinItems, v.MaxItems)
+	}
+	return nil
+}
+
+// varDecl returns the declaration for name, creating it if absent.
+func (m *Manifest) varDecl(name string) *VarDecl {
+	if v, ok := m.Variables[name]; ok {
+		return v
+	}
+	v := &VarDecl{Type: "string"}
+	m.Variables[name] = v
+	return v
 }
 
-// applyVarProp is a helper used when re-processing a line after ending a
-// multi-line list.
-func applyVarProp(v *VarDecl, key, val string, hasVal bool, startList func()) {
+// EnvironmentNames returns the names of the environment sections declared
+// under environments:, in sorted order.
+func (m *Manifest) EnvironmentNames() []string {
+	names := make([]string, 0, len(m.environments))
+	for name := range m.environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyEnvironment merges the named environments: section into the manifest.
+// Properties set in the environment section override the base declarations;
+// properties it omits keep their base values. Variables and settings that
+// only exist in the environment section are added.
+func (m *Manifest) ApplyEnvironment(name string) error {
+	lines, ok := m.environments[name]
+	if !ok {
+		return fmt.Errorf("environment %q is not defined in the manifest (available: %v)", name, m.EnvironmentNames())
+	}
+	if err := m.parseLines(lines); err != nil {
+		return fmt.Errorf("applying environment %q: %w", name, err)
+	}
+	return nil
+}
+
+// applyVarProp sets one variable property. It reports false for a key it
+// does not recognise, and an error for a value that does not parse.
+func applyVarProp(v *VarDecl, key, val string, hasVal bool, startList func()) (bool, error) {
 	switch key {
 	case "tier":
 		v.Tier = unquoteYAML(val)
@@ -437,19 +1270,157 @@ func applyVarProp(v *VarDecl, key, val string, hasVal bool, startList func()) {
 		v.Example = unquoteYAML(val)
 	case "pattern":
 		v.Pattern = unquoteYAML(val)
+	case "min":
+		n, err := parseBound(val)
+		if err != nil {
+			return true, err
+		}
+		v.Min, v.HasMin = n, true
+	case "max":
+		n, err := parseBound(val)
+		if err != nil {
+			return true, err
+		}
+		v.Max, v.HasMax = n, true
+	case "min_length":
+		n, err := parseCount(val)
+		if err != nil {
+			return true, err
+		}
+		v.MinLength, v.HasMinLength = n, true
+	case "max_length":
+		n, err := parseCount(val)
+		if err != nil {
+			return true, err
+		}
+		v.MaxLength, v.HasMaxLength = n, true
+	case "min_items":
+		n, err := parseCount(val)
+		if err != nil {
+			return true, err
+		}
+		v.MinItems, v.HasMinItems = n, true
+	case "max_items":
+		n, err := parseCount(val)
+		if err != nil {
+			return true, err
+		}
+		v.MaxItems, v.HasMaxItems = n, true
 	case "deprecated":
 		v.Deprecated = parseBoolLiteral(val)
 	case "deprecated_message":
 		v.DeprecatedMessage = unquoteYAML(val)
+	case "reload":
+		v.BuildTime = strings.EqualFold(unquoteYAML(val), "false")
+	case "format":
+		v.Format = unquoteYAML(val)
+	case "required_if":
+		v.RequiredIf = unquoteYAML(val)
+	case "requires_if":
+		if isEmptyInlineMap(val) {
+			v.RequiresIf = nil
+		} else {
+			v.RequiresIf = parseInlineMap(val)
+		}
 	case "values":
 		if hasVal && strings.HasPrefix(strings.TrimSpace(val), "[") {
 			v.Values = parseInlineSequence(val)
 		} else if !hasVal {
+			v.Values = nil
 			if startList != nil {
 				startList()
 			}
 		}
+	default:
+		return false, nil
 	}
+	return true, nil
+}
+
+// parseBound parses a min: or max: value, which must be a finite number.
+func parseBound(val string) (float64, error) {
+	n, err := strconv.ParseFloat(unquoteYAML(val), 64)
+	if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, errors.New("must be a finite number")
+	}
+	return n, nil
+}
+
+// parseCount parses a length or item-count bound, which must be a
+// non-negative integer.
+func parseCount(val string) (int, error) {
+	n, err := strconv.Atoi(unquoteYAML(val))
+	if err != nil || n < 0 {
+		return 0, errors.New("must be a non-negative integer")
+	}
+	return n, nil
+}
+
+// setVarBlock sets a string variable property from a block scalar. It
+// reports false for a key that does not take free text.
+func setVarBlock(v *VarDecl, key, text string) bool {
+	switch key {
+	case "description":
+		v.Description = text
+	case "default":
+		v.Default, v.HasDefault = text, true
+	case "example":
+		v.Example = text
+	case "deprecated_message":
+		v.DeprecatedMessage = text
+	default:
+		return false
+	}
+	return true
+}
+
+// apply sets one settings key. For list settings written as a block
+// ("key:" followed by "- item" lines) it clears the list and returns it so
+// the caller can collect the items; otherwise it returns nil. ok is false
+// for a key it does not recognise.
+func (st *Settings) apply(key, val string, hasVal bool) (list *[]string, ok bool) {
+	switch key {
+	case "strict_guardrails":
+		st.StrictGuardrails = parseBoolLiteral(val)
+	case "hot_reload":
+		st.HotReload = parseBoolLiteral(val)
+	case "hot_reload_mode":
+		st.HotReloadMode = unquoteYAML(val)
+	case "hot_reload_poll_interval":
+		if d, err := time.ParseDuration(unquoteYAML(val)); err == nil {
+			st.HotReloadPollInterval = d
+		}
+	case "session_key_ttl":
+		if d, err := time.ParseDuration(unquoteYAML(val)); err == nil {
+			st.SessionKeyTTL = d
+		}
+	case "session_key_max_rate":
+		if n, err := strconv.Atoi(val); err == nil {
+			st.SessionKeyMaxRate = n
+		}
+	case "environment":
+		st.Environment = unquoteYAML(val)
+	case "allowed_origins":
+		list = &st.AllowedOrigins
+	case "body_rewrites":
+		list = &st.BodyRewrites
+	case "rate_limits":
+		list = &st.RateLimits
+	default:
+		return nil, false
+	}
+	if list == nil {
+		return nil, true
+	}
+	if hasVal && strings.HasPrefix(strings.TrimSpace(val), "[") {
+		*list = parseInlineSequence(val)
+		return nil, true
+	}
+	if !hasVal {
+		*list = nil
+		return list, true
+	}
+	return nil, true
 }
 
 // defaultSettings returns a Settings struct populated with spec defaults.
@@ -510,11 +1481,43 @@ func unquoteYAML(s string) string {
 	return s
 }
 
+// declName returns the name declared by a mapping-key line inside variables:
+// or environments: — "NAME:", "NAME: {}" or a bare "NAME". Lines that assign
+// a scalar ("NAME: value") are not declarations.
+func declName(trimmed string) (string, bool) {
+	key, val, hasVal := splitKV(trimmed)
+	if hasVal && !isEmptyInlineMap(val) {
+		return "", false
+	}
+	return key, key != ""
+}
+
+// isEmptyInlineMap reports whether a YAML value is the empty flow mapping
+// "{}" (whitespace inside the braces is allowed).
+func isEmptyInlineMap(val string) bool {
+	val = strings.TrimSpace(val)
+	return strings.HasPrefix(val, "{") && strings.HasSuffix(val, "}") &&
+		strings.TrimSpace(val[1:len(val)-1]) == ""
+}
+
 // parseBoolLiteral converts "true"/"false" (case-insensitive) to bool.
 func parseBoolLiteral(s string) bool {
 	return strings.EqualFold(strings.TrimSpace(s), "true")
 }
 
+// checkFlow reports an unterminated inline sequence ("[a, b") or map
+// ("{K: v") value.
+func checkFlow(val string) error {
+	val = strings.TrimSpace(val)
+	switch {
+	case strings.HasPrefix(val, "[") && !strings.HasSuffix(val, "]"):
+		return fmt.Errorf("unterminated inline sequence %q", val)
+	case strings.HasPrefix(val, "{") && !strings.HasSuffix(val, "}"):
+		return fmt.Errorf("unterminated inline map %q", val)
+	}
+	return nil
+}
+
 // parseInlineSequence parses ["v1", "v2", "v3"] into a string slice.
 // It handles quoted and unquoted items separated by commas.
 func parseInlineSequence(s string) []string {
@@ -532,6 +1535,49 @@ func parseInlineSequence(s string) []string {
 	return items
 }
 
+// parseInlineMap parses {K1: "v1", K2: v2} into a map. Items without a
+// colon are ignored.
+func parseInlineMap(s string) map[string]string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "{")
+	s = strings.TrimSuffix(s, "}")
+
+	m := make(map[string]string)
+	for _, part := range splitFlow(s) {
+		key, val, ok := strings.Cut(part, ":")
+		if !ok {
+			continue
+		}
+		key = unquoteYAML(key)
+		if key != "" {
+			m[key] = unquoteYAML(val)
+		}
+	}
+	return m
+}
+
+// splitFlow splits the inside of a flow collection on commas that are not
+// inside a quoted scalar, so {A: "x,y"} yields a single item. Quotes are
+// tracked the same way stripComment tracks them.
+func splitFlow(s string) []string {
+	var parts []string
+	inSingle := false
+	inDouble := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '\'' && !inDouble:
+			inSingle = !inSingle
+		case ch == '"' && !inSingle:
+			inDouble = !inDouble
+		case ch == ',' && !inSingle && !inDouble:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
 // stripComment removes a trailing YAML comment from a line, respecting quoted
 // strings so that #-characters inside quotes are preserved.
 func stripComment(line string) string {
@@ -1,6 +1,15 @@
 package manifest
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -190,6 +199,82 @@ variables:
 	}
 }
 
+func TestParseNumberBounds(t *testing.T) {
+	lines := strings.Split(`version: "0.1.0"
+variables:
+  MAX_UPLOAD_MB:
+    tier: public
+    type: number
+    min: 0
+    max: 100
+  RATIO:
+    tier: public
+    type: number
+    max: 0.5
+`, "\n")
+
+	m, err := parseManifest(lines)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	upload := m.Variables["MAX_UPLOAD_MB"]
+	if !upload.HasMin || upload.Min != 0 || !upload.HasMax || upload.Max != 100 {
+		t.Errorf("MAX_UPLOAD_MB bounds: got %+v", upload)
+	}
+	ratio := m.Variables["RATIO"]
+	if ratio.HasMin || !ratio.HasMax || ratio.Max != 0.5 {
+		t.Errorf("RATIO bounds: got %+v", ratio)
+	}
+
+	for _, tc := range []struct{ bounds, want string }{
+		{"min: abc", `line 5: invalid min "abc" for variable "X": must be a finite number`},
+		{"max: Inf", `line 5: invalid max "Inf" for variable "X": must be a finite number`},
+		{"min: 10\n    max: 1", `variable "X": min 10 is greater than max 1`},
+	} {
+		_, err := parseManifest(strings.Split("version: \"0.1.0\"\nvariables:\n  X:\n    type: number\n    "+tc.bounds+"\n", "\n"))
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%q: got %v, want %q", tc.bounds, err, tc.want)
+		}
+	}
+}
+
+func TestParseLengthBounds(t *testing.T) {
+	lines := strings.Split(`version: "0.1.0"
+variables:
+  REGION:
+    tier: public
+    min_length: 2
+    max_length: 2
+  NOTE:
+    tier: public
+    max_length: 0
+`, "\n")
+
+	m, err := parseManifest(lines)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	region := m.Variables["REGION"]
+	if !region.HasMinLength || region.MinLength != 2 || !region.HasMaxLength || region.MaxLength != 2 {
+		t.Errorf("REGION length bounds: got %+v", region)
+	}
+	note := m.Variables["NOTE"]
+	if note.HasMinLength || !note.HasMaxLength || note.MaxLength != 0 {
+		t.Errorf("expected an explicit max_length: 0 on NOTE, got %+v", note)
+	}
+
+	for _, tc := range []struct{ bounds, want string }{
+		{"min_length: two", `line 4: invalid min_length "two" for variable "X": must be a non-negative integer`},
+		{"max_length: -1", `line 4: invalid max_length "-1" for variable "X": must be a non-negative integer`},
+		{"min_length: 5\n    max_length: 2", `variable "X": min_length 5 is greater than max_length 2`},
+	} {
+		_, err := parseManifest(strings.Split("version: \"0.1.0\"\nvariables:\n  X:\n    "+tc.bounds+"\n", "\n"))
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%q: got %v, want %q", tc.bounds, err, tc.want)
+		}
+	}
+}
+
 func TestParseDeprecated(t *testing.T) {
 	lines := strings.Split(`version: "0.1.0"
 variables:
@@ -260,6 +345,159 @@ settings:
 	}
 }
 
+func TestParseInlineEmptyMaps(t *testing.T) {
+	lines := strings.Split(`version: "0.1.0"
+variables:
+  FLAG: {}
+  API_URL:
+    tier: public
+    requires_if: {}
+  EMPTY_SPACED: { }
+  NOT_A_DECL: value
+    tier: server
+settings: {}
+environments:
+  staging: {}
+  production:
+    variables:
+      API_URL: {}
+`, "\n")
+
+	m, err := parseManifest(lines)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	flag, ok := m.Variables["FLAG"]
+	if !ok {
+		t.Fatal("FLAG: {} should declare a variable")
+	}
+	if flag.Type != "string" || flag.Tier != "" {
+		t.Errorf("FLAG: expected defaults, got %+v", flag)
+	}
+	if _, ok := m.Variables["EMPTY_SPACED"]; !ok {
+		t.Error("EMPTY_SPACED: { } should declare a variable")
+	}
+	for name := range m.Variables {
+		if strings.Contains(name, ":") || strings.Contains(name, "{") {
+			t.Errorf("malformed variable name %q", name)
+		}
+	}
+	if _, ok := m.Variables["NOT_A_DECL"]; ok {
+		t.Error("NOT_A_DECL: value is not a declaration")
+	}
+	if len(m.Variables) != 3 {
+		t.Errorf("expected 3 variables, got %d", len(m.Variables))
+	}
+
+	api := m.Variables["API_URL"]
+	if api.Tier != "public" {
+		t.Errorf("API_URL tier: got %q", api.Tier)
+	}
+	if api.RequiresIf != nil {
+		t.Errorf("requires_if: {} should be nil, got %v", api.RequiresIf)
+	}
+
+	if m.Settings == nil || m.Settings.HotReloadMode != "signal" {
+		t.Errorf("settings: {} should yield default settings, got %+v", m.Settings)
+	}
+
+	if got := m.EnvironmentNames(); len(got) != 2 || got[0] != "production" || got[1] != "staging" {
+		t.Fatalf("environments: got %v", got)
+	}
+	if err := m.ApplyEnvironment("staging"); err != nil {
+		t.Fatalf("ApplyEnvironment(staging): %v", err)
+	}
+	if err := m.ApplyEnvironment("production"); err != nil {
+		t.Fatalf("ApplyEnvironment(production): %v", err)
+	}
+	if m.Variables["API_URL"].Tier != "public" {
+		t.Error("API_URL: {} in an environment should not reset the base declaration")
+	}
+}
+
+func TestParseInlineEmptyRootSections(t *testing.T) {
+	lines := strings.Split(`version: "0.1.0"
+variables: {}
+environments: {}
+settings: {}
+`, "\n")
+
+	m, err := parseManifest(lines)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Variables) != 0 {
+		t.Errorf("expected no variables, got %v", m.Variables)
+	}
+	if len(m.EnvironmentNames()) != 0 {
+		t.Errorf("expected no environments, got %v", m.EnvironmentNames())
+	}
+	if m.Settings == nil {
+		t.Error("expected default settings")
+	}
+}
+
+func TestParseReload(t *testing.T) {
+	lines := strings.Split(`version: "0.1.0"
+variables:
+  BUILD_ID:
+    tier: public
+    reload: false
+  API_URL:
+    tier: public
+    reload: true
+  THEME:
+    tier: public
+`, "\n")
+
+	m, err := parseManifest(lines)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !m.IsBuildTime("BUILD_ID") {
+		t.Error("BUILD_ID: reload: false should be build-time")
+	}
+	if m.IsBuildTime("API_URL") || m.IsBuildTime("THEME") || m.IsBuildTime("UNDECLARED") {
+		t.Error("only reload: false variables should be build-time")
+	}
+	var nilManifest *Manifest
+	if nilManifest.IsBuildTime("BUILD_ID") {
+		t.Error("nil manifest should report no build-time variables")
+	}
+}
+
+func TestParseSettingsLists(t *testing.T) {
+	lines := strings.Split(`version: "0.1.0"
+variables: {}
+settings:
+  body_rewrites:
+    - "http://app.internal.svc => https://app.example.com"
+    - "re:http://10\.0\.\d+\.\d+ => https://app.example.com"
+  allowed_origins:
+    - https://app.example.com
+  rate_limits: ["changes=30", "health=120"]
+  hot_reload: true
+`, "\n")
+
+	m, err := parseManifest(lines)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Settings.BodyRewrites) != 2 || m.Settings.BodyRewrites[0] != "http://app.internal.svc => https://app.example.com" {
+		t.Errorf("body_rewrites: got %q", m.Settings.BodyRewrites)
+	}
+	if len(m.Settings.AllowedOrigins) != 1 {
+		t.Errorf("allowed_origins: got %q", m.Settings.AllowedOrigins)
+	}
+	if len(m.Settings.RateLimits) != 2 || m.Settings.RateLimits[0] != "changes=30" {
+		t.Errorf("rate_limits: got %q", m.Settings.RateLimits)
+	}
+	if !m.Settings.HotReload {
+		t.Error("a setting following a block list should still be applied")
+	}
+}
+
 func TestParseAllowedOriginsInline(t *testing.T) {
 	lines := strings.Split(`version: "0.1.0"
 variables: {}
@@ -354,6 +592,139 @@ func TestValidateTypeNumber(t *testing.T) {
 	}
 }
 
+func TestValidateNumberBounds(t *testing.T) {
+	m := &Manifest{
+		Variables: map[string]*VarDecl{
+			"MAX_UPLOAD_MB": {Tier: "public", Type: "number", Required: true, Min: 1, HasMin: true, Max: 100, HasMax: true},
+		},
+	}
+	err := m.Validate(map[string]string{"MAX_UPLOAD_MB": "250"}, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), `variable "MAX_UPLOAD_MB" must be <= 100, got 250`) {
+		t.Errorf("expected max violation, got %v", err)
+	}
+	err = m.Validate(map[string]string{"MAX_UPLOAD_MB": "0.5"}, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), `variable "MAX_UPLOAD_MB" must be >= 1, got 0.5`) {
+		t.Errorf("expected min violation, got %v", err)
+	}
+	// Bounds compare numerically: "9" < "10" even though "9" > "10" lexically.
+	for _, good := range []string{"1", "9", "100", "1e2"} {
+		if err := m.Validate(map[string]string{"MAX_UPLOAD_MB": good}, nil, nil, nil); err != nil {
+			t.Errorf("unexpected error for %q: %v", good, err)
+		}
+	}
+
+	unbounded := &Manifest{
+		Variables: map[string]*VarDecl{
+			"OFFSET": {Tier: "public", Type: "number", Required: true},
+		},
+	}
+	if err := unbounded.Validate(map[string]string{"OFFSET": "-1e9"}, nil, nil, nil); err != nil {
+		t.Errorf("expected no bound without min/max, got %v", err)
+	}
+
+	// NaN slips past any comparison and ±Inf is not a usable value; both
+	// are rejected whether or not bounds are declared.
+	for _, bad := range []string{"NaN", "nan", "Inf", "+Inf", "-Inf", "infinity"} {
+		for _, mm := range []*Manifest{m, unbounded} {
+			vars := map[string]string{"MAX_UPLOAD_MB": bad, "OFFSET": bad}
+			if err := mm.Validate(vars, nil, nil, nil); err == nil || !strings.Contains(err.Error(), "must be a number") {
+				t.Errorf("expected %q to be rejected, got %v", bad, err)
+			}
+		}
+	}
+}
+
+func TestValidateTypeJSON(t *testing.T) {
+	m := &Manifest{
+		Variables: map[string]*VarDecl{
+			"FEATURE_CONFIG": {Tier: "public", Type: "json", Required: true},
+		},
+	}
+	err := m.Validate(map[string]string{"FEATURE_CONFIG": `{"dark_mode": true`}, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), `variable "FEATURE_CONFIG" must be valid JSON: `) {
+		t.Errorf("expected JSON violation, got %v", err)
+	}
+	for _, good := range []string{`{"dark_mode": true}`, `["a", "b"]`, `[]`, `"text"`, `42`} {
+		if err := m.Validate(map[string]string{"FEATURE_CONFIG": good}, nil, nil, nil); err != nil {
+			t.Errorf("unexpected error for %s: %v", good, err)
+		}
+	}
+	if err := m.Validate(map[string]string{"FEATURE_CONFIG": ""}, nil, nil, nil); err == nil {
+		t.Error("expected an empty value without a default to be invalid JSON")
+	}
+
+	withDefault := &Manifest{
+		Variables: map[string]*VarDecl{
+			"LIMITS": {Tier: "public", Type: "json", Default: `{"max": 3}`, HasDefault: true},
+			"EXTRAS": {Tier: "public", Type: "json", Default: "", HasDefault: true},
+			"BROKEN": {Tier: "public", Type: "json", Default: `{`, HasDefault: true},
+		},
+	}
+	if err := withDefault.Validate(map[string]string{"EXTRAS": ""}, nil, nil, nil); err != nil {
+		t.Errorf("expected an empty value with an empty default to validate, got %v", err)
+	}
+	// A set-but-empty value is served as "", not as the default, so it is
+	// what gets checked.
+	if err := withDefault.Validate(map[string]string{"LIMITS": ""}, nil, nil, nil); err == nil || !strings.Contains(err.Error(), `"LIMITS" must be valid JSON: it is set but empty`) {
+		t.Errorf("expected the empty LIMITS value to be invalid, got %v", err)
+	}
+	if err := withDefault.Validate(map[string]string{"BROKEN": `{`}, nil, nil, nil); err == nil || !strings.Contains(err.Error(), `"BROKEN"`) {
+		t.Errorf("expected an invalid value to be reported, got %v", err)
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		good   []string
+		bad    []string
+	}{
+		{"email", []string{"ops@example.com", "a.b+tag@mail.example.co.uk"}, []string{"ops", "ops@example", "a b@example.com", "a@b@example.com", ""}},
+		{"uuid", []string{"123e4567-e89b-12d3-a456-426614174000", "123E4567-E89B-12D3-A456-426614174000"}, []string{"123e4567e89b12d3a456426614174000", "123e4567-e89b-12d3-a456-42661417400g"}},
+		{"ipv4", []string{"10.0.0.1", "255.255.255.255"}, []string{"256.0.0.1", "::1", "::ffff:10.0.0.1", "10.0.0"}},
+		{"date-time", []string{"2026-01-02T15:04:05Z", "2026-01-02T15:04:05.5+02:00"}, []string{"2026-01-02", "2026-01-02 15:04:05"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			m := &Manifest{
+				Variables: map[string]*VarDecl{"X": {Tier: "public", Format: tt.format}},
+			}
+			for _, v := range tt.good {
+				if err := m.Validate(map[string]string{"X": v}, nil, nil, nil); err != nil {
+					t.Errorf("unexpected error for %q: %v", v, err)
+				}
+			}
+			want := `variable "X" is not a valid ` + tt.format
+			for _, v := range tt.bad {
+				err := m.Validate(map[string]string{"X": v}, nil, nil, nil)
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("expected %q for %q, got %v", want, v, err)
+				}
+			}
+		})
+	}
+
+	m, err := parseManifest(strings.Split(`version: "0.1.0"
+variables:
+  SUPPORT_EMAIL:
+    tier: public
+    format: email
+  REGION_ID:
+    tier: public
+    format: mac-address
+`, "\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := m.Variables["SUPPORT_EMAIL"].Format; got != "email" {
+		t.Errorf("format: got %q", got)
+	}
+	if err := m.Validate(map[string]string{"SUPPORT_EMAIL": "help@example.com", "REGION_ID": "anything"}, nil, nil, nil); err != nil {
+		t.Errorf("expected an unknown format to be ignored, got %v", err)
+	}
+}
+
 func TestValidateTypeBoolean(t *testing.T) {
 	m := &Manifest{
 		Variables: map[string]*VarDecl{
@@ -410,6 +781,97 @@ func TestValidatePattern(t *testing.T) {
 	}
 }
 
+func TestValidateLengthBounds(t *testing.T) {
+	m := &Manifest{
+		Variables: map[string]*VarDecl{
+			"REGION": {Tier: "public", Type: "string", Required: true, MaxLength: 2, HasMaxLength: true, MinLength: 2, HasMinLength: true, Pattern: `[a-z]+`},
+			"TAGS":   {Tier: "public", Type: "csv", MaxLength: 5, HasMaxLength: true},
+		},
+	}
+	err := m.Validate(map[string]string{"REGION": "eu-we"}, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), `variable "REGION" length 5 is greater than max_length 2`) {
+		t.Errorf("expected max_length violation, got %v", err)
+	}
+	err = m.Validate(map[string]string{"REGION": "e"}, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), `variable "REGION" length 1 is less than min_length 2`) {
+		t.Errorf("expected min_length violation, got %v", err)
+	}
+	// Within the length bounds, the pattern still applies.
+	if err := m.Validate(map[string]string{"REGION": "EU"}, nil, nil, nil); err == nil {
+		t.Error("expected pattern mismatch for a value of valid length")
+	}
+	if err := m.Validate(map[string]string{"REGION": "eu"}, nil, nil, nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// Length counts characters, not bytes.
+	if err := m.Validate(map[string]string{"REGION": "eu", "TAGS": "é,ü,ö"}, nil, nil, nil); err != nil {
+		t.Errorf("unexpected error for a 5-character csv value: %v", err)
+	}
+	if err := m.Validate(map[string]string{"REGION": "eu", "TAGS": "a,b,cd"}, nil, nil, nil); err == nil {
+		t.Error("expected max_length violation for the csv value")
+	}
+}
+
+func TestValidateCSVItems(t *testing.T) {
+	lines := strings.Split(`version: "0.1.0"
+variables:
+  FEATURE_FLAGS:
+    tier: public
+    type: csv
+    min_items: 1
+    max_items: 3
+  TAGS:
+    tier: public
+    type: csv
+    max_items: 2
+`, "\n")
+	m, err := parseManifest(lines)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	flags := m.Variables["FEATURE_FLAGS"]
+	if !flags.HasMinItems || flags.MinItems != 1 || !flags.HasMaxItems || flags.MaxItems != 3 {
+		t.Fatalf("FEATURE_FLAGS item bounds: got %+v", flags)
+	}
+
+	tests := []struct {
+		value   string
+		wantErr string
+	}{
+		{"dark-mode", ""},
+		{" a , b , c ", ""},
+		{"a,b,c,,", ""}, // Empty trailing items are dropped.
+		{"a,b,c,d", `variable "FEATURE_FLAGS" has 4 items, more than max_items 3`},
+		{"", `variable "FEATURE_FLAGS" has 0 items, fewer than min_items 1`},
+		{" , ", `variable "FEATURE_FLAGS" has 0 items, fewer than min_items 1`},
+	}
+	for _, tt := range tests {
+		err := m.Validate(map[string]string{"FEATURE_FLAGS": tt.value}, nil, nil, nil)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%q: unexpected error: %v", tt.value, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%q: expected %q, got %v", tt.value, tt.wantErr, err)
+		}
+	}
+
+	// Optional without min_items: an empty value is zero items and passes.
+	if err := m.Validate(map[string]string{"FEATURE_FLAGS": "a", "TAGS": ""}, nil, nil, nil); err != nil {
+		t.Errorf("unexpected error for an empty optional csv: %v", err)
+	}
+
+	for _, tc := range []struct{ bounds, want string }{
+		{"min_items: one", `line 5: invalid min_items "one" for variable "X": must be a non-negative integer`},
+		{"max_items: -2", `line 5: invalid max_items "-2" for variable "X": must be a non-negative integer`},
+		{"min_items: 3\n    max_items: 1", `variable "X": min_items 3 is greater than max_items 1`},
+	} {
+		_, err := parseManifest(strings.Split("version: \"0.1.0\"\nvariables:\n  X:\n    type: csv\n    "+tc.bounds+"\n", "\n"))
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("%q: got %v, want %q", tc.bounds, err, tc.want)
+		}
+	}
+}
+
 func TestValidateDeprecatedWarning(t *testing.T) {
 	m := &Manifest{
 		Variables: map[string]*VarDecl{
@@ -534,3 +996,1023 @@ func TestStripComment(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadRejectsOverlongLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".rep.yaml")
+	content := "version: \"0.1.0\"\nvariables:\n  MODE:\n    type: enum\n    values: [" +
+		strings.Repeat(`"x",`, 200) + "]\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadWithLimits(path, Limits{MaxLineLength: 256})
+	if err == nil {
+		t.Fatal("expected error for overlong line")
+	}
+	if !strings.Contains(err.Error(), "line 5 exceeds the maximum line length of 256 bytes") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
+
+func TestLoadRejectsOversizeFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".rep.yaml")
+	content := "version: \"0.1.0\"\n" + strings.Repeat("# padding\n", 100)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadWithLimits(path, Limits{MaxFileSize: 128})
+	if err == nil {
+		t.Fatal("expected error for oversize manifest")
+	}
+	if !strings.Contains(err.Error(), "exceeding the maximum size of 128 bytes") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
+
+func TestLoadReportsParseErrorLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".rep.yaml")
+	content := `version: "0.1.0"
+variables:
+  API_URL:
+    tier: public
+    type: url
+
+  MODE:
+    tier: public
+    type: enum
+    values: [
+      "dev", "prod"]
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("expected error for unterminated inline sequence")
+	}
+	want := fmt.Sprintf("parsing manifest %q: line 10: unterminated inline sequence", path)
+	if !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("got %q, want prefix %q", err, want)
+	}
+}
+
+func TestLoadExtends(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("common.rep.yaml", `version: "0.1.0"
+variables:
+  LOG_LEVEL:
+    tier: server
+    default: info
+settings:
+  session_key_ttl: "60s"
+`)
+	write("shared/base.rep.yaml", `version: "0.1.0"
+extends: ../common.rep.yaml
+variables:
+  API_URL:
+    tier: public
+    type: url
+    required: true
+  THEME:
+    tier: public
+    default: light
+`)
+	app := write("apps/web/.rep.yaml", `version: "0.1.0"
+extends: "../../shared/base.rep.yaml"
+variables:
+  THEME:
+    default: dark
+  CHECKOUT_URL:
+    tier: public
+    type: url
+settings:
+  hot_reload: true
+`)
+
+	m, err := Load(app)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Variables) != 4 {
+		t.Errorf("expected 4 variables across all levels, got %d", len(m.Variables))
+	}
+	if v := m.Variables["LOG_LEVEL"]; v == nil || v.Default != "info" {
+		t.Errorf("expected LOG_LEVEL from the grandparent, got %+v", v)
+	}
+	if v := m.Variables["API_URL"]; v == nil || !v.Required || v.Type != "url" {
+		t.Errorf("expected API_URL from the parent, got %+v", v)
+	}
+	// Overridden properties change; the others keep the base values.
+	if v := m.Variables["THEME"]; v.Default != "dark" || v.Tier != "public" {
+		t.Errorf("expected THEME default overridden and tier kept, got %+v", v)
+	}
+	if !m.Settings.HotReload || m.Settings.SessionKeyTTL != 60*time.Second {
+		t.Errorf("expected settings merged, got %+v", m.Settings)
+	}
+
+	// The digest covers the whole chain, so a pin breaks when a base changes.
+	var chain []byte
+	for _, name := range []string{"apps/web/.rep.yaml", "shared/base.rep.yaml", "common.rep.yaml"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, data...)
+	}
+	sum := sha256.Sum256(chain)
+	if m.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected SHA256 of the extends chain, got %q", m.SHA256)
+	}
+	write("common.rep.yaml", "version: \"0.1.0\"\nvariables:\n  LOG_LEVEL:\n    tier: public\n")
+	if changed, err := Load(app); err != nil || changed.SHA256 == m.SHA256 {
+		t.Errorf("expected a changed base to change the digest (err %v)", err)
+	}
+
+	// Every manifest in the chain must have a compatible version.
+	write("common.rep.yaml", "version: \"1.0.0\"\n")
+	if _, err := Load(app); err == nil || !strings.Contains(err.Error(), "common.rep.yaml") || !strings.Contains(err.Error(), `manifest version "1.0.0" is not supported`) {
+		t.Errorf("expected the base's incompatible version to be rejected, got %v", err)
+	}
+}
+
+func TestLoadExtendsCycle(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.rep.yaml")
+	b := filepath.Join(dir, "b.rep.yaml")
+	if err := os.WriteFile(a, []byte("version: \"0.1.0\"\nextends: b.rep.yaml\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("version: \"0.1.0\"\nextends: ./a.rep.yaml\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(a)
+	want := fmt.Sprintf("manifest extends cycle: %s -> %s -> %s", a, b, a)
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+}
+
+const sourceManifest = `version: "0.1.0"
+variables:
+  API_URL:
+    tier: public
+    type: url
+`
+
+func TestLoadFromStdin(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(sourceManifest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = stdin }()
+
+	m, err := Load("-")
+	if err != nil {
+		t.Fatalf("Load(-): %v", err)
+	}
+	if m.Variables["API_URL"] == nil || m.Variables["API_URL"].Type != "url" {
+		t.Errorf("expected API_URL from stdin, got %v", m.Variables)
+	}
+	sum := sha256.Sum256([]byte(sourceManifest))
+	if m.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected SHA256 of the input, got %q", m.SHA256)
+	}
+}
+
+func TestLoadFromURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.rep.yaml":
+			_, _ = w.Write([]byte(sourceManifest))
+		case "/large.yaml":
+			_, _ = w.Write([]byte(strings.Repeat("# padding\n", 100)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	m, err := Load(srv.URL + "/.rep.yaml")
+	if err != nil {
+		t.Fatalf("Load(url): %v", err)
+	}
+	if m.Variables["API_URL"] == nil {
+		t.Errorf("expected API_URL from the URL, got %v", m.Variables)
+	}
+	if m.SHA256 == "" {
+		t.Error("expected SHA256 to be set")
+	}
+
+	if _, err := Load(srv.URL + "/missing.yaml"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected an error naming the 404 status, got %v", err)
+	}
+	if _, err := LoadWithLimits(srv.URL+"/large.yaml", Limits{MaxFileSize: 128}); err == nil || !strings.Contains(err.Error(), "maximum size of 128 bytes") {
+		t.Errorf("expected an oversize error, got %v", err)
+	}
+}
+
+func TestApplyEnvironment_OverridesBase(t *testing.T) {
+	lines := strings.Split(`version: "0.1.0"
+variables:
+  API_URL:
+    tier: public
+    type: url
+    required: false
+    default: "http://localhost:3000"
+  ENV_NAME:
+    tier: public
+    type: enum
+    values: ["development", "production"]
+settings:
+  strict_guardrails: false
+  session_key_max_rate: 10
+environments:
+  production:
+    variables:
+      API_URL:
+        default: "https://api.example.com"
+      SENTRY_DSN:
+        tier: sensitive
+        type: url
+        required: true
+    settings:
+      strict_guardrails: true
+  staging:
+    variables:
+      API_URL:
+        default: "https://api.staging.example.com"
+`, "\n")
+
+	m, err := parseManifest(lines)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := m.EnvironmentNames(); len(got) != 2 || got[0] != "production" || got[1] != "staging" {
+		t.Fatalf("EnvironmentNames: got %v", got)
+	}
+
+	// Before applying, the base values are in effect.
+	if m.Variables["API_URL"].Default != "http://localhost:3000" {
+		t.Errorf("base default: got %q", m.Variables["API_URL"].Default)
+	}
+
+	if err := m.ApplyEnvironment("production"); err != nil {
+		t.Fatalf("ApplyEnvironment: %v", err)
+	}
+
+	apiURL := m.Variables["API_URL"]
+	if apiURL.Default != "https://api.example.com" {
+		t.Errorf("default: got %q, want production override", apiURL.Default)
+	}
+	// Properties not overridden keep their base values.
+	if apiURL.Tier != "public" || apiURL.Type != "url" {
+		t.Errorf("base properties lost: tier=%q type=%q", apiURL.Tier, apiURL.Type)
+	}
+	if len(m.Variables["ENV_NAME"].Values) != 2 {
+		t.Errorf("untouched variable should keep values, got %v", m.Variables["ENV_NAME"].Values)
+	}
+
+	dsn, ok := m.Variables["SENTRY_DSN"]
+	if !ok {
+		t.Fatal("environment-only variable SENTRY_DSN not added")
+	}
+	if dsn.Tier != "sensitive" || !dsn.Required {
+		t.Errorf("SENTRY_DSN: got tier=%q required=%v", dsn.Tier, dsn.Required)
+	}
+
+	if !m.Settings.StrictGuardrails {
+		t.Error("strict_guardrails: expected production override true")
+	}
+	if m.Settings.SessionKeyMaxRate != 10 {
+		t.Errorf("session_key_max_rate: got %d, want base 10", m.Settings.SessionKeyMaxRate)
+	}
+}
+
+func TestParseStrictUnknownKeys(t *testing.T) {
+	doc := `version: "0.1.0"
+%s
+variables:
+  API_URL:
+    tier: public
+    requird: true
+  REGIONS:
+    tier: public
+    values:
+      - eu
+      - us
+    descripton: "Regions"
+settings:
+  hot_reloud: true
+  allowed_origins:
+    - https://app.example.com
+environments:
+  production:
+    variables:
+      API_URL:
+        tyep: url
+`
+
+	// Lenient by default: unknown keys are ignored.
+	m, err := parseManifest(strings.Split(strings.Replace(doc, "%s", "", 1), "\n"))
+	if err != nil {
+		t.Fatalf("unexpected error without strict: %v", err)
+	}
+	if m.Variables["API_URL"].Required {
+		t.Error("expected the typoed key to be ignored")
+	}
+	if err := m.ApplyEnvironment("production"); err != nil {
+		t.Fatalf("unexpected error applying environment without strict: %v", err)
+	}
+
+	_, err = parseManifest(strings.Split(strings.Replace(doc, "%s", "strict: true", 1), "\n"))
+	if err == nil {
+		t.Fatal("expected unknown keys to be rejected under strict: true")
+	}
+	for _, want := range []string{
+		`unknown key "requird" under variable "API_URL" (line 6)`,
+		`unknown key "descripton" under variable "REGIONS" (line 12)`,
+		`unknown key "hot_reloud" under settings (line 14)`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in error, got:\n%v", want, err)
+		}
+	}
+
+	// Environment overlays report the line in the original file.
+	m, err = parseManifest(strings.Split(`strict: true
+variables:
+  API_URL:
+    tier: public
+environments:
+  production:
+    variables:
+      API_URL:
+        tyep: url
+`, "\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = m.ApplyEnvironment("production")
+	if err == nil || !strings.Contains(err.Error(), `unknown key "tyep" under variable "API_URL" (line 9)`) {
+		t.Errorf("expected the overlay's unknown key reported, got %v", err)
+	}
+}
+
+func TestParseBlockScalar(t *testing.T) {
+	m, err := parseManifest(strings.Split(`version: "0.1.0"
+variables:
+  API_URL:
+    description: |
+      Base URL of the API.
+      Must not end with a slash.
+    tier: public
+  FEATURE_FLAGS:
+    tier: public
+    type: json
+    default: |-
+      {
+        "beta": false # not a comment
+      }
+
+  THEME:
+    tier: public
+`, "\n"))
+	if err != nil {
+		t.Fatalf("parseManifest: %v", err)
+	}
+
+	api := m.Variables["API_URL"]
+	if want := "Base URL of the API.\nMust not end with a slash.\n"; api.Description != want {
+		t.Errorf("description: got %q, want %q", api.Description, want)
+	}
+	if api.Tier != "public" {
+		t.Errorf("property after the block: got tier %q", api.Tier)
+	}
+
+	// Lines are de-indented relative to the first, keeping deeper nesting,
+	// and |- drops the final newline.
+	flags := m.Variables["FEATURE_FLAGS"]
+	if want := "{\n  \"beta\": false # not a comment\n}"; !flags.HasDefault || flags.Default != want {
+		t.Errorf("default: got %q, want %q", flags.Default, want)
+	}
+	if _, ok := m.Variables["THEME"]; !ok {
+		t.Error("variable after the block was not parsed")
+	}
+
+	_, err = parseManifest(strings.Split(`variables:
+  API_URL:
+    description: |
+        first
+      second
+`, "\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 5: block scalar line is indented less than its first line") {
+		t.Errorf("expected an indentation error, got %v", err)
+	}
+}
+
+func TestParseRejectsTabIndentation(t *testing.T) {
+	for _, tt := range []struct {
+		doc  string
+		line int
+	}{
+		{"variables:\n\tAPI_URL:\n\t\ttier: public\n", 2},
+		{"variables:\n  API_URL:\n  \ttier: public\n", 3},
+		{"variables:\n  API_URL:\n    tier: public\n\t\ttype: url\n", 4},
+	} {
+		_, err := parseManifest(strings.Split(tt.doc, "\n"))
+		want := fmt.Sprintf("line %d: tabs are not allowed for indentation", tt.line)
+		if err == nil || err.Error() != want {
+			t.Errorf("%q: expected %q, got %v", tt.doc, want, err)
+		}
+	}
+
+	// Tabs inside values and blank lines are fine.
+	m, err := parseManifest(strings.Split("variables:\n  API_URL:\n    tier: public\n\t\n    description: \"a\tb\"\n", "\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := m.Variables["API_URL"].Description; got != "a\tb" {
+		t.Errorf("description: got %q", got)
+	}
+}
+
+func TestParseInvalidTierAndType(t *testing.T) {
+	_, err := parseManifest(strings.Split(`version: "0.1.0"
+variables:
+  API_URL:
+    tier: piblic
+    type: url
+`, "\n"))
+	if err == nil || !strings.Contains(err.Error(), `line 4: invalid tier "piblic" for variable "API_URL"`) {
+		t.Errorf("expected an invalid tier error, got %v", err)
+	}
+
+	doc := `version: "0.1.0"
+%s
+variables:
+  API_URL:
+    tier: public
+    type: urll
+`
+	// Unknown types stay allowed for forward compatibility...
+	m, err := parseManifest(strings.Split(strings.Replace(doc, "%s", "", 1), "\n"))
+	if err != nil {
+		t.Fatalf("unexpected error without strict: %v", err)
+	}
+	if m.Variables["API_URL"].Type != "urll" {
+		t.Errorf("type: got %q", m.Variables["API_URL"].Type)
+	}
+	// ...unless the manifest is strict.
+	_, err = parseManifest(strings.Split(strings.Replace(doc, "%s", "strict: true", 1), "\n"))
+	if err == nil || !strings.Contains(err.Error(), `unknown type "urll" for variable "API_URL" (line 6)`) {
+		t.Errorf("expected an unknown type error under strict, got %v", err)
+	}
+}
+
+func TestApplyEnvironment_Unknown(t *testing.T) {
+	m, err := parseManifest(strings.Split(`version: "0.1.0"
+environments:
+  production:
+    settings:
+      hot_reload: true
+`, "\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = m.ApplyEnvironment("qa")
+	if err == nil {
+		t.Fatal("expected error for undefined environment")
+	}
+	if !strings.Contains(err.Error(), `"qa"`) {
+		t.Errorf("error should name the environment: %v", err)
+	}
+}
+
+func TestLintRequiredWithDefault(t *testing.T) {
+	m, err := parseManifest(strings.Split(`version: "0.1.0"
+variables:
+  API_URL:
+    tier: public
+    required: true
+    default: "https://api.example.com"
+  APP_VERSION:
+    tier: public
+    required: false
+    default: "0.0.0"
+`, "\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	findings := m.Lint()
+	if len(findings) != 1 {
+		t.Fatalf("expected 1 lint finding, got %d: %v", len(findings), findings)
+	}
+	if !strings.Contains(findings[0], `"API_URL"`) || !strings.Contains(findings[0], "default") {
+		t.Errorf("unexpected finding: %s", findings[0])
+	}
+}
+
+func TestLintRequiredIfWithRequiresIf(t *testing.T) {
+	m, err := parseManifest(strings.Split(`version: "0.1.0"
+variables:
+  SMTP_TLS_CERT:
+    tier: server
+    required_if: SMTP_MODE=tls
+    requires_if: {SMTP_MODE: "tls"}
+  FEATURE_X_URL:
+    tier: public
+    requires_if: {FEATURE_X: "true"}
+`, "\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	findings := m.Lint()
+	if len(findings) != 1 {
+		t.Fatalf("expected 1 lint finding, got %d: %v", len(findings), findings)
+	}
+	if !strings.Contains(findings[0], `"SMTP_TLS_CERT"`) || !strings.Contains(findings[0], "requires_if") {
+		t.Errorf("unexpected finding: %s", findings[0])
+	}
+}
+
+func TestLintClean(t *testing.T) {
+	m, err := Load("../../../examples/.rep.yaml")
+	if err != nil {
+		t.Fatalf("failed to load example manifest: %v", err)
+	}
+	if findings := m.Lint(); len(findings) != 0 {
+		t.Errorf("example manifest should lint clean, got %v", findings)
+	}
+}
+
+func TestLoadVersionCompatibility(t *testing.T) {
+	tests := []struct {
+		version  string
+		wantErr  string
+		wantLint string
+	}{
+		{version: "0.1.0"},
+		{version: "0.1.7"},
+		{version: "0.0.9", wantLint: `manifest version "0.0.9" is older than the supported protocol version`},
+		{version: "0.2.0", wantLint: `manifest version "0.2.0" is newer than the supported protocol version`},
+		{version: "1.0.0", wantErr: `manifest version "1.0.0" is not supported by this gateway`},
+		{version: "latest", wantErr: `invalid version "latest"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".rep.yaml")
+			if err := os.WriteFile(path, []byte("version: \""+tt.version+"\"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := Load(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			findings := m.Lint()
+			if tt.wantLint == "" {
+				if len(findings) != 0 {
+					t.Errorf("expected no findings, got %v", findings)
+				}
+				return
+			}
+			if len(findings) != 1 || !strings.Contains(findings[0], tt.wantLint) {
+				t.Errorf("expected a finding containing %q, got %v", tt.wantLint, findings)
+			}
+		})
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	tests := []struct {
+		manifest, protocol string
+		wantWarning        string
+		wantErr            string
+	}{
+		{manifest: "0.1.0", protocol: "0.1.0"},
+		{manifest: "0.1.3", protocol: "0.1.0"},
+		{manifest: "", protocol: "0.1.0"},
+		{manifest: "1.3.0", protocol: "1.2.5", wantWarning: `manifest version "1.3.0" is newer than the supported protocol version 1.2.5; newer declarations may be ignored`},
+		{manifest: "1.1.0", protocol: "1.2.5", wantWarning: `manifest version "1.1.0" is older than the supported protocol version 1.2.5`},
+		{manifest: "2.0.0", protocol: "1.2.5", wantErr: `manifest version "2.0.0" is not supported by this gateway (protocol version 1.2.5)`},
+		{manifest: "0.9.0", protocol: "1.2.5", wantErr: `manifest version "0.9.0" is not supported`},
+		{manifest: "1.2", protocol: "1.2.5", wantErr: `invalid version "1.2"`},
+	}
+	for _, tt := range tests {
+		warning, err := CheckCompatibility(tt.manifest, tt.protocol)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CheckCompatibility(%q, %q): expected error containing %q, got %v", tt.manifest, tt.protocol, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("CheckCompatibility(%q, %q): unexpected error: %v", tt.manifest, tt.protocol, err)
+		}
+		if warning != tt.wantWarning {
+			t.Errorf("CheckCompatibility(%q, %q): warning = %q, want %q", tt.manifest, tt.protocol, warning, tt.wantWarning)
+		}
+	}
+}
+
+func TestExpandDefault(t *testing.T) {
+	env := map[string]string{"REGION": "eu-west-1", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	tests := []struct {
+		in         string
+		want       string
+		unresolved string
+	}{
+		{in: "plain", want: "plain"},
+		{in: "${REGION}-bucket", want: "eu-west-1-bucket"},
+		{in: "${REGION}/${REGION}", want: "eu-west-1/eu-west-1"},
+		{in: "x${EMPTY}y", want: "xy"},
+		{in: "${MISSING}-bucket", want: "${MISSING}-bucket", unresolved: "MISSING"},
+		{in: "$${REGION}", want: "${REGION}"},
+		{in: "cost: $5", want: "cost: $5"},
+		{in: "${REGION", want: "${REGION"},
+	}
+	for _, tt := range tests {
+		got, unresolved := ExpandDefault(tt.in, lookup)
+		if got != tt.want {
+			t.Errorf("ExpandDefault(%q): got %q, want %q", tt.in, got, tt.want)
+		}
+		if strings.Join(unresolved, ",") != tt.unresolved {
+			t.Errorf("ExpandDefault(%q): unresolved %v, want %q", tt.in, unresolved, tt.unresolved)
+		}
+	}
+}
+
+func TestToJSONSchema(t *testing.T) {
+	m, err := parseManifest(strings.Split(`version: "0.1.0"
+variables:
+  API_URL:
+    tier: public
+    type: url
+    required: true
+    description: "Backend base URL"
+  MAX_ITEMS:
+    tier: public
+    type: number
+    min: 1
+    max: 100
+    default: "20"
+  DEBUG:
+    tier: server
+    type: boolean
+    default: "false"
+  MODE:
+    tier: public
+    type: enum
+    values: ["dev", "prod"]
+    required: true
+  CLIENT_ID:
+    tier: sensitive
+    pattern: "^[a-z0-9]+$"
+    max_length: 32
+  REGION:
+    tier: public
+    pattern: "[a-z]{2}"
+`, "\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out, err := m.ToJSONSchema()
+	if err != nil {
+		t.Fatalf("ToJSONSchema: %v", err)
+	}
+	var doc struct {
+		Type       string                    `json:"type"`
+		Required   []string                  `json:"required"`
+		Properties map[string]map[string]any `json:"properties"`
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out)
+	}
+
+	if doc.Type != "object" {
+		t.Errorf("type: got %q", doc.Type)
+	}
+	if got := strings.Join(doc.Required, ","); got != "REP_PUBLIC_API_URL,REP_PUBLIC_MODE" {
+		t.Errorf("required: got %s", got)
+	}
+	if len(doc.Properties) != 6 {
+		t.Errorf("expected 6 properties, got %d", len(doc.Properties))
+	}
+
+	tests := []struct {
+		key, field string
+		want       any
+	}{
+		{"REP_PUBLIC_API_URL", "type", "string"},
+		{"REP_PUBLIC_API_URL", "format", "uri"},
+		{"REP_PUBLIC_API_URL", "description", "Backend base URL"},
+		{"REP_PUBLIC_MAX_ITEMS", "type", "number"},
+		{"REP_PUBLIC_MAX_ITEMS", "minimum", float64(1)},
+		{"REP_PUBLIC_MAX_ITEMS", "maximum", float64(100)},
+		{"REP_PUBLIC_MAX_ITEMS", "default", float64(20)},
+		{"REP_SERVER_DEBUG", "type", "boolean"},
+		{"REP_SERVER_DEBUG", "default", false},
+		{"REP_PUBLIC_MODE", "enum", []any{"dev", "prod"}},
+		{"REP_SENSITIVE_CLIENT_ID", "pattern", "^(?:^[a-z0-9]+$)$"},
+		{"REP_SENSITIVE_CLIENT_ID", "maxLength", float64(32)},
+	}
+	for _, tt := range tests {
+		got := doc.Properties[tt.key][tt.field]
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s.%s: got %#v, want %#v", tt.key, tt.field, got, tt.want)
+		}
+	}
+
+	// Schema validators search patterns unanchored; the emitted pattern
+	// must still reject a value the gateway rejects.
+	pattern, _ := doc.Properties["REP_PUBLIC_REGION"]["pattern"].(string)
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		t.Fatalf("REGION pattern %q: %v", pattern, err)
+	}
+	if !re.MatchString("eu") || re.MatchString("eu-west") {
+		t.Errorf("REGION pattern %q: expected a full match only", pattern)
+	}
+	if err := m.Validate(map[string]string{"REGION": "eu-west"}, nil, nil, nil); err == nil {
+		t.Error("expected the gateway to reject the partial match too")
+	}
+}
+
+func TestParseRequiresIf(t *testing.T) {
+	m, err := parseManifest(strings.Split(`version: "0.1.0"
+variables:
+  FEATURE_X_URL:
+    tier: public
+    type: url
+    requires_if: {FEATURE_X: "true", REGION: eu}
+`, "\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := m.Variables["FEATURE_X_URL"].RequiresIf
+	if len(got) != 2 || got["FEATURE_X"] != "true" || got["REGION"] != "eu" {
+		t.Errorf("requires_if: got %v", got)
+	}
+}
+
+func TestParseInlineMapQuotedComma(t *testing.T) {
+	got := parseInlineMap(`{A: "x,y", B: 'p, q', C: z}`)
+	if len(got) != 3 || got["A"] != "x,y" || got["B"] != "p, q" || got["C"] != "z" {
+		t.Errorf("parseInlineMap: got %v", got)
+	}
+}
+
+func TestValidateRequiresIf(t *testing.T) {
+	m := &Manifest{
+		Variables: map[string]*VarDecl{
+			"FEATURE_X":     {Tier: "public", Type: "boolean"},
+			"FEATURE_X_URL": {Tier: "public", Type: "url", RequiresIf: map[string]string{"FEATURE_X": "true"}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		public  map[string]string
+		wantErr bool
+	}{
+		{"condition holds, dependent missing", map[string]string{"FEATURE_X": "true"}, true},
+		{"condition holds, dependent set", map[string]string{"FEATURE_X": "true", "FEATURE_X_URL": "https://x.example.com"}, false},
+		{"condition false", map[string]string{"FEATURE_X": "false"}, false},
+		{"condition var absent", map[string]string{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.Validate(tt.public, nil, nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), `variable "FEATURE_X_URL" is required when FEATURE_X="true"`) {
+				t.Errorf("unexpected error message: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateRequiredIf(t *testing.T) {
+	m, err := parseManifest(strings.Split(`version: "0.1.0"
+variables:
+  SMTP_HOST:
+    tier: public
+  SMTP_PASSWORD:
+    tier: sensitive
+    required_if: SMTP_HOST
+  SMTP_TLS_CERT:
+    tier: server
+    required_if: "SMTP_MODE=tls"
+  SMTP_USER:
+    tier: public
+    required: true
+    required_if: SMTP_HOST
+`, "\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := m.Variables["SMTP_TLS_CERT"].RequiredIf; got != "SMTP_MODE=tls" {
+		t.Errorf("required_if: got %q", got)
+	}
+
+	tests := []struct {
+		name      string
+		public    map[string]string
+		sensitive map[string]string
+		want      []string
+	}{
+		{"condition var absent", map[string]string{"SMTP_USER": "u"}, nil, nil},
+		{"condition var set", map[string]string{"SMTP_USER": "u", "SMTP_HOST": "mail.example.com"}, nil, []string{
+			`variable "SMTP_PASSWORD" is required because SMTP_HOST is set`,
+		}},
+		{"value matches", map[string]string{"SMTP_USER": "u", "SMTP_MODE": "tls"}, nil, []string{
+			`variable "SMTP_TLS_CERT" is required because SMTP_MODE is "tls"`,
+		}},
+		{"value differs", map[string]string{"SMTP_USER": "u", "SMTP_MODE": "plain"}, nil, nil},
+		{"required takes precedence", map[string]string{"SMTP_HOST": "mail.example.com"}, map[string]string{"SMTP_PASSWORD": "p"}, []string{
+			`required variable "SMTP_USER" is not set`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, v := range m.ValidateDetailed(tt.public, tt.sensitive, nil, nil) {
+				got = append(got, v.Message)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("violations: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if findings := m.Lint(); len(findings) != 1 || !strings.Contains(findings[0], "SMTP_USER") {
+		t.Errorf("expected a lint finding for SMTP_USER, got %v", findings)
+	}
+}
+
+func TestValidateTierDowngrade(t *testing.T) {
+	m, err := parseManifest(strings.Split(`version: "0.1.0"
+variables:
+  API_URL:
+    tier: public
+  ANALYTICS_KEY:
+    tier: sensitive
+  DB_PASSWORD:
+    tier: server
+`, "\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Every variable in its declared tier passes, as does a variable
+	// supplied in a more protected tier than declared.
+	if v := m.ValidateDetailed(
+		nil,
+		map[string]string{"API_URL": "https://api.example.com", "ANALYTICS_KEY": "ak_123"},
+		map[string]string{"DB_PASSWORD": "secret"},
+		nil,
+	); len(v) != 0 {
+		t.Errorf("expected no violations, got %+v", v)
+	}
+
+	v := m.ValidateDetailed(
+		map[string]string{"ANALYTICS_KEY": "ak_123"},
+		map[string]string{"DB_PASSWORD": "secret"},
+		nil, nil,
+	)
+	want := []Violation{
+		{Name: "ANALYTICS_KEY", Kind: KindTierDowngrade, Message: `variable "ANALYTICS_KEY" is declared sensitive but supplied as REP_PUBLIC_ANALYTICS_KEY`},
+		{Name: "DB_PASSWORD", Kind: KindTierDowngrade, Message: `variable "DB_PASSWORD" is declared server but supplied as REP_SENSITIVE_DB_PASSWORD`},
+	}
+	if len(v) != len(want) {
+		t.Fatalf("expected %d violations, got %+v", len(want), v)
+	}
+	for i := range want {
+		if v[i] != want[i] {
+			t.Errorf("violation %d: got %+v, want %+v", i, v[i], want[i])
+		}
+	}
+}
+
+func TestCheckUndeclared(t *testing.T) {
+	m := &Manifest{Variables: map[string]*VarDecl{
+		"API_URL": {Tier: "public"},
+		"DB_URL":  {Tier: "server"},
+	}}
+
+	var got []string
+	log := func(msg string, args ...any) {
+		got = append(got, fmt.Sprint(append([]any{msg}, args...)...))
+	}
+	m.CheckUndeclared(
+		map[string]string{"API_URL": "https://api.example.com", "THEME": "dark", "APIURL": "x"},
+		map[string]string{"ANALYTICS_KEY": "ak_123"},
+		map[string]string{"DB_URL": "postgres://db"},
+		log,
+	)
+	want := []string{
+		fmt.Sprint("rep.manifest.undeclared_var", "name", "APIURL", "tier", "public"),
+		fmt.Sprint("rep.manifest.undeclared_var", "name", "THEME", "tier", "public"),
+		fmt.Sprint("rep.manifest.undeclared_var", "name", "ANALYTICS_KEY", "tier", "sensitive"),
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Without a manifest there is nothing to compare against.
+	got = nil
+	(*Manifest)(nil).CheckUndeclared(map[string]string{"THEME": "dark"}, nil, nil, log)
+	if len(got) != 0 {
+		t.Errorf("expected no warnings without a manifest, got %q", got)
+	}
+}
+
+func TestValidateDetailed_MultipleViolations(t *testing.T) {
+	m := &Manifest{
+		Variables: map[string]*VarDecl{
+			"API_URL":  {Tier: "public", Type: "url", Required: true},
+			"TIMEOUT":  {Tier: "public", Type: "number"},
+			"CLIENT":   {Tier: "sensitive", Type: "string", Pattern: `[a-z]+`},
+			"ENV_NAME": {Tier: "public", Type: "enum", Values: []string{"dev", "prod"}},
+			"OK":       {Tier: "public", Type: "string"},
+		},
+	}
+
+	violations := m.ValidateDetailed(
+		map[string]string{"TIMEOUT": "soon", "ENV_NAME": "prod", "OK": "fine"},
+		map[string]string{"CLIENT": "ABC123"},
+		nil, nil,
+	)
+
+	want := []Violation{
+		{Name: "API_URL", Kind: KindMissingRequired},
+		{Name: "CLIENT", Kind: KindPatternMismatch},
+		{Name: "TIMEOUT", Kind: KindType},
+	}
+	if len(violations) != len(want) {
+		t.Fatalf("expected %d violations, got %d: %+v", len(want), len(violations), violations)
+	}
+	for i, w := range want {
+		got := violations[i]
+		if got.Name != w.Name || got.Kind != w.Kind {
+			t.Errorf("violation %d: got {%s %s}, want {%s %s}", i, got.Name, got.Kind, w.Name, w.Kind)
+		}
+		if !strings.Contains(got.Message, w.Name) {
+			t.Errorf("violation %d message should name the variable: %q", i, got.Message)
+		}
+	}
+
+	// Validate wraps the same violations into a single error.
+	err := m.Validate(
+		map[string]string{"TIMEOUT": "soon", "ENV_NAME": "prod", "OK": "fine"},
+		map[string]string{"CLIENT": "ABC123"},
+		nil, nil,
+	)
+	if err == nil {
+		t.Fatal("expected Validate error")
+	}
+	for _, v := range violations {
+		if !strings.Contains(err.Error(), v.Message) {
+			t.Errorf("Validate error missing %q", v.Message)
+		}
+	}
+}
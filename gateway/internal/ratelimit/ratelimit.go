@@ -0,0 +1,121 @@
+// Package ratelimit provides per-client request rate limiting for the
+// gateway's /rep/* endpoints.
+//
+// /rep/session-key uses a Limiter directly (its limit is --session-key-max-rate,
+// per §4.4). The other endpoints are wrapped with Limiter.Wrap when a limit
+// is configured for them via --rate-limits.
+package ratelimit
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// window is the sliding window over which requests are counted.
+const window = time.Minute
+
+// Limiter enforces a sliding-window limit of requests per minute per key
+// (normally the client IP).
+type Limiter struct {
+	max int
+
+	mu        sync.Mutex
+	hits      map[string][]time.Time // key → request timestamps in the window
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// New creates a limiter allowing maxPerMinute requests per key per minute.
+func New(maxPerMinute int) *Limiter {
+	return &Limiter{
+		max:  maxPerMinute,
+		hits: make(map[string][]time.Time),
+		now:  time.Now,
+	}
+}
+
+// Max returns the number of requests allowed per key per minute.
+func (l *Limiter) Max() int {
+	return l.max
+}
+
+// Allow records a request for key and reports whether it is within the limit.
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	windowStart := now.Add(-window)
+
+	// Drop idle keys once per window so the map doesn't grow without bound.
+	if now.Sub(l.lastSweep) >= window {
+		for k, timestamps := range l.hits {
+			if len(timestamps) == 0 || !timestamps[len(timestamps)-1].After(windowStart) {
+				delete(l.hits, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	// Filter timestamps within the window.
+	timestamps := l.hits[key]
+	valid := timestamps[:0]
+	for _, ts := range timestamps {
+		if ts.After(windowStart) {
+			valid = append(valid, ts)
+		}
+	}
+
+	if len(valid) >= l.max {
+		l.hits[key] = valid
+		return false
+	}
+
+	l.hits[key] = append(valid, now)
+	return true
+}
+
+// Wrap returns a handler that answers 429 once a client IP exceeds the
+// limit and otherwise calls next. endpoint names the endpoint in logs.
+func (l *Limiter) Wrap(next http.Handler, endpoint string, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIP := ClientIP(r)
+		if !l.Allow(clientIP) {
+			logger.Warn("rep.rate_limited",
+				"endpoint", endpoint,
+				"client_ip", clientIP,
+				"requests_in_window", l.max,
+			)
+			w.Header().Set("Retry-After", strconv.Itoa(int(window.Seconds())))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP extracts the client IP from the request,
+// respecting X-Forwarded-For if present.
+func ClientIP(r *http.Request) string {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		// Take the first IP in the chain (the client).
+		for i := 0; i < len(xff); i++ {
+			if xff[i] == ',' {
+				return xff[:i]
+			}
+		}
+		return xff
+	}
+
+	// Fall back to RemoteAddr (strip port).
+	addr := r.RemoteAddr
+	for i := len(addr) - 1; i >= 0; i-- {
+		if addr[i] == ':' {
+			return addr[:i]
+		}
+	}
+	return addr
+}
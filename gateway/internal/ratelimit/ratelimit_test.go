@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	l := New(2)
+	if !l.Allow("a") || !l.Allow("a") {
+		t.Fatal("expected the first two requests to be allowed")
+	}
+	if l.Allow("a") {
+		t.Error("expected the third request to be limited")
+	}
+	if !l.Allow("b") {
+		t.Error("expected another key to be unaffected")
+	}
+}
+
+func TestLimiter_WindowSlides(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := New(1)
+	l.now = func() time.Time { return now }
+
+	if !l.Allow("a") {
+		t.Fatal("expected first request to be allowed")
+	}
+	if l.Allow("a") {
+		t.Fatal("expected second request to be limited")
+	}
+	now = now.Add(window + time.Second)
+	if !l.Allow("a") {
+		t.Error("expected request to be allowed after the window passed")
+	}
+}
+
+func TestLimiter_SweepsIdleKeys(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := New(5)
+	l.now = func() time.Time { return now }
+
+	l.Allow("idle")
+	now = now.Add(2 * window)
+	l.Allow("active")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.hits["idle"]; ok {
+		t.Error("expected idle key to be swept")
+	}
+}
+
+func TestLimiter_Wrap(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h := New(1).Wrap(ok, "health", slog.Default())
+
+	get := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/rep/health", nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("10.0.0.1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	rec := get("10.0.0.1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header")
+	}
+	if rec := get("10.0.0.2"); rec.Code != http.StatusOK {
+		t.Errorf("expected another IP to be unaffected, got %d", rec.Code)
+	}
+}
+
+func TestClientIP_XForwardedFor(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 5.6.7.8")
+
+	ip := ClientIP(req)
+	if ip != "1.2.3.4" {
+		t.Errorf("expected 1.2.3.4, got %s", ip)
+	}
+}
+
+func TestClientIP_RemoteAddr(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Del("X-Forwarded-For")
+	req.RemoteAddr = "192.168.1.1:12345"
+
+	ip := ClientIP(req)
+	if ip != "192.168.1.1" {
+		t.Errorf("expected 192.168.1.1, got %s", ip)
+	}
+}
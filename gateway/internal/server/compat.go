@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/ruachtech/rep/gateway/internal/manifest"
+)
+
+// compatInfo is the JSON body of /rep/compat.
+type compatInfo struct {
+	SDK             string `json:"sdk"`
+	ProtocolVersion string `json:"protocol_version"`
+	Compatible      bool   `json:"compatible"`
+	Detail          string `json:"detail,omitempty"`
+}
+
+// compatHandler serves /rep/compat?sdk=<version>, reporting whether an SDK
+// of that version can read payloads of manifest.ProtocolVersion, so apps
+// can warn about a mismatched SDK. The SDK is compatible when its major
+// version matches the protocol's; a differing minor version is noted in
+// detail. A missing or malformed sdk parameter gets 400.
+func compatHandler(logger *slog.Logger) http.Handler {
+	protocol, _ := manifest.ParseVersion(manifest.ProtocolVersion)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		sdk := r.URL.Query().Get("sdk")
+		v, err := manifest.ParseVersion(sdk)
+		if err != nil {
+			http.Error(w, "sdk must be a major.minor.patch version", http.StatusBadRequest)
+			return
+		}
+
+		info := compatInfo{
+			SDK:             sdk,
+			ProtocolVersion: manifest.ProtocolVersion,
+			Compatible:      v.Major == protocol.Major,
+		}
+		switch {
+		case !info.Compatible:
+			info.Detail = fmt.Sprintf("SDK major version %d does not match protocol major version %d; upgrade the SDK or gateway", v.Major, protocol.Major)
+		case v.Less(protocol):
+			info.Detail = "SDK is older than the protocol version; newer payload fields are ignored"
+		case protocol.Less(v):
+			info.Detail = "SDK is newer than the protocol version; it may expect payload fields this gateway does not send"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(info); err != nil {
+			logger.Error("rep.compat.encode_error", "error", err)
+		}
+	})
+}
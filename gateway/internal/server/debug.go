@@ -0,0 +1,65 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+
+	"github.com/ruachtech/rep/gateway/internal/ratelimit"
+)
+
+// debugVars is the JSON body of /rep/debug/vars: enough runtime state to
+// spot goroutine or memory leaks, without pprof's stacks and profiles.
+type debugVars struct {
+	Goroutines int              `json:"goroutines"`
+	MemStats   runtime.MemStats `json:"memstats"`
+}
+
+// debugVarsHandler serves runtime statistics to requests that present
+// token as a bearer token. Others get 401.
+func debugVarsHandler(token string, logger *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			logger.Warn("rep.debug.unauthorized", "client_ip", ratelimit.ClientIP(r))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="rep-debug"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		vars := debugVars{Goroutines: runtime.NumGoroutine()}
+		runtime.ReadMemStats(&vars.MemStats)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(vars); err != nil {
+			logger.Error("rep.debug.encode_error", "error", err)
+		}
+	})
+}
+
+// pprofHandler serves the net/http/pprof handlers under /rep/debug/pprof/.
+// It is only mounted on the separate --pprof-addr listener, never on the
+// main port.
+func pprofHandler() http.Handler {
+	// pprof.Index resolves profile names relative to /debug/pprof/, so
+	// the routes are registered there and /rep is stripped first.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	outer := http.NewServeMux()
+	outer.Handle("/rep/debug/pprof/", http.StripPrefix("/rep", mux))
+	return outer
+}
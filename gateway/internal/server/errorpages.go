@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// errorPageStatuses are the statuses that can be replaced by a custom page.
+// A page for status N is read from <error-page-dir>/N.html.
+var errorPageStatuses = []int{http.StatusNotFound, http.StatusInternalServerError}
+
+// loadErrorPages reads the custom error pages from dir. Missing pages are
+// skipped; a missing or unreadable directory is an error.
+func loadErrorPages(dir string) (map[int][]byte, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	pages := make(map[int][]byte)
+	for _, status := range errorPageStatuses {
+		data, err := os.ReadFile(filepath.Join(dir, strconv.Itoa(status)+".html"))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pages[status] = data
+	}
+	return pages, nil
+}
+
+// withErrorPages wraps next so that responses with a status that has a page
+// in pages are served with that page instead of the default body. The page
+// is served as HTML, so the injection middleware in front of this handler
+// adds the REP payload to it like any other document (except on asset paths,
+// which the middleware streams without injection).
+func withErrorPages(next http.Handler, pages map[int][]byte) http.Handler {
+	if len(pages) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&errorPageWriter{ResponseWriter: w, pages: pages}, r)
+	})
+}
+
+// errorPageWriter swaps the body of matching error responses for a custom
+// page. Once a page has been written, further writes from the wrapped
+// handler are discarded.
+type errorPageWriter struct {
+	http.ResponseWriter
+	pages       map[int][]byte
+	wroteHeader bool
+	replaced    bool
+}
+
+func (w *errorPageWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	page, ok := w.pages[code]
+	if !ok {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	w.replaced = true
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	w.ResponseWriter.WriteHeader(code)
+	_, _ = w.ResponseWriter.Write(page)
+}
+
+func (w *errorPageWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		// Report success so the wrapped handler doesn't log a write error.
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ruachtech/rep/gateway/internal/config"
+	repcrypto "github.com/ruachtech/rep/gateway/internal/crypto"
+	"github.com/ruachtech/rep/gateway/internal/health"
+)
+
+// TestServer_Integration builds a complete Server through NewFromVars and
+// exercises every endpoint against one running instance.
+func TestServer_Integration(t *testing.T) {
+	// The env file is only read on reload; the initial variables are injected.
+	envFile := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(envFile, []byte("REP_PUBLIC_API_URL=https://api.example.com\nREP_SENSITIVE_TOKEN=secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig(func(cfg *config.Config) {
+		cfg.EnvFile = envFile
+		cfg.HotReload = true
+		cfg.HotReloadMode = "signal"
+		cfg.SessionKeyTTL = 30 * time.Second
+		cfg.SessionKeyMaxRate = 10
+		cfg.ValidatePayload = true
+	})
+	vars := &config.ClassifiedVars{
+		Public: []config.Variable{
+			{Name: "API_URL", Value: "https://api.example.com", Tier: config.TierPublic, OriginalKey: "REP_PUBLIC_API_URL"},
+		},
+		Sensitive: []config.Variable{
+			{Name: "TOKEN", Value: "secret", Tier: config.TierSensitive, OriginalKey: "REP_SENSITIVE_TOKEN"},
+		},
+		Server: []config.Variable{
+			{Name: "DB_URL", Value: "postgres://db", Tier: config.TierServer, OriginalKey: "REP_SERVER_DB_URL"},
+		},
+	}
+
+	srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", vars)
+	if err != nil {
+		t.Fatalf("NewFromVars: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	defer srv.hotReloadHub.Close()
+
+	t.Run("html injection", func(t *testing.T) {
+		body := fetchBody(t, ts.URL+"/")
+		if !strings.Contains(body, `id="__rep__"`) {
+			t.Fatal("expected injected script tag")
+		}
+		if !strings.Contains(body, `"API_URL":"https://api.example.com"`) {
+			t.Error("expected public variable in payload")
+		}
+		if strings.Contains(body, "postgres://db") {
+			t.Error("server-tier value leaked into the page")
+		}
+	})
+
+	t.Run("health", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/rep/health")
+		if err != nil {
+			t.Fatalf("GET error: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		var h health.Response
+		if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		if h.Status != "healthy" {
+			t.Errorf("expected healthy, got %q", h.Status)
+		}
+		if h.Variables.Public != 1 || h.Variables.Sensitive != 1 || h.Variables.Server != 1 {
+			t.Errorf("unexpected variable counts: %+v", h.Variables)
+		}
+	})
+
+	t.Run("ready", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/rep/ready")
+		if err != nil {
+			t.Fatalf("GET error: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected 200, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("session key", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/rep/session-key")
+		if err != nil {
+			t.Fatalf("GET error: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+		var sk repcrypto.SessionKeyResponse
+		if err := json.NewDecoder(resp.Body).Decode(&sk); err != nil {
+			t.Fatalf("decode error: %v", err)
+		}
+		if sk.Key == "" {
+			t.Error("expected non-empty session key")
+		}
+	})
+
+	t.Run("changes", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/rep/changes")
+		if err != nil {
+			t.Fatalf("GET error: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("expected text/event-stream, got %q", ct)
+		}
+
+		// The connected comment is written after the client subscribes, so
+		// a reload triggered once it is read is guaranteed to be delivered.
+		lines := bufio.NewReader(resp.Body)
+		if line, err := lines.ReadString('\n'); err != nil || !strings.HasPrefix(line, ": connected") {
+			t.Fatalf("expected connected comment, got %q (%v)", line, err)
+		}
+
+		if err := os.WriteFile(envFile, []byte("REP_PUBLIC_API_URL=https://api2.example.com\nREP_SENSITIVE_TOKEN=secret\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.Reload(); err != nil {
+			t.Fatalf("Reload: %v", err)
+		}
+
+		var event, data string
+		for data == "" {
+			line, err := lines.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading event stream: %v", err)
+			}
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimSpace(strings.TrimPrefix(line, "event: "))
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		if event != "rep:config:update" {
+			t.Errorf("expected rep:config:update, got %q", event)
+		}
+		if !strings.Contains(data, `"key":"API_URL"`) || !strings.Contains(data, "api2.example.com") {
+			t.Errorf("unexpected event data: %s", data)
+		}
+
+		if body := fetchBody(t, ts.URL+"/"); !strings.Contains(body, "api2.example.com") {
+			t.Error("expected reloaded value in injected payload")
+		}
+	})
+}
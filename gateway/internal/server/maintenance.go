@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// defaultMaintenancePage is served in maintenance mode without
+// --maintenance-page. It has a <head> so the payload can be injected.
+const defaultMaintenancePage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Down for maintenance</title>
+</head>
+<body>
+<h1>Down for maintenance</h1>
+<p>We'll be back shortly.</p>
+</body>
+</html>
+`
+
+// maintenanceRetryAfter is the Retry-After, in seconds, of maintenance
+// responses.
+const maintenanceRetryAfter = 60
+
+// loadMaintenancePage reads the page at path, or returns the built-in page
+// when path is empty.
+func loadMaintenancePage(path string) ([]byte, error) {
+	if path == "" {
+		return []byte(defaultMaintenancePage), nil
+	}
+	return os.ReadFile(path)
+}
+
+// withMaintenance wraps next so that while on reports true, every request
+// is answered with page and 503 instead of reaching next. Like
+// withErrorPages it sits behind the injection middleware, which adds the
+// REP payload to the page. The /rep/* endpoints are routed around it.
+func withMaintenance(next http.Handler, page []byte, on func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !on() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		h.Set("Cache-Control", "no-store")
+		h.Set("Retry-After", strconv.Itoa(maintenanceRetryAfter))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(page)
+		}
+	})
+}
+
+// CheckMaintenance re-evaluates maintenance mode: on with --maintenance,
+// or while --maintenance-file exists. Changes are logged and reported by
+// /rep/health. Reload calls it; without signal-mode hot reload, main calls
+// it on SIGHUP.
+func (s *Server) CheckMaintenance() {
+	on := s.cfg.Maintenance
+	if !on && s.cfg.MaintenanceFile != "" {
+		_, err := os.Stat(s.cfg.MaintenanceFile)
+		on = err == nil
+	}
+	if s.maintenance.Swap(on) != on {
+		if on {
+			s.logger.Warn("rep.maintenance.enabled")
+		} else {
+			s.logger.Info("rep.maintenance.disabled")
+		}
+	}
+	if s.health != nil {
+		s.health.SetMaintenance(on)
+	}
+}
@@ -11,6 +11,10 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
@@ -19,15 +23,21 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ruachtech/rep/gateway/internal/config"
+	"github.com/ruachtech/rep/gateway/internal/cors"
 	repcrypto "github.com/ruachtech/rep/gateway/internal/crypto"
 	"github.com/ruachtech/rep/gateway/internal/guardrails"
 	"github.com/ruachtech/rep/gateway/internal/health"
 	"github.com/ruachtech/rep/gateway/internal/hotreload"
 	"github.com/ruachtech/rep/gateway/internal/inject"
+	"github.com/ruachtech/rep/gateway/internal/manifest"
+	"github.com/ruachtech/rep/gateway/internal/ratelimit"
 	"github.com/ruachtech/rep/gateway/pkg/payload"
 )
 
@@ -37,18 +47,48 @@ type Server struct {
 	logger  *slog.Logger
 	version string
 
-	vars         *config.ClassifiedVars
-	keys         *repcrypto.Keys
-	injector     *inject.Middleware
-	hotReloadHub *hotreload.Hub
-	httpServer   *http.Server
-	healthServer *http.Server // Optional separate health server.
-	startTime    time.Time
+	vars           *config.ClassifiedVars
+	payload        *payload.Payload        // Last built payload, reused by reload.
+	scriptTemplate *payload.ScriptTemplate // --script-template, if set.
+	keys           *repcrypto.Keys
+	injector       *inject.Middleware
+	health         *health.Handler
+	hotReloadHub   *hotreload.Hub
+	origins        *cors.Origins
+	sessionKey     *repcrypto.SessionKeyHandler
+	keyEndpoint    atomic.Bool // Whether the served payload advertises key_endpoint.
+	maintenance    atomic.Bool // Whether maintenance mode is on (see CheckMaintenance).
+	httpServer     *http.Server
+	healthServer   *http.Server // Optional separate health server.
+	pprofServer    *http.Server // Optional pprof server (--enable-pprof).
+	startup        *Startup     // Pre-bound listeners, if any (see UseStartup).
+	startTime      time.Time
 }
 
 // New creates and initialises a new REP gateway server.
 // This performs steps 1–9 of the startup sequence (§4.2).
 func New(cfg *config.Config, logger *slog.Logger, version string) (*Server, error) {
+	return newServer(cfg, logger, version, func() (*config.ClassifiedVars, error) {
+		return config.ReadAndClassifyFrom(cfg.Sources())
+	})
+}
+
+// NewFromVars is like New but starts from the given classified variables
+// instead of reading the process environment and cfg's env file/dir. The
+// rest of the startup sequence (manifest validation, guardrails, keys,
+// payload, mux wiring) is identical.
+//
+// It exists as a seam for integration tests, which can build a complete
+// Server and drive it through Handler() without mutating the process
+// environment. Hot reload still re-reads cfg's sources.
+func NewFromVars(cfg *config.Config, logger *slog.Logger, version string, vars *config.ClassifiedVars) (*Server, error) {
+	return newServer(cfg, logger, version, func() (*config.ClassifiedVars, error) {
+		return vars, nil
+	})
+}
+
+// newServer runs the startup sequence using read to obtain variables.
+func newServer(cfg *config.Config, logger *slog.Logger, version string, read func() (*config.ClassifiedVars, error)) (*Server, error) {
 	s := &Server{
 		cfg:       cfg,
 		logger:    logger,
@@ -56,52 +96,90 @@ func New(cfg *config.Config, logger *slog.Logger, version string) (*Server, erro
 		startTime: time.Now(),
 	}
 
-	// Step 1–2: Read and classify environment variables.
+	// Check the manifest's protocol version and lint the manifest itself
+	// before reading any variables. A minor version mismatch is reported
+	// as a lint finding.
+	if cfg.Manifest != nil {
+		if _, err := manifest.CheckCompatibility(cfg.Manifest.Version, manifest.ProtocolVersion); err != nil {
+			return nil, fmt.Errorf("manifest %q: %w; refusing to start", cfg.ManifestPath, err)
+		}
+		findings := cfg.Manifest.Lint()
+		for _, f := range findings {
+			logger.Warn("rep.manifest.lint", "manifest", cfg.ManifestPath, "detail", f)
+		}
+		if len(findings) > 0 && cfg.Strict {
+			return nil, fmt.Errorf(
+				"manifest lint found %d issue(s) and --strict is enabled; refusing to start",
+				len(findings),
+			)
+		}
+	}
+
+	// Step 1–2: Read and classify environment variables, then validate
+	// against the manifest if one was loaded (§6, §4.2 step 3).
 	logger.Info("reading environment variables")
-	vars, err := config.ReadAndClassify(cfg.EnvFile)
+	vars, err := s.classifyAndValidate(read)
 	if err != nil {
-		return nil, fmt.Errorf("classifying variables: %w", err)
+		return nil, err
 	}
 	s.vars = vars
-
-	// Step 2b: Validate against manifest if one was loaded (§6, §4.2 step 3).
-	if cfg.Manifest != nil {
-		logger.Info("validating environment variables against manifest", "manifest", cfg.ManifestPath)
-		if err := cfg.Manifest.Validate(
-			vars.PublicMap(),
-			vars.SensitiveMap(),
-			vars.ServerMap(),
-			func(msg string, args ...any) { logger.Warn(msg, args...) },
-		); err != nil {
-			return nil, fmt.Errorf("manifest validation: %w", err)
-		}
-	}
+	cfg.Manifest.CheckUndeclared(vars.PublicMap(), vars.SensitiveMap(), vars.ServerMap(),
+		func(msg string, args ...any) { logger.Warn(msg, args...) })
 
 	// Step 3–4: Run secret detection guardrails.
 	logger.Info("running guardrail scan on PUBLIC tier variables")
-	gr := guardrails.Scan(vars, logger)
+	gr := guardrails.ScanWithLimits(vars, logger, guardrails.Limits{MaxScanLength: cfg.GuardrailMaxScan})
 
 	if gr.HasWarnings() && cfg.Strict {
+		logger.Error("rep.guardrail.blocked", "warnings", len(gr.Warnings))
 		return nil, fmt.Errorf(
 			"guardrail scan found %d warning(s) and --strict is enabled; refusing to start",
 			len(gr.Warnings),
 		)
 	}
 
-	// Step 5: Generate ephemeral crypto keys.
-	logger.Info("generating ephemeral cryptographic keys")
-	keys, err := repcrypto.GenerateKeys()
+	// Step 5: Generate ephemeral crypto keys, or derive shared ones.
+	var keys *repcrypto.Keys
+	switch {
+	case cfg.KeyFile != "":
+		logger.Info("deriving shared cryptographic keys from key file")
+		keys, err = repcrypto.LoadKeyFile(cfg.KeyFile)
+	case cfg.KeySeed != "":
+		logger.Info("deriving shared cryptographic keys from REP_GATEWAY_KEY_SEED")
+		var seed []byte
+		if seed, err = repcrypto.ParseSeed(cfg.KeySeed); err == nil {
+			keys, err = repcrypto.KeysFromSeed(seed)
+		}
+	default:
+		logger.Info("generating ephemeral cryptographic keys")
+		keys, err = repcrypto.GenerateKeys()
+	}
 	if err != nil {
 		return nil, fmt.Errorf("generating keys: %w", err)
 	}
 	s.keys = keys
 
+	if cfg.ScriptTemplate != "" {
+		s.scriptTemplate, err = payload.LoadScriptTemplate(cfg.ScriptTemplate)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("rendering the payload with a custom script template", "path", cfg.ScriptTemplate)
+	}
+
 	// Step 6–7: Build the payload and render the script tag.
-	builder := payload.NewBuilder(keys, version, cfg.HotReload)
+	builder := payload.NewBuilder(keys, version, cfg.HotReload, s.builderOptions()...)
 	p, err := builder.Build(vars)
 	if err != nil {
 		return nil, fmt.Errorf("building payload: %w", err)
 	}
+	s.payload = p
+	if cfg.ValidatePayload {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("payload self-check failed: %w", err)
+		}
+		logger.Info("payload self-check passed")
+	}
 
 	scriptTag, err := p.ScriptTag()
 	if err != nil {
@@ -110,6 +188,7 @@ func New(cfg *config.Config, logger *slog.Logger, version string) (*Server, erro
 
 	// Step 8: Create the upstream handler (proxy or file server).
 	var upstream http.Handler
+	var errorPages map[int][]byte
 	switch cfg.Mode {
 	case "proxy":
 		upstream, err = s.createReverseProxy()
@@ -117,15 +196,72 @@ func New(cfg *config.Config, logger *slog.Logger, version string) (*Server, erro
 			return nil, fmt.Errorf("creating reverse proxy: %w", err)
 		}
 	case "embedded":
-		upstream = s.createFileServer()
+		upstream, err = s.createFileServer()
+		if err != nil {
+			return nil, fmt.Errorf("creating file server: %w", err)
+		}
+		if cfg.ErrorPageDir != "" {
+			errorPages, err = loadErrorPages(cfg.ErrorPageDir)
+			if err != nil {
+				return nil, fmt.Errorf("loading error pages: %w", err)
+			}
+			logger.Info("serving custom error pages", "directory", cfg.ErrorPageDir, "pages", len(errorPages))
+			upstream = withErrorPages(upstream, errorPages)
+		}
+	}
+	// Maintenance mode answers in place of the upstream. It is only wired
+	// up when it can be turned on.
+	maintenance := cfg.Maintenance || cfg.MaintenanceFile != ""
+	if maintenance {
+		page, err := loadMaintenancePage(cfg.MaintenancePage)
+		if err != nil {
+			return nil, fmt.Errorf("loading maintenance page: %w", err)
+		}
+		upstream = withMaintenance(upstream, page, s.maintenance.Load)
 	}
 
 	// Create the injection middleware wrapping the upstream.
-	s.injector = inject.New(upstream, scriptTag, logger)
+	rewrites, err := inject.ParseRewrites(cfg.BodyRewrites)
+	if err != nil {
+		return nil, fmt.Errorf("parsing body_rewrites: %w", err)
+	}
+	statuses, err := inject.ParseStatuses(cfg.InjectStatus)
+	if err != nil {
+		return nil, fmt.Errorf("parsing inject-status: %w", err)
+	}
+	// Custom error pages are served to be injected.
+	for status := range errorPages {
+		statuses.Add(status)
+	}
+	if maintenance {
+		statuses.Add(http.StatusServiceUnavailable)
+	}
+	injectOpts := []inject.Option{
+		inject.WithRewrites(rewrites),
+		inject.WithStatuses(statuses),
+		inject.WithContentSniffing(cfg.SniffContentType),
+		inject.WithBaseHref(cfg.BaseHref, cfg.BaseHrefReplace),
+		inject.WithLogSampling(cfg.InjectLogSample, cfg.InjectLogSummary),
+		inject.WithReportOnlyCSP(cfg.CSPReportOnly, cfg.CSPReportURI),
+		inject.WithMaxConcurrent(cfg.InjectMaxConcurrent),
+	}
+	if cfg.PublicHeaders {
+		injectOpts = append(injectOpts, inject.WithPublicHeaders(inject.PublicHeaders(vars.PublicMap(), logger)))
+	}
+	if cfg.NotReadyPolicy == "fail-closed" {
+		// s.health is created below; the gate is only consulted per request.
+		injectOpts = append(injectOpts, inject.WithReadinessGate(func() (bool, string) {
+			return s.health.Ready()
+		}))
+	}
+	s.injector = inject.New(upstream, scriptTag, logger, injectOpts...)
 
 	// Step 9: Create hot reload hub if enabled.
 	if cfg.HotReload {
-		s.hotReloadHub = hotreload.NewHub(logger)
+		s.hotReloadHub = hotreload.NewHub(logger,
+			hotreload.WithClientBuffer(cfg.SSEBuffer),
+			hotreload.WithReplay(cfg.SSEReplay, cfg.SSEReplayMaxAge),
+		)
 	}
 
 	// Build the HTTP mux.
@@ -133,49 +269,106 @@ func New(cfg *config.Config, logger *slog.Logger, version string) (*Server, erro
 
 	// Health check (§4.5).
 	healthHandler := health.NewHandler(version, vars, gr, s.startTime)
-	mux.Handle("/rep/health", healthHandler)
-
-	// Session key endpoint (§4.4) — only if sensitive vars exist.
-	if len(vars.Sensitive) > 0 {
-		skHandler := repcrypto.NewSessionKeyHandler(
-			keys.EncryptionKey,
-			cfg.SessionKeyTTL,
-			cfg.SessionKeyMaxRate,
-			cfg.AllowedOrigins,
-			logger,
-		)
-		mux.HandleFunc("/rep/session-key", func(w http.ResponseWriter, r *http.Request) {
-			if r.Method == http.MethodOptions {
-				skHandler.CORSPreflight(w, r)
-				return
-			}
-			skHandler.ServeHTTP(w, r)
-		})
+	if cfg.Manifest != nil {
+		// Startup validation passed (it is fatal otherwise).
+		healthHandler.SetValidation(nil)
+	}
+	if s.hotReloadHub != nil {
+		healthHandler.SetSSEClients(s.hotReloadHub.ClientCount)
 	}
+	s.health = healthHandler
+	s.CheckMaintenance()
+	// Non-session-key REP endpoints share one CORS policy so preflights
+	// get a 204 instead of the handlers' 405.
+	origins, err := cfg.Origins()
+	if err != nil {
+		return nil, err
+	}
+	s.origins = cors.NewOrigins(origins)
+	corsPolicy := cors.Policy{Origins: s.origins}
+	mux.Handle("/rep/health", corsPolicy.Wrap(s.rateLimit("health", healthHandler), http.MethodGet))
+	mux.Handle("/rep/ready", corsPolicy.Wrap(s.rateLimit("ready", healthHandler.ReadyHandler()), http.MethodGet))
+	mux.Handle("/rep/compat", corsPolicy.Wrap(s.rateLimit("compat", compatHandler(logger)), http.MethodGet))
+
+	// Session key endpoint (§4.4) — only while the payload has encrypted
+	// vars. It is registered regardless and gated per request, so that the
+	// key_endpoint advertised by the served payload always resolves, even
+	// when a reload adds the first SENSITIVE variable.
+	s.keyEndpoint.Store(p.Meta.KeyEndpoint != "")
+	s.sessionKey = repcrypto.NewSessionKeyHandler(
+		keys.EncryptionKey,
+		cfg.SessionKeyTTL,
+		cfg.SessionKeyMaxRate,
+		origins,
+		logger,
+	)
+	s.sessionKey.SetReplicaID(cfg.ReplicaID)
+	s.sessionKey.SetClockSkew(cfg.SessionKeySkew)
+	mux.HandleFunc("/rep/session-key", func(w http.ResponseWriter, r *http.Request) {
+		if !s.keyEndpoint.Load() {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodOptions {
+			s.sessionKey.CORSPreflight(w, r)
+			return
+		}
+		s.sessionKey.ServeHTTP(w, r)
+	})
 
 	// Hot reload SSE endpoint (§4.6).
 	if cfg.HotReload && s.hotReloadHub != nil {
-		mux.Handle("/rep/changes", hotreload.NewHandler(s.hotReloadHub))
+		changes := hotreload.NewHandler(s.hotReloadHub,
+			hotreload.WithMaxConnectionsPerIP(cfg.SSEMaxPerIP),
+			hotreload.WithCompression(cfg.SSEGzip),
+		)
+		mux.Handle("/rep/changes", corsPolicy.Wrap(s.rateLimit("changes", changes), http.MethodGet))
 	}
 
-	// All other requests go through the injection middleware.
-	mux.Handle("/", s.injector)
+	// Effective configuration dump, for debugging precedence issues.
+	if cfg.ConfigEndpoint {
+		mux.Handle("/rep/config/effective", corsPolicy.Wrap(effectiveConfigHandler(cfg, logger), http.MethodGet))
+	}
 
-	s.httpServer = &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.Port),
-		Handler:      mux,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+	// Runtime statistics, for diagnosing leaks. /rep/debug/ is reserved
+	// either way, so it never falls through to the app (or its SPA
+	// fallback) when disabled.
+	if cfg.DebugEndpoint {
+		mux.Handle("/rep/debug/vars", debugVarsHandler(cfg.DebugToken, logger))
+	}
+	mux.Handle("/rep/debug/", http.NotFoundHandler())
+
+	// All other requests go through the injection middleware.
+	var app http.Handler = s.injector
+	if cfg.EarlyHints {
+		app = earlyHints(app, s.keyEndpoint.Load)
+	}
+	if cfg.MaxRequestBody > 0 {
+		app = limitRequestBody(app, int64(cfg.MaxRequestBody))
 	}
+	mux.Handle("/", allowMethods(app, cfg.AllowedMethods))
 
-	// Optional separate health server.
+	s.httpServer = newHTTPServer(cfg.Port, serverHeader(mux, cfg.ServerHeader, cfg.Mode == "embedded"))
+
+	// Optional separate health server. It serves orchestrator probes, so
+	// --rate-limits does not apply to it.
 	if cfg.HealthPort > 0 && cfg.HealthPort != cfg.Port {
 		healthMux := http.NewServeMux()
-		healthMux.Handle("/rep/health", healthHandler)
+		healthMux.Handle("/rep/health", corsPolicy.Wrap(healthHandler, http.MethodGet))
+		healthMux.Handle("/rep/ready", corsPolicy.Wrap(healthHandler.ReadyHandler(), http.MethodGet))
 		s.healthServer = &http.Server{
 			Addr:    fmt.Sprintf(":%d", cfg.HealthPort),
-			Handler: healthMux,
+			Handler: serverHeader(healthMux, cfg.ServerHeader, true),
+		}
+	}
+
+	// Optional pprof server, bound to its own (by default loopback)
+	// address so profiles are never reachable through the main port.
+	if cfg.EnablePprof {
+		s.pprofServer = &http.Server{
+			Addr:              cfg.PprofAddr,
+			Handler:           serverHeader(pprofHandler(), cfg.ServerHeader, true),
+			ReadHeaderTimeout: 10 * time.Second,
 		}
 	}
 
@@ -195,35 +388,87 @@ func New(cfg *config.Config, logger *slog.Logger, version string) (*Server, erro
 	return s, nil
 }
 
+// requiredGracePoll is how often startup re-reads the environment while
+// waiting out --required-grace for missing required variables.
+var requiredGracePoll = 250 * time.Millisecond
+
+// classifyAndValidate reads and classifies variables and validates them
+// against the manifest, if any. When required variables are missing and
+// --required-grace is set, it keeps retrying until they appear or the grace
+// period elapses, so secrets injected just after container start don't
+// cause a crash loop.
+func (s *Server) classifyAndValidate(read func() (*config.ClassifiedVars, error)) (*config.ClassifiedVars, error) {
+	deadline := time.Now().Add(s.cfg.RequiredGrace)
+
+	for attempt := 1; ; attempt++ {
+		vars, err := read()
+		if err != nil {
+			return nil, fmt.Errorf("classifying variables: %w", err)
+		}
+		s.applyDefaults(vars)
+		if s.cfg.Manifest == nil {
+			return vars, nil
+		}
+
+		if attempt == 1 {
+			s.logger.Info("validating environment variables against manifest", "manifest", s.cfg.ManifestPath)
+		}
+		violations := s.cfg.Manifest.ValidateDetailed(
+			vars.PublicMap(),
+			vars.SensitiveMap(),
+			vars.ServerMap(),
+			func(msg string, args ...any) { s.logger.Warn(msg, args...) },
+		)
+		if len(violations) == 0 {
+			return vars, nil
+		}
+
+		var missing []string
+		for _, v := range violations {
+			if v.Kind == manifest.KindMissingRequired || v.Kind == manifest.KindRequiresIf {
+				missing = append(missing, v.Name)
+			}
+		}
+		if len(missing) > 0 && time.Now().Before(deadline) {
+			s.logger.Info("rep.manifest.waiting_for_required",
+				"missing", missing,
+				"attempt", attempt,
+				"remaining", time.Until(deadline).Round(time.Millisecond).String(),
+			)
+			time.Sleep(requiredGracePoll)
+			continue
+		}
+
+		return nil, fmt.Errorf("manifest validation: %w", manifest.ViolationsError(violations))
+	}
+}
+
+// Handler returns the main HTTP handler (the /rep/* endpoints plus the
+// injecting proxy or file server), e.g. for use with httptest.NewServer.
+func (s *Server) Handler() http.Handler {
+	return s.httpServer.Handler
+}
+
 // Start begins serving HTTP requests. Blocks until context is cancelled.
 func (s *Server) Start(ctx context.Context) error {
-	// Start optional separate health server.
-	if s.healthServer != nil {
+	var errCh <-chan error
+	if s.startup != nil {
+		// The ports are already bound and serving an initializing status.
+		errCh = s.startup.handOver(s)
+		s.logger.Info("gateway listening", "addr", s.startup.Addr().String())
+	} else {
+		errCh = s.listenAndServe()
+	}
+
+	if s.pprofServer != nil {
 		go func() {
-			s.logger.Info("health server starting", "addr", s.healthServer.Addr)
-			if err := s.healthServer.ListenAndServe(); err != http.ErrServerClosed {
-				s.logger.Error("health server error", "error", err)
+			s.logger.Warn("pprof server starting", "addr", s.pprofServer.Addr)
+			if err := s.pprofServer.ListenAndServe(); err != http.ErrServerClosed {
+				s.logger.Error("pprof server error", "error", err)
 			}
 		}()
 	}
 
-	// Start main server in a goroutine.
-	errCh := make(chan error, 1)
-	go func() {
-		s.logger.Info("gateway listening", "addr", s.httpServer.Addr)
-
-		var err error
-		if s.cfg.TLSCert != "" && s.cfg.TLSKey != "" {
-			err = s.httpServer.ListenAndServeTLS(s.cfg.TLSCert, s.cfg.TLSKey)
-		} else {
-			err = s.httpServer.ListenAndServe()
-		}
-
-		if err != http.ErrServerClosed {
-			errCh <- err
-		}
-	}()
-
 	// Start hot reload watcher for file_watch and poll modes.
 	if s.cfg.HotReload {
 		switch s.cfg.HotReloadMode {
@@ -234,6 +479,9 @@ func (s *Server) Start(ctx context.Context) error {
 		}
 	}
 
+	// Emit injection summaries on their own interval until shutdown.
+	go s.injector.RunSummary(ctx)
+
 	// Wait for shutdown signal or error.
 	select {
 	case <-ctx.Done():
@@ -253,6 +501,11 @@ func (s *Server) Start(ctx context.Context) error {
 				s.logger.Error("health server shutdown error", "error", err)
 			}
 		}
+		if s.pprofServer != nil {
+			if err := s.pprofServer.Shutdown(shutdownCtx); err != nil {
+				s.logger.Error("pprof server shutdown error", "error", err)
+			}
+		}
 		return s.httpServer.Shutdown(shutdownCtx)
 
 	case err := <-errCh:
@@ -265,6 +518,38 @@ func (s *Server) Start(ctx context.Context) error {
 // stat-polling a single file is cheap and dev workflows expect fast feedback.
 const fileWatchDefaultInterval = 2 * time.Second
 
+// listenAndServe starts the optional health server and the main server,
+// returning the channel on which the main server reports a fatal error.
+func (s *Server) listenAndServe() <-chan error {
+	// Start optional separate health server.
+	if s.healthServer != nil {
+		go func() {
+			s.logger.Info("health server starting", "addr", s.healthServer.Addr)
+			if err := s.healthServer.ListenAndServe(); err != http.ErrServerClosed {
+				s.logger.Error("health server error", "error", err)
+			}
+		}()
+	}
+
+	// Start main server in a goroutine.
+	errCh := make(chan error, 1)
+	go func() {
+		s.logger.Info("gateway listening", "addr", s.httpServer.Addr)
+
+		var err error
+		if s.cfg.TLSCert != "" && s.cfg.TLSKey != "" {
+			err = s.httpServer.ListenAndServeTLS(s.cfg.TLSCert, s.cfg.TLSKey)
+		} else {
+			err = s.httpServer.ListenAndServe()
+		}
+
+		if err != http.ErrServerClosed {
+			errCh <- err
+		}
+	}()
+	return errCh
+}
+
 // runFileWatcher runs a background goroutine that watches a file for mtime
 // changes and triggers Reload() when a change is detected.
 // This implements the "file_watch" hot reload mode (REP-RFC-0001 §4.6).
@@ -320,30 +605,46 @@ func (s *Server) runFileWatcher(ctx context.Context) {
 	}
 }
 
-// runPoller runs a background goroutine that re-reads environment variables on
-// every PollInterval tick and triggers Reload() when any change is detected.
+// runPoller runs a background goroutine that re-reads variables on every
+// PollInterval tick and triggers Reload() when any change is detected.
 // This implements the "poll" hot reload mode (REP-RFC-0001 §4.6).
+//
+// The kernel never updates a running process's environment, so os.Environ
+// is effectively static; poll mode is only useful when variables come from
+// --env-file or --env-dir, which are re-read on every tick.
 func (s *Server) runPoller(ctx context.Context) {
 	interval := s.cfg.PollInterval
 	if interval <= 0 {
 		interval = 30 * time.Second
 	}
 
+	if s.cfg.EnvFile == "" && s.cfg.EnvDir == "" {
+		s.logger.Warn("rep.hotreload.poll.no_source",
+			"detail", "process environment cannot change after startup; set --env-file or --env-dir for poll mode to detect changes",
+		)
+	}
+
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	s.logger.Info("rep.hotreload.poll.started", "interval", interval.String())
+	s.logger.Info("rep.hotreload.poll.started",
+		"interval", interval.String(),
+		"env_file", s.cfg.EnvFile,
+		"env_dir", s.cfg.EnvDir,
+	)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			newVars, err := config.ReadAndClassify(s.cfg.EnvFile)
+			newVars, err := config.ReadAndClassifyFrom(s.cfg.Sources())
 			if err != nil {
 				s.logger.Error("rep.hotreload.poll.classify_error", "error", err)
 				continue
 			}
+			newVars.ApplyDefaults(s.cfg.Manifest)
+			newVars, _ = pinBuildTime(s.cfg.Manifest, s.vars, newVars)
 			if varsChanged(s.vars, newVars) {
 				s.logger.Info("rep.hotreload.poll.changed")
 				if err := s.Reload(); err != nil {
@@ -354,50 +655,186 @@ func (s *Server) runPoller(ctx context.Context) {
 	}
 }
 
-// varsChanged returns true when any public variable value has changed between
-// old and new. Sensitive and server variables are not compared because they
-// are never exposed to the client in plain text.
+// varsChanged returns true when any variable value in any tier has changed
+// between old and new. Sensitive values feed the encrypted blob and server
+// values feed manifest validation, so all tiers are compared even though
+// only public changes are broadcast to clients.
 func varsChanged(old, new *config.ClassifiedVars) bool {
 	if old == nil || new == nil {
 		return true
 	}
-	oldMap := old.PublicMap()
-	newMap := new.PublicMap()
-	if len(oldMap) != len(newMap) {
-		return true
+	return !mapsEqual(old.PublicMap(), new.PublicMap()) ||
+		!mapsEqual(old.SensitiveMap(), new.SensitiveMap()) ||
+		!mapsEqual(old.ServerMap(), new.ServerMap())
+}
+
+// builderOptions returns the payload builder options derived from the
+// configuration, shared by startup and reload.
+func (s *Server) builderOptions() []payload.BuilderOption {
+	opts := []payload.BuilderOption{
+		payload.WithEncryptedPublic(s.cfg.EncryptPublic),
+		payload.WithEnvironment(s.cfg.Environment),
+		payload.WithReplicaID(s.cfg.ReplicaID),
+		payload.WithScriptTemplate(s.scriptTemplate),
+	}
+	if s.cfg.MetaTypes && s.cfg.Manifest != nil {
+		types := make(map[string]string, len(s.cfg.Manifest.Variables))
+		for name, decl := range s.cfg.Manifest.Variables {
+			types[name] = decl.Type
+		}
+		opts = append(opts, payload.WithTypes(types))
+	}
+	return opts
+}
+
+// applyDefaults fills in manifest defaults for absent variables, logging
+// each one applied and each ${VAR} reference left unresolved.
+func (s *Server) applyDefaults(vars *config.ClassifiedVars) {
+	applied, unresolved := vars.ApplyDefaults(s.cfg.Manifest)
+	for _, name := range applied {
+		s.logger.Debug("rep.manifest.default_applied", "name", name)
+	}
+	for _, ref := range unresolved {
+		s.logger.Warn("rep.manifest.default_unresolved", "reference", ref)
+	}
+}
+
+// pinBuildTime returns newVars with every variable the manifest declares as
+// build-time (reload: false) restored to its state in oldVars: changed values
+// are reverted, additions dropped and removals undone. It also returns the
+// names of build-time variables whose reloaded state differed.
+func pinBuildTime(m *manifest.Manifest, oldVars, newVars *config.ClassifiedVars) (*config.ClassifiedVars, []string) {
+	if m == nil || oldVars == nil {
+		return newVars, nil
 	}
-	for k, v := range newMap {
-		if oldMap[k] != v {
-			return true
+
+	var ignored []string
+	pin := func(old, cur []config.Variable) []config.Variable {
+		before := make(map[string]string)
+		out := make([]config.Variable, 0, len(cur))
+		for _, v := range old {
+			if m.IsBuildTime(v.Name) {
+				before[v.Name] = v.Value
+				out = append(out, v)
+			}
 		}
+		for _, v := range cur {
+			if !m.IsBuildTime(v.Name) {
+				out = append(out, v)
+				continue
+			}
+			if val, ok := before[v.Name]; !ok || val != v.Value {
+				ignored = append(ignored, v.Name)
+			}
+			delete(before, v.Name)
+		}
+		for name := range before {
+			ignored = append(ignored, name) // Removed on reload.
+		}
+		return out
+	}
+
+	pinned := &config.ClassifiedVars{
+		Public:    pin(oldVars.Public, newVars.Public),
+		Sensitive: pin(oldVars.Sensitive, newVars.Sensitive),
+		Server:    pin(oldVars.Server, newVars.Server),
+	}
+	sort.Strings(ignored)
+	return pinned, ignored
+}
+
+// mapsEqual reports whether a and b hold the same keys and values.
+func mapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
 	}
-	for k := range oldMap {
-		if _, ok := newMap[k]; !ok {
-			return true
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
 		}
 	}
-	return false
+	return true
 }
 
-// Reload re-reads environment variables and rebuilds the payload.
-// Used for hot reload (SIGHUP signal mode).
+// reloadErrorMessage is sent to SSE clients when a reload fails. The
+// underlying error is only logged, since /rep/changes is public.
+const reloadErrorMessage = "configuration reload failed; serving the previous configuration"
+
+// Reload re-reads environment variables and rebuilds the payload, and
+// re-checks maintenance mode. Used for hot reload (SIGHUP signal mode).
+//
+// If the reload fails, the previous payload keeps being served; the failure
+// is surfaced as stale in /rep/health and as a rep:config:error SSE event so
+// that operators and clients don't assume the configuration is current.
+// Every reload's outcome and duration are counted in /rep/health.
 func (s *Server) Reload() error {
+	// Maintenance mode follows --maintenance-file even if the reload fails.
+	s.CheckMaintenance()
+
+	start := time.Now()
+	err := s.reload()
+	elapsed := time.Since(start)
+	if s.health != nil {
+		s.health.RecordReload(elapsed, err != nil)
+	}
+	if err != nil {
+		s.logger.Warn("rep.config.reload", "outcome", "failed", "duration_ms", elapsed.Milliseconds(), "error", err)
+	} else {
+		s.logger.Info("rep.config.reload", "outcome", "ok", "duration_ms", elapsed.Milliseconds())
+	}
+	if err != nil && s.hotReloadHub != nil {
+		s.hotReloadHub.Broadcast(hotreload.Event{
+			Type:  "rep:config:error",
+			Error: reloadErrorMessage,
+		})
+	}
+	return err
+}
+
+// reload performs a Reload, returning the first error encountered.
+func (s *Server) reload() error {
 	s.logger.Info("reloading configuration")
 
+	// Re-read the origin allowlist; it is applied with the new payload.
+	origins, err := s.cfg.Origins()
+	if err != nil {
+		return fmt.Errorf("re-reading allowed origins: %w", err)
+	}
+
 	// Re-read and classify.
-	vars, err := config.ReadAndClassify(s.cfg.EnvFile)
+	vars, err := config.ReadAndClassifyFrom(s.cfg.Sources())
 	if err != nil {
 		return fmt.Errorf("re-classifying variables: %w", err)
 	}
+	s.applyDefaults(vars)
 
-	// Detect changes and broadcast.
-	if s.hotReloadHub != nil {
-		s.broadcastChanges(s.vars, vars)
+	// Build-time variables (reload: false) keep their startup values.
+	vars, ignored := pinBuildTime(s.cfg.Manifest, s.vars, vars)
+	for _, name := range ignored {
+		s.logger.Info("rep.hotreload.build_time_ignored", "name", name)
 	}
 
-	// Rebuild payload.
-	builder := payload.NewBuilder(s.keys, s.version, s.cfg.HotReload)
-	p, err := builder.Build(vars)
+	// Re-validate against the manifest. Unlike at startup, a failure does not
+	// stop the reload; it is surfaced through /rep/health and /rep/ready.
+	if s.cfg.Manifest != nil {
+		violations := s.cfg.Manifest.ValidateDetailed(
+			vars.PublicMap(),
+			vars.SensitiveMap(),
+			vars.ServerMap(),
+			func(msg string, args ...any) { s.logger.Warn(msg, args...) },
+		)
+		for _, v := range violations {
+			s.logger.Warn("rep.manifest.violation", "name", v.Name, "kind", v.Kind, "detail", v.Message)
+		}
+		if s.health != nil {
+			s.health.SetValidation(violations)
+		}
+	}
+
+	// Rebuild payload, keeping encrypted blobs whose contents are unchanged
+	// so clients holding them are not needlessly invalidated.
+	builder := payload.NewBuilder(s.keys, s.version, s.cfg.HotReload, s.builderOptions()...)
+	p, err := builder.Rebuild(s.payload, s.vars, vars)
 	if err != nil {
 		return fmt.Errorf("rebuilding payload: %w", err)
 	}
@@ -407,9 +844,34 @@ func (s *Server) Reload() error {
 		return fmt.Errorf("re-rendering script tag: %w", err)
 	}
 
-	// Update the injector.
+	// Update the injector, session-key endpoint and CORS allowlist. The
+	// key endpoint is enabled before, and disabled after, the payload that
+	// does or no longer advertises it is served.
+	needsKey := p.Meta.KeyEndpoint != ""
+	if needsKey {
+		s.keyEndpoint.Store(true)
+	}
 	s.injector.UpdateScriptTag(scriptTag)
+	if s.cfg.PublicHeaders {
+		s.injector.UpdatePublicHeaders(inject.PublicHeaders(vars.PublicMap(), s.logger))
+	}
+	if !needsKey {
+		s.keyEndpoint.Store(false)
+	}
+	s.origins.Set(origins)
+	s.sessionKey.SetAllowedOrigins(origins)
+
+	// Detect changes and broadcast, now that the new payload is being
+	// served. Encrypted public values must not be sent in the clear over
+	// SSE; clients see them on their next page load.
+	if s.hotReloadHub != nil && !s.cfg.EncryptPublic {
+		s.broadcastChanges(s.vars, vars)
+	}
 	s.vars = vars
+	s.payload = p
+	if s.health != nil {
+		s.health.SetVars(vars)
+	}
 
 	s.logger.Info("configuration reloaded",
 		"public_vars", len(vars.Public),
@@ -449,6 +911,17 @@ func (s *Server) broadcastChanges(oldVars, newVars *config.ClassifiedVars) {
 	}
 }
 
+// newHTTPServer returns the main gateway server for port.
+func newHTTPServer(port int, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      handler,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+}
+
 // createReverseProxy sets up a reverse proxy to the upstream server.
 func (s *Server) createReverseProxy() (http.Handler, error) {
 	upstream := s.cfg.Upstream
@@ -462,21 +935,265 @@ func (s *Server) createReverseProxy() (http.Handler, error) {
 	}
 
 	proxy := httputil.NewSingleHostReverseProxy(target)
-	proxy.Transport = &http.Transport{
+	if prefix := strings.TrimSuffix(s.cfg.StripPrefix, "/"); prefix != "" {
+		stripPrefix(proxy, target, prefix)
+	}
+	transport, err := s.upstreamTransport()
+	if err != nil {
+		return nil, err
+	}
+	proxy.Transport = transport
+	proxy.ErrorHandler = s.proxyError
+
+	return proxy, nil
+}
+
+// proxyError replaces ReverseProxy's default error handler so that a body
+// cut off by limitRequestBody is reported as 413 rather than 502.
+func (s *Server) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	s.logger.Warn("rep.upstream.error", "path", r.URL.Path, "error", err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// upstreamTransport builds the proxy's transport from the --upstream-*
+// connection and TLS flags. Zero pool settings take the flag defaults and
+// negative ones mean no limit (see config.Config.UpstreamMaxIdleConns).
+func (s *Server) upstreamTransport() (*http.Transport, error) {
+	tlsConfig, err := s.upstreamTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Transport{
 		DialContext: (&net.Dialer{
 			Timeout:   5 * time.Second,
 			KeepAlive: 30 * time.Second,
 		}).DialContext,
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 20,
-		IdleConnTimeout:     90 * time.Second,
+		TLSClientConfig:     tlsConfig,
+		ForceAttemptHTTP2:   s.cfg.UpstreamHTTP2,
+		MaxIdleConns:        poolLimit(s.cfg.UpstreamMaxIdleConns, config.DefaultUpstreamMaxIdleConns),
+		MaxIdleConnsPerHost: poolLimit(s.cfg.UpstreamMaxIdleConnsPerHost, config.DefaultUpstreamMaxIdleConnsPerHost),
+		IdleConnTimeout:     poolLimit(s.cfg.UpstreamIdleConnTimeout, config.DefaultUpstreamIdleConnTimeout),
+	}, nil
+}
+
+// poolLimit maps a pool setting to its http.Transport value: zero takes def
+// and a negative value becomes 0, which the transport reads as no limit.
+func poolLimit[T int | time.Duration](v, def T) T {
+	switch {
+	case v == 0:
+		return def
+	case v < 0:
+		return 0
 	}
+	return v
+}
 
-	return proxy, nil
+// upstreamTLSConfig builds the TLS client config for an https upstream from
+// the --upstream-* flags. It returns nil, keeping Go's defaults, when none
+// are set.
+func (s *Server) upstreamTLSConfig() (*tls.Config, error) {
+	cfg := s.cfg
+	if cfg.UpstreamCA == "" && cfg.UpstreamClientCert == "" && !cfg.UpstreamInsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.UpstreamCA != "" {
+		pem, err := os.ReadFile(cfg.UpstreamCA)
+		if err != nil {
+			return nil, fmt.Errorf("reading upstream CA: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("upstream CA %s contains no PEM certificates", cfg.UpstreamCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.UpstreamClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.UpstreamClientCert, cfg.UpstreamClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading upstream client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.UpstreamInsecureSkipVerify {
+		s.logger.Warn("rep.upstream.insecure_skip_verify",
+			"upstream", cfg.Upstream,
+			"detail", "upstream TLS certificates are not verified",
+		)
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
+
+// stripPrefix makes proxy remove prefix from request paths before they are
+// forwarded, and re-add it to redirect Locations that point back at the app
+// (host-relative, or absolute on the gateway's or upstream's host), so clients stay
+// under the prefix the gateway serves the app at.
+func stripPrefix(proxy *httputil.ReverseProxy, target *url.URL, prefix string) {
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		if p, ok := trimPathPrefix(r.URL.Path, prefix); ok {
+			r.URL.Path = p
+			if r.URL.RawPath != "" {
+				r.URL.RawPath, _ = trimPathPrefix(r.URL.RawPath, prefix)
+			}
+		}
+		director(r)
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		loc := resp.Header.Get("Location")
+		if loc == "" {
+			return nil
+		}
+		u, err := url.Parse(loc)
+		if err != nil || !strings.HasPrefix(u.Path, "/") {
+			return nil // Unparseable or relative to the current path.
+		}
+		switch u.Host {
+		case "", resp.Request.Host:
+			// Host-relative, or absolute on the host the client used.
+		case target.Host:
+			// Point absolute upstream URLs back at the gateway.
+			u.Scheme, u.Host = "", ""
+		default:
+			return nil // Redirect to another site.
+		}
+		u.Path = prefix + u.Path
+		if u.RawPath != "" {
+			u.RawPath = prefix + u.RawPath
+		}
+		resp.Header.Set("Location", u.String())
+		return nil
+	}
+}
+
+// trimPathPrefix removes prefix from path when it matches a whole path
+// segment ("/app" matches "/app" and "/app/x" but not "/apple").
+func trimPathPrefix(path, prefix string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || (rest != "" && rest[0] != '/') {
+		return path, false
+	}
+	if rest == "" {
+		rest = "/"
+	}
+	return rest, true
+}
+
+// rateLimit wraps the handler for the named /rep/ endpoint with a per-IP
+// limiter when --rate-limits sets one for it. Limits sit inside the CORS
+// wrapper so preflight requests are not counted.
+func (s *Server) rateLimit(endpoint string, next http.Handler) http.Handler {
+	n := s.cfg.RateLimits[endpoint]
+	if n <= 0 {
+		return next
+	}
+	return ratelimit.New(n).Wrap(next, "/rep/"+endpoint, s.logger)
+}
+
+// serverHeader wraps next so that responses carry a Server header of value.
+// It applies to /rep/* paths, or to every path when all is set; proxied
+// responses keep the upstream's own header. An empty value leaves next as is.
+func serverHeader(next http.Handler, value string, all bool) http.Handler {
+	if value == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if all || strings.HasPrefix(r.URL.Path, "/rep/") {
+			w.Header().Set("Server", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowMethods wraps next so that requests whose method is not in methods
+// receive 405 with an Allow header instead of reaching next. A nil methods
+// allows everything.
+func allowMethods(next http.Handler, methods []string) http.Handler {
+	if methods == nil {
+		return next
+	}
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(methods, r.Method) {
+			w.Header().Set("Allow", allow)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitRequestBody wraps next so that request bodies larger than max bytes
+// get 413. Requests that declare a larger Content-Length are refused before
+// reaching next; others are streamed through http.MaxBytesReader, which
+// fails the read once max is exceeded.
+func limitRequestBody(next http.Handler, max int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// effectiveConfigHandler serves cfg.Effective() as JSON.
+func effectiveConfigHandler(cfg *config.Config, logger *slog.Logger) http.Handler {
+	effective := cfg.Effective()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(effective); err != nil {
+			logger.Error("rep.config.encode_error", "error", err)
+		}
+	})
+}
+
+// earlyHintsLink is sent in 103 Early Hints so the browser opens its
+// connection for the SDK's session-key fetch while the upstream renders.
+const earlyHintsLink = "</rep/session-key>; rel=preconnect"
+
+// earlyHints wraps next so that HTML navigations over HTTP/2 or later first
+// receive a 103 Early Hints response, while advertised reports that the
+// payload names a key endpoint; without one there is nothing to hint.
+// HTTP/1.1 clients are skipped, since some mishandle 1xx responses. The Link
+// header is sent on the 103 only, not on the final response.
+func earlyHints(next http.Handler, advertised func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor >= 2 && r.Method == http.MethodGet &&
+			strings.Contains(r.Header.Get("Accept"), "text/html") && advertised() {
+			h := w.Header()
+			links := h["Link"]
+			h["Link"] = append(slices.Clip(links), earlyHintsLink)
+			w.WriteHeader(http.StatusEarlyHints)
+			if links == nil {
+				h.Del("Link")
+			} else {
+				h["Link"] = links
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // createFileServer sets up a static file server for embedded mode.
-func (s *Server) createFileServer() http.Handler {
+func (s *Server) createFileServer() (http.Handler, error) {
 	dir := s.cfg.StaticDir
 	absDir, err := filepath.Abs(dir)
 	if err != nil {
@@ -485,10 +1202,26 @@ func (s *Server) createFileServer() http.Handler {
 
 	s.logger.Info("serving static files", "directory", absDir)
 
-	fs := http.FileServer(http.Dir(absDir))
+	// Symlinks resolving outside the directory are refused unless
+	// --static-follow-symlinks is set.
+	var root http.FileSystem = http.Dir(absDir)
+	if !s.cfg.StaticFollowSymlinks {
+		root, err = newContainedDir(absDir, func(name string) {
+			s.logger.Warn("rep.static.symlink_outside_root", "path", name)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("resolving static directory: %w", err)
+		}
+	}
+	fs := http.FileServer(root)
 
 	// Wrap with SPA fallback: if a file is not found, serve index.html.
+	// With --spa-paths, only paths under those prefixes fall back.
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.DisableDirRedirects && serveDirIndex(w, r, root) {
+			return
+		}
+
 		// Try to serve the file directly.
 		// For SPA routing, we want to serve index.html for non-file paths.
 		path := r.URL.Path
@@ -498,7 +1231,65 @@ func (s *Server) createFileServer() http.Handler {
 		}
 
 		// For paths without extensions (likely SPA routes), serve index.html.
-		r.URL.Path = "/"
+		prefix, ok := spaPrefix(path, s.cfg.SPAPaths)
+		if !ok {
+			fs.ServeHTTP(w, r)
+			return
+		}
+		r.URL.Path = strings.TrimSuffix(prefix, "/") + "/"
 		fs.ServeHTTP(w, r)
-	})
+	}), nil
+}
+
+// spaPrefix returns the longest of prefixes that path falls under, matching
+// whole path segments ("/app" covers "/app/x" but not "/apple"). Nil
+// prefixes cover every path with "/".
+func spaPrefix(path string, prefixes []string) (string, bool) {
+	if prefixes == nil {
+		return "/", true
+	}
+	best, found := "", false
+	for _, p := range prefixes {
+		if p != "/" && path != p && !strings.HasPrefix(path, p+"/") {
+			continue
+		}
+		if !found || len(p) > len(best) {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
+
+// serveDirIndex serves the index.html for requests that http.FileServer
+// would answer with a redirect: a directory without a trailing slash, or
+// an explicit .../index.html. It reports whether it handled the request.
+func serveDirIndex(w http.ResponseWriter, r *http.Request, root http.FileSystem) bool {
+	name := r.URL.Path
+	if !strings.HasSuffix(name, "/index.html") {
+		if name == "/" || strings.HasSuffix(name, "/") {
+			return false // FileServer serves these without redirecting.
+		}
+		d, err := root.Open(name)
+		if err != nil {
+			return false
+		}
+		fi, err := d.Stat()
+		_ = d.Close()
+		if err != nil || !fi.IsDir() {
+			return false
+		}
+		name += "/index.html"
+	}
+
+	f, err := root.Open(name)
+	if err != nil {
+		return false
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		return false
+	}
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+	return true
 }
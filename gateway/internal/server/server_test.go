@@ -1,11 +1,21 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"encoding/pem"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,6 +24,7 @@ import (
 	"github.com/ruachtech/rep/gateway/internal/guardrails"
 	"github.com/ruachtech/rep/gateway/internal/health"
 	"github.com/ruachtech/rep/gateway/internal/inject"
+	"github.com/ruachtech/rep/gateway/internal/manifest"
 	"github.com/ruachtech/rep/gateway/pkg/payload"
 )
 
@@ -207,3 +218,1982 @@ func findSubstr(s, substr string) bool {
 	}
 	return false
 }
+
+// testConfig returns an embedded-mode config serving testdata/static,
+// adjusted by mutate if it is non-nil.
+func testConfig(mutate func(*config.Config)) *config.Config {
+	cfg := &config.Config{Mode: "embedded", StaticDir: "../../testdata/static"}
+	if mutate != nil {
+		mutate(cfg)
+	}
+	return cfg
+}
+
+// newTestServer builds a Server with New from testConfig(mutate) and serves
+// its handler until the test ends. A non-empty env is written to a
+// temporary .env file used as cfg.EnvFile; tests that reload rewrite
+// srv.cfg.EnvFile.
+func newTestServer(t *testing.T, env string, mutate func(*config.Config)) (*Server, *httptest.Server) {
+	t.Helper()
+	cfg := testConfig(mutate)
+	if env != "" {
+		cfg.EnvFile = filepath.Join(t.TempDir(), ".env")
+		if err := os.WriteFile(cfg.EnvFile, []byte(env), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv, err := New(cfg, slog.Default(), "0.1.0-test")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return srv, ts
+}
+
+func TestServer_ReloadValidationFlipsReadiness(t *testing.T) {
+	srv, ts := newTestServer(t, "REP_PUBLIC_API_URL=https://api.example.com\n", func(cfg *config.Config) {
+		cfg.Manifest = &manifest.Manifest{
+			Variables: map[string]*manifest.VarDecl{
+				"API_URL": {Tier: "public", Type: "url", Required: true},
+			},
+		}
+	})
+	envFile := srv.cfg.EnvFile
+
+	readyStatus := func() int {
+		resp, err := http.Get(ts.URL + "/rep/ready")
+		if err != nil {
+			t.Fatalf("GET error: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := readyStatus(); code != http.StatusOK {
+		t.Fatalf("expected ready before reload, got %d", code)
+	}
+
+	// Remove the required variable and reload.
+	if err := os.WriteFile(envFile, []byte("REP_PUBLIC_OTHER=x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if code := readyStatus(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 after reload dropped a required var, got %d", code)
+	}
+
+	resp, err := http.Get(ts.URL + "/rep/health")
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var healthResp health.Response
+	if err := json.NewDecoder(resp.Body).Decode(&healthResp); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if healthResp.Validation == nil || healthResp.Validation.Passed {
+		t.Fatalf("expected failed validation in health, got %+v", healthResp.Validation)
+	}
+	if len(healthResp.Validation.Names) != 1 || healthResp.Validation.Names[0] != "API_URL" {
+		t.Errorf("expected API_URL violation, got %v", healthResp.Validation.Names)
+	}
+}
+
+func TestServer_RequiredGrace(t *testing.T) {
+	prev := requiredGracePoll
+	requiredGracePoll = 10 * time.Millisecond
+	t.Cleanup(func() { requiredGracePoll = prev })
+
+	newCfg := func(envFile string, grace time.Duration) *config.Config {
+		return testConfig(func(cfg *config.Config) {
+			cfg.EnvFile = envFile
+			cfg.RequiredGrace = grace
+			cfg.Manifest = &manifest.Manifest{
+				Variables: map[string]*manifest.VarDecl{
+					"API_URL": {Tier: "public", Type: "url", Required: true},
+				},
+			}
+		})
+	}
+
+	t.Run("variable appears within grace", func(t *testing.T) {
+		envFile := filepath.Join(t.TempDir(), ".env")
+		if err := os.WriteFile(envFile, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_ = os.WriteFile(envFile, []byte("REP_PUBLIC_API_URL=https://api.example.com\n"), 0o644)
+		}()
+
+		srv, err := New(newCfg(envFile, 5*time.Second), slog.Default(), "0.1.0-test")
+		if err != nil {
+			t.Fatalf("expected startup to succeed within grace, got %v", err)
+		}
+		if srv.vars.PublicMap()["API_URL"] != "https://api.example.com" {
+			t.Errorf("expected API_URL from late env file, got %v", srv.vars.PublicMap())
+		}
+	})
+
+	t.Run("grace elapses", func(t *testing.T) {
+		envFile := filepath.Join(t.TempDir(), ".env")
+		if err := os.WriteFile(envFile, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		_, err := New(newCfg(envFile, 50*time.Millisecond), slog.Default(), "0.1.0-test")
+		if err == nil {
+			t.Fatal("expected startup failure after grace elapsed")
+		}
+		if time.Since(start) < 50*time.Millisecond {
+			t.Error("expected startup to wait out the grace period")
+		}
+	})
+}
+
+func TestVarsChanged(t *testing.T) {
+	base := &config.ClassifiedVars{
+		Public:    []config.Variable{{Name: "A", Value: "1"}},
+		Sensitive: []config.Variable{{Name: "B", Value: "2"}},
+		Server:    []config.Variable{{Name: "C", Value: "3"}},
+	}
+	same := &config.ClassifiedVars{
+		Public:    []config.Variable{{Name: "A", Value: "1"}},
+		Sensitive: []config.Variable{{Name: "B", Value: "2"}},
+		Server:    []config.Variable{{Name: "C", Value: "3"}},
+	}
+	sensitiveChanged := &config.ClassifiedVars{
+		Public:    []config.Variable{{Name: "A", Value: "1"}},
+		Sensitive: []config.Variable{{Name: "B", Value: "changed"}},
+		Server:    []config.Variable{{Name: "C", Value: "3"}},
+	}
+
+	if varsChanged(base, same) {
+		t.Error("identical vars should not be reported as changed")
+	}
+	if !varsChanged(base, sensitiveChanged) {
+		t.Error("sensitive value change should be detected")
+	}
+}
+
+// fetchBody issues a GET and returns the response body.
+func fetchBody(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read error: %v", err)
+	}
+	return string(b)
+}
+
+func TestServer_PollDetectsSourceChanges(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, ".env")
+	envDir := filepath.Join(dir, "vars")
+	if err := os.Mkdir(envDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(envFile, []byte("REP_PUBLIC_FROM_FILE=one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(envDir, "REP_PUBLIC_FROM_DIR"), []byte("alpha\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, ts := newTestServer(t, "", func(cfg *config.Config) {
+		cfg.EnvFile = envFile
+		cfg.EnvDir = envDir
+		cfg.HotReload = true
+		cfg.HotReloadMode = "poll"
+		cfg.PollInterval = 20 * time.Millisecond
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.runPoller(ctx)
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if cond() {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for %s", what)
+	}
+
+	if err := os.WriteFile(envFile, []byte("REP_PUBLIC_FROM_FILE=two\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("env file change", func() bool {
+		return containsStr(fetchBody(t, ts.URL+"/"), `"FROM_FILE":"two"`)
+	})
+
+	if err := os.WriteFile(filepath.Join(envDir, "REP_PUBLIC_FROM_DIR"), []byte("beta\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("env dir change", func() bool {
+		return containsStr(fetchBody(t, ts.URL+"/"), `"FROM_DIR":"beta"`)
+	})
+
+	// A sensitive-only change must also trigger a reload.
+	if err := os.WriteFile(filepath.Join(envDir, "REP_SENSITIVE_TOKEN"), []byte("secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("sensitive change", func() bool {
+		return containsStr(fetchBody(t, ts.URL+"/rep/health"), `"sensitive":1`)
+	})
+}
+
+func TestServer_PreflightOnREPEndpoints(t *testing.T) {
+	_, ts := newTestServer(t, "REP_PUBLIC_A=1\n", func(cfg *config.Config) {
+		cfg.AllowedOrigins = []string{"https://app.example.com"}
+	})
+
+	for _, path := range []string{"/rep/health", "/rep/ready"} {
+		t.Run(path, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodOptions, ts.URL+path, nil)
+			req.Header.Set("Origin", "https://app.example.com")
+			req.Header.Set("Access-Control-Request-Method", "GET")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("OPTIONS error: %v", err)
+			}
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != http.StatusNoContent {
+				t.Errorf("expected 204, got %d", resp.StatusCode)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+				t.Errorf("expected allow-origin header, got %q", got)
+			}
+		})
+	}
+}
+
+func TestServer_CustomErrorPage(t *testing.T) {
+	pagesDir := t.TempDir()
+	page := "<!DOCTYPE html><html><head><title>Lost</title></head><body>Custom not found</body></html>"
+	if err := os.WriteFile(filepath.Join(pagesDir, "404.html"), []byte(page), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, ts := newTestServer(t, "REP_PUBLIC_API_URL=https://api.example.com\n", func(cfg *config.Config) {
+		cfg.ErrorPageDir = pagesDir
+	})
+
+	resp, err := http.Get(ts.URL + "/missing.html")
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !containsStr(ct, "text/html") {
+		t.Errorf("expected text/html, got %q", ct)
+	}
+	if !containsStr(string(body), "Custom not found") {
+		t.Errorf("expected custom page, got %q", body)
+	}
+	if !containsStr(string(body), `id="__rep__"`) || !containsStr(string(body), "api.example.com") {
+		t.Error("expected REP payload injected into the error page")
+	}
+	if containsStr(string(body), "404 page not found") {
+		t.Error("default 404 body leaked into the custom page")
+	}
+
+	// Missing assets get the page too, streamed without injection.
+	if body := fetchBody(t, ts.URL+"/missing.js"); !containsStr(body, "Custom not found") || containsStr(body, "__rep__") {
+		t.Errorf("expected uninjected custom page for a missing asset, got %q", body)
+	}
+
+	// Existing files are unaffected.
+	if body := fetchBody(t, ts.URL+"/"); containsStr(body, "Custom not found") {
+		t.Error("custom page served for an existing file")
+	}
+}
+
+func TestServer_DirRedirects(t *testing.T) {
+	staticDir := t.TempDir()
+	page := func(title string) []byte {
+		return []byte("<!DOCTYPE html><html><head><title>" + title + "</title></head><body></body></html>")
+	}
+	if err := os.WriteFile(filepath.Join(staticDir, "index.html"), page("root"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(staticDir, "guide.v2"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(staticDir, "guide.v2", "index.html"), page("guide"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	vars := &config.ClassifiedVars{
+		Public: []config.Variable{{Name: "API_URL", Value: "https://api.example.com"}},
+	}
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(t *testing.T, url string) (*http.Response, string) {
+		t.Helper()
+		resp, err := noFollow.Get(url)
+		if err != nil {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	for _, tt := range []struct {
+		disable bool
+		path    string
+		want    int
+		detail  string // Location for redirects, page title otherwise.
+	}{
+		{false, "/guide.v2", http.StatusMovedPermanently, "guide.v2/"},
+		{false, "/index.html", http.StatusMovedPermanently, "./"},
+		{true, "/guide.v2", http.StatusOK, "guide"},
+		{true, "/guide.v2/index.html", http.StatusOK, "guide"},
+		{true, "/index.html", http.StatusOK, "root"},
+		{true, "/some/route", http.StatusOK, "root"},
+	} {
+		cfg := &config.Config{Mode: "embedded", StaticDir: staticDir, DisableDirRedirects: tt.disable}
+		srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", vars)
+		if err != nil {
+			t.Fatalf("NewFromVars: %v", err)
+		}
+		ts := httptest.NewServer(srv.Handler())
+		resp, body := get(t, ts.URL+tt.path)
+		ts.Close()
+
+		if resp.StatusCode != tt.want {
+			t.Errorf("disable=%v %s: expected %d, got %d", tt.disable, tt.path, tt.want, resp.StatusCode)
+			continue
+		}
+		if tt.want == http.StatusOK {
+			if !strings.Contains(body, "<title>"+tt.detail+"</title>") || !strings.Contains(body, `id="__rep__"`) {
+				t.Errorf("disable=%v %s: expected injected %q page, got %q", tt.disable, tt.path, tt.detail, body)
+			}
+		} else {
+			if loc := resp.Header.Get("Location"); loc != tt.detail {
+				t.Errorf("disable=%v %s: expected Location %q, got %q", tt.disable, tt.path, tt.detail, loc)
+			}
+			if strings.Contains(body, "__rep__") {
+				t.Errorf("disable=%v %s: redirect should not be injected", tt.disable, tt.path)
+			}
+		}
+	}
+}
+
+func TestServer_SPAPaths(t *testing.T) {
+	staticDir := t.TempDir()
+	page := func(title string) []byte {
+		return []byte("<!DOCTYPE html><html><head><title>" + title + "</title></head><body></body></html>")
+	}
+	if err := os.WriteFile(filepath.Join(staticDir, "index.html"), page("site"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(staticDir, "app"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(staticDir, "app", "index.html"), page("app"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{Mode: "embedded", StaticDir: staticDir, SPAPaths: []string{"/app"}}
+	srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", &config.ClassifiedVars{})
+	if err != nil {
+		t.Fatalf("NewFromVars: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		path  string
+		want  int
+		title string
+	}{
+		{"/app/settings/profile", http.StatusOK, "app"},
+		{"/app", http.StatusOK, "app"},
+		{"/", http.StatusOK, "site"},
+		{"/pricing", http.StatusNotFound, ""},
+		{"/apple", http.StatusNotFound, ""},
+		{"/app/missing.js", http.StatusNotFound, ""},
+	} {
+		resp, err := http.Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", tt.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.want, resp.StatusCode)
+			continue
+		}
+		if tt.title != "" && !strings.Contains(string(body), "<title>"+tt.title+"</title>") {
+			t.Errorf("%s: expected the %q page, got %q", tt.path, tt.title, body)
+		}
+	}
+}
+
+func TestServer_StaticSymlinks(t *testing.T) {
+	base := t.TempDir()
+	staticDir := filepath.Join(base, "static")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(staticDir, "assets"), outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(staticDir, "assets", "app.js"), []byte("console.log('app')"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"app.js":     filepath.Join(staticDir, "assets", "app.js"),
+		"secret.txt": filepath.Join(outside, "secret.txt"),
+		"escape":     outside,
+	} {
+		if err := os.Symlink(target, filepath.Join(staticDir, link)); err != nil {
+			t.Skipf("symlinks unsupported: %v", err)
+		}
+	}
+
+	status := func(t *testing.T, follow bool, path string) int {
+		t.Helper()
+		cfg := &config.Config{Mode: "embedded", StaticDir: staticDir, StaticFollowSymlinks: follow}
+		srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", &config.ClassifiedVars{})
+		if err != nil {
+			t.Fatalf("NewFromVars: %v", err)
+		}
+		ts := httptest.NewServer(srv.Handler())
+		defer ts.Close()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, tt := range []struct {
+		follow bool
+		path   string
+		want   int
+	}{
+		{false, "/app.js", http.StatusOK},
+		{false, "/secret.txt", http.StatusNotFound},
+		{false, "/escape/secret.txt", http.StatusNotFound},
+		{true, "/secret.txt", http.StatusOK},
+		{true, "/escape/secret.txt", http.StatusOK},
+	} {
+		if got := status(t, tt.follow, tt.path); got != tt.want {
+			t.Errorf("follow=%v %s: expected %d, got %d", tt.follow, tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestLoadErrorPages_MissingDir(t *testing.T) {
+	if _, err := loadErrorPages(filepath.Join(t.TempDir(), "nope")); err == nil {
+		t.Fatal("expected error for missing directory")
+	}
+}
+
+func TestPinBuildTime(t *testing.T) {
+	m := &manifest.Manifest{
+		Variables: map[string]*manifest.VarDecl{
+			"BUILD_ID": {Tier: "public", BuildTime: true},
+			"GONE":     {Tier: "public", BuildTime: true},
+			"NEW":      {Tier: "server", BuildTime: true},
+		},
+	}
+	pub := func(name, value string) config.Variable {
+		return config.Variable{Name: name, Value: value, Tier: config.TierPublic}
+	}
+	oldVars := &config.ClassifiedVars{
+		Public: []config.Variable{pub("BUILD_ID", "1"), pub("GONE", "x"), pub("API_URL", "a")},
+	}
+	newVars := &config.ClassifiedVars{
+		Public: []config.Variable{pub("BUILD_ID", "2"), pub("API_URL", "b")},
+		Server: []config.Variable{{Name: "NEW", Value: "n", Tier: config.TierServer}},
+	}
+
+	pinned, ignored := pinBuildTime(m, oldVars, newVars)
+
+	want := map[string]string{"BUILD_ID": "1", "GONE": "x", "API_URL": "b"}
+	if got := pinned.PublicMap(); !mapsEqual(got, want) {
+		t.Errorf("public: got %v, want %v", got, want)
+	}
+	if len(pinned.Server) != 0 {
+		t.Errorf("build-time addition should be dropped, got %v", pinned.Server)
+	}
+	if len(ignored) != 3 || ignored[0] != "BUILD_ID" || ignored[1] != "GONE" || ignored[2] != "NEW" {
+		t.Errorf("ignored: got %v", ignored)
+	}
+}
+
+func TestServer_ReloadSkipsBuildTimeVars(t *testing.T) {
+	srv, ts := newTestServer(t, "REP_PUBLIC_BUILD_ID=100\nREP_PUBLIC_API_URL=https://a.example.com\n", func(cfg *config.Config) {
+		cfg.HotReload = true
+		cfg.HotReloadMode = "signal"
+		cfg.Manifest = &manifest.Manifest{
+			Variables: map[string]*manifest.VarDecl{
+				"BUILD_ID": {Tier: "public", BuildTime: true},
+				"API_URL":  {Tier: "public", Type: "url"},
+			},
+		}
+	})
+	envFile := srv.cfg.EnvFile
+	defer srv.hotReloadHub.Close()
+
+	resp, err := http.Get(ts.URL + "/rep/changes")
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	stream := bufio.NewReader(resp.Body)
+	if line, err := stream.ReadString('\n'); err != nil || !strings.HasPrefix(line, ": connected") {
+		t.Fatalf("expected connected comment, got %q (%v)", line, err)
+	}
+
+	if err := os.WriteFile(envFile, []byte("REP_PUBLIC_BUILD_ID=200\nREP_PUBLIC_API_URL=https://b.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	// Close the hub so the stream ends after the events already queued.
+	srv.hotReloadHub.Close()
+
+	events, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("reading event stream: %v", err)
+	}
+	if !strings.Contains(string(events), `"key":"API_URL"`) {
+		t.Errorf("expected a runtime variable update, got:\n%s", events)
+	}
+	if strings.Contains(string(events), "BUILD_ID") {
+		t.Errorf("build-time variable change was broadcast:\n%s", events)
+	}
+
+	body := fetchBody(t, ts.URL+"/")
+	if !strings.Contains(body, `"BUILD_ID":"100"`) || !strings.Contains(body, "b.example.com") {
+		t.Errorf("expected pinned BUILD_ID and reloaded API_URL in payload, got %s", body)
+	}
+}
+
+func TestServer_ManifestDefaults(t *testing.T) {
+	cfg := testConfig(func(cfg *config.Config) {
+		cfg.Manifest = &manifest.Manifest{
+			Variables: map[string]*manifest.VarDecl{
+				"THEME":     {Tier: "public", Default: "light", HasDefault: true},
+				"PAGE_SIZE": {Tier: "public", Type: "number", Default: "20", HasDefault: true},
+			},
+		}
+	})
+	vars := &config.ClassifiedVars{
+		Public: []config.Variable{{Name: "THEME", Value: "dark", Tier: config.TierPublic, OriginalKey: "REP_PUBLIC_THEME"}},
+	}
+	srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", vars)
+	if err != nil {
+		t.Fatalf("NewFromVars: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	body := fetchBody(t, ts.URL+"/")
+	if !strings.Contains(body, `"THEME":"dark"`) || !strings.Contains(body, `"PAGE_SIZE":"20"`) {
+		t.Errorf("expected THEME as set and PAGE_SIZE from its default, got %s", body)
+	}
+}
+
+func TestServer_RefusesTierDowngrade(t *testing.T) {
+	newCfg := func() *config.Config {
+		return testConfig(func(cfg *config.Config) {
+			cfg.Manifest = &manifest.Manifest{
+				Variables: map[string]*manifest.VarDecl{
+					"ANALYTICS_KEY": {Tier: "sensitive"},
+				},
+			}
+		})
+	}
+
+	downgraded := &config.ClassifiedVars{
+		Public: []config.Variable{{Name: "ANALYTICS_KEY", Value: "ak_123", Tier: config.TierPublic, OriginalKey: "REP_PUBLIC_ANALYTICS_KEY"}},
+	}
+	_, err := NewFromVars(newCfg(), slog.Default(), "0.1.0-test", downgraded)
+	if err == nil || !strings.Contains(err.Error(), "declared sensitive but supplied as REP_PUBLIC_ANALYTICS_KEY") {
+		t.Fatalf("expected startup to refuse the downgrade, got %v", err)
+	}
+
+	declared := &config.ClassifiedVars{
+		Sensitive: []config.Variable{{Name: "ANALYTICS_KEY", Value: "ak_123", Tier: config.TierSensitive, OriginalKey: "REP_SENSITIVE_ANALYTICS_KEY"}},
+	}
+	if _, err := NewFromVars(newCfg(), slog.Default(), "0.1.0-test", declared); err != nil {
+		t.Fatalf("NewFromVars: %v", err)
+	}
+}
+
+func TestServer_ManifestVersionCompatibility(t *testing.T) {
+	newCfg := func(version string) *config.Config {
+		return testConfig(func(cfg *config.Config) {
+			cfg.Manifest = &manifest.Manifest{Version: version}
+		})
+	}
+
+	_, err := NewFromVars(newCfg("1.0.0"), slog.Default(), "0.1.0-test", &config.ClassifiedVars{})
+	if err == nil || !strings.Contains(err.Error(), `manifest version "1.0.0" is not supported`) {
+		t.Fatalf("expected startup to refuse an incompatible major version, got %v", err)
+	}
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	if _, err := NewFromVars(newCfg("0.2.0"), logger, "0.1.0-test", &config.ClassifiedVars{}); err != nil {
+		t.Fatalf("NewFromVars: %v", err)
+	}
+	if !strings.Contains(logs.String(), `manifest version \"0.2.0\" is newer`) {
+		t.Errorf("expected a warning for a newer minor version, got logs:\n%s", logs.String())
+	}
+}
+
+func TestCompatHandler(t *testing.T) {
+	h := compatHandler(slog.Default())
+	tests := []struct {
+		sdk        string
+		compatible bool
+		detail     string
+	}{
+		{sdk: "0.1.0", compatible: true},
+		{sdk: "0.1.9", compatible: true},
+		{sdk: "0.2.0", compatible: true, detail: "SDK is newer than the protocol version"},
+		{sdk: "1.2.3", compatible: false, detail: "SDK major version 1 does not match protocol major version 0"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rep/compat?sdk="+tt.sdk, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("sdk %s: expected 200, got %d", tt.sdk, rec.Code)
+		}
+		var info compatInfo
+		if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+			t.Fatalf("sdk %s: decoding body: %v", tt.sdk, err)
+		}
+		if info.SDK != tt.sdk || info.ProtocolVersion != manifest.ProtocolVersion {
+			t.Errorf("sdk %s: unexpected versions in %+v", tt.sdk, info)
+		}
+		if info.Compatible != tt.compatible {
+			t.Errorf("sdk %s: compatible = %v, want %v", tt.sdk, info.Compatible, tt.compatible)
+		}
+		if !strings.Contains(info.Detail, tt.detail) || (tt.detail == "" && info.Detail != "") {
+			t.Errorf("sdk %s: detail = %q, want %q", tt.sdk, info.Detail, tt.detail)
+		}
+	}
+
+	for _, query := range []string{"", "?sdk=", "?sdk=latest"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rep/compat"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestServer_Maintenance(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "maintenance")
+	page := filepath.Join(t.TempDir(), "maintenance.html")
+	if err := os.WriteFile(page, []byte("<html><head></head><body>Back soon</body></html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testConfig(func(cfg *config.Config) {
+		cfg.MaintenancePage = page
+		cfg.MaintenanceFile = marker
+	})
+	vars := &config.ClassifiedVars{
+		Public: []config.Variable{{Name: "API_URL", Value: "https://api.example.com", Tier: config.TierPublic, OriginalKey: "REP_PUBLIC_API_URL"}},
+	}
+	srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", vars)
+	if err != nil {
+		t.Fatalf("NewFromVars: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	maintenance := func() bool {
+		t.Helper()
+		var body struct {
+			Maintenance bool `json:"maintenance"`
+		}
+		resp, err := http.Get(ts.URL + "/rep/health")
+		if err != nil {
+			t.Fatalf("GET /rep/health: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("health: expected 200, got %d", resp.StatusCode)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decoding health: %v", err)
+		}
+		return body.Maintenance
+	}
+
+	// Off until the marker file appears.
+	if maintenance() {
+		t.Error("health reports maintenance before the marker exists")
+	}
+	if body := fetchBody(t, ts.URL+"/"); strings.Contains(body, "Back soon") {
+		t.Error("maintenance page served while maintenance is off")
+	}
+
+	if err := os.WriteFile(marker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv.CheckMaintenance()
+	if !maintenance() {
+		t.Error("health does not report maintenance")
+	}
+	resp, err := http.Get(ts.URL + "/dashboard")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if !strings.Contains(string(body), "Back soon") || !strings.Contains(string(body), `"API_URL":"https://api.example.com"`) {
+		t.Errorf("expected the maintenance page with the REP payload, got:\n%s", body)
+	}
+
+	// Removing the marker turns it off again on the next check.
+	if err := os.Remove(marker); err != nil {
+		t.Fatal(err)
+	}
+	srv.CheckMaintenance()
+	if maintenance() {
+		t.Error("health still reports maintenance after the marker was removed")
+	}
+}
+
+func TestServer_MaintenanceDefaultPage(t *testing.T) {
+	cfg := testConfig(func(cfg *config.Config) {
+		cfg.Maintenance = true
+	})
+	srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", &config.ClassifiedVars{})
+	if err != nil {
+		t.Fatalf("NewFromVars: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "Down for maintenance") || !strings.Contains(body, `id="__rep__"`) {
+		t.Errorf("expected the built-in page with the REP payload, got:\n%s", body)
+	}
+}
+
+func TestServer_MetaTypes(t *testing.T) {
+	t.Setenv("REP_PUBLIC_API_URL", "https://api.example.com")
+	t.Setenv("REP_PUBLIC_MAX_ITEMS", "25")
+	t.Setenv("REP_PUBLIC_THEME", "dark")
+	t.Setenv("REP_SERVER_DB_URL", "postgres://db.internal/app")
+
+	_, ts := newTestServer(t, "", func(cfg *config.Config) {
+		cfg.HotReloadMode = "signal"
+		cfg.MetaTypes = true
+		cfg.Manifest = &manifest.Manifest{
+			Variables: map[string]*manifest.VarDecl{
+				"API_URL":   {Tier: "public", Type: "url"},
+				"MAX_ITEMS": {Tier: "public", Type: "number"},
+				"DB_URL":    {Tier: "server", Type: "url"},
+			},
+		}
+	})
+
+	body := fetchBody(t, ts.URL+"/")
+	if !strings.Contains(body, `"types":{"API_URL":"url","MAX_ITEMS":"number"}`) {
+		t.Errorf("expected _meta.types from the manifest's public declarations, got %s", body)
+	}
+	if strings.Contains(body, "DB_URL") || strings.Contains(body, `"THEME":"string"`) {
+		t.Errorf("expected server and undeclared variables left out of _meta.types, got %s", body)
+	}
+}
+
+func TestServer_ReloadKeepsUnchangedSensitiveBlob(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	write := func(public string) {
+		t.Helper()
+		content := "REP_PUBLIC_API_URL=" + public + "\nREP_SENSITIVE_ANALYTICS_KEY=UA-12345\n"
+		if err := os.WriteFile(envFile, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("https://a.example.com")
+
+	cfg := testConfig(func(cfg *config.Config) {
+		cfg.EnvFile = envFile
+		cfg.HotReloadMode = "signal"
+		cfg.EncryptPublic = true
+	})
+	srv, err := New(cfg, slog.Default(), "0.1.0-test")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	before := srv.payload
+
+	write("https://b.example.com")
+	if err := srv.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if srv.payload.Sensitive != before.Sensitive {
+		t.Error("expected a public-only reload to keep the sensitive blob byte-identical")
+	}
+	if srv.payload.EncryptedPublic == before.EncryptedPublic {
+		t.Error("expected the encrypted public blob to change")
+	}
+}
+
+func TestServer_KeySeedSharedAcrossReplicas(t *testing.T) {
+	t.Setenv("REP_PUBLIC_API_URL", "https://api.example.com")
+	t.Setenv("REP_SENSITIVE_ANALYTICS_KEY", "UA-12345")
+
+	newReplica := func(seed string) *Server {
+		t.Helper()
+		cfg := testConfig(func(cfg *config.Config) {
+			cfg.KeySeed = seed
+		})
+		srv, err := New(cfg, slog.Default(), "0.1.0-test")
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		return srv
+	}
+	seedA := "QUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUE="
+	a, b := newReplica(seedA), newReplica(seedA)
+
+	if a.payload.Meta.Integrity != b.payload.Meta.Integrity {
+		t.Error("expected replicas with the same seed to produce the same integrity token")
+	}
+	aad := repcrypto.BlobAAD(a.payload.Meta.Version, a.payload.Meta.Integrity)
+	if _, err := repcrypto.DecryptSensitive(a.payload.Sensitive, b.keys.EncryptionKey, aad); err != nil {
+		t.Errorf("expected one replica's key to decrypt the other's blob: %v", err)
+	}
+
+	c := newReplica("QkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkJCQkI=")
+	if c.payload.Meta.Integrity == a.payload.Meta.Integrity {
+		t.Error("expected a different seed to produce a different integrity token")
+	}
+}
+
+func TestServer_ReplicaID(t *testing.T) {
+	t.Setenv("REP_SENSITIVE_ANALYTICS_KEY", "UA-12345")
+
+	newReplica := func(id string) (*Server, *httptest.Server) {
+		t.Helper()
+		return newTestServer(t, "", func(cfg *config.Config) {
+			cfg.ReplicaID = id
+			cfg.SessionKeyTTL = time.Minute
+			cfg.SessionKeyMaxRate = 100
+		})
+	}
+	a, tsA := newReplica("a")
+	_, tsB := newReplica("b")
+
+	endpoint := a.payload.Meta.KeyEndpoint
+	if a.payload.Meta.ReplicaID != "a" || endpoint != "/rep/session-key?replica=a" {
+		t.Fatalf("expected replica a in _meta, got %+v", a.payload.Meta)
+	}
+
+	for _, tc := range []struct {
+		url  string
+		want int
+	}{
+		{tsA.URL + endpoint, http.StatusOK},
+		{tsB.URL + endpoint, http.StatusConflict},
+	} {
+		resp, err := http.Get(tc.url)
+		if err != nil {
+			t.Fatalf("GET %s: %v", tc.url, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.url, tc.want, resp.StatusCode)
+		}
+	}
+}
+
+func TestServer_ReloadAllowedOriginsFile(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, ".env")
+	if err := os.WriteFile(envFile, []byte("REP_SENSITIVE_TOKEN=one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	originsFile := filepath.Join(dir, "origins.txt")
+	if err := os.WriteFile(originsFile, []byte("https://a.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, ts := newTestServer(t, "", func(cfg *config.Config) {
+		cfg.EnvFile = envFile
+		cfg.AllowedOriginsFile = originsFile
+		cfg.SessionKeyTTL = time.Minute
+		cfg.SessionKeyMaxRate = 100
+	})
+
+	// check reports whether origin may fetch a session key and receives
+	// CORS headers from /rep/health.
+	check := func(t *testing.T, origin string, want bool) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/rep/session-key", nil)
+		req.Header.Set("Origin", origin)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET session-key: %v", err)
+		}
+		_ = resp.Body.Close()
+		if got := resp.StatusCode == http.StatusOK; got != want {
+			t.Errorf("%s: session-key status %d, want allowed=%v", origin, resp.StatusCode, want)
+		}
+
+		req, _ = http.NewRequest(http.MethodGet, ts.URL+"/rep/health", nil)
+		req.Header.Set("Origin", origin)
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET health: %v", err)
+		}
+		_ = resp.Body.Close()
+		if got := resp.Header.Get("Access-Control-Allow-Origin") == origin; got != want {
+			t.Errorf("%s: health CORS header %q, want allowed=%v", origin, resp.Header.Get("Access-Control-Allow-Origin"), want)
+		}
+	}
+
+	check(t, "https://a.example.com", true)
+	check(t, "https://b.example.com", false)
+
+	if err := os.WriteFile(originsFile, []byte("# rotated\nhttps://b.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	check(t, "https://a.example.com", false)
+	check(t, "https://b.example.com", true)
+
+	// A missing file fails the reload and keeps the previous allowlist.
+	if err := os.Remove(originsFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Reload(); err == nil {
+		t.Error("expected reload to fail without the origins file")
+	}
+	check(t, "https://b.example.com", true)
+
+	// An emptied file must not open the endpoints to every origin: the
+	// reload fails and the previous allowlist stays.
+	if err := os.WriteFile(originsFile, []byte("# all partners removed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Reload(); err == nil {
+		t.Error("expected reload to fail with an empty origins file")
+	}
+	check(t, "https://b.example.com", true)
+	check(t, "https://evil.example.com", false)
+}
+
+func TestServer_ReloadTogglesSessionKeyEndpoint(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	writeEnv := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(envFile, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeEnv("REP_PUBLIC_API_URL=https://a.example.com\n")
+
+	srv, ts := newTestServer(t, "", func(cfg *config.Config) {
+		cfg.EnvFile = envFile
+		cfg.SessionKeyTTL = time.Minute
+		cfg.SessionKeyMaxRate = 100
+	})
+
+	sessionKeyStatus := func() int {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/rep/session-key")
+		if err != nil {
+			t.Fatalf("GET session-key: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := sessionKeyStatus(); code != http.StatusNotFound {
+		t.Errorf("expected 404 without encrypted vars, got %d", code)
+	}
+
+	// The payload gains key_endpoint on reload; it must resolve.
+	writeEnv("REP_PUBLIC_API_URL=https://a.example.com\nREP_SENSITIVE_TOKEN=one\n")
+	if err := srv.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if !strings.Contains(fetchBody(t, ts.URL+"/"), `"key_endpoint":"/rep/session-key"`) {
+		t.Fatal("expected the reloaded payload to advertise key_endpoint")
+	}
+	if code := sessionKeyStatus(); code != http.StatusOK {
+		t.Errorf("expected 200 once the payload advertises key_endpoint, got %d", code)
+	}
+
+	writeEnv("REP_PUBLIC_API_URL=https://a.example.com\n")
+	if err := srv.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if code := sessionKeyStatus(); code != http.StatusNotFound {
+		t.Errorf("expected 404 after encrypted vars are removed, got %d", code)
+	}
+}
+
+func TestServer_ReloadCounted(t *testing.T) {
+	srv, ts := newTestServer(t, "REP_SENSITIVE_TOKEN=one\n", nil)
+	envFile := srv.cfg.EnvFile
+
+	reloads := func() health.ReloadStats {
+		t.Helper()
+		var h health.Response
+		if err := json.Unmarshal([]byte(fetchBody(t, ts.URL+"/rep/health")), &h); err != nil {
+			t.Fatalf("decode health: %v", err)
+		}
+		if h.Reloads == nil {
+			t.Fatal("expected a reloads block")
+		}
+		return *h.Reloads
+	}
+
+	if err := srv.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := reloads(); got.Total != 1 || got.Failures != 0 {
+		t.Errorf("expected 1 reload and no failures, got %+v", got)
+	}
+
+	// An invalid AES key makes re-encrypting the changed sensitive blob fail.
+	if err := os.WriteFile(envFile, []byte("REP_SENSITIVE_TOKEN=two\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv.keys.EncryptionKey = []byte("short")
+	if err := srv.Reload(); err == nil {
+		t.Fatal("expected Reload to fail")
+	}
+	if got := reloads(); got.Total != 2 || got.Failures != 1 {
+		t.Errorf("expected 2 reloads and 1 failure, got %+v", got)
+	}
+}
+
+func TestServer_ServerHeader(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "upstream/1.0")
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	get := func(t *testing.T, ts *httptest.Server, path string) []string {
+		t.Helper()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		_ = resp.Body.Close()
+		return resp.Header.Values("Server")
+	}
+
+	cfg := &config.Config{Mode: "proxy", Upstream: upstream.URL, ServerHeader: "rep-gateway"}
+	srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", &config.ClassifiedVars{})
+	if err != nil {
+		t.Fatalf("NewFromVars: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	if got := get(t, ts, "/rep/health"); len(got) != 1 || got[0] != "rep-gateway" {
+		t.Errorf("expected Server rep-gateway on /rep/health, got %q", got)
+	}
+	if got := get(t, ts, "/app"); len(got) != 1 || got[0] != "upstream/1.0" {
+		t.Errorf("expected the upstream's Server header on proxied responses, got %q", got)
+	}
+
+	cfg = &config.Config{Mode: "proxy", Upstream: upstream.URL}
+	srv, err = NewFromVars(cfg, slog.Default(), "0.1.0-test", &config.ClassifiedVars{})
+	if err != nil {
+		t.Fatalf("NewFromVars: %v", err)
+	}
+	ts2 := httptest.NewServer(srv.Handler())
+	defer ts2.Close()
+	if got := get(t, ts2, "/rep/health"); len(got) != 0 {
+		t.Errorf("expected no Server header when disabled, got %q", got)
+	}
+}
+
+func TestServer_HealthReportsSSEClients(t *testing.T) {
+	srv, ts := newTestServer(t, "", func(cfg *config.Config) {
+		cfg.HotReload = true
+		cfg.HotReloadMode = "signal"
+	})
+	defer srv.hotReloadHub.Close()
+
+	clients := func() int {
+		t.Helper()
+		var h health.Response
+		if err := json.Unmarshal([]byte(fetchBody(t, ts.URL+"/rep/health")), &h); err != nil {
+			t.Fatalf("decode health: %v", err)
+		}
+		if h.HotReload == nil {
+			t.Fatal("expected a hot_reload block with hot reload enabled")
+		}
+		return h.HotReload.Clients
+	}
+
+	if n := clients(); n != 0 {
+		t.Errorf("expected 0 clients before any connection, got %d", n)
+	}
+
+	var streams []*http.Response
+	for range 2 {
+		resp, err := http.Get(ts.URL + "/rep/changes")
+		if err != nil {
+			t.Fatalf("GET error: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if line, err := bufio.NewReader(resp.Body).ReadString('\n'); err != nil || !strings.HasPrefix(line, ": connected") {
+			t.Fatalf("expected connected comment, got %q (%v)", line, err)
+		}
+		streams = append(streams, resp)
+	}
+	if n := clients(); n != 2 {
+		t.Errorf("expected 2 clients, got %d", n)
+	}
+
+	_ = streams[0].Body.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for clients() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 1 client after a disconnect, got %d", clients())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer_ReloadFailureMarksStale(t *testing.T) {
+	srv, ts := newTestServer(t, "REP_PUBLIC_API_URL=https://a.example.com\nREP_SENSITIVE_TOKEN=one\n", func(cfg *config.Config) {
+		cfg.HotReload = true
+		cfg.HotReloadMode = "signal"
+	})
+	envFile := srv.cfg.EnvFile
+	defer srv.hotReloadHub.Close()
+
+	resp, err := http.Get(ts.URL + "/rep/changes")
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	stream := bufio.NewReader(resp.Body)
+	if line, err := stream.ReadString('\n'); err != nil || !strings.HasPrefix(line, ": connected") {
+		t.Fatalf("expected connected comment, got %q (%v)", line, err)
+	}
+
+	// An invalid AES key makes encrypting the sensitive blob fail.
+	goodKey := srv.keys.EncryptionKey
+	srv.keys.EncryptionKey = []byte("short")
+	if err := os.WriteFile(envFile, []byte("REP_PUBLIC_API_URL=https://b.example.com\nREP_SENSITIVE_TOKEN=two\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Reload(); err == nil {
+		t.Fatal("expected Reload to fail")
+	}
+
+	healthBody := fetchBody(t, ts.URL+"/rep/health")
+	var h health.Response
+	if err := json.Unmarshal([]byte(healthBody), &h); err != nil {
+		t.Fatalf("decode health: %v", err)
+	}
+	if h.Status != "degraded" || h.Reload == nil || !h.Reload.Stale {
+		t.Errorf("expected degraded health with a stale reload, got %s", healthBody)
+	}
+	if body := fetchBody(t, ts.URL+"/"); !strings.Contains(body, "a.example.com") {
+		t.Error("expected the previous payload to keep being served")
+	}
+
+	srv.keys.EncryptionKey = goodKey
+	if err := srv.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	srv.hotReloadHub.Close()
+
+	events, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("reading event stream: %v", err)
+	}
+	errIdx := strings.Index(string(events), "event: rep:config:error")
+	updIdx := strings.Index(string(events), "event: rep:config:update")
+	if errIdx < 0 || updIdx < errIdx {
+		t.Errorf("expected an error event followed by the update from the successful reload, got:\n%s", events)
+	}
+
+	healthBody = fetchBody(t, ts.URL+"/rep/health")
+	if strings.Contains(healthBody, `"reload"`) || !strings.Contains(healthBody, `"status":"healthy"`) {
+		t.Errorf("expected the stale flag to clear after a successful reload, got %s", healthBody)
+	}
+}
+
+func TestServer_NotReadyPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		policy     string
+		wantInject bool
+	}{
+		{"fail-open", true},
+		{"fail-closed", false},
+	} {
+		t.Run(tt.policy, func(t *testing.T) {
+			srv, ts := newTestServer(t, "REP_PUBLIC_API_URL=https://api.example.com\n", func(cfg *config.Config) {
+				cfg.NotReadyPolicy = tt.policy
+				cfg.Manifest = &manifest.Manifest{
+					Variables: map[string]*manifest.VarDecl{
+						"API_URL": {Tier: "public", Type: "url", Required: true},
+					},
+				}
+			})
+			envFile := srv.cfg.EnvFile
+
+			// Drop the required variable so the gateway becomes not ready.
+			if err := os.WriteFile(envFile, []byte("REP_PUBLIC_OTHER=x\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := srv.Reload(); err != nil {
+				t.Fatalf("Reload: %v", err)
+			}
+			if ready, _ := srv.health.Ready(); ready {
+				t.Fatal("expected not ready after reload")
+			}
+
+			resp, err := http.Get(ts.URL + "/")
+			if err != nil {
+				t.Fatalf("GET error: %v", err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("expected 200, got %d", resp.StatusCode)
+			}
+			if got := strings.Contains(string(body), `id="__rep__"`); got != tt.wantInject {
+				t.Errorf("injected = %v, want %v", got, tt.wantInject)
+			}
+			if got := resp.Header.Get(inject.NotReadyHeader) != ""; got == tt.wantInject {
+				t.Errorf("%s header present = %v, want %v", inject.NotReadyHeader, got, !tt.wantInject)
+			}
+		})
+	}
+}
+
+func TestServer_StripPrefix(t *testing.T) {
+	var gotPath, upstreamHost string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		switch r.URL.Path {
+		case "/login":
+			http.Redirect(w, r, "/dashboard?x=1", http.StatusFound)
+		case "/abs":
+			http.Redirect(w, r, "http://"+r.Host+"/home", http.StatusFound)
+		case "/upstream-abs":
+			http.Redirect(w, r, "http://"+upstreamHost+"/home", http.StatusFound)
+		case "/external":
+			http.Redirect(w, r, "https://auth.example.com/login", http.StatusFound)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html><head></head><body></body></html>"))
+		}
+	}))
+	defer upstream.Close()
+	upstreamHost = strings.TrimPrefix(upstream.URL, "http://")
+
+	_, ts := newTestServer(t, "REP_PUBLIC_API_URL=https://api.example.com\n", func(cfg *config.Config) {
+		cfg.Mode = "proxy"
+		cfg.Upstream = upstream.URL
+		cfg.StripPrefix = "/app"
+	})
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(path string) *http.Response {
+		t.Helper()
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	for _, tt := range []struct{ path, upstreamPath string }{
+		{"/app/assets/main.js", "/assets/main.js"},
+		{"/app", "/"},
+		{"/apple", "/apple"},
+	} {
+		get(tt.path)
+		if gotPath != tt.upstreamPath {
+			t.Errorf("%s: upstream received %q, want %q", tt.path, gotPath, tt.upstreamPath)
+		}
+	}
+
+	for _, tt := range []struct{ path, location string }{
+		{"/app/login", "/app/dashboard?x=1"},
+		{"/app/abs", ts.URL + "/app/home"},
+		{"/app/upstream-abs", "/app/home"},
+		{"/app/external", "https://auth.example.com/login"},
+	} {
+		if loc := get(tt.path).Header.Get("Location"); loc != tt.location {
+			t.Errorf("%s: Location = %q, want %q", tt.path, loc, tt.location)
+		}
+	}
+}
+
+func TestServer_AllowedMethods(t *testing.T) {
+	var gotMethod string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+
+	vars := &config.ClassifiedVars{
+		Public: []config.Variable{{Name: "API_URL", Value: "https://api.example.com"}},
+	}
+	post := func(t *testing.T, url string) *http.Response {
+		t.Helper()
+		resp, err := http.Post(url, "application/json", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatalf("POST error: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	t.Run("embedded rejects POST", func(t *testing.T) {
+		cfg := testConfig(func(cfg *config.Config) {
+			cfg.AllowedMethods = []string{http.MethodGet, http.MethodHead}
+		})
+		srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", vars)
+		if err != nil {
+			t.Fatalf("NewFromVars: %v", err)
+		}
+		ts := httptest.NewServer(srv.Handler())
+		defer ts.Close()
+
+		resp := post(t, ts.URL+"/")
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("expected 405, got %d", resp.StatusCode)
+		}
+		if allow := resp.Header.Get("Allow"); allow != "GET, HEAD" {
+			t.Errorf("expected Allow: GET, HEAD, got %q", allow)
+		}
+		if body := fetchBody(t, ts.URL+"/"); !strings.Contains(body, `id="__rep__"`) {
+			t.Error("expected GET to be served with injection")
+		}
+	})
+
+	t.Run("proxy forwards POST", func(t *testing.T) {
+		cfg := &config.Config{Mode: "proxy", Upstream: upstream.URL}
+		srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", vars)
+		if err != nil {
+			t.Fatalf("NewFromVars: %v", err)
+		}
+		ts := httptest.NewServer(srv.Handler())
+		defer ts.Close()
+
+		resp := post(t, ts.URL+"/api/items")
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("expected upstream 201, got %d", resp.StatusCode)
+		}
+		if gotMethod != http.MethodPost {
+			t.Errorf("expected upstream to receive POST, got %q", gotMethod)
+		}
+	})
+}
+
+func TestServer_EarlyHints(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html><head></head><body></body></html>")
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{Mode: "proxy", Upstream: upstream.URL, EarlyHints: true}
+	vars := &config.ClassifiedVars{
+		Sensitive: []config.Variable{{Name: "ANALYTICS_KEY", Value: "ak_123"}},
+	}
+	srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", vars)
+	if err != nil {
+		t.Fatalf("NewFromVars: %v", err)
+	}
+
+	// hints fetches / from ts and returns the Link headers of any 103s.
+	hints := func(t *testing.T, ts *httptest.Server) []string {
+		t.Helper()
+		var links []string
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				if code == http.StatusEarlyHints {
+					links = append(links, header.Values("Link")...)
+				}
+				return nil
+			},
+		}
+		req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, ts.URL+"/", nil)
+		req.Header.Set("Accept", "text/html")
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("GET /: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `id="__rep__"`) {
+			t.Errorf("expected injected 200, got %d", resp.StatusCode)
+		}
+		if final := resp.Header.Values("Link"); len(final) != 0 {
+			t.Errorf("expected Link on the 103 only, final response has %v", final)
+		}
+		return links
+	}
+
+	t.Run("HTTP/2", func(t *testing.T) {
+		ts := httptest.NewUnstartedServer(srv.Handler())
+		ts.EnableHTTP2 = true
+		ts.StartTLS()
+		defer ts.Close()
+
+		links := hints(t, ts)
+		if len(links) != 1 || links[0] != earlyHintsLink {
+			t.Errorf("expected 103 with Link %q, got %v", earlyHintsLink, links)
+		}
+	})
+
+	t.Run("HTTP/1.1", func(t *testing.T) {
+		ts := httptest.NewServer(srv.Handler())
+		defer ts.Close()
+
+		if links := hints(t, ts); len(links) != 0 {
+			t.Errorf("expected no 103 over HTTP/1.1, got %v", links)
+		}
+	})
+
+	t.Run("NoKeyEndpoint", func(t *testing.T) {
+		// Without SENSITIVE variables the payload names no key endpoint.
+		plain, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", &config.ClassifiedVars{})
+		if err != nil {
+			t.Fatalf("NewFromVars: %v", err)
+		}
+		ts := httptest.NewUnstartedServer(plain.Handler())
+		ts.EnableHTTP2 = true
+		ts.StartTLS()
+		defer ts.Close()
+
+		if links := hints(t, ts); len(links) != 0 {
+			t.Errorf("expected no 103 without a key endpoint, got %v", links)
+		}
+	})
+}
+
+func TestServer_EffectiveConfigEndpoint(t *testing.T) {
+	vars := &config.ClassifiedVars{
+		Public: []config.Variable{{Name: "API_URL", Value: "https://api.example.com"}},
+	}
+	newTS := func(t *testing.T, enabled bool) *httptest.Server {
+		t.Helper()
+		cfg := testConfig(func(cfg *config.Config) {
+			cfg.TLSKey = "/etc/tls/key.pem"
+			cfg.ConfigEndpoint = enabled
+		})
+		srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", vars)
+		if err != nil {
+			t.Fatalf("NewFromVars: %v", err)
+		}
+		ts := httptest.NewServer(srv.Handler())
+		t.Cleanup(ts.Close)
+		return ts
+	}
+
+	ts := newTS(t, true)
+	resp, err := http.Get(ts.URL + "/rep/config/effective")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var eff map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&eff); err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if eff["Mode"] != "embedded" {
+		t.Errorf("expected Mode=embedded, got %v", eff["Mode"])
+	}
+	if eff["TLSKey"] != "[REDACTED]" {
+		t.Errorf("expected TLSKey redacted, got %v", eff["TLSKey"])
+	}
+
+	// Disabled, the path falls through to the injection middleware.
+	if body := fetchBody(t, newTS(t, false).URL+"/rep/config/effective"); strings.Contains(body, `"Mode"`) {
+		t.Error("expected no config dump without --config-endpoint")
+	}
+}
+
+func TestServer_PublicHeaders(t *testing.T) {
+	cfg := testConfig(func(cfg *config.Config) {
+		cfg.PublicHeaders = true
+	})
+	vars := &config.ClassifiedVars{
+		Public:    []config.Variable{{Name: "API_URL", Value: "https://api.example.com"}},
+		Sensitive: []config.Variable{{Name: "ANALYTICS_KEY", Value: "ak_123"}},
+		Server:    []config.Variable{{Name: "DB_PASSWORD", Value: "hunter2"}},
+	}
+	srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", vars)
+	if err != nil {
+		t.Fatalf("NewFromVars: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if got := resp.Header.Get("X-REP-API-URL"); got != "https://api.example.com" {
+		t.Errorf("expected X-REP-API-URL header, got %q", got)
+	}
+	for name, values := range resp.Header {
+		for _, v := range values {
+			if v == "ak_123" || v == "hunter2" {
+				t.Errorf("non-public value leaked in header %s", name)
+			}
+		}
+	}
+	if resp.Header.Get("X-REP-ANALYTICS-KEY") != "" || resp.Header.Get("X-REP-DB-PASSWORD") != "" {
+		t.Error("expected no headers for SENSITIVE or SERVER variables")
+	}
+}
+
+func TestStartup_InitializingUntilHandOver(t *testing.T) {
+	cfg := testConfig(nil)
+	st, err := Listen(cfg, slog.Default(), "0.1.0-test")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	base := "http://" + st.Addr().String()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// Slow init: the ports answer before New has run.
+	if code, body := get("/rep/health"); code != http.StatusOK || !strings.Contains(body, `"status":"initializing"`) {
+		t.Errorf("expected initializing health, got %d %s", code, body)
+	}
+	if code, _ := get("/rep/ready"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 from /rep/ready while initializing, got %d", code)
+	}
+	if code, _ := get("/"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for pages while initializing, got %d", code)
+	}
+
+	vars := &config.ClassifiedVars{
+		Public: []config.Variable{{Name: "API_URL", Value: "https://api.example.com"}},
+	}
+	srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", vars)
+	if err != nil {
+		t.Fatalf("NewFromVars: %v", err)
+	}
+	srv.UseStartup(st)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Start(ctx) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, body := get("/rep/health")
+		if strings.Contains(body, `"status":"healthy"`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for healthy, last body %s", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, body := get("/"); !strings.Contains(body, `id="__rep__"`) {
+		t.Error("expected injected page after hand-over")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Start: %v", err)
+	}
+}
+
+func TestServer_RateLimitedChanges(t *testing.T) {
+	cfg := testConfig(func(cfg *config.Config) {
+		cfg.HotReload = true
+		cfg.HotReloadMode = "signal"
+		cfg.RateLimits = map[string]int{"changes": 2}
+	})
+	vars := &config.ClassifiedVars{
+		Public: []config.Variable{{Name: "API_URL", Value: "https://api.example.com"}},
+	}
+	srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", vars)
+	if err != nil {
+		t.Fatalf("NewFromVars: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	defer srv.hotReloadHub.Close()
+
+	status := func(path, ip string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := status("/rep/changes", "10.0.0.1"); code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i+1, code)
+		}
+	}
+	if code := status("/rep/changes", "10.0.0.1"); code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 past the limit, got %d", code)
+	}
+	if code := status("/rep/changes", "10.0.0.2"); code != http.StatusOK {
+		t.Errorf("expected another IP to be unaffected, got %d", code)
+	}
+	for i := 0; i < 5; i++ {
+		if code := status("/rep/health", "10.0.0.1"); code != http.StatusOK {
+			t.Fatalf("expected unlimited /rep/health, got %d", code)
+		}
+	}
+}
+
+func TestServer_UpstreamCA(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html><head></head><body>secure upstream</body></html>"))
+	}))
+	defer upstream.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(t *testing.T, cfg *config.Config) *http.Response {
+		t.Helper()
+		srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", &config.ClassifiedVars{})
+		if err != nil {
+			t.Fatalf("NewFromVars: %v", err)
+		}
+		ts := httptest.NewServer(srv.Handler())
+		defer ts.Close()
+		resp, err := http.Get(ts.URL + "/")
+		if err != nil {
+			t.Fatalf("GET error: %v", err)
+		}
+		return resp
+	}
+
+	t.Run("trusted via upstream-ca", func(t *testing.T) {
+		resp := get(t, &config.Config{Mode: "proxy", Upstream: upstream.URL, UpstreamCA: caFile})
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "secure upstream") {
+			t.Errorf("expected the upstream page, got %d: %s", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("untrusted without it", func(t *testing.T) {
+		resp := get(t, &config.Config{Mode: "proxy", Upstream: upstream.URL})
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Errorf("expected 502 when the upstream certificate cannot be verified, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("insecure skip verify", func(t *testing.T) {
+		resp := get(t, &config.Config{Mode: "proxy", Upstream: upstream.URL, UpstreamInsecureSkipVerify: true})
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected 200 with verification disabled, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("bad CA file", func(t *testing.T) {
+		notPEM := filepath.Join(t.TempDir(), "ca.pem")
+		if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg := &config.Config{Mode: "proxy", Upstream: upstream.URL, UpstreamCA: notPEM}
+		if _, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", &config.ClassifiedVars{}); err == nil {
+			t.Error("expected an error for a CA file without certificates")
+		}
+	})
+}
+
+func TestServer_UpstreamTransport(t *testing.T) {
+	cfg, err := config.Parse([]string{"--mode", "proxy",
+		"--upstream-max-idle-conns", "512", "--upstream-max-idle-conns-per-host", "128",
+		"--upstream-idle-conn-timeout", "5m", "--upstream-http2=false"}, "0.1.0-test")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	tr, err := (&Server{cfg: cfg, logger: slog.Default()}).upstreamTransport()
+	if err != nil {
+		t.Fatalf("upstreamTransport: %v", err)
+	}
+	if tr.MaxIdleConns != 512 || tr.MaxIdleConnsPerHost != 128 || tr.IdleConnTimeout != 5*time.Minute || tr.ForceAttemptHTTP2 {
+		t.Errorf("transport does not reflect the flags: MaxIdleConns=%d MaxIdleConnsPerHost=%d IdleConnTimeout=%s ForceAttemptHTTP2=%t",
+			tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout, tr.ForceAttemptHTTP2)
+	}
+
+	cfg, err = config.Parse([]string{"--mode", "proxy"}, "0.1.0-test")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	tr, err = (&Server{cfg: cfg, logger: slog.Default()}).upstreamTransport()
+	if err != nil {
+		t.Fatalf("upstreamTransport: %v", err)
+	}
+	if tr.MaxIdleConns != 100 || tr.MaxIdleConnsPerHost != 20 || tr.IdleConnTimeout != 90*time.Second || !tr.ForceAttemptHTTP2 {
+		t.Errorf("unexpected default transport: MaxIdleConns=%d MaxIdleConnsPerHost=%d IdleConnTimeout=%s ForceAttemptHTTP2=%t",
+			tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout, tr.ForceAttemptHTTP2)
+	}
+
+	// A Config literal gets the same pool defaults as the flags.
+	tr, err = (&Server{cfg: &config.Config{Mode: "proxy"}, logger: slog.Default()}).upstreamTransport()
+	if err != nil {
+		t.Fatalf("upstreamTransport: %v", err)
+	}
+	if tr.MaxIdleConns != 100 || tr.MaxIdleConnsPerHost != 20 || tr.IdleConnTimeout != 90*time.Second {
+		t.Errorf("zero Config fields: MaxIdleConns=%d MaxIdleConnsPerHost=%d IdleConnTimeout=%s",
+			tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout)
+	}
+
+	// Explicit 0 flags still mean no limit.
+	cfg, err = config.Parse([]string{"--mode", "proxy", "--upstream-max-idle-conns", "0", "--upstream-idle-conn-timeout", "0"}, "0.1.0-test")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	tr, err = (&Server{cfg: cfg, logger: slog.Default()}).upstreamTransport()
+	if err != nil {
+		t.Fatalf("upstreamTransport: %v", err)
+	}
+	if tr.MaxIdleConns != 0 || tr.IdleConnTimeout != 0 {
+		t.Errorf("expected unlimited pool, got MaxIdleConns=%d IdleConnTimeout=%s", tr.MaxIdleConns, tr.IdleConnTimeout)
+	}
+}
+
+func TestServer_MaxRequestBody(t *testing.T) {
+	var received atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil {
+			return
+		}
+		received.Store(n)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{Mode: "proxy", Upstream: upstream.URL, MaxRequestBody: 1024}
+	srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", &config.ClassifiedVars{})
+	if err != nil {
+		t.Fatalf("NewFromVars: %v", err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	// post sends size bytes; chunked hides the length so the limit can only
+	// be enforced while streaming.
+	post := func(t *testing.T, size int, chunked bool) int {
+		t.Helper()
+		received.Store(-1)
+		var body io.Reader = strings.NewReader(strings.Repeat("x", size))
+		if chunked {
+			body = io.MultiReader(body) // Not a known-length reader.
+		}
+		resp, err := http.Post(ts.URL+"/upload", "application/octet-stream", body)
+		if err != nil {
+			t.Fatalf("POST error: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, chunked := range []bool{false, true} {
+		if code := post(t, 1024, chunked); code != http.StatusCreated || received.Load() != 1024 {
+			t.Errorf("chunked=%t: expected a body at the limit to reach the upstream, got %d with %d bytes", chunked, code, received.Load())
+		}
+		if code := post(t, 4096, chunked); code != http.StatusRequestEntityTooLarge {
+			t.Errorf("chunked=%t: expected 413 for an oversized body, got %d", chunked, code)
+		}
+	}
+
+	if code := post(t, 4096, false); code != http.StatusRequestEntityTooLarge || received.Load() != -1 {
+		t.Errorf("expected a declared oversized body to be refused before reaching the upstream, got %d", code)
+	}
+}
+
+func TestServer_DebugVars(t *testing.T) {
+	newServer := func(t *testing.T, enabled bool) *httptest.Server {
+		t.Helper()
+		cfg := testConfig(func(cfg *config.Config) {
+			cfg.DebugEndpoint = enabled
+			cfg.DebugToken = "d3bug-t0ken"
+		})
+		srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", &config.ClassifiedVars{})
+		if err != nil {
+			t.Fatalf("NewFromVars: %v", err)
+		}
+		ts := httptest.NewServer(srv.Handler())
+		t.Cleanup(ts.Close)
+		return ts
+	}
+	get := func(t *testing.T, url, token string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, url+"/rep/debug/vars", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET error: %v", err)
+		}
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		if resp := get(t, newServer(t, false).URL, "d3bug-t0ken"); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected 404 when disabled, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		ts := newServer(t, true)
+		for _, token := range []string{"", "wrong"} {
+			if resp := get(t, ts.URL, token); resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("token %q: expected 401, got %d", token, resp.StatusCode)
+			}
+		}
+
+		resp := get(t, ts.URL, "d3bug-t0ken")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+		var vars struct {
+			Goroutines int `json:"goroutines"`
+			MemStats   struct {
+				HeapAlloc uint64
+				NumGC     uint32
+			} `json:"memstats"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if vars.Goroutines <= 0 || vars.MemStats.HeapAlloc == 0 {
+			t.Errorf("expected populated stats, got %+v", vars)
+		}
+	})
+}
+
+func TestServer_Pprof(t *testing.T) {
+	newServer := func(t *testing.T, enabled bool) *Server {
+		t.Helper()
+		cfg := testConfig(func(cfg *config.Config) {
+			cfg.EnablePprof = enabled
+			cfg.PprofAddr = "localhost:0"
+		})
+		srv, err := NewFromVars(cfg, slog.Default(), "0.1.0-test", &config.ClassifiedVars{})
+		if err != nil {
+			t.Fatalf("NewFromVars: %v", err)
+		}
+		return srv
+	}
+	status := func(t *testing.T, h http.Handler, path string) int {
+		t.Helper()
+		ts := httptest.NewServer(h)
+		defer ts.Close()
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		srv := newServer(t, false)
+		if srv.pprofServer != nil {
+			t.Error("expected no pprof server by default")
+		}
+		if code := status(t, srv.Handler(), "/rep/debug/pprof/"); code != http.StatusNotFound {
+			t.Errorf("expected 404 on the main port, got %d", code)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		srv := newServer(t, true)
+		if srv.pprofServer == nil {
+			t.Fatal("expected a pprof server")
+		}
+		for _, path := range []string{"/rep/debug/pprof/", "/rep/debug/pprof/goroutine?debug=1", "/rep/debug/pprof/cmdline"} {
+			if code := status(t, srv.pprofServer.Handler, path); code != http.StatusOK {
+				t.Errorf("%s: expected 200 on the pprof listener, got %d", path, code)
+			}
+		}
+		if code := status(t, srv.Handler(), "/rep/debug/pprof/"); code != http.StatusNotFound {
+			t.Errorf("expected pprof to stay off the main port, got %d", code)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/ruachtech/rep/gateway/internal/config"
+	"github.com/ruachtech/rep/gateway/internal/health"
+)
+
+// Startup binds the gateway's ports before the startup sequence runs and
+// serves an "initializing" status on them, so probes hitting a slow start
+// get a clear answer instead of connection refused. Once New completes,
+// Server.UseStartup hands the listeners over to the server.
+type Startup struct {
+	main   *startupListener
+	health *startupListener // Nil without a separate --health-port.
+}
+
+// startupListener is a server whose handler is switched from the
+// initializing handler to the real one at hand-over.
+type startupListener struct {
+	srv     *http.Server
+	addr    net.Addr
+	handler atomic.Pointer[http.Handler]
+	errCh   chan error
+}
+
+// Listen binds the main port (and the separate health port, if any) and
+// starts serving health.InitializingHandler on them.
+func Listen(cfg *config.Config, logger *slog.Logger, version string) (*Startup, error) {
+	initializing := health.InitializingHandler(version)
+
+	st := &Startup{}
+	var err error
+	st.main, err = listen(newHTTPServer(cfg.Port, nil), initializing, cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.HealthPort > 0 && cfg.HealthPort != cfg.Port {
+		st.health, err = listen(&http.Server{Addr: fmt.Sprintf(":%d", cfg.HealthPort)}, initializing, "", "")
+		if err != nil {
+			_ = st.main.srv.Close()
+			return nil, err
+		}
+	}
+
+	logger.Info("rep.gateway.initializing", "addr", st.main.addr.String())
+	return st, nil
+}
+
+func listen(srv *http.Server, initial http.Handler, certFile, keyFile string) (*startupListener, error) {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", srv.Addr, err)
+	}
+
+	l := &startupListener{srv: srv, addr: ln.Addr(), errCh: make(chan error, 1)}
+	l.handler.Store(&initial)
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		(*l.handler.Load()).ServeHTTP(w, r)
+	})
+
+	go func() {
+		var err error
+		if certFile != "" && keyFile != "" {
+			err = srv.ServeTLS(ln, certFile, keyFile)
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != http.ErrServerClosed {
+			l.errCh <- err
+		}
+	}()
+	return l, nil
+}
+
+// Addr returns the address the main listener is bound to.
+func (st *Startup) Addr() net.Addr {
+	return st.main.addr
+}
+
+// UseStartup makes Start serve on st's listeners instead of binding its
+// own. The initializing handlers are replaced when Start is called.
+func (s *Server) UseStartup(st *Startup) {
+	s.startup = st
+}
+
+// handOver switches st's listeners to s's handlers and adopts them as s's
+// servers. It returns the channel on which the main server reports a fatal
+// serve error.
+func (st *Startup) handOver(s *Server) <-chan error {
+	mainHandler := s.httpServer.Handler
+	st.main.handler.Store(&mainHandler)
+	s.httpServer = st.main.srv
+
+	if st.health != nil && s.healthServer != nil {
+		healthHandler := s.healthServer.Handler
+		st.health.handler.Store(&healthHandler)
+		s.healthServer = st.health.srv
+	}
+	return st.main.errCh
+}
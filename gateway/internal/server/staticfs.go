@@ -0,0 +1,48 @@
+package server
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// containedDir is an http.FileSystem over a directory that refuses files
+// reached through symlinks resolving outside the directory. http.Dir alone
+// follows them wherever they point.
+type containedDir struct {
+	dir  http.Dir
+	root string // dir with its own symlinks resolved.
+
+	// escaped is called with the requested name when a symlink escapes.
+	escaped func(name string)
+}
+
+// newContainedDir returns a containedDir for the absolute directory dir.
+func newContainedDir(dir string, escaped func(name string)) (*containedDir, error) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &containedDir{dir: http.Dir(root), root: root, escaped: escaped}, nil
+}
+
+// Open implements http.FileSystem. Names whose resolved path is outside the
+// root report fs.ErrNotExist, so they are served as 404.
+func (d *containedDir) Open(name string) (http.File, error) {
+	full := filepath.Join(d.root, filepath.FromSlash(path.Clean("/"+name)))
+	resolved, err := filepath.EvalSymlinks(full)
+	if err != nil {
+		// Missing files and broken links: let http.Dir report the error.
+		return d.dir.Open(name)
+	}
+	rel, err := filepath.Rel(d.root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		if d.escaped != nil {
+			d.escaped(name)
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return d.dir.Open(name)
+}
@@ -7,6 +7,8 @@ package payload
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
+	"net/url"
 	"time"
 
 	"github.com/ruachtech/rep/gateway/internal/config"
@@ -18,7 +20,16 @@ import (
 type Payload struct {
 	Public    map[string]string `json:"public"`
 	Sensitive string            `json:"sensitive,omitempty"`
-	Meta      Meta              `json:"_meta"`
+
+	// EncryptedPublic holds the PUBLIC tier variables encrypted like
+	// Sensitive when the builder was created WithEncryptedPublic; Public is
+	// then empty.
+	EncryptedPublic string `json:"encrypted_public,omitempty"`
+
+	Meta Meta `json:"_meta"`
+
+	// scriptTemplate, when set, renders ScriptTag (see WithScriptTemplate).
+	scriptTemplate *ScriptTemplate
 }
 
 // Meta contains metadata about the payload.
@@ -29,22 +40,86 @@ type Meta struct {
 	KeyEndpoint string `json:"key_endpoint,omitempty"`
 	HotReload   string `json:"hot_reload,omitempty"`
 	TTL         int    `json:"ttl"`
+	Environment string `json:"environment,omitempty"`
+	ReplicaID   string `json:"replica_id,omitempty"`
+
+	// Types maps each PUBLIC variable to its manifest-declared type, so SDKs
+	// can coerce values. Present only when the builder was created WithTypes.
+	Types map[string]string `json:"types,omitempty"`
 }
 
 // Builder constructs REP payloads from classified variables.
 type Builder struct {
-	keys      *repcrypto.Keys
-	version   string
-	hotReload bool
+	keys           *repcrypto.Keys
+	version        string
+	hotReload      bool
+	encryptPublic  bool
+	environment    string
+	types          map[string]string
+	replicaID      string
+	scriptTemplate *ScriptTemplate
+}
+
+// BuilderOption configures optional Builder behaviour.
+type BuilderOption func(*Builder)
+
+// WithEncryptedPublic treats PUBLIC tier variables as sensitive: they are
+// encrypted into the encrypted_public blob (same format and AAD as the
+// sensitive blob) instead of appearing in plain text, and the client must
+// fetch a session key to read them. Used when even public configuration
+// must not be visible in page source before an auth step.
+func WithEncryptedPublic(enabled bool) BuilderOption {
+	return func(b *Builder) {
+		b.encryptPublic = enabled
+	}
+}
+
+// WithEnvironment sets _meta.environment, the deployment environment label
+// SDKs attach to logs and error reports. An empty name omits the field.
+func WithEnvironment(name string) BuilderOption {
+	return func(b *Builder) {
+		b.environment = name
+	}
+}
+
+// WithTypes adds _meta.types, mapping each PUBLIC variable in the payload to
+// its declared type from types (variable name to manifest type). Variables
+// without a declaration are left out. Ignored under WithEncryptedPublic,
+// where the map would reveal the hidden variable names.
+func WithTypes(types map[string]string) BuilderOption {
+	return func(b *Builder) {
+		b.types = types
+	}
+}
+
+// WithReplicaID sets _meta.replica_id and adds it to key_endpoint as a
+// "replica" query parameter, so the session key request names the replica
+// whose keys sealed the payload. An empty id omits both.
+func WithReplicaID(id string) BuilderOption {
+	return func(b *Builder) {
+		b.replicaID = id
+	}
+}
+
+// WithScriptTemplate makes ScriptTag on built payloads render t instead of
+// the default script element. A nil t keeps the default.
+func WithScriptTemplate(t *ScriptTemplate) BuilderOption {
+	return func(b *Builder) {
+		b.scriptTemplate = t
+	}
 }
 
 // NewBuilder creates a payload builder with the given cryptographic keys.
-func NewBuilder(keys *repcrypto.Keys, version string, hotReload bool) *Builder {
-	return &Builder{
+func NewBuilder(keys *repcrypto.Keys, version string, hotReload bool, opts ...BuilderOption) *Builder {
+	b := &Builder{
 		keys:      keys,
 		version:   version,
 		hotReload: hotReload,
 	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
 }
 
 // Build constructs the full REP payload from classified variables.
@@ -53,43 +128,117 @@ func NewBuilder(keys *repcrypto.Keys, version string, hotReload bool) *Builder {
 //  1. Computes HMAC integrity token over public + sensitive data.
 //  2. Encrypts sensitive variables using AES-256-GCM.
 //  3. Constructs the JSON payload object.
+//
+// Values are used byte-for-byte: no Unicode normalization is applied, since
+// the standard library has no NFC tables. A value written with a combining
+// accent and its precomposed form are therefore distinct values with
+// distinct integrity tokens; normalize at the source if that matters.
 func (b *Builder) Build(vars *config.ClassifiedVars) (*Payload, error) {
+	return b.build(nil, nil, vars)
+}
+
+// Rebuild is like Build but reuses prev's encrypted blobs where neither
+// their plaintext nor their AAD changed, so a reload that leaves the
+// sensitive variables alone does not hand clients a freshly sealed (and
+// therefore different) sensitive blob. prev and prevVars are the payload
+// and variables of the previous build, which must have used the same keys.
+//
+// The AAD binds _meta.integrity, which covers the public variables, so a
+// change to a plain-text public variable still re-seals the blobs. Changes
+// to SERVER variables, or to public variables under WithEncryptedPublic
+// (where the integrity token covers no plain-text values), keep the
+// sensitive blob byte-identical.
+func (b *Builder) Rebuild(prev *Payload, prevVars, vars *config.ClassifiedVars) (*Payload, error) {
+	return b.build(prev, prevVars, vars)
+}
+
+func (b *Builder) build(prev *Payload, prevVars, vars *config.ClassifiedVars) (*Payload, error) {
 	publicMap := vars.PublicMap()
 	sensitiveMap := vars.SensitiveMap()
 
+	// In encrypted-public mode the page carries no plain-text variables;
+	// the public map is encrypted alongside the sensitive one below.
+	var hiddenPublic map[string]string
+	if b.encryptPublic {
+		hiddenPublic, publicMap = publicMap, map[string]string{}
+	}
+
 	// Step 1: Compute the integrity token over the public vars only.
-	// This value is stored in _meta.integrity AND used as AAD for AES-GCM
-	// encryption, so the SDK can use _payload._meta.integrity as AAD for
-	// decryption (per §8.2). A single-pass approach avoids the circular
-	// dependency that arises when trying to include the encrypted blob in
-	// its own encryption AAD.
+	// This value is stored in _meta.integrity AND bound into the AES-GCM
+	// AAD together with _meta.version, so the SDK can rebuild the AAD from
+	// _meta for decryption (per §8.2). A single-pass approach avoids the
+	// circular dependency that arises when trying to include the encrypted
+	// blob in its own encryption AAD.
 	integrity := repcrypto.ComputeIntegrity(publicMap, "", b.keys.HMACSecret)
+	aad := repcrypto.BlobAAD(b.version, integrity)
 
-	// Step 2: Encrypt sensitive variables, binding them to integrity via AAD.
+	// A previous blob can be reused only if it was sealed under the same AAD.
+	var prevSensitive, prevHidden map[string]string
+	if prev != nil && prevVars != nil && prev.Meta.Version == b.version && prev.Meta.Integrity == integrity {
+		prevSensitive = prevVars.SensitiveMap()
+		if b.encryptPublic {
+			prevHidden = prevVars.PublicMap()
+		}
+	}
+
+	// Step 2: Encrypt sensitive variables, binding them to the AAD.
 	var sensitiveBlob string
 	if len(sensitiveMap) > 0 {
-		var err error
-		sensitiveBlob, err = repcrypto.EncryptSensitive(sensitiveMap, b.keys.EncryptionKey, integrity)
-		if err != nil {
-			return nil, fmt.Errorf("encrypting sensitive vars: %w", err)
+		if prevSensitive != nil && prev.Sensitive != "" && maps.Equal(prevSensitive, sensitiveMap) {
+			sensitiveBlob = prev.Sensitive
+		} else {
+			var err error
+			sensitiveBlob, err = repcrypto.EncryptSensitive(sensitiveMap, b.keys.EncryptionKey, aad)
+			if err != nil {
+				return nil, fmt.Errorf("encrypting sensitive vars: %w", err)
+			}
+		}
+	}
+
+	var publicBlob string
+	if len(hiddenPublic) > 0 {
+		if prevHidden != nil && prev.EncryptedPublic != "" && maps.Equal(prevHidden, hiddenPublic) {
+			publicBlob = prev.EncryptedPublic
+		} else {
+			var err error
+			publicBlob, err = repcrypto.EncryptSensitive(hiddenPublic, b.keys.EncryptionKey, aad)
+			if err != nil {
+				return nil, fmt.Errorf("encrypting public vars: %w", err)
+			}
 		}
 	}
 
 	// Construct the payload.
 	p := &Payload{
-		Public:    publicMap,
-		Sensitive: sensitiveBlob,
+		Public:          publicMap,
+		Sensitive:       sensitiveBlob,
+		EncryptedPublic: publicBlob,
 		Meta: Meta{
-			Version:    b.version,
-			InjectedAt: time.Now().UTC().Format(time.RFC3339Nano),
-			Integrity:  integrity,
-			TTL:        0,
+			Version:     b.version,
+			InjectedAt:  time.Now().UTC().Format(time.RFC3339Nano),
+			Integrity:   integrity,
+			TTL:         0,
+			Environment: b.environment,
+			ReplicaID:   b.replicaID,
 		},
+		scriptTemplate: b.scriptTemplate,
 	}
 
-	// Add session key endpoint if sensitive vars exist.
-	if len(sensitiveMap) > 0 {
+	// Add session key endpoint if anything is encrypted.
+	if sensitiveBlob != "" || publicBlob != "" {
 		p.Meta.KeyEndpoint = "/rep/session-key"
+		if b.replicaID != "" {
+			p.Meta.KeyEndpoint += "?replica=" + url.QueryEscape(b.replicaID)
+		}
+	}
+
+	if b.types != nil && !b.encryptPublic {
+		p.Meta.Types = make(map[string]string, len(publicMap))
+		for name := range publicMap {
+			if typ, ok := b.types[name]; ok {
+				p.Meta.Types[name] = typ
+			}
+		}
 	}
 
 	// Add hot reload endpoint if enabled.
@@ -119,6 +268,8 @@ func (p *Payload) ToJSON() ([]byte, error) {
 //   - type="application/json" to prevent execution
 //   - data-rep-version for protocol version
 //   - data-rep-integrity for SRI verification
+//
+// A payload built WithScriptTemplate renders that template instead.
 func (p *Payload) ScriptTag() (string, error) {
 	jsonBytes, err := p.ToJSON()
 	if err != nil {
@@ -127,6 +278,14 @@ func (p *Payload) ScriptTag() (string, error) {
 
 	sri := repcrypto.ComputeSRI(jsonBytes)
 
+	if p.scriptTemplate != nil {
+		return p.scriptTemplate.render(scriptTemplateData{
+			JSON:    string(jsonBytes),
+			SRI:     sri,
+			Version: p.Meta.Version,
+		})
+	}
+
 	return fmt.Sprintf(
 		`<script id="__rep__" type="application/json" data-rep-version="%s" data-rep-integrity="%s">%s</script>`,
 		p.Meta.Version,
@@ -1,7 +1,12 @@
 package payload
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -71,6 +76,131 @@ func TestBuild_WithSensitive(t *testing.T) {
 	}
 }
 
+func TestBuild_SensitiveAADBindsVersion(t *testing.T) {
+	keys := testKeys(t)
+	builder := NewBuilder(keys, "0.1.0", false)
+
+	p, err := builder.Build(&config.ClassifiedVars{
+		Sensitive: []config.Variable{
+			{Name: "ANALYTICS_KEY", Value: "UA-12345"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	if _, err := repcrypto.DecryptSensitive(p.Sensitive, keys.EncryptionKey, repcrypto.BlobAAD("0.1.0", p.Meta.Integrity)); err != nil {
+		t.Fatalf("decrypt with matching version: %v", err)
+	}
+	if _, err := repcrypto.DecryptSensitive(p.Sensitive, keys.EncryptionKey, repcrypto.BlobAAD("0.2.0", p.Meta.Integrity)); err == nil {
+		t.Error("expected decryption to fail for a different gateway version")
+	}
+	if _, err := repcrypto.DecryptSensitive(p.Sensitive, keys.EncryptionKey, p.Meta.Integrity); err == nil {
+		t.Error("expected decryption to fail without the domain separator")
+	}
+}
+
+func TestRebuild_ReusesUnchangedSensitiveBlob(t *testing.T) {
+	keys := testKeys(t)
+	sensitive := []config.Variable{{Name: "ANALYTICS_KEY", Value: "UA-12345"}}
+	base := &config.ClassifiedVars{
+		Public:    []config.Variable{{Name: "API_URL", Value: "https://a.example.com"}},
+		Sensitive: sensitive,
+		Server:    []config.Variable{{Name: "DB_URL", Value: "postgres://a"}},
+	}
+	rebuild := func(b *Builder, prevVars, vars *config.ClassifiedVars) (prev, next *Payload) {
+		t.Helper()
+		prev, err := b.Build(prevVars)
+		if err != nil {
+			t.Fatalf("build error: %v", err)
+		}
+		next, err = b.Rebuild(prev, prevVars, vars)
+		if err != nil {
+			t.Fatalf("rebuild error: %v", err)
+		}
+		if _, err := repcrypto.DecryptSensitive(next.Sensitive, keys.EncryptionKey, repcrypto.BlobAAD(next.Meta.Version, next.Meta.Integrity)); err != nil {
+			t.Fatalf("rebuilt sensitive blob does not decrypt under its _meta: %v", err)
+		}
+		return prev, next
+	}
+
+	// A SERVER-only change leaves the AAD and the sensitive vars alone.
+	prev, next := rebuild(NewBuilder(keys, "0.1.0", false), base, &config.ClassifiedVars{
+		Public:    base.Public,
+		Sensitive: sensitive,
+		Server:    []config.Variable{{Name: "DB_URL", Value: "postgres://b"}},
+	})
+	if next.Sensitive != prev.Sensitive {
+		t.Error("expected server-only change to keep the sensitive blob")
+	}
+
+	// With encrypted public vars the integrity token covers no plain-text
+	// values, so a public-only change keeps the sensitive blob too.
+	publicChange := &config.ClassifiedVars{
+		Public:    []config.Variable{{Name: "API_URL", Value: "https://b.example.com"}},
+		Sensitive: sensitive,
+		Server:    base.Server,
+	}
+	prev, next = rebuild(NewBuilder(keys, "0.1.0", false, WithEncryptedPublic(true)), base, publicChange)
+	if next.Sensitive != prev.Sensitive {
+		t.Error("expected public-only change to keep the sensitive blob under encrypted public")
+	}
+	if next.EncryptedPublic == prev.EncryptedPublic {
+		t.Error("expected the encrypted public blob to be re-sealed")
+	}
+
+	// A plain-text public change alters the integrity token bound into the
+	// AAD, so the blob must be re-sealed.
+	prev, next = rebuild(NewBuilder(keys, "0.1.0", false), base, publicChange)
+	if next.Sensitive == prev.Sensitive {
+		t.Error("expected a plain-text public change to re-seal the sensitive blob")
+	}
+
+	// A sensitive change always re-seals.
+	prev, next = rebuild(NewBuilder(keys, "0.1.0", false), base, &config.ClassifiedVars{
+		Public:    base.Public,
+		Sensitive: []config.Variable{{Name: "ANALYTICS_KEY", Value: "UA-67890"}},
+	})
+	if next.Sensitive == prev.Sensitive {
+		t.Error("expected a sensitive change to re-seal the sensitive blob")
+	}
+}
+
+func TestBuild_SharedKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	seed := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, repcrypto.MinSeedSize))
+	if err := os.WriteFile(path, []byte(seed), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	vars := &config.ClassifiedVars{
+		Public:    []config.Variable{{Name: "API_URL", Value: "https://api.example.com"}},
+		Sensitive: []config.Variable{{Name: "ANALYTICS_KEY", Value: "UA-12345"}},
+	}
+
+	var built []*Payload
+	var keys []*repcrypto.Keys
+	for range 2 {
+		k, err := repcrypto.LoadKeyFile(path)
+		if err != nil {
+			t.Fatalf("loading key file: %v", err)
+		}
+		p, err := NewBuilder(k, "0.1.0", false).Build(vars)
+		if err != nil {
+			t.Fatalf("build error: %v", err)
+		}
+		keys, built = append(keys, k), append(built, p)
+	}
+
+	if built[0].Meta.Integrity != built[1].Meta.Integrity {
+		t.Errorf("expected identical integrity tokens, got %s and %s", built[0].Meta.Integrity, built[1].Meta.Integrity)
+	}
+	// One replica's session key decrypts the other's blob.
+	aad := repcrypto.BlobAAD(built[0].Meta.Version, built[0].Meta.Integrity)
+	if _, err := repcrypto.DecryptSensitive(built[0].Sensitive, keys[1].EncryptionKey, aad); err != nil {
+		t.Errorf("expected blob to decrypt with the other builder's key: %v", err)
+	}
+}
+
 func TestBuild_HotReload(t *testing.T) {
 	keys := testKeys(t)
 	builder := NewBuilder(keys, "0.1.0", true)
@@ -101,6 +231,106 @@ func TestBuild_NoHotReload(t *testing.T) {
 	}
 }
 
+func TestBuild_Environment(t *testing.T) {
+	keys := testKeys(t)
+	vars := &config.ClassifiedVars{
+		Public: []config.Variable{{Name: "API_URL", Value: "https://api.example.com"}},
+	}
+
+	p, err := NewBuilder(keys, "0.1.0", false, WithEnvironment("staging")).Build(vars)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	data, err := p.ToJSON()
+	if err != nil {
+		t.Fatalf("serialising: %v", err)
+	}
+	if !bytes.Contains(data, []byte(`"environment":"staging"`)) {
+		t.Errorf("expected _meta.environment=staging in %s", data)
+	}
+
+	p, err = NewBuilder(keys, "0.1.0", false).Build(vars)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	data, err = p.ToJSON()
+	if err != nil {
+		t.Fatalf("serialising: %v", err)
+	}
+	if bytes.Contains(data, []byte(`"environment"`)) {
+		t.Errorf("expected no _meta.environment when unconfigured, got %s", data)
+	}
+}
+
+func TestBuild_Types(t *testing.T) {
+	keys := testKeys(t)
+	vars := &config.ClassifiedVars{
+		Public: []config.Variable{
+			{Name: "API_URL", Value: "https://api.example.com"},
+			{Name: "DEBUG", Value: "false"},
+			{Name: "UNDECLARED", Value: "x"},
+		},
+		Sensitive: []config.Variable{{Name: "ANALYTICS_KEY", Value: "UA-12345"}},
+	}
+	types := map[string]string{"API_URL": "url", "DEBUG": "boolean", "ANALYTICS_KEY": "string"}
+
+	p, err := NewBuilder(keys, "0.1.0", false, WithTypes(types)).Build(vars)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	want := map[string]string{"API_URL": "url", "DEBUG": "boolean"}
+	if len(p.Meta.Types) != len(want) {
+		t.Errorf("expected types %v, got %v", want, p.Meta.Types)
+	}
+	for name, typ := range want {
+		if p.Meta.Types[name] != typ {
+			t.Errorf("types[%s]: expected %q, got %q", name, typ, p.Meta.Types[name])
+		}
+	}
+
+	p, err = NewBuilder(keys, "0.1.0", false).Build(vars)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	if p.Meta.Types != nil {
+		t.Errorf("expected no types by default, got %v", p.Meta.Types)
+	}
+
+	p, err = NewBuilder(keys, "0.1.0", false, WithTypes(types), WithEncryptedPublic(true)).Build(vars)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	if p.Meta.Types != nil {
+		t.Errorf("expected no types with encrypted public vars, got %v", p.Meta.Types)
+	}
+}
+
+func TestBuild_ReplicaID(t *testing.T) {
+	keys := testKeys(t)
+	vars := &config.ClassifiedVars{
+		Sensitive: []config.Variable{{Name: "ANALYTICS_KEY", Value: "UA-12345"}},
+	}
+
+	p, err := NewBuilder(keys, "0.1.0", false, WithReplicaID("eu-1")).Build(vars)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	if p.Meta.ReplicaID != "eu-1" {
+		t.Errorf("expected replica_id=eu-1, got %q", p.Meta.ReplicaID)
+	}
+	if p.Meta.KeyEndpoint != "/rep/session-key?replica=eu-1" {
+		t.Errorf("expected key_endpoint to name the replica, got %q", p.Meta.KeyEndpoint)
+	}
+
+	p, err = NewBuilder(keys, "0.1.0", false).Build(vars)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	if p.Meta.ReplicaID != "" || p.Meta.KeyEndpoint != "/rep/session-key" {
+		t.Errorf("expected no replica by default, got %q and %q", p.Meta.ReplicaID, p.Meta.KeyEndpoint)
+	}
+}
+
 func TestBuild_IntegrityFormat(t *testing.T) {
 	keys := testKeys(t)
 	builder := NewBuilder(keys, "0.1.0", false)
@@ -117,6 +347,47 @@ func TestBuild_IntegrityFormat(t *testing.T) {
 	}
 }
 
+func TestBuild_CombiningCharactersStableIntegrity(t *testing.T) {
+	keys := testKeys(t)
+	builder := NewBuilder(keys, "0.1.0", false)
+
+	build := func(value string) *Payload {
+		t.Helper()
+		p, err := builder.Build(&config.ClassifiedVars{
+			Public: []config.Variable{{Name: "CAFE", Value: value}},
+		})
+		if err != nil {
+			t.Fatalf("build error: %v", err)
+		}
+		return p
+	}
+
+	decomposed := "cafe\u0301" // "e" + COMBINING ACUTE ACCENT
+	p1, p2 := build(decomposed), build(decomposed)
+	if p1.Meta.Integrity != p2.Meta.Integrity {
+		t.Errorf("expected stable integrity, got %q and %q", p1.Meta.Integrity, p2.Meta.Integrity)
+	}
+	if p1.Public["CAFE"] != decomposed {
+		t.Errorf("expected value emitted unnormalized, got %q", p1.Public["CAFE"])
+	}
+	if composed := build("caf\u00e9"); composed.Meta.Integrity == p1.Meta.Integrity {
+		t.Error("expected the NFC form to be a distinct value")
+	}
+
+	// The SRI hash covers exactly the bytes emitted in the script tag.
+	tag, err := p1.ScriptTag()
+	if err != nil {
+		t.Fatalf("ScriptTag error: %v", err)
+	}
+	emitted := tag[strings.Index(tag, ">")+1 : strings.Index(tag, "</script>")]
+	if !strings.Contains(emitted, decomposed) {
+		t.Error("expected the combining sequence in the emitted bytes")
+	}
+	if want := `data-rep-integrity="` + repcrypto.ComputeSRI([]byte(emitted)) + `"`; !strings.Contains(tag, want) {
+		t.Errorf("expected %s in script tag", want)
+	}
+}
+
 func TestBuild_InjectedAt(t *testing.T) {
 	keys := testKeys(t)
 	builder := NewBuilder(keys, "0.1.0", false)
@@ -225,3 +496,236 @@ func TestToJSON_Roundtrip(t *testing.T) {
 		t.Errorf("expected version=0.1.0, got %v", meta["version"])
 	}
 }
+
+func TestBuild_EncryptedPublic_RoundTrip(t *testing.T) {
+	keys := testKeys(t)
+	builder := NewBuilder(keys, "0.1.0", false, WithEncryptedPublic(true))
+
+	vars := &config.ClassifiedVars{
+		Public: []config.Variable{
+			{Name: "API_URL", Value: "https://api.example.com"},
+			{Name: "FEATURE", Value: "on"},
+		},
+		Sensitive: []config.Variable{
+			{Name: "TOKEN", Value: "secret"},
+		},
+	}
+
+	p, err := builder.Build(vars)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	if len(p.Public) != 0 {
+		t.Errorf("expected no plain-text public vars, got %v", p.Public)
+	}
+	if p.EncryptedPublic == "" || p.Sensitive == "" {
+		t.Fatal("expected both encrypted blobs")
+	}
+	if p.Meta.KeyEndpoint != "/rep/session-key" {
+		t.Errorf("expected key endpoint, got %q", p.Meta.KeyEndpoint)
+	}
+
+	tag, err := p.ScriptTag()
+	if err != nil {
+		t.Fatalf("script tag error: %v", err)
+	}
+	if strings.Contains(tag, "api.example.com") {
+		t.Error("public value visible in script tag")
+	}
+
+	// The SDK decrypts with the session key and _meta.integrity as AAD.
+	plaintext, err := repcrypto.DecryptSensitive(p.EncryptedPublic, keys.EncryptionKey, repcrypto.BlobAAD(p.Meta.Version, p.Meta.Integrity))
+	if err != nil {
+		t.Fatalf("decrypting public blob: %v", err)
+	}
+	var public map[string]string
+	if err := json.Unmarshal(plaintext, &public); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(public) != 2 || public["API_URL"] != "https://api.example.com" || public["FEATURE"] != "on" {
+		t.Errorf("unexpected decrypted public vars: %v", public)
+	}
+
+	plaintext, err = repcrypto.DecryptSensitive(p.Sensitive, keys.EncryptionKey, repcrypto.BlobAAD(p.Meta.Version, p.Meta.Integrity))
+	if err != nil {
+		t.Fatalf("decrypting sensitive blob: %v", err)
+	}
+	if !strings.Contains(string(plaintext), `"TOKEN":"secret"`) {
+		t.Errorf("unexpected decrypted sensitive vars: %s", plaintext)
+	}
+}
+
+func TestBuild_EncryptedPublic_Disabled(t *testing.T) {
+	keys := testKeys(t)
+	p, err := NewBuilder(keys, "0.1.0", false, WithEncryptedPublic(false)).Build(&config.ClassifiedVars{
+		Public: []config.Variable{{Name: "API_URL", Value: "https://api.example.com"}},
+	})
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	if p.EncryptedPublic != "" || p.Public["API_URL"] == "" {
+		t.Errorf("expected plain-text public vars, got %+v", p)
+	}
+}
+
+func TestValidate_BuiltPayloads(t *testing.T) {
+	keys := testKeys(t)
+	vars := &config.ClassifiedVars{
+		Public:    []config.Variable{{Name: "API_URL", Value: "https://api.example.com"}},
+		Sensitive: []config.Variable{{Name: "ANALYTICS_KEY", Value: "UA-12345"}},
+	}
+
+	for _, b := range []*Builder{
+		NewBuilder(keys, "0.1.0", false),
+		NewBuilder(keys, "0.1.0-dev", true),
+		NewBuilder(keys, "1.2.3", true, WithEncryptedPublic(true)),
+		NewBuilder(keys, "0.1.0", false, WithEnvironment("production")),
+		NewBuilder(keys, "0.1.0", false, WithTypes(map[string]string{"API_URL": "url"})),
+		NewBuilder(keys, "0.1.0", false, WithReplicaID("eu-1")),
+	} {
+		p, err := b.Build(vars)
+		if err != nil {
+			t.Fatalf("build error: %v", err)
+		}
+		if err := p.Validate(); err != nil {
+			t.Errorf("version %s: expected built payload to validate, got %v", b.version, err)
+		}
+	}
+}
+
+func TestValidate_MalformedPayload(t *testing.T) {
+	valid := Payload{
+		Public: map[string]string{"A": "1"},
+		Meta: Meta{
+			Version:    "0.1.0",
+			InjectedAt: "2026-02-20T12:00:00Z",
+			Integrity:  "hmac-sha256:dGVzdA==",
+		},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected valid payload, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(p *Payload)
+	}{
+		{"bad integrity prefix", func(p *Payload) { p.Meta.Integrity = "sha1:abc" }},
+		{"non-semver version", func(p *Payload) { p.Meta.Version = "latest" }},
+		{"bad timestamp", func(p *Payload) { p.Meta.InjectedAt = "yesterday" }},
+		{"non-base64 blob", func(p *Payload) { p.Sensitive = "not base64!" }},
+		{"negative ttl", func(p *Payload) { p.Meta.TTL = -1 }},
+		{"missing public", func(p *Payload) { p.Public = nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid
+			tt.mutate(&p)
+			if err := p.Validate(); err == nil {
+				t.Error("expected self-check to fail")
+			}
+		})
+	}
+}
+
+func TestValidate_UnexpectedProperty(t *testing.T) {
+	schema, err := loadSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := `{"public":{},"debug":true,"_meta":{"version":"0.1.0","injected_at":"2026-02-20T12:00:00Z","integrity":"hmac-sha256:x"}}`
+	if err := validateJSON(schema, []byte(doc)); err == nil || !strings.Contains(err.Error(), `"debug"`) {
+		t.Errorf("expected unexpected-property error, got %v", err)
+	}
+	if err := validateJSON(schema, []byte(`{"public":{"A":1},"_meta":{}}`)); err == nil {
+		t.Error("expected error for non-string public value and missing meta fields")
+	}
+}
+
+func TestEmbeddedSchemaMatchesRepo(t *testing.T) {
+	repo, err := os.ReadFile("../../../schema/rep-payload.schema.json")
+	if err != nil {
+		t.Skipf("repository schema not available: %v", err)
+	}
+	if !bytes.Equal(repo, payloadSchema) {
+		t.Error("pkg/payload/rep-payload.schema.json is out of sync with schema/rep-payload.schema.json")
+	}
+}
+
+// TestSchemaCoversPayloadFields fails when a payload field is added without
+// a matching schema property; additionalProperties is false, so such a
+// payload would fail validation against the published schema.
+func TestSchemaCoversPayloadFields(t *testing.T) {
+	schema, err := loadSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(typ reflect.Type, node *schemaNode, where string) {
+		for i := 0; i < typ.NumField(); i++ {
+			name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			if _, ok := node.Properties[name]; !ok {
+				t.Errorf("%s field %q has no property in rep-payload.schema.json", where, name)
+			}
+		}
+	}
+	check(reflect.TypeOf(Payload{}), schema, "payload")
+	check(reflect.TypeOf(Meta{}), schema.Properties["_meta"], "_meta")
+}
+
+func TestScriptTag_Template(t *testing.T) {
+	tmpl, err := ParseScriptTemplate(`<!-- app config -->
+<script id="__rep__" type="application/json" nonce="static" data-rep-version="{{.Version}}" data-rep-integrity="{{.SRI}}">{{.JSON}}</script>
+<script>window.initApp()</script>`)
+	if err != nil {
+		t.Fatalf("ParseScriptTemplate: %v", err)
+	}
+
+	builder := NewBuilder(testKeys(t), "0.1.0", false, WithScriptTemplate(tmpl))
+	p, err := builder.Build(&config.ClassifiedVars{
+		Public: []config.Variable{{Name: "X", Value: "1"}},
+	})
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+	tag, err := p.ScriptTag()
+	if err != nil {
+		t.Fatalf("ScriptTag error: %v", err)
+	}
+
+	jsonBytes, _ := p.ToJSON()
+	for _, want := range []string{
+		"<!-- app config -->",
+		`nonce="static"`,
+		`data-rep-version="0.1.0"`,
+		`data-rep-integrity="` + repcrypto.ComputeSRI(jsonBytes) + `"`,
+		`>` + string(jsonBytes) + `</script>`,
+		"window.initApp()",
+	} {
+		if !strings.Contains(tag, want) {
+			t.Errorf("expected %q in the rendered tag:\n%s", want, tag)
+		}
+	}
+}
+
+func TestParseScriptTemplate_Rejects(t *testing.T) {
+	tests := map[string]string{
+		"missing id":        `<script type="application/json" data-rep-integrity="{{.SRI}}">{{.JSON}}</script>`,
+		"missing payload":   `<script id="__rep__" data-rep-integrity="{{.SRI}}"></script>`,
+		"missing integrity": `<script id="__rep__" type="application/json">{{.JSON}}</script>`,
+		"unknown field":     `<script id="__rep__" data-rep-integrity="{{.SRI}}">{{.JSON}}{{.Nonce}}</script>`,
+		"syntax error":      `<script id="__rep__">{{.JSON</script>`,
+	}
+	for name, text := range tests {
+		if _, err := ParseScriptTemplate(text); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	_, err := ParseScriptTemplate(tests["missing id"])
+	if err == nil || !strings.Contains(err.Error(), `id="__rep__"`) {
+		t.Errorf("expected the error to name the required id, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package payload
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// payloadSchema is a copy of schema/rep-payload.schema.json (REP-RFC-0001
+// §8.1). go:embed cannot reach outside the module, so the file is duplicated
+// here; a test keeps the two in sync.
+//
+//go:embed rep-payload.schema.json
+var payloadSchema []byte
+
+// schemaNode is the subset of JSON Schema used by the payload schema:
+// type, required, properties, additionalProperties, pattern, format
+// (date-time only) and minimum. Other keywords are ignored.
+type schemaNode struct {
+	Type                 string                 `json:"type"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*schemaNode `json:"properties"`
+	AdditionalProperties *additionalProperties  `json:"additionalProperties"`
+	Pattern              string                 `json:"pattern"`
+	Format               string                 `json:"format"`
+	Minimum              *float64               `json:"minimum"`
+
+	re *regexp.Regexp
+}
+
+// additionalProperties is either a boolean or a schema.
+type additionalProperties struct {
+	allowed bool
+	schema  *schemaNode
+}
+
+func (a *additionalProperties) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.allowed); err == nil {
+		return nil
+	}
+	a.allowed = true
+	return json.Unmarshal(data, &a.schema)
+}
+
+// loadSchema parses the embedded schema once and compiles its patterns.
+var loadSchema = sync.OnceValues(func() (*schemaNode, error) {
+	var root schemaNode
+	if err := json.Unmarshal(payloadSchema, &root); err != nil {
+		return nil, fmt.Errorf("parsing payload schema: %w", err)
+	}
+	if err := root.compile(); err != nil {
+		return nil, err
+	}
+	return &root, nil
+})
+
+func (n *schemaNode) compile() error {
+	if n.Pattern != "" {
+		re, err := regexp.Compile(n.Pattern)
+		if err != nil {
+			return fmt.Errorf("payload schema pattern %q: %w", n.Pattern, err)
+		}
+		n.re = re
+	}
+	for _, child := range n.Properties {
+		if err := child.compile(); err != nil {
+			return err
+		}
+	}
+	if n.AdditionalProperties != nil && n.AdditionalProperties.schema != nil {
+		return n.AdditionalProperties.schema.compile()
+	}
+	return nil
+}
+
+// Validate checks the payload's JSON form against the REP payload schema
+// (§8.1). The gateway runs it at startup with --validate-payload so that a
+// payload drifting from the spec fails fast instead of reaching clients.
+func (p *Payload) Validate() error {
+	schema, err := loadSchema()
+	if err != nil {
+		return err
+	}
+	data, err := p.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("marshalling payload: %w", err)
+	}
+	return validateJSON(schema, data)
+}
+
+// validateJSON validates a JSON document against schema.
+func validateJSON(schema *schemaNode, data []byte) error {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parsing payload: %w", err)
+	}
+	return schema.validate(doc, "payload")
+}
+
+func (n *schemaNode) validate(v any, path string) error {
+	switch n.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected object", path)
+		}
+		for _, name := range n.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		// Sorted so the first reported error is deterministic.
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := n.Properties[k]
+			if child == nil && n.AdditionalProperties != nil {
+				if !n.AdditionalProperties.allowed {
+					return fmt.Errorf("%s: unexpected property %q", path, k)
+				}
+				child = n.AdditionalProperties.schema
+			}
+			if child == nil {
+				continue
+			}
+			if err := child.validate(obj[k], path+"."+k); err != nil {
+				return err
+			}
+		}
+
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected string", path)
+		}
+		if n.re != nil && !n.re.MatchString(s) {
+			return fmt.Errorf("%s: %q does not match %s", path, s, n.Pattern)
+		}
+		if n.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return fmt.Errorf("%s: %q is not an RFC 3339 date-time", path, s)
+			}
+		}
+
+	case "integer":
+		f, ok := v.(float64)
+		if !ok || f != math.Trunc(f) {
+			return fmt.Errorf("%s: expected integer", path)
+		}
+		if n.Minimum != nil && f < *n.Minimum {
+			return fmt.Errorf("%s: %v is below the minimum %v", path, f, *n.Minimum)
+		}
+	}
+	return nil
+}
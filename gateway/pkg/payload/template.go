@@ -0,0 +1,69 @@
+package payload
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// ScriptTemplate renders the injected script block in place of the default
+// <script id="__rep__"> element (--script-template). Templates use
+// text/template syntax with these fields:
+//
+//	{{.JSON}}     the serialised payload (already safe inside a <script>)
+//	{{.SRI}}      the SRI hash for data-rep-integrity
+//	{{.Version}}  the REP protocol version for data-rep-version
+type ScriptTemplate struct {
+	tmpl *template.Template
+}
+
+// scriptTemplateData is the data a ScriptTemplate is executed with.
+type scriptTemplateData struct {
+	JSON    string
+	SRI     string
+	Version string
+}
+
+// ParseScriptTemplate parses text and checks, by rendering a probe payload,
+// that the output still carries the payload in an element with
+// id="__rep__" and its SRI hash, so the SDK can discover and verify it.
+func ParseScriptTemplate(text string) (*ScriptTemplate, error) {
+	tmpl, err := template.New("script").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing script template: %w", err)
+	}
+	st := &ScriptTemplate{tmpl: tmpl}
+
+	probe := scriptTemplateData{JSON: `{"_meta":{"probe":true}}`, SRI: "sha256-probe", Version: "0.0.0"}
+	out, err := st.render(probe)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !strings.Contains(out, `id="__rep__"`):
+		return nil, fmt.Errorf("script template must render an element with id=\"__rep__\" for SDK discovery")
+	case !strings.Contains(out, probe.JSON):
+		return nil, fmt.Errorf("script template must render the payload ({{.JSON}})")
+	case !strings.Contains(out, probe.SRI):
+		return nil, fmt.Errorf("script template must render the integrity hash ({{.SRI}}) for data-rep-integrity")
+	}
+	return st, nil
+}
+
+// LoadScriptTemplate reads and parses the script template at path.
+func LoadScriptTemplate(path string) (*ScriptTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading script template: %w", err)
+	}
+	return ParseScriptTemplate(string(data))
+}
+
+func (st *ScriptTemplate) render(data scriptTemplateData) (string, error) {
+	var b strings.Builder
+	if err := st.tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("rendering script template: %w", err)
+	}
+	return b.String(), nil
+}
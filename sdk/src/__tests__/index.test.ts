@@ -190,6 +190,59 @@ describe('getSecure()', () => {
   });
 });
 
+// ─── unlock() ────────────────────────────────────────────────────────────────
+
+describe('unlock()', () => {
+  it('decrypts encrypted public variables with a session key', async () => {
+    // AAD per §8.2: domain separator, gateway version, integrity token.
+    const aad = 'rep-blob-v1|0.1.0|hmac-sha256:dGVzdA==';
+    const rawKey = crypto.getRandomValues(new Uint8Array(32));
+    const key = await crypto.subtle.importKey('raw', rawKey, { name: 'AES-GCM' }, false, [
+      'encrypt',
+    ]);
+    const nonce = crypto.getRandomValues(new Uint8Array(12));
+    const ciphertext = new Uint8Array(
+      await crypto.subtle.encrypt(
+        { name: 'AES-GCM', iv: nonce, additionalData: new TextEncoder().encode(aad) },
+        key,
+        new TextEncoder().encode(JSON.stringify({ API_URL: 'https://api.example.com' }))
+      )
+    );
+    const blob = new Uint8Array(nonce.length + ciphertext.length);
+    blob.set(nonce);
+    blob.set(ciphertext, nonce.length);
+
+    const payload = makePayload({}, { keyEndpoint: '/rep/session-key' });
+    injectPayload({ ...payload, encrypted_public: btoa(String.fromCharCode(...blob)) });
+    vi.stubGlobal(
+      'fetch',
+      vi.fn().mockResolvedValue({
+        ok: true,
+        json: async () => ({ key: btoa(String.fromCharCode(...rawKey)), expires_at: '' }),
+      })
+    );
+
+    const { get, unlock, meta } = await import('../index');
+    expect(meta()!.publicEncrypted).toBe(true);
+    expect(get('API_URL')).toBeUndefined();
+
+    await unlock();
+    expect(get('API_URL')).toBe('https://api.example.com');
+    expect(meta()!.publicCount).toBe(1);
+  });
+
+  it('resolves without fetching when public variables are not encrypted', async () => {
+    injectPayload(makePayload({ A: '1' }));
+    const fetchMock = vi.fn();
+    vi.stubGlobal('fetch', fetchMock);
+
+    const { unlock, get } = await import('../index');
+    await unlock();
+    expect(fetchMock).not.toHaveBeenCalled();
+    expect(get('A')).toBe('1');
+  });
+});
+
 // ─── onChange() ──────────────────────────────────────────────────────────────
 
 describe('onChange()', () => {
@@ -227,6 +280,10 @@ describe('onChange()', () => {
       'rep:config:delete',
       expect.any(Function)
     );
+    expect(mockES.addEventListener).toHaveBeenCalledWith(
+      'rep:config:reload',
+      expect.any(Function)
+    );
   });
 
   it('unsubscribe closes EventSource when no listeners remain', async () => {
@@ -287,6 +344,7 @@ describe('exports', () => {
 
     expect(typeof mod.get).toBe('function');
     expect(typeof mod.getSecure).toBe('function');
+    expect(typeof mod.unlock).toBe('function');
     expect(typeof mod.getAll).toBe('function');
     expect(typeof mod.verify).toBe('function');
     expect(typeof mod.meta).toBe('function');
@@ -17,6 +17,7 @@
 interface REPPayload {
   public: Record<string, string>;
   sensitive?: string;
+  encrypted_public?: string;
   _meta: {
     version: string;
     injected_at: string;
@@ -24,6 +25,9 @@ interface REPPayload {
     key_endpoint?: string;
     hot_reload?: string;
     ttl: number;
+    environment?: string;
+    replica_id?: string;
+    types?: Record<string, string>;
   };
 }
 
@@ -34,6 +38,8 @@ interface REPMeta {
   publicCount: number;
   sensitiveAvailable: boolean;
   hotReloadAvailable: boolean;
+  publicEncrypted: boolean;
+  environment?: string;
 }
 
 interface SessionKeyResponse {
@@ -187,8 +193,69 @@ export async function getSecure(key: string): Promise<string> {
     return _sensitiveCache[key];
   }
 
+  const sensitiveMap = await _decryptBlob(
+    _payload.sensitive,
+    _payload._meta.key_endpoint,
+    _blobAAD(_payload._meta)
+  );
+
+  // Cache all decrypted values.
+  _sensitiveCache = sensitiveMap;
+
+  if (!(key in sensitiveMap)) {
+    throw new REPError(`SENSITIVE variable "${key}" not found in payload.`);
+  }
+
+  return sensitiveMap[key];
+}
+
+/**
+ * Decrypt the PUBLIC tier variables when the gateway runs with
+ * `--encrypt-public`. Until this resolves, `get()` returns defaults.
+ *
+ * Fetches a session key from the key endpoint — typically only reachable
+ * after an auth step — then makes the variables available to `get()` and
+ * `getAll()`. Resolves immediately if public variables are not encrypted.
+ *
+ * @throws REPError if the session key endpoint is unreachable or decryption fails.
+ */
+export async function unlock(): Promise<void> {
+  if (!_available || !_payload) {
+    throw new REPError('REP payload not available.');
+  }
+
+  if (!_payload.encrypted_public) return;
+
+  if (!_payload._meta.key_endpoint) {
+    throw new REPError('Encrypted payload has no key endpoint.');
+  }
+
+  const publicMap = await _decryptBlob(
+    _payload.encrypted_public,
+    _payload._meta.key_endpoint,
+    _blobAAD(_payload._meta)
+  );
+  _publicVars = Object.freeze({ ...publicMap });
+}
+
+/**
+ * Build the AES-GCM additional authenticated data for a blob (§8.2):
+ * `rep-blob-v1|<version>|<integrity>`, matching the gateway's BlobAAD.
+ */
+function _blobAAD(meta: REPPayload['_meta']): Uint8Array {
+  return new TextEncoder().encode(`rep-blob-v1|${meta.version}|${meta.integrity}`);
+}
+
+/**
+ * Fetch a session key and decrypt an AES-256-GCM blob (§8.2) into a map.
+ */
+async function _decryptBlob(
+  blob: string,
+  keyEndpoint: string,
+  aad: Uint8Array
+): Promise<Record<string, string>> {
   // Fetch session key from the gateway.
-  const resp = await fetch(_payload._meta.key_endpoint);
+  const resp = await fetch(keyEndpoint);
   if (!resp.ok) {
     throw new REPError(`Session key request failed: ${resp.status} ${resp.statusText}`);
   }
@@ -199,7 +266,7 @@ export async function getSecure(key: string): Promise<string> {
   const rawKey = Uint8Array.from(atob(sessionKey.key), (c) => c.charCodeAt(0));
 
   // Decode the encrypted blob.
-  const blobBytes = Uint8Array.from(atob(_payload.sensitive), (c) => c.charCodeAt(0));
+  const blobBytes = Uint8Array.from(atob(blob), (c) => c.charCodeAt(0));
 
   // Extract nonce (first 12 bytes) and ciphertext+tag (rest).
   const nonce = blobBytes.slice(0, 12);
@@ -214,12 +281,6 @@ export async function getSecure(key: string): Promise<string> {
     ['decrypt']
   );
 
-  // The AAD is the preliminary integrity token (see payload builder).
-  // For simplicity in the SDK, we use the integrity from _meta.
-  // This matches the gateway's encryption AAD.
-  const encoder = new TextEncoder();
-  const aad = encoder.encode(_payload._meta.integrity);
-
   const plaintext = await crypto.subtle.decrypt(
     { name: 'AES-GCM', iv: nonce, additionalData: aad },
     cryptoKey,
@@ -227,16 +288,7 @@ export async function getSecure(key: string): Promise<string> {
   );
 
   const decoder = new TextDecoder();
-  const sensitiveMap: Record<string, string> = JSON.parse(decoder.decode(plaintext));
-
-  // Cache all decrypted values.
-  _sensitiveCache = sensitiveMap;
-
-  if (!(key in sensitiveMap)) {
-    throw new REPError(`SENSITIVE variable "${key}" not found in payload.`);
-  }
-
-  return sensitiveMap[key];
+  return JSON.parse(decoder.decode(plaintext));
 }
 
 /**
@@ -265,9 +317,11 @@ export function meta(): REPMeta | null {
     version: _payload._meta.version,
     injectedAt: new Date(_payload._meta.injected_at),
     integrityValid: !_tampered,
-    publicCount: Object.keys(_payload.public).length,
+    publicCount: Object.keys(_publicVars).length,
     sensitiveAvailable: !!_payload.sensitive,
     hotReloadAvailable: !!_payload._meta.hot_reload,
+    publicEncrypted: !!_payload.encrypted_public,
+    environment: _payload._meta.environment,
   };
 }
 
@@ -375,6 +429,14 @@ function _ensureEventSource(): void {
     }
   });
 
+  _eventSource.addEventListener('rep:config:error', () => {
+    console.warn('[REP] Gateway configuration reload failed. Values may be stale.');
+  });
+
+  _eventSource.addEventListener('rep:config:reload', () => {
+    console.warn('[REP] Gateway could not replay missed changes. Reload the page for current values.');
+  });
+
   _eventSource.onerror = () => {
     console.warn('[REP] Hot reload SSE connection lost. Will reconnect automatically.');
   };
@@ -401,6 +463,7 @@ function _maybeCloseEventSource(): void {
 export const rep = {
   get,
   getSecure,
+  unlock,
   getAll,
   verify,
   meta,